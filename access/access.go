@@ -0,0 +1,116 @@
+// access/access.go
+package access
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"turanga/users"
+)
+
+// Единые правила видимости книг. Книга закрыта, если у неё стоит пометка 18+
+// или есть закрытый тег (tags.restricted), например «private». Закрытые книги
+// видят только пользователи с разрешением users.PermOver18; гостю, детскому
+// профилю и ответам Nostr они недоступны ни в списках, ни по прямой ссылке
+// (страница книги, скачивание, обложка).
+
+// Policy — правила видимости для одного запроса
+type Policy struct {
+	showRestricted bool
+}
+
+// Guest — правила для гостя без входа. Ими же пользуется Nostr:
+// ответы уходят в публичную сеть, закрытые книги в них не попадают.
+var Guest = Policy{}
+
+// ForUser возвращает правила для пользователя (nil — гость)
+func ForUser(u *users.User) Policy {
+	return Policy{showRestricted: u.Can(users.PermOver18)}
+}
+
+// ForRequest возвращает правила для пользователя, определённого по сессии или токену устройства
+func ForRequest(r *http.Request) Policy {
+	return ForUser(users.FromContext(r.Context()))
+}
+
+// ShowRestricted сообщает, видны ли закрытые книги
+func (p Policy) ShowRestricted() bool {
+	return p.showRestricted
+}
+
+// VisibleCondition возвращает SQL-условие «книга не закрыта»; alias — псевдоним таблицы books
+func VisibleCondition(alias string) string {
+	return fmt.Sprintf(`(IFNULL(%[1]s.over18, 0) = 0 AND NOT EXISTS (
+            SELECT 1 FROM book_tags rbt JOIN tags rt ON rt.id = rbt.tag_id
+            WHERE rbt.book_id = %[1]s.id AND rt.restricted = 1))`, alias)
+}
+
+// Filter возвращает "AND <условие>" для скрытия закрытых книг или пустую строку,
+// если они видны. alias — псевдоним таблицы books в запросе.
+func (p Policy) Filter(alias string) string {
+	if p.showRestricted {
+		return ""
+	}
+	return "AND " + VisibleCondition(alias)
+}
+
+// TagFilter возвращает "AND <условие>" для скрытия самих закрытых тегов
+// из списков тегов или пустую строку. alias — псевдоним таблицы tags.
+func (p Policy) TagFilter(alias string) string {
+	if p.showRestricted {
+		return ""
+	}
+	return fmt.Sprintf("AND IFNULL(%s.restricted, 0) = 0", alias)
+}
+
+// CanSeeBook проверяет, видна ли книга. Для несуществующей книги возвращает false.
+func (p Policy) CanSeeBook(db *sql.DB, bookID int64) (bool, error) {
+	var visible bool
+	err := db.QueryRow("SELECT 1 FROM books b WHERE b.id = ? "+p.Filter("b"), bookID).Scan(&visible)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("ошибка проверки доступа к книге %d: %w", bookID, err)
+	}
+	return true, nil
+}
+
+// CanSeeFile проверяет, есть ли видимая книга с таким хешем файла.
+// Используется для обложек и аннотаций, которые хранятся по хешу.
+func (p Policy) CanSeeFile(db *sql.DB, fileHash string) (bool, error) {
+	var visible bool
+	err := db.QueryRow("SELECT 1 FROM books b WHERE b.file_hash = ? "+p.Filter("b")+" LIMIT 1", fileHash).Scan(&visible)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("ошибка проверки доступа к файлу %s: %w", fileHash, err)
+	}
+	return true, nil
+}
+
+// IsTagRestricted сообщает, закрыт ли тег
+func IsTagRestricted(db *sql.DB, tagName string) (bool, error) {
+	var restricted sql.NullBool
+	err := db.QueryRow("SELECT restricted FROM tags WHERE name = ?", strings.TrimSpace(tagName)).Scan(&restricted)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("ошибка чтения тега '%s': %w", tagName, err)
+	}
+	return restricted.Bool, nil
+}
+
+// SetTagRestricted закрывает или открывает тег: книги с закрытым тегом
+// видны только тем, кому разрешены книги 18+
+func SetTagRestricted(db *sql.DB, tagName string, restricted bool) error {
+	res, err := db.Exec("UPDATE tags SET restricted = ? WHERE name = ?", restricted, strings.TrimSpace(tagName))
+	if err != nil {
+		return fmt.Errorf("ошибка изменения тега '%s': %w", tagName, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("тег '%s' не найден", tagName)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// access/sharing.go
+package access
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Настройка shared определяет, можно ли предлагать книгу в ответах на запросы Nostr.
+// Она задаётся у книги и у тега; действует самая строгая из настроек книги и её тегов,
+// так что тег «личное» с настройкой never скрывает от сети все книги с этим тегом.
+
+// Sharing — настройка доступа к книге через Nostr
+type Sharing string
+
+const (
+	SharingShared  Sharing = "shared"  // Отвечать на запросы всех
+	SharingFriends Sharing = "friends" // Отвечать только друзьям (таблица friends)
+	SharingNever   Sharing = "never"   // Никогда не предлагать книгу
+)
+
+// SharingLevels — допустимые значения в порядке строгости
+var SharingLevels = []Sharing{SharingShared, SharingFriends, SharingNever}
+
+// SharingLabels — подписи настроек для интерфейса
+var SharingLabels = map[Sharing]string{
+	SharingShared:  "всем",
+	SharingFriends: "только друзьям",
+	SharingNever:   "никому",
+}
+
+// ParseSharing проверяет значение настройки
+func ParseSharing(s string) (Sharing, error) {
+	for _, level := range SharingLevels {
+		if Sharing(s) == level {
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("неизвестная настройка доступа через nostr: %s", s)
+}
+
+// allowedSharing возвращает настройки, при которых книгу можно отправить получателю
+func allowedSharing(friend bool) string {
+	if friend {
+		return fmt.Sprintf("'%s', '%s'", SharingShared, SharingFriends)
+	}
+	return fmt.Sprintf("'%s'", SharingShared)
+}
+
+// SharingFilter возвращает "AND <условие>", оставляющее книги, которые можно предложить
+// в ответе на запрос Nostr. friend — запрос пришёл от друга. alias — псевдоним таблицы books.
+func SharingFilter(alias string, friend bool) string {
+	allowed := allowedSharing(friend)
+	return fmt.Sprintf(`AND IFNULL(%[1]s.shared, '%[2]s') IN (%[3]s) AND NOT EXISTS (
+            SELECT 1 FROM book_tags sbt JOIN tags st ON st.id = sbt.tag_id
+            WHERE sbt.book_id = %[1]s.id AND IFNULL(st.shared, '%[2]s') NOT IN (%[3]s))`, alias, SharingShared, allowed)
+}
+
+// BookSharing возвращает собственную настройку книги и действующую с учётом её тегов.
+// restrictedBy — тег, из-за которого действующая настройка строже собственной.
+func BookSharing(db *sql.DB, bookID int64) (own, effective Sharing, restrictedBy string, err error) {
+	var shared sql.NullString
+	if err := db.QueryRow("SELECT shared FROM books WHERE id = ?", bookID).Scan(&shared); err != nil {
+		return "", "", "", fmt.Errorf("ошибка чтения настройки доступа книги %d: %w", bookID, err)
+	}
+	own = SharingShared
+	if shared.Valid && shared.String != "" {
+		own = Sharing(shared.String)
+	}
+	effective = own
+
+	rows, err := db.Query(`
+        SELECT t.name, t.shared FROM tags t
+        JOIN book_tags bt ON bt.tag_id = t.id
+        WHERE bt.book_id = ?
+        ORDER BY t.name`, bookID)
+	if err != nil {
+		return "", "", "", fmt.Errorf("ошибка чтения тегов книги %d: %w", bookID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var tagShared sql.NullString
+		if err := rows.Scan(&name, &tagShared); err != nil {
+			return "", "", "", fmt.Errorf("ошибка чтения тегов книги %d: %w", bookID, err)
+		}
+		if sharingRank(Sharing(tagShared.String)) > sharingRank(effective) {
+			effective = Sharing(tagShared.String)
+			restrictedBy = name
+		}
+	}
+	return own, effective, restrictedBy, rows.Err()
+}
+
+// sharingRank возвращает строгость настройки
+func sharingRank(s Sharing) int {
+	for i, level := range SharingLevels {
+		if s == level {
+			return i
+		}
+	}
+	return 0
+}
+
+// StricterSharing возвращает более строгую из двух настроек
+func StricterSharing(a, b Sharing) Sharing {
+	if sharingRank(b) > sharingRank(a) {
+		return b
+	}
+	return a
+}
+
+// TagSharing возвращает настройку доступа через Nostr для тега
+func TagSharing(db *sql.DB, tagName string) (Sharing, error) {
+	var shared sql.NullString
+	err := db.QueryRow("SELECT shared FROM tags WHERE name = ?", strings.TrimSpace(tagName)).Scan(&shared)
+	if err == sql.ErrNoRows || (err == nil && !shared.Valid) {
+		return SharingShared, nil
+	} else if err != nil {
+		return "", fmt.Errorf("ошибка чтения тега '%s': %w", tagName, err)
+	}
+	return Sharing(shared.String), nil
+}
+
+// SetTagSharing задаёт настройку доступа через Nostr для тега; она действует на все книги с этим тегом
+func SetTagSharing(db *sql.DB, tagName string, sharing Sharing) error {
+	res, err := db.Exec("UPDATE tags SET shared = ? WHERE name = ?", string(sharing), strings.TrimSpace(tagName))
+	if err != nil {
+		return fmt.Errorf("ошибка изменения тега '%s': %w", tagName, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("тег '%s' не найден", tagName)
+	}
+	return nil
+}
+
+// FilterShared оставляет из bookIDs книги, которые можно предложить в ответе на запрос Nostr,
+// сохраняя порядок. Закрытые книги (см. Policy) не предлагаются никогда.
+func FilterShared(db *sql.DB, bookIDs []int64, friend bool) ([]int64, error) {
+	var shared []int64
+	for _, id := range bookIDs {
+		var ok bool
+		err := db.QueryRow("SELECT 1 FROM books b WHERE b.id = ? "+Guest.Filter("b")+" "+SharingFilter("b", friend), id).Scan(&ok)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("ошибка проверки доступа к книге %d через nostr: %w", id, err)
+		}
+		shared = append(shared, id)
+	}
+	return shared, nil
+}
@@ -0,0 +1,389 @@
+// backup/backup.go
+package backup
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"turanga/config"
+	"turanga/migrations"
+)
+
+// FormatVersion — версия формата архива; увеличивается при несовместимых изменениях
+const FormatVersion = 1
+
+// ManifestName — имя файла описания внутри архива
+const ManifestName = "manifest.json"
+
+// Пути внутри архива
+const (
+	dbEntry        = "turanga.db"
+	configEntry    = "turanga.conf"
+	blacklistEntry = "blacklist.txt"
+	coversDir      = "covers"
+	notesDir       = "notes"
+	booksDir       = "books"
+	deletedDir     = "deleted" // Файлы книг в корзине
+)
+
+// FileEntry описывает один файл архива
+type FileEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest описывает содержимое архива резервной копии
+type Manifest struct {
+	Format        int         `json:"format"`
+	CreatedAt     time.Time   `json:"created_at"`
+	SchemaVersion int         `json:"schema_version"`
+	IncludesBooks bool        `json:"includes_books"`
+	BooksDir      string      `json:"books_dir"` // Каталог книг на момент создания копии (справочно)
+	Files         []FileEntry `json:"files"`
+}
+
+// Options задаёт параметры создания резервной копии
+type Options struct {
+	IncludeBooks bool
+}
+
+// Write записывает резервную копию библиотеки в w в виде zip-архива.
+// Снимок БД делается онлайн-бэкапом SQLite, поэтому сервер может продолжать работу.
+func Write(db *sql.DB, cfg *config.Config, rootPath string, w io.Writer, opts Options) (*Manifest, error) {
+	schemaVersion, err := migrations.CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Снимок БД сначала во временный файл: архив пишется потоком, а копия должна быть целостной
+	tmpDir, err := os.MkdirTemp(rootPath, "backup-")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания временного каталога: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := filepath.Join(tmpDir, dbEntry)
+	if err := migrations.BackupDatabase(db, snapshotPath); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Format:        FormatVersion,
+		CreatedAt:     time.Now(),
+		SchemaVersion: schemaVersion,
+		IncludesBooks: opts.IncludeBooks,
+		BooksDir:      cfg.GetBooksDirAbs(rootPath),
+	}
+
+	zw := zip.NewWriter(w)
+
+	addFile := func(archivePath, diskPath string) error {
+		entry, err := writeEntry(zw, archivePath, diskPath)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	}
+
+	if err := addFile(dbEntry, snapshotPath); err != nil {
+		return nil, err
+	}
+
+	configPath := filepath.Join(rootPath, "turanga.conf")
+	if _, err := os.Stat(configPath); err == nil {
+		if err := addFile(configEntry, configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Чёрный список Nostr читается по пути из конфига как есть, так же делаем и здесь
+	blacklistPath := cfg.BlacklistFile
+	if _, err := os.Stat(blacklistPath); err == nil {
+		if err := addFile(blacklistEntry, blacklistPath); err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := []struct {
+		archive string
+		disk    string
+	}{
+		{coversDir, filepath.Join(rootPath, "covers")},
+		{notesDir, filepath.Join(rootPath, "notes")},
+		{deletedDir, filepath.Join(rootPath, "deleted")},
+	}
+	if opts.IncludeBooks {
+		dirs = append(dirs, struct {
+			archive string
+			disk    string
+		}{booksDir, manifest.BooksDir})
+	}
+
+	for _, d := range dirs {
+		err := filepath.WalkDir(d.disk, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() || !entry.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(d.disk, path)
+			if err != nil {
+				return err
+			}
+			return addFile(d.archive+"/"+filepath.ToSlash(rel), path)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка архивирования каталога %s: %w", d.disk, err)
+		}
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка формирования манифеста: %w", err)
+	}
+	mw, err := zw.Create(ManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка записи манифеста: %w", err)
+	}
+	if _, err := mw.Write(manifestData); err != nil {
+		return nil, fmt.Errorf("ошибка записи манифеста: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("ошибка завершения архива: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// writeEntry добавляет файл в архив, одновременно подсчитывая контрольную сумму
+func writeEntry(zw *zip.Writer, archivePath, diskPath string) (FileEntry, error) {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("ошибка открытия %s: %w", diskPath, err)
+	}
+	defer f.Close()
+
+	header := &zip.FileHeader{
+		Name:     archivePath,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	if info, err := f.Stat(); err == nil {
+		header.Modified = info.ModTime()
+	}
+	zf, err := zw.CreateHeader(header)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("ошибка добавления %s в архив: %w", archivePath, err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(zf, hash), f)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("ошибка записи %s в архив: %w", archivePath, err)
+	}
+
+	return FileEntry{Path: archivePath, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Create сохраняет резервную копию в каталог backups рядом с программой
+// и возвращает путь к созданному архиву
+func Create(db *sql.DB, cfg *config.Config, rootPath string, opts Options) (string, error) {
+	dir := filepath.Join(rootPath, "backups")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("ошибка создания каталога %s: %w", dir, err)
+	}
+
+	archivePath := filepath.Join(dir, FileName(time.Now()))
+	if err := WriteFile(db, cfg, rootPath, archivePath, opts); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// WriteFile сохраняет резервную копию в указанный файл
+func WriteFile(db *sql.DB, cfg *config.Config, rootPath, archivePath string, opts Options) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла %s: %w", archivePath, err)
+	}
+
+	manifest, err := Write(db, cfg, rootPath, f, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("ошибка записи файла %s: %w", archivePath, closeErr)
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return err
+	}
+
+	log.Printf("Резервная копия создана: %s (%d файлов)", archivePath, len(manifest.Files))
+	return nil
+}
+
+// FileName возвращает имя файла резервной копии для момента t
+func FileName(t time.Time) string {
+	return "turanga-" + t.Format("20060102_150405") + ".zip"
+}
+
+// Verify проверяет архив: наличие манифеста, совпадение размеров и контрольных сумм
+// всех файлов, отсутствие лишних и небезопасных путей
+func Verify(archivePath string) (*Manifest, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия архива %s: %w", archivePath, err)
+	}
+	defer zr.Close()
+
+	return verifyReader(&zr.Reader)
+}
+
+func verifyReader(zr *zip.Reader) (*Manifest, error) {
+	entries := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if !safePath(f.Name) {
+			return nil, fmt.Errorf("недопустимый путь в архиве: %s", f.Name)
+		}
+		if _, dup := entries[f.Name]; dup {
+			return nil, fmt.Errorf("повторяющийся файл в архиве: %s", f.Name)
+		}
+		entries[f.Name] = f
+	}
+
+	mf, ok := entries[ManifestName]
+	if !ok {
+		return nil, fmt.Errorf("в архиве нет %s", ManifestName)
+	}
+	rc, err := mf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения манифеста: %w", err)
+	}
+	var manifest Manifest
+	err = json.NewDecoder(rc).Decode(&manifest)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора манифеста: %w", err)
+	}
+
+	if manifest.Format != FormatVersion {
+		return nil, fmt.Errorf("неподдерживаемый формат архива: %d", manifest.Format)
+	}
+	if manifest.SchemaVersion > migrations.LatestVersion() {
+		return nil, fmt.Errorf("%w: версия в копии %d, поддерживается до %d",
+			migrations.ErrSchemaTooNew, manifest.SchemaVersion, migrations.LatestVersion())
+	}
+
+	listed := make(map[string]bool, len(manifest.Files))
+	hasDB := false
+	for _, fe := range manifest.Files {
+		if !safePath(fe.Path) {
+			return nil, fmt.Errorf("недопустимый путь в манифесте: %s", fe.Path)
+		}
+		zf, ok := entries[fe.Path]
+		if !ok {
+			return nil, fmt.Errorf("файл %s указан в манифесте, но отсутствует в архиве", fe.Path)
+		}
+		if err := verifyEntry(zf, fe); err != nil {
+			return nil, err
+		}
+		listed[fe.Path] = true
+		if fe.Path == dbEntry {
+			hasDB = true
+		}
+	}
+	if !hasDB {
+		return nil, fmt.Errorf("в архиве нет базы данных")
+	}
+
+	for name := range entries {
+		if name != ManifestName && !listed[name] {
+			return nil, fmt.Errorf("файл %s отсутствует в манифесте", name)
+		}
+	}
+
+	return &manifest, nil
+}
+
+// verifyEntry сверяет размер и SHA-256 файла архива с манифестом
+func verifyEntry(zf *zip.File, fe FileEntry) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s из архива: %w", fe.Path, err)
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s из архива: %w", fe.Path, err)
+	}
+	if size != fe.Size {
+		return fmt.Errorf("размер %s не совпадает: %d вместо %d", fe.Path, size, fe.Size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != fe.SHA256 {
+		return fmt.Errorf("контрольная сумма %s не совпадает", fe.Path)
+	}
+	return nil
+}
+
+// safePath запрещает абсолютные пути, выход за пределы каталога и пути вне известных разделов.
+// Двоеточия и обратные косые черты в именах файлов допустимы (Write копирует имена книг
+// как есть), поэтому обратная косая черта проверяется как разделитель: на Windows она им станет.
+func safePath(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return false
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' })
+	if len(parts) != strings.Count(name, "/")+strings.Count(name, "\\")+1 {
+		// Пустой сегмент: «//» или разделитель в конце
+		return false
+	}
+	for _, part := range parts {
+		if part == ".." || part == "." {
+			return false
+		}
+	}
+	switch name {
+	case ManifestName, dbEntry, configEntry, blacklistEntry:
+		return true
+	}
+	// Раздел — только первый сегмент до «/», поэтому «C:» в начале пути не пройдёт
+	top := strings.SplitN(name, "/", 2)[0]
+	return (top == coversDir || top == notesDir || top == booksDir || top == deletedDir) && strings.Contains(name, "/")
+}
+
+// List возвращает архивы из каталога backups, новые первыми
+func List(rootPath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(rootPath, "backups"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "turanga-") && strings.HasSuffix(e.Name(), ".zip") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
@@ -0,0 +1,102 @@
+// backup/backup_test.go
+package backup
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"turanga/config"
+)
+
+func TestSafePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"turanga.db", true},
+		{"books/Title: Subtitle.fb2", true},
+		{`books/a\b.fb2`, true},
+		{"covers/1.jpg", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{`\books\a.fb2`, false},
+		{"books/../turanga.db", false},
+		{`books\..\..\x`, false},
+		{"books/./a.fb2", false},
+		{"books//a.fb2", false},
+		{"books/", false},
+		{"books", false},
+		{"C:/books/a.fb2", false},
+		{"other/a.fb2", false},
+	}
+	for _, tt := range tests {
+		if got := safePath(tt.name); got != tt.want {
+			t.Errorf("safePath(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteVerifyRestoreRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("двоеточие недопустимо в именах файлов Windows")
+	}
+
+	src := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.BlacklistFile = filepath.Join(src, "blacklist.txt")
+
+	files := map[string]string{
+		filepath.Join("books", "Title: Subtitle.fb2"): "book",
+		filepath.Join("covers", "1: cover.jpg"):       "cover",
+		filepath.Join("notes", "1.txt"):               "note",
+	}
+	for name, data := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(src, "turanga.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "backup.zip")
+	if err := WriteFile(db, cfg, src, archivePath, Options{IncludeBooks: true}); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Verify(archivePath); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	dst := t.TempDir()
+	if err := Restore(archivePath, dst); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s не восстановлен: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: получено %q, ожидалось %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "turanga.db")); err != nil {
+		t.Errorf("БД не восстановлена: %v", err)
+	}
+}
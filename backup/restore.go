@@ -0,0 +1,263 @@
+// backup/restore.go
+package backup
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"turanga/config"
+)
+
+// PendingName — имя архива, ожидающего восстановления при следующем запуске
+const PendingName = "restore.pending.zip"
+
+// Restore восстанавливает библиотеку из архива в каталог rootPath.
+// Вызывается только при остановленном сервере: до открытия БД или из командной строки.
+//
+// Архив сначала полностью проверяется и распаковывается во временный каталог.
+// Текущие БД, конфиг, чёрный список, обложки и аннотации переносятся
+// в restore.old-<время>, чтобы неудачное восстановление можно было откатить вручную.
+// Файлы книг из архива добавляются в каталог книг поверх существующих, лишние не удаляются.
+func Restore(archivePath, rootPath string) error {
+	manifest, err := Verify(archivePath)
+	if err != nil {
+		return fmt.Errorf("архив не прошёл проверку: %w", err)
+	}
+
+	stamp := time.Now().Format("20060102_150405")
+	stagingDir := filepath.Join(rootPath, "restore.staging-"+stamp)
+	if err := extract(archivePath, stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	// Каталог книг и чёрный список берём из восстанавливаемого конфига, если он есть в архиве
+	cfg := config.DefaultConfig()
+	stagedConfig := filepath.Join(stagingDir, configEntry)
+	if _, err := os.Stat(stagedConfig); err == nil {
+		if loaded, err := config.LoadConfig(stagedConfig); err == nil {
+			cfg = loaded
+		} else {
+			log.Printf("Предупреждение: не удалось прочитать конфиг из архива: %v", err)
+		}
+	} else if loaded, err := config.LoadConfig(filepath.Join(rootPath, "turanga.conf")); err == nil {
+		cfg = loaded
+	}
+
+	oldDir := filepath.Join(rootPath, "restore.old-"+stamp)
+	if err := os.MkdirAll(oldDir, 0755); err != nil {
+		return fmt.Errorf("ошибка создания каталога %s: %w", oldDir, err)
+	}
+
+	// Порядок важен: БД последней, чтобы при сбое на середине старая БД осталась на месте
+	targets := []struct {
+		staged string
+		live   string
+	}{
+		{filepath.Join(stagingDir, coversDir), filepath.Join(rootPath, "covers")},
+		{filepath.Join(stagingDir, notesDir), filepath.Join(rootPath, "notes")},
+		{filepath.Join(stagingDir, deletedDir), filepath.Join(rootPath, "deleted")},
+		{filepath.Join(stagingDir, blacklistEntry), cfg.BlacklistFile},
+		{stagedConfig, filepath.Join(rootPath, "turanga.conf")},
+		{filepath.Join(stagingDir, dbEntry), filepath.Join(rootPath, "turanga.db")},
+	}
+
+	for _, t := range targets {
+		if _, err := os.Stat(t.staged); os.IsNotExist(err) {
+			continue
+		}
+		if err := moveAside(t.live, oldDir); err != nil {
+			return err
+		}
+		if t.live == filepath.Join(rootPath, "turanga.db") {
+			// Журналы WAL относятся к старой БД и не должны примениться к новой
+			for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+				if err := moveAside(t.live+suffix, oldDir); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(t.staged, t.live); err != nil {
+			return fmt.Errorf("ошибка переноса %s: %w", t.live, err)
+		}
+	}
+
+	if manifest.IncludesBooks {
+		booksAbs := cfg.GetBooksDirAbs(rootPath)
+		if err := mergeDir(filepath.Join(stagingDir, booksDir), booksAbs); err != nil {
+			return fmt.Errorf("ошибка восстановления файлов книг: %w", err)
+		}
+	}
+
+	log.Printf("Библиотека восстановлена из %s (копия от %s), прежние данные сохранены в %s",
+		archivePath, manifest.CreatedAt.Format("2006-01-02 15:04:05"), oldDir)
+	return nil
+}
+
+// ApplyPending восстанавливает библиотеку из архива, загруженного через веб-интерфейс.
+// Вызывается при запуске до загрузки конфигурации и открытия БД.
+// Архив, не прошедший проверку, переименовывается в .rejected и не мешает запуску.
+func ApplyPending(rootPath string) error {
+	pending := filepath.Join(rootPath, PendingName)
+	if _, err := os.Stat(pending); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Printf("Найден архив для восстановления: %s", pending)
+	if err := Restore(pending, rootPath); err != nil {
+		rejected := pending + ".rejected"
+		if renameErr := os.Rename(pending, rejected); renameErr != nil {
+			log.Printf("Не удалось переименовать %s: %v", pending, renameErr)
+		}
+		return err
+	}
+	return os.Remove(pending)
+}
+
+// StagePending проверяет загруженный архив и ставит его в очередь на восстановление при перезапуске
+func StagePending(r io.Reader, rootPath string) (*Manifest, error) {
+	tmp, err := os.CreateTemp(rootPath, "restore-upload-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания временного файла: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("ошибка сохранения архива: %w", err)
+	}
+
+	manifest, err := Verify(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(rootPath, PendingName)); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("ошибка сохранения архива: %w", err)
+	}
+	return manifest, nil
+}
+
+// HasPending сообщает, ожидает ли архив восстановления
+func HasPending(rootPath string) bool {
+	_, err := os.Stat(filepath.Join(rootPath, PendingName))
+	return err == nil
+}
+
+// extract распаковывает проверенный архив в каталог dest
+func extract(archivePath, dest string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия архива %s: %w", archivePath, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name == ManifestName {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(f.Name))
+		// Пути уже проверены в Verify, но распаковку вне dest исключаем ещё раз
+		if !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("недопустимый путь в архиве: %s", f.Name)
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("ошибка создания каталога для %s: %w", target, err)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s из архива: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("ошибка создания %s: %w", target, err)
+	}
+	_, err = io.Copy(out, rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка распаковки %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// moveAside переносит файл или каталог в oldDir, если он существует
+func moveAside(path, oldDir string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	dest := filepath.Join(oldDir, filepath.Base(path))
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("ошибка переноса %s в %s: %w", path, oldDir, err)
+	}
+	return nil
+}
+
+// mergeDir переносит файлы из src в dst, заменяя совпадающие по имени
+func mergeDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, target); err == nil {
+			return nil
+		}
+		// Каталог книг может находиться на другом диске, тогда переименование невозможно
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,267 @@
+// commands.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"turanga/backup"
+	"turanga/config"
+	"turanga/metadata"
+	"turanga/users"
+)
+
+// runCommand выполняет служебную команду и возвращает код завершения
+//
+//	turanga backup [--books] [файл.zip]  — создать резервную копию
+//	turanga restore файл.zip             — восстановить библиотеку (сервер должен быть остановлен)
+//	turanga export-meta [--format json|csv|opf] файл — выгрузить метаданные книг
+//	turanga import-meta файл             — применить метаданные к книгам по хешу файла
+//	turanga user list|add|passwd|role|delete — управление учётными записями
+func runCommand(rootPath string, args []string) int {
+	switch args[0] {
+	case "backup":
+		return runBackup(rootPath, args[1:])
+	case "restore":
+		return runRestore(rootPath, args[1:])
+	case "export-meta":
+		return runExportMeta(rootPath, args[1:])
+	case "import-meta":
+		return runImportMeta(rootPath, args[1:])
+	case "user":
+		return runUser(rootPath, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестная команда: %s\n", args[0])
+		fmt.Fprintln(os.Stderr, "Использование:")
+		fmt.Fprintln(os.Stderr, "  turanga                           — запуск сервера")
+		fmt.Fprintln(os.Stderr, "  turanga backup [--books] [файл]   — резервная копия библиотеки")
+		fmt.Fprintln(os.Stderr, "  turanga restore файл              — восстановление из копии")
+		fmt.Fprintln(os.Stderr, "  turanga export-meta [--format json|csv|opf] файл — выгрузка метаданных")
+		fmt.Fprintln(os.Stderr, "  turanga import-meta файл          — загрузка метаданных")
+		fmt.Fprintln(os.Stderr, "  turanga user list|add|passwd|role|delete — учётные записи")
+		return 2
+	}
+}
+
+func runBackup(rootPath string, args []string) int {
+	opts := backup.Options{}
+	var archivePath string
+	for _, arg := range args {
+		switch {
+		case arg == "--books":
+			opts.IncludeBooks = true
+		case archivePath == "":
+			archivePath = arg
+		default:
+			fmt.Fprintf(os.Stderr, "Лишний аргумент: %s\n", arg)
+			return 2
+		}
+	}
+
+	cfg := openLibrary(rootPath)
+	defer db.Close()
+
+	var err error
+	if archivePath == "" {
+		archivePath, err = backup.Create(db, cfg, rootPath, opts)
+	} else {
+		err = backup.WriteFile(db, cfg, rootPath, archivePath, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка создания резервной копии: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(archivePath)
+	return 0
+}
+
+func runRestore(rootPath string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Использование: turanga restore файл.zip")
+		return 2
+	}
+
+	if err := backup.Restore(args[0], rootPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка восстановления: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runExportMeta(rootPath string, args []string) int {
+	format := metadata.FormatJSON
+	var outPath string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--format" && i+1 < len(args):
+			i++
+			format = args[i]
+		case outPath == "":
+			outPath = args[i]
+		default:
+			fmt.Fprintf(os.Stderr, "Лишний аргумент: %s\n", args[i])
+			return 2
+		}
+	}
+	if outPath == "" {
+		fmt.Fprintln(os.Stderr, "Использование: turanga export-meta [--format json|csv|opf] файл")
+		return 2
+	}
+
+	openLibrary(rootPath)
+	defer db.Close()
+
+	records, err := metadata.Export(db, rootPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка выгрузки метаданных: %v\n", err)
+		return 1
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка создания файла %s: %v\n", outPath, err)
+		return 1
+	}
+	err = metadata.Write(f, format, records)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outPath)
+		fmt.Fprintf(os.Stderr, "Ошибка записи метаданных: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Выгружено книг: %d\n", len(records))
+	return 0
+}
+
+func runImportMeta(rootPath string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Использование: turanga import-meta файл")
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка открытия файла %s: %v\n", args[0], err)
+		return 1
+	}
+	records, err := metadata.Read(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка чтения метаданных: %v\n", err)
+		return 1
+	}
+
+	openLibrary(rootPath)
+	defer db.Close()
+
+	stats, err := metadata.Apply(db, rootPath, records)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка применения метаданных: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Записей: %d, применено: %d, не найдено книг: %d, ошибок: %d\n",
+		stats.Total, stats.Applied, stats.NotFound, stats.Failed)
+	return 0
+}
+
+func runUser(rootPath string, args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "Использование:")
+		fmt.Fprintln(os.Stderr, "  turanga user list")
+		fmt.Fprintln(os.Stderr, "  turanga user add логин admin|reader|child  — пароль читается со стандартного ввода")
+		fmt.Fprintln(os.Stderr, "  turanga user passwd логин                  — пароль читается со стандартного ввода")
+		fmt.Fprintln(os.Stderr, "  turanga user role логин admin|reader|child")
+		fmt.Fprintln(os.Stderr, "  turanga user delete логин")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	cfg := openLibrary(rootPath)
+	defer db.Close()
+	if err := users.ImportLegacyPassword(db, cfg, "turanga.conf"); err != nil {
+		fmt.Fprintf(os.Stderr, "Предупреждение: не удалось перенести пароль из конфигурации: %v\n", err)
+	}
+
+	if args[0] == "list" {
+		list, err := users.List(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, u := range list {
+			fmt.Printf("%-20s %-8s %s\n", u.Login, u.Role, u.CreatedAt.Format("2006-01-02"))
+		}
+		return 0
+	}
+
+	if len(args) < 2 {
+		return usage()
+	}
+	login := args[1]
+
+	var err error
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		var password string
+		if password, err = readPassword(); err == nil {
+			_, err = users.Create(db, login, password, args[2])
+		}
+	case args[0] == "passwd" && len(args) == 2:
+		var user *users.User
+		if user, err = users.GetByLogin(db, login); err == nil {
+			var password string
+			if password, err = readPassword(); err == nil {
+				err = users.SetPassword(db, user.ID, password)
+			}
+		}
+	case args[0] == "role" && len(args) == 3:
+		var user *users.User
+		if user, err = users.GetByLogin(db, login); err == nil {
+			err = users.SetRole(db, user.ID, args[2])
+		}
+	case args[0] == "delete" && len(args) == 2:
+		var user *users.User
+		if user, err = users.GetByLogin(db, login); err == nil {
+			err = users.Delete(db, user.ID)
+		}
+	default:
+		return usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readPassword читает пароль из первой строки стандартного ввода
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Пароль: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("пароль не введён: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// openLibrary загружает конфигурацию и открывает БД для служебной команды
+func openLibrary(rootPath string) *config.Config {
+	cfg, err := config.LoadConfig(filepath.Join(rootPath, "turanga.conf"))
+	if err != nil {
+		cfg = config.DefaultConfig()
+	}
+	config.SetGlobalConfig(cfg)
+	initDB(rootPath)
+	return cfg
+}
@@ -34,8 +34,8 @@ type Config struct {
 	Debug                  bool   `ini:"debug"`
 	Port                   int    `ini:"port"`
 	BooksDir               string `ini:"books_dir"`
-	RenameBook             string `ini:"rename_book"` // "no", "autit", "hash"
-	PasswordHash           string `ini:"password_hash"`
+	RenameBook             string `ini:"rename_book"`   // "no", "autit", "hash"
+	PasswordHash           string `ini:"password_hash"` // Устарело: переносится в учётную запись admin
 	CatalogTitle           string `ini:"catalog_title"`
 	LocalIPFSAPI           string `ini:"local_ipfs_api"`
 	LocalIPFSGateway       string `ini:"local_ipfs_gateway"`
@@ -46,6 +46,16 @@ type Config struct {
 	NostrRelays            string `ini:"nostr_relays"`
 	BlacklistFile          string `ini:"blacklist_file"`
 	MaxRequestsPerDay      int    `ini:"max_requests_per_day"`
+	TrashDays              int    `ini:"trash_days"`   // Через сколько дней очищать корзину, 0 — не очищать
+	SessionDays            int    `ini:"session_days"` // Сколько дней действует вход в веб-интерфейс
+	OPDSAuth               bool   `ini:"opds_auth"`    // Требовать вход для OPDS-каталога
+	PSEWidth               int    `ini:"pse_width"`    // Наибольшая ширина страниц PDF/DJVU в OPDS-PSE, 0 — не показывать PDF/DJVU
+	SMTPHost               string `ini:"smtp_host"`    // Сервер для отправки книг на устройства по почте, пустой — отправка отключена
+	SMTPPort               int    `ini:"smtp_port"`
+	SMTPUser               string `ini:"smtp_user"` // Пустой — без авторизации
+	SMTPPassword           string `ini:"smtp_password"`
+	SMTPFrom               string `ini:"smtp_from"`     // Адрес отправителя
+	SMTPSecurity           string `ini:"smtp_security"` // "starttls", "tls", "none"
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
@@ -66,6 +76,12 @@ func DefaultConfig() *Config {
 		NostrRelays:            "wss://relay.damus.io,wss://relay.primal.net",
 		BlacklistFile:          "blacklist.txt",
 		MaxRequestsPerDay:      10,
+		TrashDays:              30,
+		SessionDays:            30,
+		OPDSAuth:               false,
+		PSEWidth:               1200,
+		SMTPPort:               587,
+		SMTPSecurity:           "starttls",
 	}
 }
 
@@ -128,6 +144,16 @@ func LoadConfig(configPath string) (*Config, error) {
 	cfg.NostrRelays = readString("nostr_relays", cfg.NostrRelays)
 	cfg.BlacklistFile = readString("blacklist_file", cfg.BlacklistFile)
 	cfg.MaxRequestsPerDay = readInt("max_requests_per_day", cfg.MaxRequestsPerDay)
+	cfg.TrashDays = readInt("trash_days", cfg.TrashDays)
+	cfg.SessionDays = readInt("session_days", cfg.SessionDays)
+	cfg.OPDSAuth = readBool("opds_auth", cfg.OPDSAuth)
+	cfg.PSEWidth = readInt("pse_width", cfg.PSEWidth)
+	cfg.SMTPHost = readString("smtp_host", cfg.SMTPHost)
+	cfg.SMTPPort = readInt("smtp_port", cfg.SMTPPort)
+	cfg.SMTPUser = readString("smtp_user", cfg.SMTPUser)
+	cfg.SMTPPassword = readString("smtp_password", cfg.SMTPPassword)
+	cfg.SMTPFrom = readString("smtp_from", cfg.SMTPFrom)
+	cfg.SMTPSecurity = readString("smtp_security", cfg.SMTPSecurity)
 
 	return cfg, nil
 }
@@ -246,6 +272,42 @@ func (c *Config) Validate() error {
 		c.MaxRequestsPerDay = 10 // Значение по умолчанию
 	}
 
+	// Проверяем TrashDays (0 — автоматическая очистка корзины отключена)
+	if c.TrashDays < 0 {
+		log.Printf("Недопустимое значение trash_days: %d. Использую 30 по умолчанию.", c.TrashDays)
+		c.TrashDays = 30
+	}
+
+	// Проверяем SessionDays
+	if c.SessionDays <= 0 {
+		log.Printf("Недопустимое значение session_days: %d. Использую 30 по умолчанию.", c.SessionDays)
+		c.SessionDays = 30
+	}
+
+	// Проверяем PSEWidth (0 — постраничный просмотр отключён)
+	if c.PSEWidth < 0 {
+		log.Printf("Недопустимое значение pse_width: %d. Использую 1200 по умолчанию.", c.PSEWidth)
+		c.PSEWidth = 1200
+	}
+
+	// Проверяем настройки SMTP (пустой smtp_host — отправка на устройства отключена)
+	if c.SMTPPort <= 0 || c.SMTPPort > 65535 {
+		log.Printf("Недопустимое значение smtp_port: %d. Использую 587 по умолчанию.", c.SMTPPort)
+		c.SMTPPort = 587
+	}
+	switch c.SMTPSecurity {
+	case "starttls", "tls", "none":
+	default:
+		log.Printf("Недопустимое значение smtp_security: %s. Использую starttls по умолчанию.", c.SMTPSecurity)
+		c.SMTPSecurity = "starttls"
+	}
+	if c.SMTPHost != "" && c.SMTPFrom == "" {
+		if !strings.Contains(c.SMTPUser, "@") {
+			return fmt.Errorf("для отправки почты через %s нужен адрес отправителя smtp_from", c.SMTPHost)
+		}
+		c.SMTPFrom = c.SMTPUser
+	}
+
 	return nil
 }
 
@@ -276,6 +338,21 @@ func (c *Config) String() string {
 	sb.WriteString(fmt.Sprintf("NostrRelays: %s\n", c.NostrRelays))
 	sb.WriteString(fmt.Sprintf("BlacklistFile: %s\n", c.BlacklistFile))
 	sb.WriteString(fmt.Sprintf("MaxRequestsPerDay: %d\n", c.MaxRequestsPerDay))
+	sb.WriteString(fmt.Sprintf("TrashDays: %d\n", c.TrashDays))
+	sb.WriteString(fmt.Sprintf("SessionDays: %d\n", c.SessionDays))
+	sb.WriteString(fmt.Sprintf("OPDSAuth: %t\n", c.OPDSAuth))
+	sb.WriteString(fmt.Sprintf("PSEWidth: %d\n", c.PSEWidth))
+	sb.WriteString(fmt.Sprintf("SMTPHost: %s\n", c.SMTPHost))
+	sb.WriteString(fmt.Sprintf("SMTPPort: %d\n", c.SMTPPort))
+	sb.WriteString(fmt.Sprintf("SMTPUser: %s\n", c.SMTPUser))
+	sb.WriteString(fmt.Sprintf("SMTPPassword: %s\n", func() string {
+		if c.SMTPPassword == "" {
+			return "(не задан)"
+		}
+		return "(скрыт)"
+	}()))
+	sb.WriteString(fmt.Sprintf("SMTPFrom: %s\n", c.SMTPFrom))
+	sb.WriteString(fmt.Sprintf("SMTPSecurity: %s\n", c.SMTPSecurity))
 
 	return sb.String()
 }
@@ -350,6 +427,16 @@ func (c *Config) SaveConfig(configPath string) error {
 	section.Key("nostr_relays").SetValue(c.NostrRelays)
 	section.Key("blacklist_file").SetValue(c.BlacklistFile)
 	section.Key("max_requests_per_day").SetValue(fmt.Sprintf("%d", c.MaxRequestsPerDay))
+	section.Key("trash_days").SetValue(fmt.Sprintf("%d", c.TrashDays))
+	section.Key("session_days").SetValue(fmt.Sprintf("%d", c.SessionDays))
+	section.Key("opds_auth").SetValue(fmt.Sprintf("%t", c.OPDSAuth))
+	section.Key("pse_width").SetValue(fmt.Sprintf("%d", c.PSEWidth))
+	section.Key("smtp_host").SetValue(c.SMTPHost)
+	section.Key("smtp_port").SetValue(fmt.Sprintf("%d", c.SMTPPort))
+	section.Key("smtp_user").SetValue(c.SMTPUser)
+	section.Key("smtp_password").SetValue(c.SMTPPassword)
+	section.Key("smtp_from").SetValue(c.SMTPFrom)
+	section.Key("smtp_security").SetValue(c.SMTPSecurity)
 
 	// Сохраняем хэш пароля, если он есть
 	if c.PasswordHash != "" {
@@ -0,0 +1,90 @@
+// convert/cache.go
+package convert
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"turanga/config"
+)
+
+// Сконвертированные книги хранятся в каталоге converted под именем {хеш файла}.epub:
+// исходный файл не меняется, а повторное скачивание отдаёт готовый EPUB.
+
+// Prefix — адрес, по которому отдаются сконвертированные книги (opds.OPDSConvertHandler)
+const Prefix = "/opds-convert/"
+
+// URL возвращает адрес EPUB, сконвертированного из файла fb2/fb2.zip
+func URL(fileHash, title string) string {
+	return Prefix + fileHash + "/" + url.PathEscape(title+".epub")
+}
+
+// CanConvert сообщает, можно ли получить EPUB из книги этого формата
+func CanConvert(fileType string) bool {
+	return fileType == "fb2" || fileType == "fb2.zip"
+}
+
+// locks не даёт конвертировать одну книгу в нескольких запросах сразу
+var locks sync.Map
+
+// CachedEPUB возвращает путь к EPUB, полученному из fb2/fb2.zip; при первом обращении
+// книга конвертируется и сохраняется в cacheDir
+func CachedEPUB(cacheDir, filePath, fileType, fileHash string) (string, error) {
+	cfg := config.GetConfig()
+
+	if !CanConvert(fileType) {
+		return "", fmt.Errorf("формат %s не конвертируется в EPUB", fileType)
+	}
+	target := filepath.Join(cacheDir, fileHash+".epub")
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+
+	lock, _ := locks.LoadOrStore(fileHash, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Пока ждали, книгу мог сконвертировать другой запрос
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения файла книги: %w", err)
+	}
+	src, err := openFB2(filePath, fileType)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("не удалось создать каталог для EPUB: %w", err)
+	}
+	tmp, err := os.CreateTemp(cacheDir, fileHash+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать файл EPUB: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := FB2ToEPUB(src, tmp, Options{ID: fileHash, Modified: info.ModTime()}); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("ошибка конвертации %s в EPUB: %w", filePath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("ошибка записи EPUB: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", fmt.Errorf("не удалось сохранить EPUB: %w", err)
+	}
+
+	if cfg.Debug {
+		log.Printf("Книга %s сконвертирована в EPUB: %s", filePath, target)
+	}
+	return target, nil
+}
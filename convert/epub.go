@@ -0,0 +1,750 @@
+// convert/epub.go
+package convert
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Преобразование FB2 в EPUB 3 (с toc.ncx для старых ридеров). Основное тело книги
+// делится на главы по секциям верхнего уровня, вложенные секции попадают в оглавление;
+// примечания (body name="notes") выносятся в отдельный файл, сноски ведут на них.
+// Картинки из binary, обложка и аннотация из description переносятся в книгу.
+
+// Options — сведения о книге, которых нет в самом FB2
+type Options struct {
+	ID       string    // Постоянный идентификатор книги, например хеш файла
+	Modified time.Time // Время изменения исходного файла (dcterms:modified)
+}
+
+// epubImage — картинка из binary
+type epubImage struct {
+	file      string // Путь внутри OEBPS
+	mediaType string
+	data      []byte
+}
+
+// epubPage — один XHTML-файл книги
+type epubPage struct {
+	file  string
+	title string
+	body  string
+}
+
+// tocEntry — пункт оглавления
+type tocEntry struct {
+	title    string
+	href     string
+	children []*tocEntry
+}
+
+// converter собирает EPUB из разобранного FB2
+type converter struct {
+	fb      *node
+	images  map[string]*epubImage // По id из binary
+	order   []string              // Порядок картинок для manifest
+	idFiles map[string]string     // В каком файле находится элемент с данным id
+	pages   []*epubPage
+	toc     []*tocEntry
+	anchors int
+	file    string // Файл, который сейчас отрисовывается
+}
+
+// FB2ToEPUB читает FB2 из r и пишет EPUB в w
+func FB2ToEPUB(r io.Reader, w io.Writer, opts Options) error {
+	fb, err := parseFB2(r)
+	if err != nil {
+		return err
+	}
+	c := &converter{fb: fb, images: make(map[string]*epubImage), idFiles: make(map[string]string)}
+	c.collectImages()
+
+	titleInfo := fb.child("description").child("title-info")
+	meta := bookMeta{
+		title: titleInfo.child("book-title").plainText(),
+		lang:  strings.TrimSpace(titleInfo.child("lang").plainText()),
+	}
+	if meta.title == "" {
+		meta.title = "Без названия"
+	}
+	if meta.lang == "" {
+		meta.lang = "ru"
+	}
+	for _, a := range titleInfo.childrenNamed("author") {
+		if name := authorName(a); name != "" {
+			meta.authors = append(meta.authors, name)
+		}
+	}
+	if seq := titleInfo.child("sequence"); seq != nil {
+		meta.series, meta.seriesNumber = seq.attr("name"), seq.attr("number")
+	}
+	annotation := titleInfo.child("annotation")
+	meta.description = annotation.plainText()
+	if cover := titleInfo.child("coverpage").child("image"); cover != nil {
+		if img := c.images[strings.TrimPrefix(cover.attr("href"), "#")]; img != nil {
+			meta.cover = img
+		}
+	}
+
+	c.layout(meta, annotation)
+
+	return c.write(w, meta, opts)
+}
+
+// bookMeta — метаданные книги из description
+type bookMeta struct {
+	title        string
+	lang         string
+	authors      []string
+	series       string
+	seriesNumber string
+	description  string
+	cover        *epubImage
+}
+
+// authorName собирает имя автора из частей или берёт псевдоним
+func authorName(a *node) string {
+	var parts []string
+	for _, part := range []string{"first-name", "middle-name", "last-name"} {
+		if text := a.child(part).plainText(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		return a.child("nickname").plainText()
+	}
+	return strings.Join(parts, " ")
+}
+
+// collectImages декодирует картинки из binary
+func (c *converter) collectImages() {
+	used := make(map[string]bool)
+	for _, b := range c.fb.childrenNamed("binary") {
+		id := b.attr("id")
+		if id == "" {
+			continue
+		}
+		encoded := strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, b.plainText())
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			// Встречаются картинки без выравнивания «=» в конце
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		}
+		if err != nil || len(data) == 0 {
+			continue
+		}
+
+		mediaType := b.attr("content-type")
+		if mediaType == "" || mediaType == "application/octet-stream" {
+			mediaType = http.DetectContentType(data)
+		}
+		name := safeName(id)
+		if ext := imageExt(mediaType); ext != "" && !strings.EqualFold(path.Ext(name), ext) {
+			name += ext
+		}
+		for used[name] {
+			name = "_" + name
+		}
+		used[name] = true
+
+		c.images[id] = &epubImage{file: "images/" + name, mediaType: mediaType, data: data}
+		c.order = append(c.order, id)
+	}
+}
+
+// layout раскладывает книгу по файлам и строит оглавление
+func (c *converter) layout(meta bookMeta, annotation *node) {
+	// Титульная страница: название, авторы, серия и аннотация
+	var title strings.Builder
+	title.WriteString(`<div class="title-page">`)
+	title.WriteString("<h1>" + esc(meta.title) + "</h1>")
+	if len(meta.authors) > 0 {
+		title.WriteString(`<p class="authors">` + esc(strings.Join(meta.authors, ", ")) + "</p>")
+	}
+	if meta.series != "" {
+		series := meta.series
+		if meta.seriesNumber != "" {
+			series += " #" + meta.seriesNumber
+		}
+		title.WriteString(`<p class="series">` + esc(series) + "</p>")
+	}
+	title.WriteString("</div>")
+	titlePage := &epubPage{file: "title.xhtml", title: meta.title}
+	c.pages = append(c.pages, titlePage)
+
+	// Разметка id → файл нужна до отрисовки, чтобы сноски вели в нужный файл
+	type part struct {
+		page  *epubPage
+		nodes []*node
+		notes bool
+	}
+	var parts []part
+	for _, body := range c.fb.childrenNamed("body") {
+		if body.attr("name") != "" {
+			page := &epubPage{file: fmt.Sprintf("notes%d.xhtml", len(parts)), title: body.child("title").plainText()}
+			if page.title == "" {
+				page.title = "Примечания"
+			}
+			parts = append(parts, part{page: page, nodes: body.children, notes: true})
+			continue
+		}
+		// Всё, что стоит до первой секции (заголовок тела, эпиграфы), — отдельная страница
+		start := len(parts)
+		var lead []*node
+		for _, n := range body.children {
+			if n.name == "section" {
+				page := &epubPage{file: fmt.Sprintf("chapter%d.xhtml", len(parts)), title: n.child("title").plainText()}
+				parts = append(parts, part{page: page, nodes: []*node{n}})
+				continue
+			}
+			if n.name != "" && len(parts) == start {
+				lead = append(lead, n)
+			}
+		}
+		if len(lead) > 0 {
+			page := &epubPage{file: fmt.Sprintf("chapter%d-lead.xhtml", start), title: body.child("title").plainText()}
+			parts = slices.Insert(parts, start, part{page: page, nodes: lead})
+		}
+	}
+	for _, p := range parts {
+		for _, n := range p.nodes {
+			c.markIDs(n, p.page.file)
+		}
+	}
+
+	if annotation != nil {
+		c.markIDs(annotation, titlePage.file)
+		c.file = titlePage.file
+		title.WriteString(`<div class="annotation">` + c.renderBlocks(annotation.children, 2, nil) + "</div>")
+	}
+	titlePage.body = title.String()
+
+	for _, p := range parts {
+		var sb strings.Builder
+		var entries []*tocEntry
+		c.file = p.page.file
+		if p.notes {
+			sb.WriteString(`<div class="notes">`)
+			sb.WriteString(c.renderBlocks(p.nodes, 1, nil))
+			sb.WriteString("</div>")
+		} else {
+			sb.WriteString(c.renderBlocks(p.nodes, 1, &entries))
+		}
+		p.page.body = sb.String()
+		c.pages = append(c.pages, p.page)
+
+		switch {
+		case p.notes:
+			c.toc = append(c.toc, &tocEntry{title: p.page.title, href: p.page.file})
+		case len(entries) > 0:
+			c.toc = append(c.toc, entries...)
+		case p.page.title != "":
+			c.toc = append(c.toc, &tocEntry{title: p.page.title, href: p.page.file})
+		}
+		if p.page.title == "" {
+			p.page.title = meta.title
+		}
+	}
+	if len(c.toc) == 0 {
+		c.toc = append(c.toc, &tocEntry{title: meta.title, href: titlePage.file})
+	}
+}
+
+// markIDs запоминает, в каком файле окажутся элементы с id
+func (c *converter) markIDs(n *node, file string) {
+	if id := n.attr("id"); id != "" {
+		c.idFiles[id] = file
+	}
+	for _, child := range n.children {
+		if child.name != "" {
+			c.markIDs(child, file)
+		}
+	}
+}
+
+// renderBlocks отрисовывает блочные элементы; depth — уровень вложенности секций,
+// toc — куда добавлять секции с заголовками (nil — не добавлять)
+func (c *converter) renderBlocks(nodes []*node, depth int, toc *[]*tocEntry) string {
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(c.renderBlock(n, depth, toc))
+	}
+	return sb.String()
+}
+
+// renderBlock отрисовывает один блочный элемент FB2 в XHTML
+func (c *converter) renderBlock(n *node, depth int, toc *[]*tocEntry) string {
+	switch n.name {
+	case "":
+		// Текст между блоками — только пробелы
+		return ""
+	case "section":
+		return c.renderSection(n, depth, toc)
+	case "title":
+		level := depth
+		if level > 6 {
+			level = 6
+		}
+		return fmt.Sprintf(`<h%d class="title"%s>%s</h%d>`, level, idAttr(n), c.renderTitleLines(n), level)
+	case "p":
+		return "<p" + idAttr(n) + ">" + c.renderInline(n.children) + "</p>"
+	case "subtitle":
+		return `<p class="subtitle"` + idAttr(n) + ">" + c.renderInline(n.children) + "</p>"
+	case "text-author":
+		return `<p class="text-author"` + idAttr(n) + ">" + c.renderInline(n.children) + "</p>"
+	case "date":
+		return `<p class="date">` + c.renderInline(n.children) + "</p>"
+	case "v":
+		return `<p class="v"` + idAttr(n) + ">" + c.renderInline(n.children) + "</p>"
+	case "empty-line":
+		return `<p class="empty-line">&#160;</p>`
+	case "image":
+		return `<div class="image"` + idAttr(n) + ">" + c.renderImage(n) + "</div>"
+	case "epigraph":
+		return `<div class="epigraph"` + idAttr(n) + ">" + c.renderBlocks(n.children, depth, nil) + "</div>"
+	case "annotation":
+		return `<div class="annotation"` + idAttr(n) + ">" + c.renderBlocks(n.children, depth, nil) + "</div>"
+	case "cite":
+		return `<blockquote class="cite"` + idAttr(n) + ">" + c.renderBlocks(n.children, depth, nil) + "</blockquote>"
+	case "poem":
+		return `<div class="poem"` + idAttr(n) + ">" + c.renderPoem(n, depth) + "</div>"
+	case "stanza":
+		return `<div class="stanza">` + c.renderPoem(n, depth) + "</div>"
+	case "table":
+		return c.renderTable(n)
+	}
+	// Неизвестный элемент: выводим содержимое
+	return c.renderBlocks(n.children, depth, toc)
+}
+
+// renderSection отрисовывает секцию и добавляет её в оглавление
+func (c *converter) renderSection(n *node, depth int, toc *[]*tocEntry) string {
+	id := n.attr("id")
+	title := n.child("title").plainText()
+
+	var children *[]*tocEntry
+	var entry *tocEntry
+	if toc != nil {
+		children = toc
+		if title != "" {
+			if id == "" {
+				c.anchors++
+				id = fmt.Sprintf("toc-%d", c.anchors)
+			}
+			entry = &tocEntry{title: title}
+			*toc = append(*toc, entry)
+			children = &entry.children
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<div class="section"`)
+	if id != "" {
+		sb.WriteString(` id="` + esc(safeID(id)) + `"`)
+	}
+	sb.WriteString(">")
+	// Заголовок секции на уровень ниже заголовка тела, вложенные секции — ещё ниже
+	for _, child := range n.children {
+		sb.WriteString(c.renderBlock(child, depth+1, children))
+	}
+	sb.WriteString("</div>")
+
+	if entry != nil {
+		entry.href = c.file + "#" + safeID(id)
+	}
+	return sb.String()
+}
+
+// renderTitleLines отрисовывает строки заголовка через перенос
+func (c *converter) renderTitleLines(n *node) string {
+	var lines []string
+	for _, child := range n.children {
+		switch child.name {
+		case "p":
+			lines = append(lines, c.renderInline(child.children))
+		case "empty-line":
+			lines = append(lines, "")
+		}
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+// renderPoem отрисовывает стихотворение или строфу
+func (c *converter) renderPoem(n *node, depth int) string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		switch child.name {
+		case "title":
+			sb.WriteString(`<p class="poem-title">` + c.renderTitleLines(child) + "</p>")
+		case "":
+		default:
+			sb.WriteString(c.renderBlock(child, depth, nil))
+		}
+	}
+	return sb.String()
+}
+
+// renderTable отрисовывает таблицу
+func (c *converter) renderTable(n *node) string {
+	var sb strings.Builder
+	sb.WriteString("<table" + idAttr(n) + ">")
+	for _, tr := range n.childrenNamed("tr") {
+		sb.WriteString("<tr>")
+		for _, cell := range tr.children {
+			if cell.name != "th" && cell.name != "td" {
+				continue
+			}
+			sb.WriteString("<" + cell.name)
+			for _, a := range []string{"colspan", "rowspan"} {
+				if v := cell.attr(a); v != "" {
+					sb.WriteString(" " + a + `="` + esc(v) + `"`)
+				}
+			}
+			sb.WriteString(">" + c.renderInline(cell.children) + "</" + cell.name + ">")
+		}
+		sb.WriteString("</tr>")
+	}
+	sb.WriteString("</table>")
+	return sb.String()
+}
+
+// inlineTags — строчные элементы FB2 и соответствующие им теги XHTML
+var inlineTags = map[string]string{
+	"strong":        "strong",
+	"emphasis":      "em",
+	"strikethrough": "del",
+	"sub":           "sub",
+	"sup":           "sup",
+	"code":          "code",
+	"style":         "span",
+}
+
+// renderInline отрисовывает содержимое абзаца
+func (c *converter) renderInline(nodes []*node) string {
+	var sb strings.Builder
+	for _, n := range nodes {
+		switch n.name {
+		case "":
+			sb.WriteString(esc(n.text))
+		case "a":
+			sb.WriteString(c.renderLink(n))
+		case "image":
+			sb.WriteString(c.renderImage(n))
+		default:
+			if tag, ok := inlineTags[n.name]; ok {
+				sb.WriteString("<" + tag + ">" + c.renderInline(n.children) + "</" + tag + ">")
+			} else {
+				sb.WriteString(c.renderInline(n.children))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// renderLink отрисовывает ссылку; внутренние ссылки ведут в файл, где лежит цель
+func (c *converter) renderLink(n *node) string {
+	href := n.attr("href")
+	attrs := ""
+	if target, ok := strings.CutPrefix(href, "#"); ok {
+		href = c.idFiles[target] + "#" + safeID(target)
+		if n.attr("type") == "note" {
+			attrs = ` epub:type="noteref" class="note"`
+		}
+	}
+	return `<a href="` + esc(href) + `"` + attrs + ">" + c.renderInline(n.children) + "</a>"
+}
+
+// renderImage отрисовывает картинку из binary
+func (c *converter) renderImage(n *node) string {
+	img := c.images[strings.TrimPrefix(n.attr("href"), "#")]
+	if img == nil {
+		return ""
+	}
+	return `<img src="` + esc(img.file) + `" alt="` + esc(n.attr("alt")) + `"/>`
+}
+
+// write упаковывает книгу в EPUB
+func (c *converter) write(w io.Writer, meta bookMeta, opts Options) error {
+	zw := zip.NewWriter(w)
+
+	// mimetype — первым, без сжатия и без дескриптора данных, этого требует формат
+	mimetype := []byte("application/epub+zip")
+	mw, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		Modified:           opts.Modified,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка записи EPUB: %w", err)
+	}
+	if _, err := mw.Write(mimetype); err != nil {
+		return fmt.Errorf("ошибка записи EPUB: %w", err)
+	}
+
+	type epubFile struct {
+		name string
+		data string
+	}
+	files := []epubFile{
+		{"META-INF/container.xml", containerXML},
+		{"OEBPS/content.opf", c.packageOPF(meta, opts)},
+		{"OEBPS/nav.xhtml", c.navXHTML(meta)},
+		{"OEBPS/toc.ncx", c.tocNCX(meta, opts)},
+		{"OEBPS/style.css", styleCSS},
+	}
+	if meta.cover != nil {
+		files = append(files, epubFile{"OEBPS/cover.xhtml", xhtmlPage(meta.lang, meta.title,
+			`<div class="cover"><img src="`+esc(meta.cover.file)+`" alt="`+esc(meta.title)+`"/></div>`)})
+	}
+	for _, page := range c.pages {
+		files = append(files, epubFile{"OEBPS/" + page.file, xhtmlPage(meta.lang, page.title, page.body)})
+	}
+	for _, id := range c.order {
+		img := c.images[id]
+		files = append(files, epubFile{"OEBPS/" + img.file, string(img.data)})
+	}
+
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: opts.Modified})
+		if err != nil {
+			return fmt.Errorf("ошибка записи EPUB: %w", err)
+		}
+		if _, err := io.WriteString(fw, f.data); err != nil {
+			return fmt.Errorf("ошибка записи EPUB: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("ошибка записи EPUB: %w", err)
+	}
+	return nil
+}
+
+// packageOPF формирует content.opf
+func (c *converter) packageOPF(meta bookMeta, opts Options) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+`)
+	sb.WriteString(`<dc:identifier id="bookid">urn:turanga:` + esc(opts.ID) + "</dc:identifier>\n")
+	sb.WriteString("<dc:title>" + esc(meta.title) + "</dc:title>\n")
+	for _, author := range meta.authors {
+		sb.WriteString("<dc:creator>" + esc(author) + "</dc:creator>\n")
+	}
+	sb.WriteString("<dc:language>" + esc(meta.lang) + "</dc:language>\n")
+	if meta.description != "" {
+		sb.WriteString("<dc:description>" + esc(meta.description) + "</dc:description>\n")
+	}
+	sb.WriteString(`<meta property="dcterms:modified">` + opts.Modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	if meta.series != "" {
+		// Серия в формате Calibre (понимают Kobo, PocketBook, KOReader) и EPUB 3
+		sb.WriteString(`<meta name="calibre:series" content="` + esc(meta.series) + `"/>` + "\n")
+		if meta.seriesNumber != "" {
+			sb.WriteString(`<meta name="calibre:series_index" content="` + esc(meta.seriesNumber) + `"/>` + "\n")
+		}
+		sb.WriteString(`<meta property="belongs-to-collection" id="series">` + esc(meta.series) + "</meta>\n")
+		sb.WriteString(`<meta refines="#series" property="collection-type">series</meta>` + "\n")
+		if meta.seriesNumber != "" {
+			sb.WriteString(`<meta refines="#series" property="group-position">` + esc(meta.seriesNumber) + "</meta>\n")
+		}
+	}
+	if meta.cover != nil {
+		sb.WriteString(`<meta name="cover" content="` + itemID(meta.cover.file) + `"/>` + "\n")
+	}
+	sb.WriteString("</metadata>\n<manifest>\n")
+	sb.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	sb.WriteString(`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>` + "\n")
+	sb.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	if meta.cover != nil {
+		sb.WriteString(`<item id="cover-page" href="cover.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+	}
+	for _, page := range c.pages {
+		sb.WriteString(`<item id="` + itemID(page.file) + `" href="` + esc(page.file) + `" media-type="application/xhtml+xml"/>` + "\n")
+	}
+	for _, id := range c.order {
+		img := c.images[id]
+		properties := ""
+		if img == meta.cover {
+			properties = ` properties="cover-image"`
+		}
+		sb.WriteString(`<item id="` + itemID(img.file) + `" href="` + esc(img.file) + `" media-type="` + esc(img.mediaType) + `"` + properties + "/>\n")
+	}
+	sb.WriteString("</manifest>\n<spine toc=\"ncx\">\n")
+	if meta.cover != nil {
+		sb.WriteString(`<itemref idref="cover-page"/>` + "\n")
+	}
+	for _, page := range c.pages {
+		sb.WriteString(`<itemref idref="` + itemID(page.file) + `"/>` + "\n")
+	}
+	sb.WriteString("</spine>\n</package>\n")
+	return sb.String()
+}
+
+// navXHTML формирует оглавление EPUB 3
+func (c *converter) navXHTML(meta bookMeta) string {
+	var sb strings.Builder
+	var list func([]*tocEntry)
+	list = func(entries []*tocEntry) {
+		sb.WriteString("<ol>")
+		for _, e := range entries {
+			sb.WriteString(`<li><a href="` + esc(e.href) + `">` + esc(e.title) + "</a>")
+			if len(e.children) > 0 {
+				list(e.children)
+			}
+			sb.WriteString("</li>")
+		}
+		sb.WriteString("</ol>")
+	}
+	sb.WriteString(`<nav epub:type="toc" id="toc"><h1>Оглавление</h1>`)
+	list(c.toc)
+	sb.WriteString("</nav>")
+	return xhtmlPage(meta.lang, "Оглавление", sb.String())
+}
+
+// tocNCX формирует оглавление EPUB 2 для ридеров, не знающих nav.xhtml
+func (c *converter) tocNCX(meta bookMeta, opts Options) string {
+	var sb strings.Builder
+	order := 0
+	var points func([]*tocEntry)
+	points = func(entries []*tocEntry) {
+		for _, e := range entries {
+			order++
+			sb.WriteString(fmt.Sprintf(`<navPoint id="np%d" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/>`,
+				order, order, esc(e.title), esc(e.href)))
+			points(e.children)
+			sb.WriteString("</navPoint>\n")
+		}
+	}
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="urn:turanga:` + esc(opts.ID) + `"/></head>
+<docTitle><text>` + esc(meta.title) + "</text></docTitle>\n<navMap>\n")
+	points(c.toc)
+	sb.WriteString("</navMap>\n</ncx>\n")
+	return sb.String()
+}
+
+// xhtmlPage оборачивает тело в документ XHTML
+func xhtmlPage(lang, title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + esc(lang) + `" lang="` + esc(lang) + `">
+<head><meta charset="UTF-8"/><title>` + esc(title) + `</title><link rel="stylesheet" type="text/css" href="style.css"/></head>
+<body>` + body + "</body>\n</html>\n"
+}
+
+// idAttr возвращает атрибут id элемента для XHTML или пустую строку
+func idAttr(n *node) string {
+	if id := n.attr("id"); id != "" {
+		return ` id="` + esc(safeID(id)) + `"`
+	}
+	return ""
+}
+
+// safeID приводит id из FB2 к допустимому в XHTML виду
+func safeID(id string) string {
+	var sb strings.Builder
+	for i, r := range id {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+			sb.WriteRune(r)
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+			sb.WriteRune(r)
+		case i == 0:
+			sb.WriteString("id_")
+			if unicode.IsDigit(r) || r == '-' || r == '.' {
+				sb.WriteRune(r)
+			}
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// safeName приводит id картинки к имени файла
+func safeName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_') {
+			return r
+		}
+		return '_'
+	}, id)
+	if name == "" || name[0] == '.' {
+		name = "image" + name
+	}
+	return name
+}
+
+// itemID возвращает id элемента manifest для файла
+func itemID(file string) string {
+	return "item-" + strings.NewReplacer("/", "-", ".", "-").Replace(safeName(file))
+}
+
+// imageExt возвращает расширение файла для типа картинки
+func imageExt(mediaType string) string {
+	switch mediaType {
+	case "image/jpeg", "image/jpg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	return ""
+}
+
+// esc экранирует текст для XML
+func esc(s string) string {
+	return html.EscapeString(s)
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+
+const styleCSS = `body { margin: 0 0.5em; }
+p { margin: 0; text-indent: 1.5em; text-align: justify; }
+h1, h2, h3, h4, h5, h6 { text-align: center; margin: 1em 0 0.5em; page-break-after: avoid; }
+.title-page { text-align: center; margin-top: 20%; }
+.title-page p { text-indent: 0; text-align: center; }
+.authors { font-size: 1.2em; margin-bottom: 1em; }
+.annotation { margin: 2em 1em 0; font-size: 0.9em; text-align: justify; }
+.subtitle { text-align: center; font-weight: bold; text-indent: 0; margin: 0.5em 0; }
+.empty-line { height: 1em; }
+.epigraph { margin: 1em 0 1em 30%; font-style: italic; }
+.cite { margin: 1em 2em; }
+.poem { margin: 1em 0 1em 2em; }
+.stanza { margin-bottom: 0.8em; }
+.v, .poem-title { text-indent: 0; text-align: left; }
+.text-author { text-align: right; font-style: italic; text-indent: 0; }
+.date { text-align: right; text-indent: 0; }
+.image, .cover { text-align: center; margin: 0.5em 0; }
+.image img, .cover img { max-width: 100%; max-height: 100%; }
+.section { page-break-before: auto; }
+a.note { vertical-align: super; font-size: 0.75em; text-decoration: none; }
+table { border-collapse: collapse; margin: 0.5em auto; }
+td, th { border: 1px solid #888; padding: 0.2em 0.4em; }
+`
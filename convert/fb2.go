@@ -0,0 +1,179 @@
+// convert/fb2.go
+package convert
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// node — элемент документа FB2 или кусок текста (у текста пустое имя)
+type node struct {
+	name     string
+	attrs    map[string]string // Атрибуты по локальному имени: l:href и xlink:href — просто href
+	text     string
+	children []*node
+}
+
+// attr возвращает значение атрибута или пустую строку
+func (n *node) attr(name string) string {
+	return n.attrs[name]
+}
+
+// child возвращает первый дочерний элемент с именем name
+func (n *node) child(name string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// childrenNamed возвращает дочерние элементы с именем name
+func (n *node) childrenNamed(name string) []*node {
+	if n == nil {
+		return nil
+	}
+	var found []*node
+	for _, c := range n.children {
+		if c.name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// plainText возвращает текст элемента без разметки, пробелы схлопываются
+func (n *node) plainText() string {
+	if n == nil {
+		return ""
+	}
+	var sb strings.Builder
+	var walk func(*node)
+	walk = func(n *node) {
+		if n.name == "" {
+			sb.WriteString(n.text)
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+		// Абзацы заголовка разделяем пробелом
+		if n.name == "p" || n.name == "v" {
+			sb.WriteString(" ")
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// parseFB2 разбирает документ FB2 в дерево и возвращает корневой элемент FictionBook
+func parseFB2(r io.Reader) (*node, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+
+	root := &node{}
+	stack := []*node{root}
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора FB2: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			// Закрываем до элемента с тем же именем: в нестрогом режиме теги бывают не парными
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == t.Name.Local {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &node{text: string(t)})
+		}
+	}
+
+	fb := root.child("FictionBook")
+	if fb == nil {
+		return nil, fmt.Errorf("в файле нет элемента FictionBook")
+	}
+	return fb, nil
+}
+
+// charsetReader перекодирует в UTF-8 однобайтовые кодировки, встречающиеся в FB2
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "windows-1251", "cp1251", "win-1251":
+		return charmap.Windows1251.NewDecoder().Reader(input), nil
+	case "koi8-r", "koi8r":
+		return charmap.KOI8R.NewDecoder().Reader(input), nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder().Reader(input), nil
+	case "iso-8859-1", "latin1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "iso-8859-5":
+		return charmap.ISO8859_5.NewDecoder().Reader(input), nil
+	case "utf8":
+		return input, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемая кодировка %s", label)
+}
+
+// openFB2 открывает документ FB2: обычный файл или первый .fb2 в архиве fb2.zip
+func openFB2(filePath, fileType string) (io.ReadCloser, error) {
+	switch fileType {
+	case "fb2":
+		return os.Open(filePath)
+	case "fb2.zip":
+		archive, err := zip.OpenReader(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка открытия архива: %w", err)
+		}
+		for _, f := range archive.File {
+			if strings.HasSuffix(strings.ToLower(f.Name), ".fb2") {
+				rc, err := f.Open()
+				if err != nil {
+					archive.Close()
+					return nil, fmt.Errorf("ошибка чтения %s из архива: %w", f.Name, err)
+				}
+				return &zipEntry{ReadCloser: rc, archive: archive}, nil
+			}
+		}
+		archive.Close()
+		return nil, fmt.Errorf("fb2 файл не найден в архиве")
+	}
+	return nil, fmt.Errorf("формат %s не конвертируется в EPUB", fileType)
+}
+
+// zipEntry закрывает вместе с файлом и сам архив
+type zipEntry struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (z *zipEntry) Close() error {
+	z.ReadCloser.Close()
+	return z.archive.Close()
+}
@@ -0,0 +1,208 @@
+// generation/cache.go
+package generation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// Кэш ответов для лент OPDS и чтения через API. Каждый ответ получает ETag
+// (номер изменения + вариант запроса) и Last-Modified (время последнего изменения
+// библиотеки); на If-None-Match / If-Modified-Since отвечаем 304 без запросов к базе.
+// Тела успешных ответов хранятся в памяти до следующего изменения библиотеки.
+
+const (
+	maxEntries   = 512     // Сколько ответов хранить одновременно
+	maxEntrySize = 4 << 20 // Ответы больше этого размера не кэшируются
+)
+
+// entry — сохранённый ответ
+type entry struct {
+	header http.Header
+	body   []byte
+}
+
+// responseCache — ответы для одного номера изменения библиотеки
+type responseCache struct {
+	mu         sync.Mutex
+	generation int64
+	entries    map[string]*entry
+}
+
+var responses = &responseCache{entries: make(map[string]*entry)}
+
+// get возвращает сохранённый ответ; при смене номера изменения кэш очищается
+func (c *responseCache) get(generation int64, key string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		c.generation = generation
+		c.entries = make(map[string]*entry)
+		return nil
+	}
+	return c.entries[key]
+}
+
+// put сохраняет ответ, если номер изменения не поменялся, пока он готовился
+func (c *responseCache) put(generation int64, key string, e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if len(c.entries) >= maxEntries {
+		c.entries = make(map[string]*entry)
+	}
+	c.entries[key] = e
+}
+
+// recorder передаёт ответ клиенту и копит тело для кэша
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(p) > maxEntrySize {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Cache оборачивает обработчик GET-запросов условными ответами и кэшем.
+// Если заданы prefixes, кэшируются только пути с этими префиксами, остальные
+// запросы передаются обработчику как есть. Обработчик вызывается после проверки
+// входа, поэтому ответы разных пользователей хранятся раздельно.
+func Cache(db *sql.DB, next http.HandlerFunc, prefixes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig()
+
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !hasPrefix(r.URL.Path, prefixes) {
+			next(w, r)
+			return
+		}
+
+		stamp, err := Current(db)
+		if err != nil {
+			log.Printf("Кэш ответов: %v", err)
+			next(w, r)
+			return
+		}
+
+		key := variantKey(r)
+		etag := fmt.Sprintf(`W/"%d-%s"`, stamp.Value, key[:12])
+		cached := responses.get(stamp.Value, key)
+		if cached != nil && cached.header.Get("ETag") != "" {
+			// Обработчик мог поставить свой ETag (API) — сравниваем с ним
+			etag = cached.header.Get("ETag")
+		}
+
+		header := w.Header()
+		header.Set("ETag", etag)
+		header.Set("Last-Modified", stamp.Modified.Format(http.TimeFormat))
+		header.Set("Cache-Control", "private, no-cache")
+		header.Add("Vary", "Accept, Authorization, Cookie")
+
+		if notModified(r, etag, stamp.Modified) {
+			if cfg.Debug {
+				log.Printf("Кэш ответов: 304 для %s", r.URL.RequestURI())
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if cached != nil {
+			if cfg.Debug {
+				log.Printf("Кэш ответов: ответ для %s из кэша", r.URL.RequestURI())
+			}
+			for name, values := range cached.header {
+				header[name] = values
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(cached.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == http.StatusOK && !rec.overflow && r.Method == http.MethodGet {
+			stored := header.Clone()
+			stored.Del("Set-Cookie")
+			responses.put(stamp.Value, key, &entry{header: stored, body: rec.body.Bytes()})
+		}
+	}
+}
+
+// hasPrefix проверяет путь по списку префиксов; пустой список пропускает всё
+func hasPrefix(path string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// variantKey описывает вариант ответа: пользователь, адрес и формат (Accept)
+func variantKey(r *http.Request) string {
+	var who string
+	if user := users.FromContext(r.Context()); user != nil {
+		who = fmt.Sprintf("%d:%s", user.ID, user.Role)
+	}
+	// RequestURI — исходный адрес: у /opds2/... путь в r.URL уже без префикса
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	sum := sha256.Sum256([]byte(who + "\n" + uri + "\n" + r.Header.Get("Accept")))
+	return hex.EncodeToString(sum[:])
+}
+
+// notModified проверяет условные заголовки запроса. If-None-Match важнее If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
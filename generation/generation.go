@@ -0,0 +1,53 @@
+// generation/generation.go
+package generation
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Счётчик изменений библиотеки. Таблица library_generation (миграция 12) хранит
+// номер изменения и время последней записи; триггеры увеличивают номер при любой
+// записи в таблицы каталога (книги, авторы, теги, произведения). По счётчику
+// строятся ETag и Last-Modified ответов и сбрасывается кэш ответов (см. cache.go).
+
+// Stamp — состояние библиотеки: номер изменения и время последней записи
+type Stamp struct {
+	Value    int64
+	Modified time.Time
+}
+
+// lastModified — время последнего изменения, прочитанное из базы (UNIX timestamp)
+var lastModified atomic.Int64
+
+// Current возвращает текущее состояние библиотеки
+func Current(db *sql.DB) (Stamp, error) {
+	var value, modified int64
+	err := db.QueryRow("SELECT value, modified_at FROM library_generation WHERE id = 1").Scan(&value, &modified)
+	if err != nil {
+		return Stamp{}, fmt.Errorf("ошибка чтения счётчика изменений: %w", err)
+	}
+	lastModified.Store(modified)
+	return Stamp{Value: value, Modified: time.Unix(modified, 0).UTC()}, nil
+}
+
+// Touch увеличивает счётчик вручную — для изменений вне таблиц каталога,
+// например аннотаций, которые хранятся в файлах
+func Touch(db *sql.DB) error {
+	_, err := db.Exec("UPDATE library_generation SET value = value + 1, modified_at = ? WHERE id = 1", time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("ошибка обновления счётчика изменений: %w", err)
+	}
+	return nil
+}
+
+// LastModified возвращает время последнего известного изменения библиотеки
+// для полей updated в лентах. Пока счётчик ни разу не читался — текущее время.
+func LastModified() time.Time {
+	if modified := lastModified.Load(); modified > 0 {
+		return time.Unix(modified, 0).UTC()
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,367 @@
+// genres/genres.go
+package genres
+
+import (
+	"strings"
+)
+
+// Жанры книг по классификатору FB2: в файле fb2 жанр записан кодом (sf_fantasy,
+// det_classic), коды собраны в разделы (фантастика, детективы, проза). Жанры — отдельная
+// от тегов классификация: теги свободные и правятся вручную, жанры берутся из файла.
+// В EPUB жанров нет, но dc:subject часто содержит их название — оно сопоставляется
+// с кодом FB2 там, где это возможно (FromSubject).
+
+// Genre — жанр классификатора FB2
+type Genre struct {
+	Code   string
+	Name   string
+	NameEn string
+	Group  string // Код раздела
+}
+
+// Group — раздел классификатора
+type Group struct {
+	Code   string
+	Name   string
+	NameEn string
+	Genres []Genre
+}
+
+// Tree — разделы и жанры в порядке показа
+var Tree = []Group{
+	{"sf", "Фантастика", "Science Fiction & Fantasy", []Genre{
+		{Code: "sf", Name: "Научная фантастика", NameEn: "Science fiction"},
+		{Code: "sf_fantasy", Name: "Фэнтези", NameEn: "Fantasy"},
+		{Code: "sf_fantasy_city", Name: "Городское фэнтези", NameEn: "Urban fantasy"},
+		{Code: "sf_history", Name: "Альтернативная история", NameEn: "Alternative history"},
+		{Code: "sf_action", Name: "Боевая фантастика", NameEn: "Action science fiction"},
+		{Code: "sf_epic", Name: "Эпическая фантастика", NameEn: "Epic science fiction"},
+		{Code: "sf_heroic", Name: "Героическая фантастика", NameEn: "Heroic science fiction"},
+		{Code: "sf_detective", Name: "Детективная фантастика", NameEn: "Detective science fiction"},
+		{Code: "sf_cyberpunk", Name: "Киберпанк", NameEn: "Cyberpunk"},
+		{Code: "sf_space", Name: "Космическая фантастика", NameEn: "Space opera"},
+		{Code: "sf_social", Name: "Социально-философская фантастика", NameEn: "Social science fiction"},
+		{Code: "sf_postapocalyptic", Name: "Постапокалипсис", NameEn: "Post-apocalyptic"},
+		{Code: "sf_horror", Name: "Ужасы и мистика", NameEn: "Horror"},
+		{Code: "sf_humor", Name: "Юмористическая фантастика", NameEn: "Humorous science fiction"},
+		{Code: "sf_etc", Name: "Фантастика: прочее", NameEn: "Misc science fiction"},
+	}},
+	{"detective", "Детективы и триллеры", "Detectives & Thrillers", []Genre{
+		{Code: "detective", Name: "Детектив", NameEn: "Detective"},
+		{Code: "det_classic", Name: "Классический детектив", NameEn: "Classical detective"},
+		{Code: "det_police", Name: "Полицейский детектив", NameEn: "Police procedural"},
+		{Code: "det_action", Name: "Боевик", NameEn: "Action"},
+		{Code: "det_irony", Name: "Иронический детектив", NameEn: "Ironical detective"},
+		{Code: "det_history", Name: "Исторический детектив", NameEn: "Historical detective"},
+		{Code: "det_espionage", Name: "Шпионский детектив", NameEn: "Espionage"},
+		{Code: "det_crime", Name: "Криминальный детектив", NameEn: "Crime"},
+		{Code: "det_political", Name: "Политический детектив", NameEn: "Political detective"},
+		{Code: "det_maniac", Name: "Маньяки", NameEn: "Maniacs"},
+		{Code: "det_hard", Name: "Крутой детектив", NameEn: "Hard-boiled"},
+		{Code: "thriller", Name: "Триллер", NameEn: "Thriller"},
+	}},
+	{"prose", "Проза", "Prose", []Genre{
+		{Code: "prose_classic", Name: "Классическая проза", NameEn: "Classics"},
+		{Code: "prose_history", Name: "Историческая проза", NameEn: "Historical fiction"},
+		{Code: "prose_contemporary", Name: "Современная проза", NameEn: "Contemporary fiction"},
+		{Code: "prose_counter", Name: "Контркультура", NameEn: "Counterculture"},
+		{Code: "prose_rus_classic", Name: "Русская классическая проза", NameEn: "Russian classics"},
+		{Code: "prose_su_classics", Name: "Советская классическая проза", NameEn: "Soviet classics"},
+		{Code: "prose_military", Name: "Военная проза", NameEn: "War fiction"},
+	}},
+	{"love", "Любовные романы", "Romance", []Genre{
+		{Code: "love_contemporary", Name: "Современные любовные романы", NameEn: "Contemporary romance"},
+		{Code: "love_history", Name: "Исторические любовные романы", NameEn: "Historical romance"},
+		{Code: "love_detective", Name: "Остросюжетные любовные романы", NameEn: "Romantic suspense"},
+		{Code: "love_short", Name: "Короткие любовные романы", NameEn: "Short romance"},
+		{Code: "love_sf", Name: "Любовное фэнтези", NameEn: "Fantasy romance"},
+		{Code: "love_erotica", Name: "Эротика", NameEn: "Erotica"},
+	}},
+	{"adventure", "Приключения", "Adventure", []Genre{
+		{Code: "adventure", Name: "Приключения", NameEn: "Adventure"},
+		{Code: "adv_western", Name: "Вестерн", NameEn: "Western"},
+		{Code: "adv_history", Name: "Исторические приключения", NameEn: "Historical adventure"},
+		{Code: "adv_indian", Name: "Приключения про индейцев", NameEn: "Indians"},
+		{Code: "adv_maritime", Name: "Морские приключения", NameEn: "Sea adventure"},
+		{Code: "adv_geo", Name: "Путешествия и география", NameEn: "Travel & geography"},
+		{Code: "adv_animal", Name: "Природа и животные", NameEn: "Nature & animals"},
+	}},
+	{"children", "Детское", "Children's", []Genre{
+		{Code: "children", Name: "Детская литература", NameEn: "Children's literature"},
+		{Code: "child_tale", Name: "Сказки", NameEn: "Fairy tales"},
+		{Code: "child_verse", Name: "Детские стихи", NameEn: "Children's verse"},
+		{Code: "child_prose", Name: "Детская проза", NameEn: "Children's prose"},
+		{Code: "child_sf", Name: "Детская фантастика", NameEn: "Children's science fiction"},
+		{Code: "child_det", Name: "Детские остросюжетные", NameEn: "Children's mystery"},
+		{Code: "child_adv", Name: "Детские приключения", NameEn: "Children's adventure"},
+		{Code: "child_education", Name: "Детская образовательная литература", NameEn: "Children's education"},
+	}},
+	{"poetry", "Поэзия и драматургия", "Poetry & Drama", []Genre{
+		{Code: "poetry", Name: "Поэзия", NameEn: "Poetry"},
+		{Code: "dramaturgy", Name: "Драматургия", NameEn: "Drama"},
+	}},
+	{"antique", "Старинное", "Antique literature", []Genre{
+		{Code: "antique", Name: "Старинная литература", NameEn: "Antique literature"},
+		{Code: "antique_ant", Name: "Античная литература", NameEn: "Ancient literature"},
+		{Code: "antique_european", Name: "Европейская старинная литература", NameEn: "European antique literature"},
+		{Code: "antique_russian", Name: "Древнерусская литература", NameEn: "Old Russian literature"},
+		{Code: "antique_east", Name: "Древневосточная литература", NameEn: "Old Eastern literature"},
+		{Code: "antique_myths", Name: "Мифы. Легенды. Эпос", NameEn: "Myths, legends, epic"},
+	}},
+	{"science", "Наука и образование", "Science & Education", []Genre{
+		{Code: "science", Name: "Научная литература", NameEn: "Science"},
+		{Code: "sci_history", Name: "История", NameEn: "History"},
+		{Code: "sci_psychology", Name: "Психология", NameEn: "Psychology"},
+		{Code: "sci_culture", Name: "Культурология", NameEn: "Cultural studies"},
+		{Code: "sci_religion", Name: "Религиоведение", NameEn: "Religious studies"},
+		{Code: "sci_philosophy", Name: "Философия", NameEn: "Philosophy"},
+		{Code: "sci_politics", Name: "Политика", NameEn: "Politics"},
+		{Code: "sci_business", Name: "Деловая литература", NameEn: "Business"},
+		{Code: "sci_juris", Name: "Юриспруденция", NameEn: "Law"},
+		{Code: "sci_linguistic", Name: "Языкознание", NameEn: "Linguistics"},
+		{Code: "sci_medicine", Name: "Медицина", NameEn: "Medicine"},
+		{Code: "sci_phys", Name: "Физика", NameEn: "Physics"},
+		{Code: "sci_math", Name: "Математика", NameEn: "Mathematics"},
+		{Code: "sci_chem", Name: "Химия", NameEn: "Chemistry"},
+		{Code: "sci_biology", Name: "Биология", NameEn: "Biology"},
+		{Code: "sci_tech", Name: "Технические науки", NameEn: "Technology"},
+	}},
+	{"computers", "Компьютеры и интернет", "Computers & Internet", []Genre{
+		{Code: "computers", Name: "Компьютеры", NameEn: "Computers"},
+		{Code: "comp_www", Name: "Интернет", NameEn: "Internet"},
+		{Code: "comp_programming", Name: "Программирование", NameEn: "Programming"},
+		{Code: "comp_hard", Name: "Компьютерное железо", NameEn: "Hardware"},
+		{Code: "comp_soft", Name: "Программы", NameEn: "Software"},
+		{Code: "comp_db", Name: "Базы данных", NameEn: "Databases"},
+		{Code: "comp_osnet", Name: "ОС и сети", NameEn: "Operating systems & networking"},
+	}},
+	{"reference", "Справочная литература", "Reference", []Genre{
+		{Code: "reference", Name: "Справочная литература", NameEn: "Reference"},
+		{Code: "ref_encyc", Name: "Энциклопедии", NameEn: "Encyclopedias"},
+		{Code: "ref_dict", Name: "Словари", NameEn: "Dictionaries"},
+		{Code: "ref_ref", Name: "Справочники", NameEn: "Handbooks"},
+		{Code: "ref_guide", Name: "Руководства", NameEn: "Guidebooks"},
+	}},
+	{"nonfiction", "Документальная литература", "Nonfiction", []Genre{
+		{Code: "nonfiction", Name: "Документальная литература", NameEn: "Nonfiction"},
+		{Code: "nonf_biography", Name: "Биографии и мемуары", NameEn: "Biography & memoirs"},
+		{Code: "nonf_publicism", Name: "Публицистика", NameEn: "Essays & journalism"},
+		{Code: "nonf_criticism", Name: "Критика", NameEn: "Criticism"},
+		{Code: "design", Name: "Искусство и дизайн", NameEn: "Art & design"},
+	}},
+	{"religion", "Религия и духовность", "Religion & Spirituality", []Genre{
+		{Code: "religion", Name: "Религиозная литература", NameEn: "Religion & spirituality"},
+		{Code: "religion_rel", Name: "Религия", NameEn: "Religion"},
+		{Code: "religion_esoterics", Name: "Эзотерика", NameEn: "Esoterics"},
+		{Code: "religion_self", Name: "Самосовершенствование", NameEn: "Self-improvement"},
+	}},
+	{"humor", "Юмор", "Humor", []Genre{
+		{Code: "humor", Name: "Юмор", NameEn: "Humor"},
+		{Code: "humor_anecdote", Name: "Анекдоты", NameEn: "Jokes"},
+		{Code: "humor_prose", Name: "Юмористическая проза", NameEn: "Humorous prose"},
+		{Code: "humor_verse", Name: "Юмористические стихи", NameEn: "Humorous verse"},
+	}},
+	{"home", "Дом и семья", "Home & Family", []Genre{
+		{Code: "home", Name: "Дом и семья", NameEn: "Home & family"},
+		{Code: "home_cooking", Name: "Кулинария", NameEn: "Cooking"},
+		{Code: "home_pets", Name: "Домашние животные", NameEn: "Pets"},
+		{Code: "home_crafts", Name: "Хобби и ремёсла", NameEn: "Crafts & hobbies"},
+		{Code: "home_entertain", Name: "Развлечения", NameEn: "Entertainment"},
+		{Code: "home_health", Name: "Здоровье", NameEn: "Health"},
+		{Code: "home_garden", Name: "Сад и огород", NameEn: "Gardening"},
+		{Code: "home_diy", Name: "Сделай сам", NameEn: "Do it yourself"},
+		{Code: "home_sport", Name: "Спорт", NameEn: "Sports"},
+		{Code: "home_sex", Name: "Эротика и секс", NameEn: "Sex & relationships"},
+	}},
+}
+
+// aliases — коды из старых версий классификатора и библиотек fb2, которые
+// соответствуют жанру из Tree
+var aliases = map[string]string{
+	"fantasy":              "sf_fantasy",
+	"sf_fantasy_irony":     "sf_humor",
+	"horror":               "sf_horror",
+	"foreign_sf":           "sf",
+	"foreign_fantasy":      "sf_fantasy",
+	"foreign_detective":    "detective",
+	"foreign_action":       "det_action",
+	"foreign_prose":        "prose_contemporary",
+	"foreign_contemporary": "prose_contemporary",
+	"foreign_love":         "love_contemporary",
+	"foreign_adventure":    "adventure",
+	"foreign_children":     "children",
+	"foreign_poetry":       "poetry",
+	"foreign_dramaturgy":   "dramaturgy",
+	"foreign_antique":      "antique",
+	"foreign_humor":        "humor",
+	"foreign_publicism":    "nonf_publicism",
+	"foreign_language":     "sci_linguistic",
+	"foreign_edu":          "science",
+	"foreign_psychology":   "sci_psychology",
+	"foreign_business":     "sci_business",
+	"foreign_comp":         "computers",
+	"prose_rus_classics":   "prose_rus_classic",
+	"prose_su_classic":     "prose_su_classics",
+	"nonf_military":        "prose_military",
+	"history":              "sci_history",
+}
+
+// subjects — названия жанров в dc:subject книг EPUB, которые не совпадают
+// с названиями из Tree
+var subjects = map[string]string{
+	"science fiction":             "sf",
+	"sci-fi":                      "sf",
+	"фантастика":                  "sf",
+	"научная фантастика":          "sf",
+	"фэнтези":                     "sf_fantasy",
+	"фентези":                     "sf_fantasy",
+	"urban fantasy":               "sf_fantasy_city",
+	"alternate history":           "sf_history",
+	"dystopian":                   "sf_social",
+	"ужасы":                       "sf_horror",
+	"мистика":                     "sf_horror",
+	"детективы":                   "detective",
+	"mystery":                     "detective",
+	"mystery & detective":         "detective",
+	"crime fiction":               "det_crime",
+	"thrillers":                   "thriller",
+	"suspense":                    "thriller",
+	"триллеры":                    "thriller",
+	"боевики":                     "det_action",
+	"classic literature":          "prose_classic",
+	"классика":                    "prose_classic",
+	"literary fiction":            "prose_contemporary",
+	"литература":                  "prose_contemporary",
+	"проза":                       "prose_contemporary",
+	"war":                         "prose_military",
+	"romance":                     "love_contemporary",
+	"любовный роман":              "love_contemporary",
+	"любовные романы":             "love_contemporary",
+	"action & adventure":          "adventure",
+	"westerns":                    "adv_western",
+	"travel":                      "adv_geo",
+	"juvenile fiction":            "children",
+	"детская литература":          "children",
+	"fairy tales":                 "child_tale",
+	"стихи":                       "poetry",
+	"драма":                       "dramaturgy",
+	"plays":                       "dramaturgy",
+	"мифы":                        "antique_myths",
+	"mythology":                   "antique_myths",
+	"business & economics":        "sci_business",
+	"economics":                   "sci_business",
+	"medical":                     "sci_medicine",
+	"наука":                       "science",
+	"programming languages":       "comp_programming",
+	"биография":                   "nonf_biography",
+	"мемуары":                     "nonf_biography",
+	"biography & autobiography":   "nonf_biography",
+	"biography":                   "nonf_biography",
+	"memoir":                      "nonf_biography",
+	"публицистика":                "nonf_publicism",
+	"literary criticism":          "nonf_criticism",
+	"art":                         "design",
+	"body, mind & spirit":         "religion_esoterics",
+	"self-help":                   "religion_self",
+	"humour":                      "humor",
+	"cookbooks":                   "home_cooking",
+	"health & fitness":            "home_health",
+	"sports & recreation":         "home_sport",
+	"crafts & hobbies":            "home_crafts",
+	"foreign language study":      "sci_linguistic",
+	"language arts & disciplines": "sci_linguistic",
+	"political science":           "sci_politics",
+	"technology & engineering":    "sci_tech",
+	"nature":                      "adv_animal",
+	"true crime":                  "nonfiction",
+}
+
+var (
+	byCode  = make(map[string]*Genre)
+	byGroup = make(map[string]*Group)
+	byName  = make(map[string]string) // Название жанра в нижнем регистре → код
+)
+
+func init() {
+	for i := range Tree {
+		group := &Tree[i]
+		byGroup[group.Code] = group
+		for j := range group.Genres {
+			g := &group.Genres[j]
+			g.Group = group.Code
+			byCode[g.Code] = g
+			byName[strings.ToLower(g.Name)] = g.Code
+			byName[strings.ToLower(g.NameEn)] = g.Code
+		}
+	}
+}
+
+// Lookup возвращает жанр по коду
+func Lookup(code string) (Genre, bool) {
+	g, ok := byCode[code]
+	if !ok {
+		return Genre{}, false
+	}
+	return *g, true
+}
+
+// LookupGroup возвращает раздел по коду
+func LookupGroup(code string) (Group, bool) {
+	group, ok := byGroup[code]
+	if !ok {
+		return Group{}, false
+	}
+	return *group, true
+}
+
+// Codes возвращает коды жанров раздела
+func (group Group) Codes() []string {
+	codes := make([]string, len(group.Genres))
+	for i, g := range group.Genres {
+		codes[i] = g.Code
+	}
+	return codes
+}
+
+// Name возвращает название жанра по коду или сам код для неизвестного жанра
+func Name(code string) string {
+	if g, ok := byCode[code]; ok {
+		return g.Name
+	}
+	return code
+}
+
+// Normalize приводит код жанра из файла fb2 к коду из Tree. Для жанра не из
+// классификатора возвращается пустая строка.
+func Normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if _, ok := byCode[code]; ok {
+		return code
+	}
+	return aliases[code]
+}
+
+// FromSubject сопоставляет dc:subject книги EPUB с жанром. Тема бывает кодом fb2,
+// названием жанра («Фэнтези», «Science Fiction») или рубрикой BISAC
+// («FICTION / Fantasy / Epic») — у рубрики проверяются части от самой точной.
+// Для темы, которой нет в классификаторе, возвращается пустая строка.
+func FromSubject(subject string) string {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	if subject == "" {
+		return ""
+	}
+	if code := Normalize(subject); code != "" {
+		return code
+	}
+	parts := strings.Split(subject, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := strings.TrimSpace(parts[i])
+		if code, ok := byName[part]; ok {
+			return code
+		}
+		if code, ok := subjects[part]; ok {
+			return code
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+// genres/store.go
+package genres
+
+import (
+	"fmt"
+	"strings"
+
+	"turanga/access"
+	"turanga/models"
+)
+
+// Жанры книги хранятся в таблице book_genres кодами из Tree. books.genres_scanned
+// отмечает книги, жанры которых уже извлекались из файла, даже если их не нашлось:
+// остальным жанры заполнит ревизия (scanner.FillMissingGenres).
+
+// Set заменяет жанры книги и отмечает, что они извлечены. Коды не из классификатора
+// пропускаются.
+func Set(q models.Querier, bookID int64, codes []string) error {
+	if _, err := q.Exec("DELETE FROM book_genres WHERE book_id = ?", bookID); err != nil {
+		return fmt.Errorf("ошибка удаления жанров книги: %w", err)
+	}
+	if err := Add(q, bookID, codes); err != nil {
+		return err
+	}
+	if _, err := q.Exec("UPDATE books SET genres_scanned = 1 WHERE id = ?", bookID); err != nil {
+		return fmt.Errorf("ошибка отметки жанров книги: %w", err)
+	}
+	return nil
+}
+
+// Add добавляет книге жанры, не трогая отметку genres_scanned: так жанры
+// возвращаются книге, восстановленной из корзины
+func Add(q models.Querier, bookID int64, codes []string) error {
+	for _, code := range codes {
+		if _, ok := byCode[code]; !ok {
+			continue
+		}
+		if _, err := q.Exec("INSERT OR IGNORE INTO book_genres (book_id, genre) VALUES (?, ?)", bookID, code); err != nil {
+			return fmt.Errorf("ошибка сохранения жанра %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// ForBook возвращает жанры книги в порядке классификатора
+func ForBook(q models.Querier, bookID int64) ([]Genre, error) {
+	rows, err := q.Query("SELECT genre FROM book_genres WHERE book_id = ?", bookID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения жанров книги: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("ошибка чтения жанра книги: %w", err)
+		}
+		found[code] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по жанрам книги: %w", err)
+	}
+
+	var result []Genre
+	for _, group := range Tree {
+		for _, g := range group.Genres {
+			if found[g.Code] {
+				result = append(result, g)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Counts считает видимые пользователю книги по жанрам
+func Counts(q models.Querier, policy access.Policy) (map[string]int, error) {
+	rows, err := q.Query(`
+        SELECT bg.genre, COUNT(*) FROM book_genres bg
+        JOIN books b ON b.id = bg.book_id
+        WHERE 1 = 1 ` + policy.Filter("b") + `
+        GROUP BY bg.genre`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта книг по жанрам: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var code string
+		var count int
+		if err := rows.Scan(&code, &count); err != nil {
+			return nil, fmt.Errorf("ошибка чтения числа книг жанра: %w", err)
+		}
+		counts[code] = count
+	}
+	return counts, rows.Err()
+}
+
+// GroupCounts считает видимые пользователю книги по разделам. Книга с несколькими
+// жанрами одного раздела считается один раз.
+func GroupCounts(q models.Querier, policy access.Policy) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, group := range Tree {
+		in, args := inCodes(group.Codes())
+		rows, err := q.Query(`
+            SELECT COUNT(DISTINCT bg.book_id) FROM book_genres bg
+            JOIN books b ON b.id = bg.book_id
+            WHERE bg.genre `+in+` `+policy.Filter("b"), args...)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка подсчёта книг раздела %s: %w", group.Code, err)
+		}
+		var count int
+		if rows.Next() {
+			err = rows.Scan(&count)
+		}
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения числа книг раздела %s: %w", group.Code, err)
+		}
+		counts[group.Code] = count
+	}
+	return counts, nil
+}
+
+// BookCondition возвращает условие на книги (псевдоним b) с любым из жанров
+// и его аргументы — для выборки книг жанра или раздела
+func BookCondition(codes []string) (string, []interface{}) {
+	in, args := inCodes(codes)
+	return "b.id IN (SELECT bg.book_id FROM book_genres bg WHERE bg.genre " + in + ")", args
+}
+
+// inCodes возвращает «IN (?, ...)» и аргументы для списка кодов
+func inCodes(codes []string) (string, []interface{}) {
+	if len(codes) == 0 {
+		return "IN (NULL)", nil
+	}
+	args := make([]interface{}, len(codes))
+	for i, code := range codes {
+		args[i] = code
+	}
+	return "IN (?" + strings.Repeat(", ?", len(codes)-1) + ")", args
+}
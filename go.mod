@@ -9,6 +9,7 @@ require (
 	github.com/ipfs/go-ipfs-api v0.7.0
 	github.com/mattn/go-sqlite3 v1.14.29
 	github.com/nbd-wtf/go-nostr v0.52.0
+	golang.org/x/crypto v0.36.0
 	gopkg.in/ini.v1 v1.67.0
 )
 
@@ -56,7 +57,6 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	golang.org/x/arch v0.15.0 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 	golang.org/x/net v0.37.0 // indirect
@@ -0,0 +1,337 @@
+// history/history.go
+package history
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"turanga/models"
+	"turanga/search"
+	"turanga/series"
+)
+
+// Журнал изменений хранит каждое изменение поля книги со старым и новым значением.
+// Изменения одной пользовательской операции (например, переименования автора
+// во всех его книгах) объединены общим operation_id и могут быть отменены вместе.
+
+// Источники изменений
+const (
+	OriginWeb      = "web"      // Редактирование в веб-интерфейсе
+	OriginRevision = "revision" // Ревизия библиотеки
+	OriginNostr    = "nostr"    // Книга получена по запросу Nostr
+	OriginImport   = "import"   // Импорт метаданных
+	OriginUndo     = "undo"     // Отмена предыдущего изменения
+	OriginAPI      = "api"      // Изменение через REST API
+)
+
+// Поля книги, изменения которых записываются в журнал
+const (
+	FieldTitle        = "title"
+	FieldAuthors      = "authors"
+	FieldSeries       = "series"
+	FieldSeriesNumber = "series_number"
+	FieldYear         = "year"
+	FieldPublisher    = "publisher"
+	FieldISBN         = "isbn"
+	FieldTags         = "tags"
+	FieldOver18       = "over18"
+	FieldAnnotation   = "annotation"
+	FieldIPFSCID      = "ipfs_cid"
+	FieldShared       = "shared"          // Доступ через Nostr: shared, friends или never
+	FieldLanguage     = "language"        // Код ISO 639-1
+	FieldSourceLang   = "source_language" // Язык оригинала переводной книги
+	FieldCreated      = "created"         // Появление книги в библиотеке; не отменяется
+)
+
+// ErrConflict означает, что поле было изменено позже и отмена затёрла бы новое значение
+var ErrConflict = errors.New("значение было изменено позже, отмена невозможна")
+
+// Change — одно изменение поля книги
+type Change struct {
+	BookID   int64
+	Field    string
+	OldValue string
+	NewValue string
+}
+
+// Entry — запись журнала
+type Entry struct {
+	ID          int64
+	OperationID string
+	BookID      int64
+	Field       string
+	OldValue    string
+	NewValue    string
+	Origin      string
+	CreatedAt   time.Time
+	Undone      bool
+
+	// OperationSize — число изменений в той же операции (для отмены операции целиком)
+	OperationSize int
+}
+
+// simpleColumns — поля, которые хранятся в одноимённых колонках books
+var simpleColumns = map[string]bool{
+	FieldTitle:        true,
+	FieldSeries:       true,
+	FieldSeriesNumber: true,
+	FieldYear:         true,
+	FieldPublisher:    true,
+	FieldISBN:         true,
+	FieldIPFSCID:      true,
+	FieldShared:       true,
+	FieldLanguage:     true,
+	FieldSourceLang:   true,
+}
+
+// NewOperation возвращает идентификатор для группы связанных изменений
+func NewOperation() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Log записывает изменения в журнал. Изменения без разницы между значениями пропускаются.
+func Log(q models.Querier, operationID, origin string, changes ...Change) error {
+	now := time.Now().Unix()
+	for _, c := range changes {
+		if c.OldValue == c.NewValue && c.Field != FieldCreated {
+			continue
+		}
+		_, err := q.Exec(`
+            INSERT INTO edit_history (operation_id, book_id, field, old_value, new_value, origin, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			operationID, c.BookID, c.Field, c.OldValue, c.NewValue, origin, now)
+		if err != nil {
+			return fmt.Errorf("ошибка записи в журнал изменений: %w", err)
+		}
+	}
+	return nil
+}
+
+// Current возвращает текущее значение поля книги в том виде, в каком оно пишется в журнал.
+// Авторы и теги — через запятую, over18 — "1" или "0", аннотация читается из каталога notes.
+func Current(q models.Querier, rootPath string, bookID int64, field string) (string, error) {
+	var value sql.NullString
+	var err error
+
+	switch {
+	case simpleColumns[field]:
+		err = q.QueryRow("SELECT "+field+" FROM books WHERE id = ?", bookID).Scan(&value)
+	case field == FieldOver18:
+		err = q.QueryRow("SELECT CASE WHEN IFNULL(over18, 0) THEN '1' ELSE '0' END FROM books WHERE id = ?", bookID).Scan(&value)
+	case field == FieldAuthors:
+		err = q.QueryRow(`
+            SELECT GROUP_CONCAT(full_name, ', ') FROM (
+                SELECT a.full_name FROM book_authors ba
+                JOIN authors a ON a.id = ba.author_id
+                WHERE ba.book_id = ?
+                ORDER BY ba.rowid
+            )`, bookID).Scan(&value)
+	case field == FieldTags:
+		err = q.QueryRow(`
+            SELECT GROUP_CONCAT(name, ', ') FROM (
+                SELECT t.name FROM book_tags bt
+                JOIN tags t ON t.id = bt.tag_id
+                WHERE bt.book_id = ?
+                ORDER BY t.name
+            )`, bookID).Scan(&value)
+	case field == FieldAnnotation:
+		var fileHash sql.NullString
+		if err := q.QueryRow("SELECT file_hash FROM books WHERE id = ?", bookID).Scan(&fileHash); err != nil {
+			return "", fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+		}
+		data, err := os.ReadFile(filepath.Join(rootPath, "notes", fileHash.String+".txt"))
+		if err != nil {
+			return "", nil
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return "", fmt.Errorf("неизвестное поле: %s", field)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения поля %s книги ID %d: %w", field, bookID, err)
+	}
+	return value.String, nil
+}
+
+// SetField записывает значение поля книги. Аннотации хранятся в файлах, для них есть SetAnnotation.
+func SetField(tx *sql.Tx, bookID int64, field, value string) error {
+	switch {
+	case field == FieldTitle || field == FieldSeries:
+		// Поля *_lower используются поиском по точному совпадению
+		_, err := tx.Exec("UPDATE books SET "+field+" = ?, "+field+"_lower = ? WHERE id = ?", value, strings.ToLower(value), bookID)
+		if err != nil || field == FieldTitle {
+			return err
+		}
+		return series.Sync(tx, bookID)
+	case field == FieldSeriesNumber:
+		// Числовой номер тома пересчитывается вместе со связью с серией
+		if _, err := tx.Exec("UPDATE books SET series_number = ? WHERE id = ?", value, bookID); err != nil {
+			return err
+		}
+		return series.Sync(tx, bookID)
+	case field == FieldIPFSCID:
+		// CID уникален, пустое значение храним как NULL
+		var cid interface{}
+		if value != "" {
+			cid = value
+		}
+		_, err := tx.Exec("UPDATE books SET ipfs_cid = ? WHERE id = ?", cid, bookID)
+		return err
+	case simpleColumns[field]:
+		_, err := tx.Exec("UPDATE books SET "+field+" = ? WHERE id = ?", value, bookID)
+		return err
+	case field == FieldOver18:
+		_, err := tx.Exec("UPDATE books SET over18 = ? WHERE id = ?", value == "1", bookID)
+		return err
+	case field == FieldAuthors:
+		return SetAuthors(tx, bookID, SplitList(value))
+	case field == FieldTags:
+		return SetTags(tx, bookID, SplitList(value))
+	default:
+		return fmt.Errorf("поле %s нельзя изменить", field)
+	}
+}
+
+// SplitList разбивает список авторов или тегов, записанный через запятую
+func SplitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// SetAuthors заменяет авторов книги и удаляет авторов, оставшихся без книг
+func SetAuthors(tx *sql.Tx, bookID int64, names []string) error {
+	var oldIDs []int64
+	rows, err := tx.Query("SELECT author_id FROM book_authors WHERE book_id = ?", bookID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения авторов книги: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			oldIDs = append(oldIDs, id)
+		}
+	}
+	rows.Close()
+
+	if _, err := tx.Exec("DELETE FROM book_authors WHERE book_id = ?", bookID); err != nil {
+		return fmt.Errorf("ошибка удаления связей авторов: %w", err)
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var authorID int64
+		err := tx.QueryRow("SELECT id FROM authors WHERE full_name = ?", name).Scan(&authorID)
+		if err == sql.ErrNoRows {
+			// last_name_lower — последнее слово имени, как при ручном редактировании
+			lastNameLower := strings.ToLower(name)
+			if parts := strings.Fields(name); len(parts) > 0 {
+				lastNameLower = strings.ToLower(parts[len(parts)-1])
+			}
+			res, err := tx.Exec("INSERT INTO authors (last_name_lower, full_name, full_name_lower) VALUES (?, ?, ?)",
+				lastNameLower, name, strings.ToLower(name))
+			if err != nil {
+				return fmt.Errorf("ошибка создания автора '%s': %w", name, err)
+			}
+			if authorID, err = res.LastInsertId(); err != nil {
+				return fmt.Errorf("ошибка получения ID автора '%s': %w", name, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("ошибка поиска автора '%s': %w", name, err)
+		}
+
+		if _, err := tx.Exec("INSERT OR IGNORE INTO book_authors (book_id, author_id) VALUES (?, ?)", bookID, authorID); err != nil {
+			return fmt.Errorf("ошибка создания связи книга-автор: %w", err)
+		}
+	}
+
+	for _, id := range oldIDs {
+		if _, err := tx.Exec("DELETE FROM authors WHERE id = ? AND NOT EXISTS (SELECT 1 FROM book_authors WHERE author_id = ?)", id, id); err != nil {
+			return fmt.Errorf("ошибка удаления автора без книг: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetTags заменяет теги книги. Длина тега ограничена так же, как в веб-интерфейсе.
+func SetTags(tx *sql.Tx, bookID int64, names []string) error {
+	if _, err := tx.Exec("DELETE FROM book_tags WHERE book_id = ?", bookID); err != nil {
+		return fmt.Errorf("ошибка удаления связей тегов: %w", err)
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if len(name) > 16 {
+			name = name[:16]
+		}
+
+		var tagID int64
+		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&tagID)
+		if err == sql.ErrNoRows {
+			res, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", name)
+			if err != nil {
+				return fmt.Errorf("ошибка создания тега '%s': %w", name, err)
+			}
+			if tagID, err = res.LastInsertId(); err != nil {
+				return fmt.Errorf("ошибка получения ID тега '%s': %w", name, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("ошибка поиска тега '%s': %w", name, err)
+		}
+
+		if _, err := tx.Exec("INSERT OR IGNORE INTO book_tags (book_id, tag_id) VALUES (?, ?)", bookID, tagID); err != nil {
+			return fmt.Errorf("ошибка создания связи книга-тег: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetAnnotation записывает аннотацию в notes/<хеш>.txt (пустая удаляет файл) и обновляет поисковый индекс
+func SetAnnotation(db *sql.DB, rootPath string, bookID int64, annotation string) error {
+	var fileHash sql.NullString
+	if err := db.QueryRow("SELECT file_hash FROM books WHERE id = ?", bookID).Scan(&fileHash); err != nil {
+		return fmt.Errorf("ошибка получения хеша книги: %w", err)
+	}
+	if fileHash.String == "" {
+		return fmt.Errorf("у книги нет хеша файла")
+	}
+
+	notesDir := filepath.Join(rootPath, "notes")
+	notePath := filepath.Join(notesDir, fileHash.String+".txt")
+	if annotation == "" {
+		if err := os.Remove(notePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("ошибка удаления файла аннотации: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(notesDir, 0755); err != nil {
+			return fmt.Errorf("ошибка создания каталога notes: %w", err)
+		}
+		if err := os.WriteFile(notePath, []byte(annotation), 0644); err != nil {
+			return fmt.Errorf("ошибка сохранения аннотации в файл: %w", err)
+		}
+	}
+	return search.SetAnnotation(db, bookID, annotation)
+}
@@ -0,0 +1,123 @@
+// history/undo.go
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ForBook возвращает последние записи журнала книги, новые первыми
+func ForBook(db *sql.DB, bookID int64, limit int) ([]Entry, error) {
+	rows, err := db.Query(`
+        SELECT h.id, h.operation_id, h.book_id, h.field, IFNULL(h.old_value, ''), IFNULL(h.new_value, ''),
+               h.origin, h.created_at, h.undone_at IS NOT NULL,
+               (SELECT COUNT(*) FROM edit_history o WHERE o.operation_id = h.operation_id)
+        FROM edit_history h
+        WHERE h.book_id = ?
+        ORDER BY h.id DESC
+        LIMIT ?`, bookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения истории изменений: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &e.OperationID, &e.BookID, &e.Field, &e.OldValue, &e.NewValue,
+			&e.Origin, &createdAt, &e.Undone, &e.OperationSize); err != nil {
+			return nil, fmt.Errorf("ошибка чтения истории изменений: %w", err)
+		}
+		e.CreatedAt = time.Unix(createdAt, 0)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Undo отменяет одно изменение
+func Undo(db *sql.DB, rootPath string, entryID int64) error {
+	return undo(db, rootPath, "id = ?", entryID)
+}
+
+// UndoOperation отменяет все ещё действующие изменения операции в обратном порядке
+func UndoOperation(db *sql.DB, rootPath string, operationID string) error {
+	return undo(db, rootPath, "operation_id = ?", operationID)
+}
+
+// undo отменяет изменения, выбранные условием where.
+// Перед отменой каждое поле сверяется с записанным новым значением: если его успели изменить
+// ещё раз, вся отмена прерывается с ErrConflict, чтобы не потерять более поздние правки.
+func undo(db *sql.DB, rootPath string, where string, arg interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        SELECT id, book_id, field, IFNULL(old_value, ''), IFNULL(new_value, '')
+        FROM edit_history
+        WHERE `+where+` AND undone_at IS NULL AND field != ?
+        ORDER BY id DESC`, arg, FieldCreated)
+	if err != nil {
+		return fmt.Errorf("ошибка получения изменений для отмены: %w", err)
+	}
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.BookID, &e.Field, &e.OldValue, &e.NewValue); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка чтения изменений для отмены: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	if len(entries) == 0 {
+		return fmt.Errorf("нет изменений для отмены")
+	}
+
+	operationID := NewOperation()
+	var annotations []Entry
+	now := time.Now().Unix()
+
+	for _, e := range entries {
+		current, err := Current(tx, rootPath, e.BookID, e.Field)
+		if err != nil {
+			return err
+		}
+		if current != e.NewValue {
+			return fmt.Errorf("%w: книга ID %d, поле %s", ErrConflict, e.BookID, e.Field)
+		}
+
+		if e.Field == FieldAnnotation {
+			// Файл аннотации пишется после фиксации транзакции
+			annotations = append(annotations, e)
+		} else if err := SetField(tx, e.BookID, e.Field, e.OldValue); err != nil {
+			return fmt.Errorf("ошибка отмены изменения поля %s книги ID %d: %w", e.Field, e.BookID, err)
+		}
+
+		if _, err := tx.Exec("UPDATE edit_history SET undone_at = ? WHERE id = ?", now, e.ID); err != nil {
+			return fmt.Errorf("ошибка отметки отмены: %w", err)
+		}
+		if err := Log(tx, operationID, OriginUndo, Change{
+			BookID: e.BookID, Field: e.Field, OldValue: e.NewValue, NewValue: e.OldValue,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации отмены: %w", err)
+	}
+
+	for _, e := range annotations {
+		if err := SetAnnotation(db, rootPath, e.BookID, e.OldValue); err != nil {
+			log.Printf("Ошибка восстановления аннотации книги ID %d: %v", e.BookID, err)
+		}
+	}
+	return nil
+}
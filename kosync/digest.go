@@ -0,0 +1,136 @@
+// kosync/digest.go
+package kosync
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"turanga/config"
+)
+
+// KOReader узнаёт документ по «частичному MD5»: хешу двенадцати кусков по 1 КБ
+// со смещений 0, 1 КБ, 4 КБ, 16 КБ ... 1 ГБ. Чтобы по присланному дайджесту найти
+// книгу, дайджесты файлов библиотеки и EPUB, сконвертированных из fb2 (каталог
+// converted), хранятся в таблице document_digests.
+
+const (
+	digestStep    = 1024
+	digestSamples = 11 // Куски со смещений 1 КБ << 2i, i = 0..10, плюс кусок с начала файла
+)
+
+// PartialMD5 считает дайджест файла так же, как KOReader (util.partialMD5)
+func PartialMD5(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	defer f.Close()
+
+	h := md5.New()
+	buf := make([]byte, digestStep)
+	offsets := []int64{0}
+	for i := 0; i < digestSamples; i++ {
+		offsets = append(offsets, int64(digestStep)<<(2*i))
+	}
+	for _, offset := range offsets {
+		n, err := f.ReadAt(buf, offset)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return "", fmt.Errorf("ошибка чтения файла: %w", err)
+			}
+			break
+		}
+		h.Write(buf[:n])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indexing не даёт запустить несколько индексаций сразу
+var indexing atomic.Bool
+
+// IndexDigests считает дайджесты книг и сконвертированных EPUB, которых ещё нет в базе.
+// Если индексация уже идёт, сразу возвращается.
+func IndexDigests(db *sql.DB, rootPath string) {
+	if !indexing.CompareAndSwap(false, true) {
+		return
+	}
+	defer indexing.Store(false)
+	cfg := config.GetConfig()
+
+	rows, err := db.Query(`
+        SELECT b.file_hash, MIN(b.file_url)
+        FROM books b
+        WHERE b.file_hash IS NOT NULL AND b.file_hash != '' AND b.file_url IS NOT NULL
+          AND b.file_hash NOT IN (SELECT file_hash FROM document_digests WHERE converted = 0)
+        GROUP BY b.file_hash`)
+	if err != nil {
+		log.Printf("Ошибка получения книг для дайджестов KOReader: %v", err)
+		return
+	}
+	files := make(map[string]string)
+	for rows.Next() {
+		var hash, filePath string
+		if err := rows.Scan(&hash, &filePath); err != nil {
+			continue
+		}
+		files[hash] = filePath
+	}
+	rows.Close()
+
+	added := 0
+	for hash, filePath := range files {
+		if storeDigest(db, hash, filePath, false) {
+			added++
+		}
+	}
+
+	// EPUB из fb2 появляются при первом скачивании, поэтому проверяются каждый раз
+	convertedDir := filepath.Join(rootPath, "converted")
+	entries, err := os.ReadDir(convertedDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Ошибка чтения каталога %s: %v", convertedDir, err)
+	}
+	for _, entry := range entries {
+		hash, ok := strings.CutSuffix(entry.Name(), ".epub")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		var known bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM document_digests WHERE file_hash = ? AND converted = 1)", hash).Scan(&known); err != nil || known {
+			continue
+		}
+		if storeDigest(db, hash, filepath.Join(convertedDir, entry.Name()), true) {
+			added++
+		}
+	}
+
+	if cfg.Debug && added > 0 {
+		log.Printf("KOReader: посчитано дайджестов файлов: %d", added)
+	}
+}
+
+// storeDigest считает и сохраняет дайджест одного файла
+func storeDigest(db *sql.DB, fileHash, filePath string, converted bool) bool {
+	digest, err := PartialMD5(filePath)
+	if err != nil {
+		if config.GetConfig().Debug {
+			log.Printf("Не удалось посчитать дайджест %s: %v", filePath, err)
+		}
+		return false
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO document_digests (digest, file_hash, converted) VALUES (?, ?, ?)",
+		digest, fileHash, converted)
+	if err != nil {
+		log.Printf("Ошибка сохранения дайджеста %s: %v", filePath, err)
+		return false
+	}
+	return true
+}
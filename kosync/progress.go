@@ -0,0 +1,90 @@
+// kosync/progress.go
+package kosync
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Progress — позиция чтения документа, как её хранит сервер синхронизации KOReader
+type Progress struct {
+	Document   string    `json:"document"`
+	Progress   string    `json:"progress"`
+	Percentage float64   `json:"percentage"`
+	Device     string    `json:"device"`
+	DeviceID   string    `json:"device_id"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+// Percent возвращает долю прочитанного в процентах
+func (p *Progress) Percent() int {
+	return int(p.Percentage*100 + 0.5)
+}
+
+// Save сохраняет позицию чтения пользователя; прежняя позиция документа заменяется
+func Save(db *sql.DB, userID int64, p *Progress) error {
+	_, err := db.Exec(`
+        INSERT OR REPLACE INTO reading_progress (user_id, document, progress, percentage, device, device_id, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, p.Document, p.Progress, p.Percentage, p.Device, p.DeviceID, p.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения прогресса чтения %s: %w", p.Document, err)
+	}
+	return nil
+}
+
+// Get возвращает позицию чтения документа или nil, если её нет
+func Get(db *sql.DB, userID int64, document string) (*Progress, error) {
+	row := db.QueryRow(`
+        SELECT document, progress, percentage, device, device_id, updated_at
+        FROM reading_progress
+        WHERE user_id = ? AND document = ?`, userID, document)
+	p, err := scanProgress(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ForFiles возвращает последнюю позицию чтения любого из файлов книги (по хешам файлов)
+// или nil, если пользователь эту книгу не читал
+func ForFiles(db *sql.DB, userID int64, fileHashes []string) (*Progress, error) {
+	if len(fileHashes) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{userID}
+	for _, hash := range fileHashes {
+		args = append(args, hash)
+	}
+	row := db.QueryRow(`
+        SELECT rp.document, rp.progress, rp.percentage, rp.device, rp.device_id, rp.updated_at
+        FROM reading_progress rp
+        JOIN document_digests dd ON dd.digest = rp.document
+        WHERE rp.user_id = ? AND dd.file_hash IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(fileHashes)), ", ")+`)
+        ORDER BY rp.updated_at DESC
+        LIMIT 1`, args...)
+	p, err := scanProgress(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// scanProgress читает позицию чтения из строки результата
+func scanProgress(row *sql.Row) (*Progress, error) {
+	var p Progress
+	var device, deviceID sql.NullString
+	var updatedAt int64
+	if err := row.Scan(&p.Document, &p.Progress, &p.Percentage, &device, &deviceID, &updatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("ошибка чтения прогресса чтения: %w", err)
+	}
+	p.Device = device.String
+	p.DeviceID = deviceID.String
+	p.UpdatedAt = time.Unix(updatedAt, 0)
+	return &p, nil
+}
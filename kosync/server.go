@@ -0,0 +1,183 @@
+// kosync/server.go
+package kosync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// Сервер синхронизации KOReader (протокол koreader-sync-server). В KOReader в настройках
+// «Синхронизация прогресса» указывается адрес {сервер}/kosync, логин пользователя turanga
+// и токен устройства со страницы учётной записи вместо пароля. Регистрации нет: «Регистрация»
+// в KOReader лишь проверяет логин и токен. Прогресс хранится отдельно для каждого пользователя.
+
+// Prefix — адрес сервера синхронизации
+const Prefix = "/kosync/"
+
+// Коды ошибок протокола
+const (
+	codeUnauthorized    = 2001
+	codeUserExists      = 2002
+	codeInvalidRequest  = 2003
+	codeDocumentMissing = 2004
+)
+
+// protocolError — ответ сервера с ошибкой
+type protocolError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// progressResponse — позиция чтения в ответе на GET /syncs/progress/{документ}
+type progressResponse struct {
+	*Progress
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Handler обрабатывает запросы KOReader
+// URL: POST /kosync/users/create, GET /kosync/users/auth, PUT /kosync/syncs/progress,
+// GET /kosync/syncs/progress/{документ}, GET /kosync/healthcheck
+func Handler(db *sql.DB, rootPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig()
+		path := strings.TrimPrefix(r.URL.Path, Prefix)
+		if cfg.Debug {
+			log.Printf("KOReader: %s %s", r.Method, r.URL.Path)
+		}
+
+		switch {
+		case path == "healthcheck" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]string{"state": "OK"})
+		case path == "users/create" && r.Method == http.MethodPost:
+			createUser(db, w, r)
+		case path == "users/auth" && r.Method == http.MethodGet:
+			if authenticate(db, w, r) != nil {
+				writeJSON(w, http.StatusOK, map[string]string{"authorized": "OK"})
+			}
+		case path == "syncs/progress" && r.Method == http.MethodPut:
+			updateProgress(db, rootPath, w, r)
+		case strings.HasPrefix(path, "syncs/progress/") && r.Method == http.MethodGet:
+			getProgress(db, w, r, strings.TrimPrefix(path, "syncs/progress/"))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// createUser отвечает на регистрацию в KOReader. Пользователей заводит администратор turanga,
+// поэтому регистрация успешна, только если логин и токен устройства уже подходят.
+func createUser(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" || body.Password == "" {
+		writeJSON(w, http.StatusForbidden, protocolError{codeInvalidRequest, "Invalid request"})
+		return
+	}
+	user, err := users.AuthenticateSyncKey(db, body.Username, body.Password)
+	if err != nil {
+		if !errors.Is(err, users.ErrInvalidCredentials) {
+			log.Printf("Ошибка проверки ключа синхронизации: %v", err)
+		}
+		writeJSON(w, http.StatusPaymentRequired, protocolError{codeUserExists,
+			"Registration is disabled: use your turanga login and a device token from the account page"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"username": user.Login})
+}
+
+// authenticate проверяет заголовки x-auth-user и x-auth-key; при ошибке отвечает сам и возвращает nil
+func authenticate(db *sql.DB, w http.ResponseWriter, r *http.Request) *users.User {
+	user, err := users.AuthenticateSyncKey(db, r.Header.Get("x-auth-user"), r.Header.Get("x-auth-key"))
+	if err != nil {
+		if !errors.Is(err, users.ErrInvalidCredentials) {
+			log.Printf("Ошибка проверки ключа синхронизации: %v", err)
+		} else if config.GetConfig().Debug {
+			log.Printf("KOReader: неверный ключ синхронизации для %s", r.Header.Get("x-auth-user"))
+		}
+		writeJSON(w, http.StatusUnauthorized, protocolError{codeUnauthorized, "Unauthorized"})
+		return nil
+	}
+	return user
+}
+
+// updateProgress сохраняет позицию чтения, присланную KOReader
+func updateProgress(db *sql.DB, rootPath string, w http.ResponseWriter, r *http.Request) {
+	user := authenticate(db, w, r)
+	if user == nil {
+		return
+	}
+
+	var p Progress
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeJSON(w, http.StatusForbidden, protocolError{codeInvalidRequest, "Invalid request"})
+		return
+	}
+	if p.Document == "" {
+		writeJSON(w, http.StatusForbidden, protocolError{codeDocumentMissing, "Field 'document' not provided."})
+		return
+	}
+	if p.Progress == "" || p.Percentage < 0 || p.Percentage > 1 {
+		writeJSON(w, http.StatusForbidden, protocolError{codeInvalidRequest, "Invalid request"})
+		return
+	}
+	p.Document = strings.ToLower(p.Document)
+	p.UpdatedAt = time.Now()
+
+	if err := Save(db, user.ID, &p); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Незнакомый документ — возможно, новая книга или только что сконвертированный EPUB
+	var known bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM document_digests WHERE digest = ?)", p.Document).Scan(&known); err == nil && !known {
+		go IndexDigests(db, rootPath)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"document": p.Document, "timestamp": p.UpdatedAt.Unix()})
+}
+
+// getProgress возвращает последнюю позицию чтения документа; если её нет — пустой объект
+func getProgress(db *sql.DB, w http.ResponseWriter, r *http.Request, document string) {
+	user := authenticate(db, w, r)
+	if user == nil {
+		return
+	}
+	if document == "" {
+		writeJSON(w, http.StatusForbidden, protocolError{codeDocumentMissing, "Field 'document' not provided."})
+		return
+	}
+
+	p, err := Get(db, user.ID, strings.ToLower(document))
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, progressResponse{Progress: p, Timestamp: p.UpdatedAt.Unix()})
+}
+
+// writeJSON отправляет ответ в JSON
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Ошибка кодирования ответа KOReader: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// language/language.go
+package language
+
+import (
+	"strings"
+)
+
+// Язык книги хранится двухбуквенным кодом ISO 639-1 (ru, uk, en). В файлах он бывает
+// записан по-разному: ru-RU, rus, Russian, «русский» — всё приводится к одному коду,
+// чтобы фильтр по языку находил книги из любых источников.
+
+// Language — язык из списка для выбора в интерфейсе
+type Language struct {
+	Code string
+	Name string
+}
+
+// Known — языки, которые предлагаются при редактировании; книги на других языках
+// хранятся со своим кодом и показываются кодом
+var Known = []Language{
+	{"ru", "русский"},
+	{"uk", "украинский"},
+	{"be", "белорусский"},
+	{"en", "английский"},
+	{"de", "немецкий"},
+	{"fr", "французский"},
+	{"es", "испанский"},
+	{"it", "итальянский"},
+	{"pt", "португальский"},
+	{"pl", "польский"},
+	{"cs", "чешский"},
+	{"bg", "болгарский"},
+	{"sr", "сербский"},
+	{"kk", "казахский"},
+	{"hy", "армянский"},
+	{"ka", "грузинский"},
+	{"he", "иврит"},
+	{"ja", "японский"},
+	{"zh", "китайский"},
+	{"la", "латинский"},
+}
+
+// aliases — трёхбуквенные коды ISO 639-2 и названия языков, которые встречаются в файлах книг
+var aliases = map[string]string{
+	"rus": "ru", "russian": "ru", "русский": "ru",
+	"ukr": "uk", "ukrainian": "uk", "українська": "uk", "украинский": "uk", "ua": "uk",
+	"bel": "be", "belarusian": "be", "беларуская": "be", "белорусский": "be",
+	"eng": "en", "english": "en", "английский": "en",
+	"ger": "de", "deu": "de", "german": "de", "deutsch": "de", "немецкий": "de",
+	"fre": "fr", "fra": "fr", "french": "fr", "français": "fr", "французский": "fr",
+	"spa": "es", "spanish": "es", "español": "es", "испанский": "es",
+	"ita": "it", "italian": "it", "italiano": "it", "итальянский": "it",
+	"por": "pt", "portuguese": "pt", "português": "pt",
+	"pol": "pl", "polish": "pl", "polski": "pl", "польский": "pl",
+	"cze": "cs", "ces": "cs", "czech": "cs", "čeština": "cs", "чешский": "cs",
+	"bul": "bg", "bulgarian": "bg", "болгарский": "bg",
+	"srp": "sr", "serbian": "sr", "сербский": "sr",
+	"kaz": "kk", "kazakh": "kk", "казахский": "kk",
+	"arm": "hy", "hye": "hy", "armenian": "hy",
+	"geo": "ka", "kat": "ka", "georgian": "ka",
+	"heb": "he", "hebrew": "he", "iw": "he",
+	"jpn": "ja", "japanese": "ja", "японский": "ja",
+	"chi": "zh", "zho": "zh", "chinese": "zh", "китайский": "zh",
+	"lat": "la", "latin": "la", "латинский": "la",
+}
+
+// Normalize приводит язык к коду ISO 639-1. Неизвестный двух- или трёхбуквенный код
+// остаётся как есть, всё остальное (пустая строка, мусор) даёт пустую строку.
+func Normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if code, ok := aliases[s]; ok {
+		return code
+	}
+	// ru-RU, en_US, zh-Hans — берём основной язык
+	if i := strings.IndexAny(s, "-_"); i > 0 {
+		s = s[:i]
+	}
+	if code, ok := aliases[s]; ok {
+		return code
+	}
+	if len(s) < 2 || len(s) > 3 {
+		return ""
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return s
+}
+
+// Name возвращает название языка по коду или сам код для языка не из списка
+func Name(code string) string {
+	for _, l := range Known {
+		if l.Code == code {
+			return l.Name
+		}
+	}
+	return code
+}
@@ -0,0 +1,108 @@
+// mailer/devices.go
+package mailer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Почтовые устройства — адреса читалок, которые принимают книги письмом
+// (Send to Kindle, Send-to-PocketBook и т.п.). У каждого пользователя свои адреса.
+
+// ErrNotFound — устройство или отправка не найдены у пользователя
+var ErrNotFound = errors.New("не найдено")
+
+// Device — почтовый адрес устройства пользователя
+type Device struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Email      string
+	ConvertFB2 bool // Отправлять книги fb2 в EPUB (Kindle не принимает FB2)
+	CreatedAt  time.Time
+}
+
+// AddDevice добавляет почтовый адрес устройства пользователя
+func AddDevice(db *sql.DB, userID int64, name, email string, convertFB2 bool) (*Device, error) {
+	name = strings.TrimSpace(name)
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, fmt.Errorf("неверный адрес %s: %w", email, err)
+	}
+	if name == "" {
+		name = addr.Address
+	}
+
+	now := time.Now()
+	res, err := db.Exec("INSERT INTO mail_devices (user_id, name, email, convert_fb2, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, name, addr.Address, convertFB2, now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка добавления адреса %s: %w", addr.Address, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ID адреса %s: %w", addr.Address, err)
+	}
+	return &Device{ID: id, UserID: userID, Name: name, Email: addr.Address, ConvertFB2: convertFB2, CreatedAt: now}, nil
+}
+
+// ListDevices возвращает почтовые адреса устройств пользователя в порядке добавления
+func ListDevices(db *sql.DB, userID int64) ([]Device, error) {
+	rows, err := db.Query("SELECT id, name, email, convert_fb2, created_at FROM mail_devices WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения почтовых адресов устройств: %w", err)
+	}
+	defer rows.Close()
+
+	var list []Device
+	for rows.Next() {
+		d := Device{UserID: userID}
+		var createdAt int64
+		if err := rows.Scan(&d.ID, &d.Name, &d.Email, &d.ConvertFB2, &createdAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения почтового адреса устройства: %w", err)
+		}
+		d.CreatedAt = time.Unix(createdAt, 0)
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// GetDevice возвращает почтовый адрес устройства пользователя
+func GetDevice(db *sql.DB, userID, id int64) (*Device, error) {
+	d := Device{ID: id, UserID: userID}
+	var createdAt int64
+	err := db.QueryRow("SELECT name, email, convert_fb2, created_at FROM mail_devices WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&d.Name, &d.Email, &d.ConvertFB2, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка получения почтового адреса устройства: %w", err)
+	}
+	d.CreatedAt = time.Unix(createdAt, 0)
+	return &d, nil
+}
+
+// DeleteDevice удаляет почтовый адрес устройства пользователя; журнал отправок сохраняется
+func DeleteDevice(db *sql.DB, userID, id int64) error {
+	res, err := db.Exec("DELETE FROM mail_devices WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления почтового адреса устройства: %w", err)
+	}
+	return checkAffected(res)
+}
+
+// checkAffected возвращает ErrNotFound, если запрос не изменил ни одной строки
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка проверки результата: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
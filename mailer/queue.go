@@ -0,0 +1,293 @@
+// mailer/queue.go
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/convert"
+	"turanga/users"
+	"turanga/works"
+)
+
+// Книги отправляются не в запросе, а из очереди mail_deliveries: письмо с большим
+// вложением идёт долго, а сервер может быть временно недоступен. Неудачная отправка
+// повторяется через растущие промежутки; отказ сервера с кодом 5xx окончателен.
+
+// Статусы отправки
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// StatusLabels — названия статусов для интерфейса
+var StatusLabels = map[string]string{
+	StatusPending: "в очереди",
+	StatusSent:    "отправлена",
+	StatusFailed:  "ошибка",
+}
+
+// maxAttachmentSize — наибольший размер книги в письме (ограничение Send to Kindle — 50 МБ)
+const maxAttachmentSize = 50 << 20
+
+// retryDelays — паузы перед повторными попытками; после последней отправка считается неудачной
+var retryDelays = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// wake будит очередь, когда в неё добавлена книга
+var wake = make(chan struct{}, 1)
+
+// permanentError — ошибка, при которой повторять отправку бесполезно
+type permanentError struct{ error }
+
+// Delivery — запись журнала отправки
+type Delivery struct {
+	ID        int64
+	BookID    int64
+	Title     string
+	Email     string
+	Status    string
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	SentAt    time.Time // Нулевое значение, пока книга не отправлена
+}
+
+// Enqueue ставит книгу в очередь на отправку на почтовый адрес устройства
+func Enqueue(db *sql.DB, userID, bookID int64, device *Device) (int64, error) {
+	var title string
+	if err := db.QueryRow("SELECT title FROM books WHERE id = ?", bookID).Scan(&title); err != nil {
+		return 0, fmt.Errorf("ошибка получения книги %d: %w", bookID, err)
+	}
+
+	now := time.Now().Unix()
+	res, err := db.Exec(`
+        INSERT INTO mail_deliveries (user_id, book_id, title, email, convert_fb2, status, created_at, next_attempt_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, bookID, title, device.Email, device.ConvertFB2, StatusPending, now, now)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка постановки книги %d в очередь отправки: %w", bookID, err)
+	}
+	notify()
+	return res.LastInsertId()
+}
+
+// Retry возвращает в очередь неудачную отправку пользователя
+func Retry(db *sql.DB, userID, id int64) error {
+	res, err := db.Exec(`
+        UPDATE mail_deliveries SET status = ?, attempts = 0, last_error = NULL, next_attempt_at = ?
+        WHERE id = ? AND user_id = ? AND status = ?`,
+		StatusPending, time.Now().Unix(), id, userID, StatusFailed)
+	if err != nil {
+		return fmt.Errorf("ошибка повторной отправки %d: %w", id, err)
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	notify()
+	return nil
+}
+
+// ListDeliveries возвращает последние отправки пользователя, новые первыми
+func ListDeliveries(db *sql.DB, userID int64, limit int) ([]Delivery, error) {
+	rows, err := db.Query(`
+        SELECT id, book_id, title, email, status, attempts, last_error, created_at, sent_at
+        FROM mail_deliveries
+        WHERE user_id = ?
+        ORDER BY id DESC
+        LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения журнала отправки: %w", err)
+	}
+	defer rows.Close()
+
+	var list []Delivery
+	for rows.Next() {
+		var d Delivery
+		var lastError sql.NullString
+		var createdAt int64
+		var sentAt sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.BookID, &d.Title, &d.Email, &d.Status, &d.Attempts, &lastError, &createdAt, &sentAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения журнала отправки: %w", err)
+		}
+		d.LastError = lastError.String
+		d.CreatedAt = time.Unix(createdAt, 0)
+		if sentAt.Valid {
+			d.SentAt = time.Unix(sentAt.Int64, 0)
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// notify будит очередь, не дожидаясь её
+func notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run отправляет книги из очереди, пока не отменён ctx. Проверяет очередь
+// раз в минуту и сразу после постановки книги в очередь.
+func Run(ctx context.Context, db *sql.DB, rootPath string) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		processQueue(db, rootPath)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// processQueue отправляет все книги, время отправки которых наступило
+func processQueue(db *sql.DB, rootPath string) {
+	cfg := config.GetConfig()
+	if !Enabled(cfg) {
+		return
+	}
+
+	type job struct {
+		id, userID, bookID int64
+		email              string
+		convertFB2         bool
+		attempts           int
+	}
+	rows, err := db.Query(`
+        SELECT id, user_id, book_id, email, convert_fb2, attempts
+        FROM mail_deliveries
+        WHERE status = ? AND next_attempt_at <= ?
+        ORDER BY id`, StatusPending, time.Now().Unix())
+	if err != nil {
+		log.Printf("Ошибка чтения очереди отправки: %v", err)
+		return
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.userID, &j.bookID, &j.email, &j.convertFB2, &j.attempts); err != nil {
+			log.Printf("Ошибка чтения очереди отправки: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		err := deliver(db, cfg, rootPath, j.userID, j.bookID, j.email, j.convertFB2)
+		attempts := j.attempts + 1
+		now := time.Now()
+		switch {
+		case err == nil:
+			_, err = db.Exec("UPDATE mail_deliveries SET status = ?, attempts = ?, last_error = NULL, sent_at = ? WHERE id = ?",
+				StatusSent, attempts, now.Unix(), j.id)
+			if cfg.Debug {
+				log.Printf("Книга %d отправлена на %s", j.bookID, j.email)
+			}
+		case errors.As(err, new(permanentError)) || isPermanent(err) || attempts > len(retryDelays):
+			log.Printf("Не удалось отправить книгу %d на %s: %v", j.bookID, j.email, err)
+			_, err = db.Exec("UPDATE mail_deliveries SET status = ?, attempts = ?, last_error = ? WHERE id = ?",
+				StatusFailed, attempts, err.Error(), j.id)
+		default:
+			log.Printf("Ошибка отправки книги %d на %s (попытка %d): %v", j.bookID, j.email, attempts, err)
+			_, err = db.Exec("UPDATE mail_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+				attempts, err.Error(), now.Add(retryDelays[attempts-1]).Unix(), j.id)
+		}
+		if err != nil {
+			log.Printf("Ошибка обновления журнала отправки %d: %v", j.id, err)
+		}
+	}
+}
+
+// deliver отправляет одну книгу. Книга берётся с правами пользователя: закрытую
+// для него книгу, даже поставленную в очередь раньше, отправить нельзя.
+func deliver(db *sql.DB, cfg *config.Config, rootPath string, userID, bookID int64, email string, convertFB2 bool) error {
+	user, err := users.Get(db, userID)
+	if err != nil {
+		return permanentError{fmt.Errorf("пользователь %d не найден: %w", userID, err)}
+	}
+	files, err := works.Files(db, bookID, access.ForUser(user), nil)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 || files[0].BookID != bookID {
+		return permanentError{fmt.Errorf("книга %d недоступна", bookID)}
+	}
+	book := files[0]
+
+	// Устройству, которое не читает FB2, отправляем epub того же произведения или EPUB из fb2
+	sendID, fileType, fileHash := book.BookID, book.FileType, book.FileHash
+	if convertFB2 && convert.CanConvert(fileType) {
+		for _, f := range files {
+			if f.FileType == "epub" {
+				sendID, fileType, fileHash = f.BookID, f.FileType, f.FileHash
+				break
+			}
+		}
+	}
+	var filePath string
+	if err := db.QueryRow("SELECT file_url FROM books WHERE id = ?", sendID).Scan(&filePath); err != nil {
+		return fmt.Errorf("ошибка получения файла книги %d: %w", sendID, err)
+	}
+	name := book.Title + "." + fileType
+	if convertFB2 && convert.CanConvert(fileType) {
+		if filePath, err = convert.CachedEPUB(filepath.Join(rootPath, "converted"), filePath, fileType, fileHash); err != nil {
+			return permanentError{err}
+		}
+		fileType = "epub"
+		name = book.Title + ".epub"
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return permanentError{fmt.Errorf("ошибка открытия файла книги: %w", err)}
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > maxAttachmentSize {
+		return permanentError{fmt.Errorf("файл больше %d МБ, его не примет почта устройства", maxAttachmentSize>>20)}
+	}
+
+	return Send(cfg, email, book.Title, "Книга из "+cfg.GetCatalogTitle()+": "+book.Title, Attachment{
+		Name:        attachmentName(name),
+		ContentType: contentType(fileType),
+		Data:        f,
+	})
+}
+
+// attachmentName убирает из имени файла символы, недопустимые в именах на устройствах
+func attachmentName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// contentType возвращает MIME-тип вложения по формату книги
+func contentType(fileType string) string {
+	switch fileType {
+	case "epub":
+		return "application/epub+zip"
+	case "pdf":
+		return "application/pdf"
+	case "fb2":
+		return "application/x-fictionbook+xml"
+	case "djvu":
+		return "image/vnd.djvu"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,196 @@
+// mailer/smtp.go
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"turanga/config"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+	sendTimeout = 10 * time.Minute // На большое вложение через медленный сервер
+)
+
+// Attachment — файл, вложенный в письмо
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        io.Reader
+}
+
+// Enabled сообщает, настроена ли отправка почты
+func Enabled(cfg *config.Config) bool {
+	return cfg != nil && cfg.SMTPHost != ""
+}
+
+// Send отправляет письмо с вложением через сервер из настроек smtp_*.
+// Подходит любой SMTP-сервер: с TLS, STARTTLS или без шифрования (например, локальный тестовый).
+func Send(cfg *config.Config, to, subject, text string, attachment Attachment) error {
+	if !Enabled(cfg) {
+		return fmt.Errorf("отправка почты не настроена (smtp_host)")
+	}
+
+	var msg bytes.Buffer
+	if err := writeMessage(&msg, cfg.SMTPFrom, to, subject, text, attachment); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: cfg.SMTPHost}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if cfg.SMTPSecurity == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка подключения к %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("ошибка приветствия сервера %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	if cfg.SMTPSecurity == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("сервер %s не поддерживает STARTTLS (smtp_security = none для сервера без шифрования)", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("ошибка STARTTLS: %w", err)
+		}
+	}
+	if cfg.SMTPUser != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)); err != nil {
+				return fmt.Errorf("ошибка авторизации на %s: %w", addr, err)
+			}
+		}
+	}
+
+	if err := c.Mail(cfg.SMTPFrom); err != nil {
+		return fmt.Errorf("сервер не принял отправителя %s: %w", cfg.SMTPFrom, err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("сервер не принял получателя %s: %w", to, err)
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("ошибка начала передачи письма: %w", err)
+	}
+	if _, err := msg.WriteTo(wc); err != nil {
+		wc.Close()
+		return fmt.Errorf("ошибка передачи письма: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("сервер не принял письмо: %w", err)
+	}
+	// Письмо уже принято: ошибка при завершении сеанса не повод отправлять его ещё раз
+	c.Quit()
+	return nil
+}
+
+// isPermanent сообщает, что сервер отказал окончательно (код 5xx) и повторять отправку бесполезно
+func isPermanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+// writeMessage собирает письмо MIME: текст и вложение в base64
+func writeMessage(w io.Writer, from, to, subject, text string, attachment Attachment) error {
+	mw := multipart.NewWriter(w)
+
+	header := []string{
+		"From: " + (&mail.Address{Address: from}).String(),
+		"To: " + (&mail.Address{Address: to}).String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(from),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary(),
+	}
+	if _, err := io.WriteString(w, strings.Join(header, "\r\n")+"\r\n\r\n"); err != nil {
+		return fmt.Errorf("ошибка записи заголовков письма: %w", err)
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка записи текста письма: %w", err)
+	}
+	if err := writeBase64(part, strings.NewReader(text)); err != nil {
+		return err
+	}
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Name})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка записи вложения: %w", err)
+	}
+	if err := writeBase64(part, attachment.Data); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// writeBase64 пишет данные в base64 строками по 76 символов
+func writeBase64(w io.Writer, r io.Reader) error {
+	buf := make([]byte, 57*64) // 57 байт дают строку из 76 символов
+	line := make([]byte, base64.StdEncoding.EncodedLen(57))
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i < n; i += 57 {
+			end := min(i+57, n)
+			m := base64.StdEncoding.EncodedLen(end - i)
+			base64.StdEncoding.Encode(line, buf[i:end])
+			if _, werr := w.Write(append(line[:m:m], '\r', '\n')); werr != nil {
+				return fmt.Errorf("ошибка записи вложения: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка чтения вложения: %w", err)
+		}
+	}
+}
+
+// messageID возвращает уникальный Message-ID в домене отправителя
+func messageID(from string) string {
+	domain := "turanga"
+	if _, d, ok := strings.Cut(from, "@"); ok && d != "" {
+		domain = d
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
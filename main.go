@@ -12,10 +12,17 @@ import (
 	"path/filepath"
 	"syscall"
 	"time"
+	"turanga/backup"
 	"turanga/config"
+	"turanga/convert"
+	"turanga/generation"
+	"turanga/kosync"
+	"turanga/mailer"
 	"turanga/nostr"
 	"turanga/opds"
 	"turanga/scanner"
+	"turanga/trash"
+	"turanga/users"
 	"turanga/web"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -37,6 +44,11 @@ func main() {
 	rootPath := filepath.Dir(exePath)
 	log.Printf("Каталог приложения: %s", rootPath)
 
+	// Служебные команды (backup, restore) выполняются без запуска сервера
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(rootPath, os.Args[1:]))
+	}
+
 	// Настройка логирования в файл с ротацией
 	logFilePath := filepath.Join(rootPath, "turanga.log")
 	logFileOldPath := logFilePath + ".old"
@@ -77,6 +89,11 @@ func main() {
 		}()
 	}
 
+	// Применяем резервную копию, загруженную через веб-интерфейс, до открытия БД
+	if err := backup.ApplyPending(rootPath); err != nil {
+		log.Printf("Ошибка восстановления из резервной копии: %v", err)
+	}
+
 	// Загружаем конфигурацию
 	cfg, err := config.LoadConfig(filepath.Join(rootPath, "turanga.conf"))
 	if err != nil {
@@ -100,6 +117,11 @@ func main() {
 	}
 	initDB(rootPath)
 
+	// Переносим пароль из конфигурации прежних версий в учётную запись администратора
+	if err := users.ImportLegacyPassword(db, cfg, "turanga.conf"); err != nil {
+		log.Printf("Предупреждение: не удалось перенести пароль из конфигурации: %v", err)
+	}
+
 	// Создаем контекст с отменой для управления жизненным циклом приложения
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Гарантируем отмену контекста при выходе из main
@@ -128,6 +150,36 @@ func main() {
 		// Не останавливаем выполнение из-за ошибки очистки
 	}
 
+	// Очищаем корзину от устаревших книг и удаляем истёкшие сессии при запуске и затем раз в сутки
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			purged, err := trash.PurgeExpired(db, cfg, rootPath)
+			if err != nil {
+				log.Printf("Предупреждение: ошибка очистки корзины: %v", err)
+			} else if purged > 0 {
+				log.Printf("Из корзины окончательно удалено книг: %d", purged)
+			}
+			if expired, err := users.PurgeExpiredSessions(db); err != nil {
+				log.Printf("Предупреждение: ошибка удаления истёкших сессий: %v", err)
+			} else if expired > 0 && cfg.Debug {
+				log.Printf("Удалено истёкших сессий: %d", expired)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Очередь отправки книг на устройства по почте
+	go mailer.Run(ctx, db, rootPath)
+
+	// Дайджесты файлов для синхронизации KOReader: новые книги досчитываются при запуске
+	go kosync.IndexDigests(db, rootPath)
+
 	// Создаем экземпляр веб-интерфейса один раз при запуске
 	webInterface := web.NewWebInterface(db, cfg, nostrClient, rootPath)
 
@@ -136,6 +188,7 @@ func main() {
 	authorHandler := opds.NewAuthorHandler(db, cfg)
 	seriesHandler := opds.NewSeriesHandler(db, cfg)
 	tagHandler := opds.NewTagHandler(db, cfg)
+	genreHandler := opds.NewGenreHandler(db, cfg)
 
 	// --- Запуск Nostr Subscription Manager с переподключением ---
 	// Создаем и запускаем подписку на запросы книг через Nostr в отдельной горутине
@@ -200,30 +253,57 @@ func main() {
 
 	// Маршруты для API OPDS
 	http.HandleFunc("/", opds.IndexHandler(webInterface, cfg))
-	http.HandleFunc("/feed", opds.ShowOPDSCatalogHandler)
-	http.HandleFunc("/books", bookHandler.BooksHandler)
-	http.HandleFunc("/books/", bookHandler.BooksHandler)
-	http.HandleFunc("/authors", authorHandler.AuthorsHandler)
-	http.HandleFunc("/authors/", authorHandler.AuthorsHandler)
-	http.HandleFunc("/series", seriesHandler.SeriesHandler)
-	http.HandleFunc("/series/", seriesHandler.SeriesHandler)
-	http.HandleFunc("/recent", bookHandler.RecentHandler)
-	http.HandleFunc("/tags", tagHandler.TagsHandler)
-	http.HandleFunc("/tags/", tagHandler.TagsHandler)
-	http.HandleFunc("/opds-search/", opds.OPDSSearchHandler(webInterface))
-	http.HandleFunc("/opds-download/", opds.OPDSDownloadBookHandler(db, rootPath))
+	http.HandleFunc("/feed", opds.RequireAuth(db, generation.Cache(db, opds.ShowOPDSCatalogHandler(db))))
+	http.HandleFunc("/books", opds.RequireAuth(db, generation.Cache(db, bookHandler.BooksHandler)))
+	http.HandleFunc("/books/", opds.RequireAuth(db, generation.Cache(db, bookHandler.BooksHandler)))
+	http.HandleFunc("/authors", opds.RequireAuth(db, generation.Cache(db, authorHandler.AuthorsHandler)))
+	http.HandleFunc("/authors/", opds.RequireAuth(db, generation.Cache(db, authorHandler.AuthorsHandler)))
+	http.HandleFunc("/series", opds.RequireAuth(db, generation.Cache(db, seriesHandler.SeriesHandler)))
+	http.HandleFunc("/series/", opds.RequireAuth(db, generation.Cache(db, seriesHandler.SeriesHandler)))
+	http.HandleFunc("/recent", opds.RequireAuth(db, generation.Cache(db, bookHandler.RecentHandler)))
+	http.HandleFunc("/tags", opds.RequireAuth(db, generation.Cache(db, tagHandler.TagsHandler)))
+	http.HandleFunc("/tags/", opds.RequireAuth(db, generation.Cache(db, tagHandler.TagsHandler)))
+	http.HandleFunc("/genres", opds.RequireAuth(db, generation.Cache(db, genreHandler.GenresHandler)))
+	http.HandleFunc("/genres/", opds.RequireAuth(db, generation.Cache(db, genreHandler.GenresHandler)))
+	http.HandleFunc("/opds-search", opds.RequireAuth(db, generation.Cache(db, opds.OPDSSearchHandler(webInterface))))
+	http.HandleFunc("/opds-search/", opds.RequireAuth(db, generation.Cache(db, opds.OPDSSearchHandler(webInterface))))
+	http.HandleFunc("/opds-search.xml", opds.RequireAuth(db, generation.Cache(db, opds.OpenSearchDescriptionHandler)))
+	http.HandleFunc("/opds-download/", opds.RequireAuth(db, opds.OPDSDownloadBookHandler(db, rootPath)))
+	http.HandleFunc("/opds-pse/", opds.RequireAuth(db, opds.PSEPageHandler(db)))
+	http.HandleFunc(convert.Prefix, opds.RequireAuth(db, opds.OPDSConvertHandler(db, rootPath)))
+	http.HandleFunc("/reading", opds.RequireAuth(db, opds.ReadingHandler(db)))
+	// Сервер синхронизации прогресса KOReader, вход — заголовками x-auth-user и x-auth-key
+	http.HandleFunc(kosync.Prefix, kosync.Handler(db, rootPath))
+	// Тот же каталог в формате OPDS 2.0 (JSON)
+	http.HandleFunc("/opds2", opds.OPDS2Handler(http.DefaultServeMux))
+	http.HandleFunc("/opds2/", opds.OPDS2Handler(http.DefaultServeMux))
 
 	// Маршруты для веб-интерфейса
 	http.HandleFunc("/author/", webInterface.ShowAuthorHandler)
 	http.HandleFunc("/s/", webInterface.ShowSeriesHandler)
 	http.HandleFunc("/save/author/", webInterface.SaveAuthorHandler)
 	http.HandleFunc("/save/series/", webInterface.SaveSeriesHandler)
+	http.HandleFunc("/save/series-details/", webInterface.SaveSeriesDetailsHandler)
 	http.HandleFunc("/save/book/", webInterface.SaveBookFieldHandler)
+	http.HandleFunc("/save/tag/", webInterface.SaveTagHandler)
+	http.HandleFunc("/save/sharing/", webInterface.SaveSharingHandler)
 	http.HandleFunc("/tag/", webInterface.ShowTagHandler)
+	http.HandleFunc("/genre/", webInterface.GenresHandler)
 	http.HandleFunc("/delete/book/", webInterface.DeleteBookHandler)
+	http.HandleFunc("/work/merge/", webInterface.MergeWorkHandler)
+	http.HandleFunc("/work/split/", webInterface.SplitWorkHandler)
+	http.HandleFunc("/history/undo/", webInterface.UndoChangeHandler)
+	http.HandleFunc("/history/undo-operation/", webInterface.UndoChangeHandler)
 	http.HandleFunc("/upload", webInterface.UploadBookHandler)
 	http.HandleFunc("/auth", webInterface.AuthHandler)
 	http.HandleFunc("/logout", webInterface.LogoutHandler)
+	http.HandleFunc("/logout/all", webInterface.LogoutAllHandler)
+	http.HandleFunc("/account", webInterface.AccountHandler)
+	http.HandleFunc("/account/password", webInterface.AccountPasswordHandler)
+	http.HandleFunc("/account/devices/", webInterface.AccountDeviceHandler)
+	http.HandleFunc("/account/mail/", webInterface.AccountMailHandler)
+	http.HandleFunc("/send/book/", webInterface.SendBookHandler)
+	http.HandleFunc("/read/", webInterface.ReaderHandler)
 	http.HandleFunc("/request", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -245,8 +325,19 @@ func main() {
 	http.HandleFunc("/request/response-count", func(w http.ResponseWriter, r *http.Request) {
 		webInterface.ResponseCountHandler(w, r)
 	})
+	http.HandleFunc("/request/series/", webInterface.RequestMissingVolumesHandler)
 	http.HandleFunc("/revision", webInterface.RevisionHandler)
 	http.HandleFunc("/revision/progress", webInterface.ProgressHandler)
+	http.HandleFunc("/backup", webInterface.BackupHandler)
+	http.HandleFunc("/backup/download", webInterface.BackupDownloadHandler)
+	http.HandleFunc("/backup/restore", webInterface.BackupRestoreHandler)
+	http.HandleFunc("/metadata/export", webInterface.MetadataExportHandler)
+	http.HandleFunc("/metadata/import", webInterface.MetadataImportHandler)
+	http.HandleFunc("/trash", webInterface.TrashHandler)
+	http.HandleFunc("/trash/restore/", webInterface.TrashRestoreHandler)
+	http.HandleFunc("/trash/purge/", webInterface.TrashPurgeHandler)
+	http.HandleFunc("/users", webInterface.UsersHandler)
+	http.HandleFunc("/users/", webInterface.UserActionHandler)
 
 	// Статические файлы
 	staticDir := filepath.Join(rootPath, "web", "static")
@@ -261,21 +352,26 @@ func main() {
 	// Маршрут для страницы деталей книги
 	http.HandleFunc("/book/", webInterface.ShowBookDetailHandler)
 
-	// Маршрут для отдачи обложек
+	// Маршрут для отдачи обложек; обложки закрытых книг скрыты так же, как сами книги
 	coversDirAbs := filepath.Join(rootPath, "covers")
-	http.Handle("/covers/", http.StripPrefix("/covers/", http.FileServer(http.Dir(coversDirAbs))))
+	http.HandleFunc("/covers/", opds.OptionalAuth(db, webInterface.CoversHandler(coversDirAbs)))
 
 	// Mаршрут для запроса книги через Nostr
 	http.HandleFunc("/request/book/", webInterface.RequestBookViaNostrHandler)
 
+	// REST API (см. API.md): токен устройства или сессия, без входа — только чтение
+	http.HandleFunc("/api/v1/", opds.OptionalAuth(db, generation.Cache(db, webInterface.APIHandler,
+		"/api/v1/books", "/api/v1/authors", "/api/v1/series", "/api/v1/tags")))
+
 	if cfg.Debug {
 		log.Printf("OPDS сервер запущен на порту :%d", cfg.Port)
 	}
 
 	// Запускаем HTTP-сервер, используя контекст с отменой
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: nil, // Обработчики уже зарегистрированы через http.HandleFunc
+		Addr: fmt.Sprintf(":%d", cfg.Port),
+		// Обработчики зарегистрированы через http.HandleFunc, сессии и CSRF проверяются до них
+		Handler: webInterface.SessionMiddleware(http.DefaultServeMux),
 	}
 
 	// Запускаем сервер в отдельной горутине, чтобы иметь возможность отменить его через контекст
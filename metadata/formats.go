@@ -0,0 +1,406 @@
+// metadata/formats.go
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Поддерживаемые форматы обмена
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatOPF  = "opf" // zip-архив с файлом <хеш>.opf на каждую книгу
+)
+
+// Разделители списков в CSV, как в экспорте Calibre
+const (
+	csvAuthorsSep = " & "
+	csvTagsSep    = ", "
+)
+
+var csvHeader = []string{
+	"file_hash", "file_type", "title", "authors", "series", "series_number",
+	"year", "publisher", "isbn", "tags", "over18", "annotation", "ipfs_cid",
+	"language", "source_language",
+}
+
+// ContentType возвращает MIME-тип и расширение файла для формата
+func ContentType(format string) (string, string) {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8", ".csv"
+	case FormatOPF:
+		return "application/zip", ".zip"
+	default:
+		return "application/json; charset=utf-8", ".json"
+	}
+}
+
+// Write записывает метаданные в выбранном формате
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatCSV:
+		return writeCSV(w, records)
+	case FormatOPF:
+		return writeOPFArchive(w, records)
+	default:
+		return fmt.Errorf("неизвестный формат: %s", format)
+	}
+}
+
+// Read читает метаданные. Формат определяется по содержимому:
+// zip — архив OPF, '[' — JSON, '<' — одиночный OPF, иначе CSV.
+func Read(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения данных: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readOPFArchive(data)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var records []Record
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, fmt.Errorf("ошибка разбора JSON: %w", err)
+		}
+		return records, nil
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		rec, err := ReadOPF(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, err
+		}
+		return []Record{*rec}, nil
+	default:
+		return readCSV(bytes.NewReader(data))
+	}
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		over18 := ""
+		if r.Over18 != nil {
+			over18 = "0"
+			if *r.Over18 {
+				over18 = "1"
+			}
+		}
+		row := []string{
+			r.FileHash, r.FileType, r.Title, strings.Join(r.Authors, csvAuthorsSep), r.Series, r.SeriesNumber,
+			r.Year, r.Publisher, r.ISBN, strings.Join(r.Tags, csvTagsSep), over18, r.Annotation, r.IPFSCID,
+			r.Language, r.SourceLang,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения заголовка CSV: %w", err)
+	}
+	// Колонки ищутся по имени, поэтому порядок и набор колонок в файле могут отличаться
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["file_hash"]; !ok {
+		return nil, fmt.Errorf("в CSV нет колонки file_hash")
+	}
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения CSV: %w", err)
+		}
+		get := func(name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		var over18 *bool
+		switch strings.ToLower(get("over18")) {
+		case "1", "true", "yes":
+			over18 = boolPtr(true)
+		case "0", "false", "no":
+			over18 = boolPtr(false)
+		}
+		records = append(records, Record{
+			FileHash:     get("file_hash"),
+			FileType:     get("file_type"),
+			Title:        get("title"),
+			Authors:      splitList(get("authors"), csvAuthorsSep),
+			Series:       get("series"),
+			SeriesNumber: get("series_number"),
+			Year:         get("year"),
+			Publisher:    get("publisher"),
+			ISBN:         get("isbn"),
+			Tags:         splitList(get("tags"), strings.TrimSpace(csvTagsSep)),
+			Over18:       over18,
+			Annotation:   get("annotation"),
+			IPFSCID:      get("ipfs_cid"),
+			Language:     get("language"),
+			SourceLang:   get("source_language"),
+		})
+	}
+	return records, nil
+}
+
+// splitList разбивает строку по разделителю, отбрасывая пустые элементы
+func splitList(s, sep string) []string {
+	var out []string
+	for _, part := range strings.Split(s, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// opfPackage — запись OPF в стиле metadata.opf из Calibre.
+// Имена с префиксами задаются явно: encoding/xml не умеет назначать префиксы пространствам имён.
+type opfPackage struct {
+	XMLName  xml.Name    `xml:"package"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Version  string      `xml:"version,attr"`
+	UniqueID string      `xml:"unique-identifier,attr"`
+	Metadata opfMetadata `xml:"metadata"`
+}
+
+type opfMetadata struct {
+	XmlnsDC     string          `xml:"xmlns:dc,attr"`
+	XmlnsOPF    string          `xml:"xmlns:opf,attr"`
+	Identifiers []opfIdentifier `xml:"dc:identifier"`
+	Title       string          `xml:"dc:title,omitempty"`
+	Creators    []opfCreator    `xml:"dc:creator"`
+	Description string          `xml:"dc:description,omitempty"`
+	Publisher   string          `xml:"dc:publisher,omitempty"`
+	Date        string          `xml:"dc:date,omitempty"`
+	Language    string          `xml:"dc:language,omitempty"`
+	Subjects    []string        `xml:"dc:subject"`
+	Meta        []opfMeta       `xml:"meta"`
+}
+
+type opfIdentifier struct {
+	ID     string `xml:"id,attr,omitempty"`
+	Scheme string `xml:"opf:scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type opfCreator struct {
+	Role  string `xml:"opf:role,attr"`
+	Value string `xml:",chardata"`
+}
+
+type opfMeta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+// opfInput — структура для чтения OPF; при разборе префиксы уже разрешены, поэтому имена локальные
+type opfInput struct {
+	Metadata struct {
+		Identifiers []struct {
+			Scheme string `xml:"scheme,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Title    string    `xml:"title"`
+		Creators []string  `xml:"creator"`
+		Desc     string    `xml:"description"`
+		Pub      string    `xml:"publisher"`
+		Date     string    `xml:"date"`
+		Language string    `xml:"language"`
+		Subjects []string  `xml:"subject"`
+		Meta     []opfMeta `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+// Схема идентификатора с хешем файла и имена собственных meta-полей
+const (
+	opfHashScheme = "turanga"
+	opfMetaOver18 = "turanga:over18"
+	opfMetaCID    = "turanga:ipfs_cid"
+	opfMetaType   = "turanga:file_type"
+	opfMetaSrcLng = "turanga:source_language"
+)
+
+// WriteOPF записывает метаданные одной книги в формате OPF
+func WriteOPF(w io.Writer, r Record) error {
+	pkg := opfPackage{
+		Xmlns:    "http://www.idpf.org/2007/opf",
+		Version:  "2.0",
+		UniqueID: "turanga_id",
+		Metadata: opfMetadata{
+			XmlnsDC:     "http://purl.org/dc/elements/1.1/",
+			XmlnsOPF:    "http://www.idpf.org/2007/opf",
+			Identifiers: []opfIdentifier{{ID: "turanga_id", Scheme: opfHashScheme, Value: r.FileHash}},
+			Title:       r.Title,
+			Description: r.Annotation,
+			Publisher:   r.Publisher,
+			Date:        r.Year,
+			Language:    r.Language,
+			Subjects:    r.Tags,
+		},
+	}
+	if r.ISBN != "" {
+		pkg.Metadata.Identifiers = append(pkg.Metadata.Identifiers, opfIdentifier{Scheme: "ISBN", Value: r.ISBN})
+	}
+	for _, a := range r.Authors {
+		pkg.Metadata.Creators = append(pkg.Metadata.Creators, opfCreator{Role: "aut", Value: a})
+	}
+	if r.Series != "" {
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: "calibre:series", Content: r.Series})
+		if r.SeriesNumber != "" {
+			pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: "calibre:series_index", Content: r.SeriesNumber})
+		}
+	}
+	if r.Over18 != nil {
+		over18 := "false"
+		if *r.Over18 {
+			over18 = "true"
+		}
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: opfMetaOver18, Content: over18})
+	}
+	if r.IPFSCID != "" {
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: opfMetaCID, Content: r.IPFSCID})
+	}
+	if r.FileType != "" {
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: opfMetaType, Content: r.FileType})
+	}
+	if r.SourceLang != "" {
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, opfMeta{Name: opfMetaSrcLng, Content: r.SourceLang})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(pkg); err != nil {
+		return fmt.Errorf("ошибка формирования OPF: %w", err)
+	}
+	return enc.Flush()
+}
+
+// ReadOPF читает метаданные одной книги из OPF.
+// Хеш берётся из идентификатора со схемой turanga; без него запись не к чему привязать.
+func ReadOPF(r io.Reader) (*Record, error) {
+	var in opfInput
+	if err := xml.NewDecoder(r).Decode(&in); err != nil {
+		return nil, fmt.Errorf("ошибка разбора OPF: %w", err)
+	}
+	m := in.Metadata
+	rec := &Record{
+		Title:      strings.TrimSpace(m.Title),
+		Annotation: strings.TrimSpace(m.Desc),
+		Publisher:  strings.TrimSpace(m.Pub),
+		Year:       strings.TrimSpace(m.Date),
+		Language:   strings.TrimSpace(m.Language),
+	}
+	for _, id := range m.Identifiers {
+		switch strings.ToLower(id.Scheme) {
+		case opfHashScheme:
+			rec.FileHash = strings.TrimSpace(id.Value)
+		case "isbn":
+			rec.ISBN = strings.TrimSpace(id.Value)
+		}
+	}
+	for _, c := range m.Creators {
+		if c = strings.TrimSpace(c); c != "" {
+			rec.Authors = append(rec.Authors, c)
+		}
+	}
+	for _, s := range m.Subjects {
+		if s = strings.TrimSpace(s); s != "" {
+			rec.Tags = append(rec.Tags, s)
+		}
+	}
+	for _, meta := range m.Meta {
+		switch meta.Name {
+		case "calibre:series":
+			rec.Series = meta.Content
+		case "calibre:series_index":
+			rec.SeriesNumber = meta.Content
+		case opfMetaOver18:
+			rec.Over18 = boolPtr(meta.Content == "true")
+		case opfMetaCID:
+			rec.IPFSCID = meta.Content
+		case opfMetaType:
+			rec.FileType = meta.Content
+		case opfMetaSrcLng:
+			rec.SourceLang = meta.Content
+		}
+	}
+	if rec.FileHash == "" {
+		return nil, fmt.Errorf("в OPF нет идентификатора со схемой %s", opfHashScheme)
+	}
+	return rec, nil
+}
+
+func writeOPFArchive(w io.Writer, records []Record) error {
+	zw := zip.NewWriter(w)
+	for _, r := range records {
+		fw, err := zw.Create(r.FileHash + ".opf")
+		if err != nil {
+			return err
+		}
+		if err := WriteOPF(fw, r); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func readOPFArchive(data []byte) ([]Record, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия архива: %w", err)
+	}
+
+	var records []Record
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".opf") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения %s: %w", f.Name, err)
+		}
+		rec, err := ReadOPF(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		records = append(records, *rec)
+	}
+	return records, nil
+}
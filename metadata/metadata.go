@@ -0,0 +1,275 @@
+// metadata/metadata.go
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"turanga/config"
+	"turanga/history"
+	"turanga/language"
+)
+
+// Record — переносимые метаданные одной книги.
+// Книга определяется по file_hash, поэтому запись применима к любой библиотеке с тем же файлом.
+type Record struct {
+	FileHash     string   `json:"file_hash"`
+	FileType     string   `json:"file_type,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Authors      []string `json:"authors,omitempty"`
+	Series       string   `json:"series,omitempty"`
+	SeriesNumber string   `json:"series_number,omitempty"`
+	Year         string   `json:"year,omitempty"`
+	Publisher    string   `json:"publisher,omitempty"`
+	ISBN         string   `json:"isbn,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Over18       *bool    `json:"over18,omitempty"` // nil — пометка в источнике не указана
+	Annotation   string   `json:"annotation,omitempty"`
+	IPFSCID      string   `json:"ipfs_cid,omitempty"`
+	Language     string   `json:"language,omitempty"`
+	SourceLang   string   `json:"source_language,omitempty"`
+}
+
+// ImportStats — итог применения метаданных
+type ImportStats struct {
+	Total    int // Записей во входных данных
+	Applied  int // Записей, применённых к книгам
+	NotFound int // Записей, для которых нет книги с таким хешем
+	Failed   int // Записей, применить которые не удалось
+}
+
+// Export собирает метаданные всех книг библиотеки.
+// Аннотации читаются из каталога notes, так же как их показывает веб-интерфейс.
+func Export(db *sql.DB, rootPath string) ([]Record, error) {
+	rows, err := db.Query(`
+        SELECT id, file_hash, file_type, title, series, series_number, year, publisher, isbn, over18, ipfs_cid,
+               language, source_language
+        FROM books
+        WHERE file_hash IS NOT NULL AND file_hash != ''
+        ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения книг: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	index := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var fileHash, fileType, title, series, seriesNumber, year, publisher, isbn, ipfsCID, lang, srcLang sql.NullString
+		var over18 sql.NullBool
+		if err := rows.Scan(&id, &fileHash, &fileType, &title, &series, &seriesNumber, &year, &publisher, &isbn, &over18, &ipfsCID, &lang, &srcLang); err != nil {
+			return nil, fmt.Errorf("ошибка чтения книги: %w", err)
+		}
+		index[id] = len(records)
+		records = append(records, Record{
+			FileHash:     fileHash.String,
+			FileType:     fileType.String,
+			Title:        title.String,
+			Series:       series.String,
+			SeriesNumber: seriesNumber.String,
+			Year:         year.String,
+			Publisher:    publisher.String,
+			ISBN:         isbn.String,
+			Over18:       boolPtr(over18.Bool),
+			IPFSCID:      ipfsCID.String,
+			Language:     lang.String,
+			SourceLang:   srcLang.String,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+
+	// Авторы и теги одним запросом каждые, а не по запросу на книгу
+	if err := collect(db, `
+        SELECT ba.book_id, a.full_name FROM book_authors ba
+        JOIN authors a ON a.id = ba.author_id
+        ORDER BY ba.book_id, ba.rowid`, func(id int64, name string) {
+		if i, ok := index[id]; ok {
+			records[i].Authors = append(records[i].Authors, name)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("ошибка получения авторов: %w", err)
+	}
+	if err := collect(db, `
+        SELECT bt.book_id, t.name FROM book_tags bt
+        JOIN tags t ON t.id = bt.tag_id
+        ORDER BY bt.book_id, t.name`, func(id int64, name string) {
+		if i, ok := index[id]; ok {
+			records[i].Tags = append(records[i].Tags, name)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("ошибка получения тегов: %w", err)
+	}
+
+	notesDir := filepath.Join(rootPath, "notes")
+	for i := range records {
+		data, err := os.ReadFile(filepath.Join(notesDir, records[i].FileHash+".txt"))
+		if err == nil {
+			records[i].Annotation = strings.TrimSpace(string(data))
+		}
+	}
+
+	return records, nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// collect выполняет запрос вида (book_id, строка) и передаёт каждую строку в fn
+func collect(db *sql.DB, query string, fn func(id int64, value string)) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var value sql.NullString
+		if err := rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		if value.Valid && value.String != "" {
+			fn(id, value.String)
+		}
+	}
+	return rows.Err()
+}
+
+// Apply применяет метаданные к книгам библиотеки по file_hash.
+// Пустые поля записи не затирают существующие значения: импорт только дополняет и исправляет.
+// Все изменения одного импорта записываются в журнал как одна операция.
+func Apply(db *sql.DB, rootPath string, records []Record) (*ImportStats, error) {
+	cfg := config.GetConfig()
+	stats := &ImportStats{Total: len(records)}
+	operationID := history.NewOperation()
+
+	for _, rec := range records {
+		hash := strings.ToLower(strings.TrimSpace(rec.FileHash))
+		if hash == "" {
+			stats.Failed++
+			continue
+		}
+
+		var bookID int64
+		err := db.QueryRow("SELECT id FROM books WHERE file_hash = ?", hash).Scan(&bookID)
+		if err == sql.ErrNoRows {
+			stats.NotFound++
+			continue
+		}
+		if err != nil {
+			return stats, fmt.Errorf("ошибка поиска книги %s: %w", hash, err)
+		}
+
+		if err := applyRecord(db, rootPath, operationID, bookID, rec); err != nil {
+			log.Printf("Ошибка применения метаданных к книге ID %d (%s): %v", bookID, hash, err)
+			stats.Failed++
+			continue
+		}
+
+		stats.Applied++
+		if cfg.Debug {
+			log.Printf("Метаданные применены к книге ID %d (%s)", bookID, hash)
+		}
+	}
+
+	return stats, nil
+}
+
+// applyRecord обновляет поля, авторов и теги книги в одной транзакции, затем аннотацию
+func applyRecord(db *sql.DB, rootPath, operationID string, bookID int64, rec Record) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	values := map[string]string{
+		history.FieldTitle:        rec.Title,
+		history.FieldSeries:       rec.Series,
+		history.FieldSeriesNumber: rec.SeriesNumber,
+		history.FieldYear:         rec.Year,
+		history.FieldPublisher:    rec.Publisher,
+		history.FieldISBN:         rec.ISBN,
+		history.FieldAuthors:      strings.Join(rec.Authors, ", "),
+		history.FieldTags:         strings.Join(rec.Tags, ", "),
+		history.FieldLanguage:     language.Normalize(rec.Language),
+		history.FieldSourceLang:   language.Normalize(rec.SourceLang),
+	}
+	if rec.Over18 != nil {
+		values[history.FieldOver18] = "0"
+		if *rec.Over18 {
+			values[history.FieldOver18] = "1"
+		}
+	}
+
+	var changes []history.Change
+	for _, field := range []string{
+		history.FieldTitle, history.FieldAuthors, history.FieldSeries, history.FieldSeriesNumber,
+		history.FieldYear, history.FieldPublisher, history.FieldISBN, history.FieldTags, history.FieldOver18,
+		history.FieldLanguage, history.FieldSourceLang,
+	} {
+		value := strings.TrimSpace(values[field])
+		if value == "" {
+			continue
+		}
+		old, err := history.Current(tx, rootPath, bookID, field)
+		if err != nil {
+			return err
+		}
+		if err := history.SetField(tx, bookID, field, value); err != nil {
+			return fmt.Errorf("ошибка обновления поля %s: %w", field, err)
+		}
+		updated, err := history.Current(tx, rootPath, bookID, field)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, history.Change{BookID: bookID, Field: field, OldValue: old, NewValue: updated})
+	}
+
+	// CID уникален: если у книги он уже есть или занят другой книгой, не трогаем
+	if cid := strings.TrimSpace(rec.IPFSCID); cid != "" {
+		res, err := tx.Exec(`
+            UPDATE books SET ipfs_cid = ?
+            WHERE id = ? AND IFNULL(ipfs_cid, '') = ''
+              AND NOT EXISTS (SELECT 1 FROM books WHERE ipfs_cid = ?)`, cid, bookID, cid)
+		if err != nil {
+			return fmt.Errorf("ошибка обновления ipfs_cid: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			changes = append(changes, history.Change{BookID: bookID, Field: history.FieldIPFSCID, NewValue: cid})
+		}
+	}
+
+	var annotationChange *history.Change
+	if annotation := strings.TrimSpace(rec.Annotation); annotation != "" {
+		old, err := history.Current(tx, rootPath, bookID, history.FieldAnnotation)
+		if err != nil {
+			return err
+		}
+		if old != annotation {
+			annotationChange = &history.Change{BookID: bookID, Field: history.FieldAnnotation, OldValue: old, NewValue: annotation}
+			changes = append(changes, *annotationChange)
+		}
+	}
+
+	if err := history.Log(tx, operationID, history.OriginImport, changes...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации изменений: %w", err)
+	}
+
+	if annotationChange != nil {
+		if err := history.SetAnnotation(db, rootPath, bookID, annotationChange.NewValue); err != nil {
+			log.Printf("Ошибка сохранения аннотации книги ID %d: %v", bookID, err)
+		}
+	}
+	return nil
+}
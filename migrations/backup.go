@@ -0,0 +1,81 @@
+// migrations/backup.go
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupDatabase создаёт согласованную копию открытой базы данных в файле destPath.
+// Используется онлайн-бэкап SQLite, поэтому копия корректна даже при параллельной записи.
+func BackupDatabase(db *sql.DB, destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("файл резервной копии уже существует: %s", destPath)
+	}
+
+	if err := copyDatabase(db, destPath); err != nil {
+		// Недописанная копия бесполезна, удаляем её (файл к этому моменту уже закрыт)
+		os.Remove(destPath)
+		return err
+	}
+
+	return nil
+}
+
+// copyDatabase выполняет постраничное копирование БД через API онлайн-бэкапа SQLite
+func copyDatabase(db *sql.DB, destPath string) error {
+	ctx := context.Background()
+
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения соединения с БД: %w", err)
+	}
+	defer srcConn.Close()
+
+	destDB, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла резервной копии %s: %w", destPath, err)
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла резервной копии %s: %w", destPath, err)
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("неожиданный тип соединения резервной копии: %T", destDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("неожиданный тип соединения БД: %T", srcDriverConn)
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("ошибка инициализации резервного копирования: %w", err)
+			}
+
+			// Копируем все страницы за один шаг
+			done, err := backup.Step(-1)
+			if err != nil {
+				backup.Finish()
+				return fmt.Errorf("ошибка копирования страниц БД: %w", err)
+			}
+			if !done {
+				backup.Finish()
+				return fmt.Errorf("резервное копирование завершилось не полностью")
+			}
+
+			return backup.Finish()
+		})
+	})
+}
@@ -0,0 +1,194 @@
+// migrations/frozen.go
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Копии кода пакетов search и works в том виде, в каком его выполняли миграции 3 и 4.
+// Миграция должна одинаково обновлять базу любой версией программы, поэтому она не
+// вызывает живой код: изменения индекса или группировки оформляются новыми миграциями.
+// Эти функции не правятся.
+
+// ftsFoldV3 — SQL-аналог search.Fold: регистр приводит токенизатор, здесь остаётся только «ё»
+func ftsFoldV3(expr string) string {
+	return "REPLACE(REPLACE(IFNULL(" + expr + ", ''), 'ё', 'е'), 'Ё', 'Е')"
+}
+
+func ftsAuthorsV3(bookID string) string {
+	return ftsFoldV3("(SELECT GROUP_CONCAT(a.full_name, ' ') FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = " + bookID + ")")
+}
+
+func ftsTagsV3(bookID string) string {
+	return ftsFoldV3("(SELECT GROUP_CONCAT(t.name, ' ') FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.book_id = " + bookID + ")")
+}
+
+func ftsISBNV3(expr string) string {
+	return "REPLACE(REPLACE(IFNULL(" + expr + ", ''), '-', ''), ' ', '')"
+}
+
+// ftsSchemaV3 создаёт таблицу полнотекстового индекса и триггеры синхронизации
+var ftsSchemaV3 = `
+    CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+        title, series, authors, tags, isbn, annotation,
+        tokenize = 'unicode61 remove_diacritics 2'
+    );
+
+    -- Веса колонок для bm25: title, series, authors, tags, isbn, annotation
+    INSERT INTO books_fts(books_fts, rank) VALUES('rank', 'bm25(10.0, 6.0, 8.0, 3.0, 10.0, 1.0)');
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_after_insert AFTER INSERT ON books BEGIN
+        INSERT INTO books_fts(rowid, title, series, authors, tags, isbn, annotation)
+        VALUES (NEW.id, ` + ftsFoldV3("NEW.title") + `, ` + ftsFoldV3("NEW.series") + `, ` + ftsAuthorsV3("NEW.id") + `, ` + ftsTagsV3("NEW.id") + `, ` + ftsISBNV3("NEW.isbn") + `, '');
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_after_update AFTER UPDATE OF title, series, isbn ON books BEGIN
+        UPDATE books_fts SET title = ` + ftsFoldV3("NEW.title") + `, series = ` + ftsFoldV3("NEW.series") + `, isbn = ` + ftsISBNV3("NEW.isbn") + `
+        WHERE rowid = NEW.id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_after_delete AFTER DELETE ON books BEGIN
+        DELETE FROM books_fts WHERE rowid = OLD.id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_book_authors_insert AFTER INSERT ON book_authors BEGIN
+        UPDATE books_fts SET authors = ` + ftsAuthorsV3("NEW.book_id") + ` WHERE rowid = NEW.book_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_book_authors_delete AFTER DELETE ON book_authors BEGIN
+        UPDATE books_fts SET authors = ` + ftsAuthorsV3("OLD.book_id") + ` WHERE rowid = OLD.book_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_authors_update AFTER UPDATE OF full_name ON authors BEGIN
+        UPDATE books_fts SET authors = ` + ftsAuthorsV3("books_fts.rowid") + `
+        WHERE rowid IN (SELECT book_id FROM book_authors WHERE author_id = NEW.id);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_book_tags_insert AFTER INSERT ON book_tags BEGIN
+        UPDATE books_fts SET tags = ` + ftsTagsV3("NEW.book_id") + ` WHERE rowid = NEW.book_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_book_tags_delete AFTER DELETE ON book_tags BEGIN
+        UPDATE books_fts SET tags = ` + ftsTagsV3("OLD.book_id") + ` WHERE rowid = OLD.book_id;
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS books_fts_tags_update AFTER UPDATE OF name ON tags BEGIN
+        UPDATE books_fts SET tags = ` + ftsTagsV3("books_fts.rowid") + `
+        WHERE rowid IN (SELECT book_id FROM book_tags WHERE tag_id = NEW.id);
+    END;
+`
+
+// ftsPopulateV3 заполняет индекс по данным таблиц (без аннотаций)
+var ftsPopulateV3 = `
+    INSERT INTO books_fts(rowid, title, series, authors, tags, isbn, annotation)
+    SELECT b.id, ` + ftsFoldV3("b.title") + `, ` + ftsFoldV3("b.series") + `, ` + ftsAuthorsV3("b.id") + `, ` + ftsTagsV3("b.id") + `, ` + ftsISBNV3("b.isbn") + `, ''
+    FROM books b;
+`
+
+// worksNormalizeV4 — копия works.normalize: нижний регистр, ё→е,
+// только буквы и цифры, одиночные пробелы между словами
+func worksNormalizeV4(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// worksKeyV4 — копия works.Key: ключ группировки по авторам, названию и серии
+func worksKeyV4(title, series string, authors []string) string {
+	title = worksNormalizeV4(title)
+	if title == "" {
+		return ""
+	}
+	var names []string
+	for _, a := range authors {
+		if n := worksNormalizeV4(a); n != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|") + "\x1f" + title + "\x1f" + worksNormalizeV4(series)
+}
+
+// assignWorksV4 — копия works.AssignAll: привязывает к произведениям все книги без них
+func assignWorksV4(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id FROM books WHERE work_id IS NULL ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("ошибка получения книг без произведения: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, bookID := range ids {
+		var title, series sql.NullString
+		if err := tx.QueryRow("SELECT title, series FROM books WHERE id = ?", bookID).Scan(&title, &series); err != nil {
+			return fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+		}
+		authors, err := queryStringsV4(tx, `
+            SELECT a.full_name FROM book_authors ba
+            JOIN authors a ON a.id = ba.author_id
+            WHERE ba.book_id = ? AND a.full_name IS NOT NULL`, bookID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения авторов книги ID %d: %w", bookID, err)
+		}
+		key := worksKeyV4(title.String, series.String, authors)
+
+		var workID int64
+		if key != "" {
+			err = tx.QueryRow("SELECT id FROM works WHERE match_key = ?", key).Scan(&workID)
+			if err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("ошибка поиска произведения: %w", err)
+			}
+		}
+		if workID == 0 {
+			// Пустой ключ хранится как NULL, такие произведения не подбираются автоматически
+			var matchKey interface{}
+			if key != "" {
+				matchKey = key
+			}
+			res, err := tx.Exec("INSERT INTO works (title, match_key, created_at) VALUES (?, ?, ?)",
+				title.String, matchKey, time.Now().Unix())
+			if err != nil {
+				return fmt.Errorf("ошибка создания произведения: %w", err)
+			}
+			if workID, err = res.LastInsertId(); err != nil {
+				return fmt.Errorf("ошибка получения ID произведения: %w", err)
+			}
+		}
+
+		if _, err := tx.Exec("UPDATE books SET work_id = ? WHERE id = ?", workID, bookID); err != nil {
+			return fmt.Errorf("ошибка привязки книги ID %d к произведению: %w", bookID, err)
+		}
+	}
+	return nil
+}
+
+// queryStringsV4 возвращает первый столбец результата запроса
+func queryStringsV4(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
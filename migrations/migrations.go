@@ -0,0 +1,178 @@
+// migrations/migrations.go
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"turanga/config"
+)
+
+// ErrSchemaTooNew возвращается, если база данных создана более новой версией программы
+var ErrSchemaTooNew = errors.New("схема базы данных новее, чем поддерживает эта версия программы")
+
+// Migration описывает один шаг изменения схемы базы данных.
+// Шаги применяются строго по возрастанию Version, каждый в своей транзакции.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// LatestVersion возвращает номер последней известной программе версии схемы
+func LatestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// CurrentVersion возвращает номер версии схемы, записанный в базе данных
+func CurrentVersion(db *sql.DB) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения версии схемы: %w", err)
+	}
+	return version, nil
+}
+
+// Run приводит схему базы данных к последней версии.
+// Перед применением изменений к непустой базе создаётся резервная копия рядом с dbPath.
+// Если версия схемы в базе новее известной программе, возвращается ErrSchemaTooNew.
+func Run(db *sql.DB, dbPath string) error {
+	cfg := config.GetConfig()
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := LatestVersion()
+
+	if current > latest {
+		return fmt.Errorf("%w: версия в БД %d, поддерживается до %d", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		if cfg != nil && cfg.Debug {
+			log.Printf("Схема базы данных актуальна (версия %d)", current)
+		}
+		return nil
+	}
+
+	// Резервная копия нужна только если в базе уже есть данные
+	hasData, err := hasUserTables(db)
+	if err != nil {
+		return err
+	}
+	if hasData && dbPath != "" {
+		backupPath := fmt.Sprintf("%s.bak.v%d.%s", dbPath, current, time.Now().Format("20060102_150405"))
+		if err := BackupDatabase(db, backupPath); err != nil {
+			return fmt.Errorf("не удалось создать резервную копию перед миграцией: %w", err)
+		}
+		log.Printf("Резервная копия БД перед миграцией создана: %s", backupPath)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		log.Printf("Применяю миграцию %d: %s", m.Version, m.Name)
+		if err := apply(db, m); err != nil {
+			return fmt.Errorf("ошибка миграции %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+
+	log.Printf("Схема базы данных обновлена с версии %d до %d", current, latest)
+	return nil
+}
+
+// apply применяет одну миграцию и фиксирует её номер в той же транзакции
+func apply(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("ошибка записи версии схемы: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ensureVersionTable создаёт таблицу schema_version, если её ещё нет
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_version (
+            version INTEGER PRIMARY KEY,   -- Номер применённой миграции
+            name TEXT NOT NULL,            -- Краткое описание миграции
+            applied_at INTEGER NOT NULL    -- Время применения (UNIX timestamp)
+        )`)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы schema_version: %w", err)
+	}
+	return nil
+}
+
+// hasUserTables проверяет, есть ли в базе таблицы помимо служебных
+func hasUserTables(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM sqlite_master
+        WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'`).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки содержимого БД: %w", err)
+	}
+	return count > 0, nil
+}
+
+// columnExists проверяет, существует ли колонка в таблице
+func columnExists(tx *sql.Tx, tableName, columnName string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == columnName {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumnIfMissing добавляет колонку, если её ещё нет в таблице
+func addColumnIfMissing(tx *sql.Tx, tableName, columnName, definition string) error {
+	exists, err := columnExists(tx, tableName, columnName)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки колонки %s.%s: %w", tableName, columnName, err)
+	}
+	if exists {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, columnName, definition))
+	if err != nil {
+		return fmt.Errorf("ошибка добавления колонки %s.%s: %w", tableName, columnName, err)
+	}
+	return nil
+}
@@ -0,0 +1,788 @@
+// migrations/schema.go
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrations — упорядоченный список всех изменений схемы.
+// Новые миграции добавляются только в конец списка; уже выпущенные не изменяются.
+var migrations = []Migration{
+	{Version: 1, Name: "базовая схема", Up: migrateBaseSchema},
+	{Version: 2, Name: "поля *_lower для поиска и сортировки", Up: migrateLowercaseFields},
+	{Version: 3, Name: "полнотекстовый поисковый индекс", Up: migrateFullTextSearch},
+	{Version: 4, Name: "произведения из нескольких файлов", Up: migrateWorks},
+	{Version: 5, Name: "история изменений метаданных", Up: migrateEditHistory},
+	{Version: 6, Name: "корзина удалённых книг", Up: migrateTrash},
+	{Version: 7, Name: "учётные записи пользователей", Up: migrateUsers},
+	{Version: 8, Name: "сессии пользователей", Up: migrateSessions},
+	{Version: 9, Name: "устройства для входа в OPDS", Up: migrateDevices},
+	{Version: 10, Name: "закрытые теги", Up: migrateRestrictedTags},
+	{Version: 11, Name: "доступ к книгам через nostr", Up: migrateSharing},
+	{Version: 12, Name: "счётчик изменений библиотеки", Up: migrateLibraryGeneration},
+	{Version: 13, Name: "число страниц pdf и djvu", Up: migratePageCounts},
+	{Version: 14, Name: "синхронизация чтения KOReader", Up: migrateReadingProgress},
+	{Version: 15, Name: "отправка книг на устройства по почте", Up: migrateMailDelivery},
+	{Version: 16, Name: "места чтения во встроенной читалке", Up: migrateReaderPositions},
+	{Version: 17, Name: "язык книги и язык оригинала", Up: migrateLanguages},
+	{Version: 18, Name: "жанры книг по классификатору fb2", Up: migrateGenres},
+	{Version: 19, Name: "серии с псевдонимами и числовыми номерами томов", Up: migrateSeries},
+	{Version: 20, Name: "теги с настройками не удаляются вместе с последней книгой", Up: migrateKeepTagSettings},
+}
+
+// migrateBaseSchema создаёт исходный набор таблиц.
+// Для баз версии v0.1 таблицы уже существуют и остаются без изменений.
+func migrateBaseSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS books (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            title TEXT,
+            title_lower TEXT,
+            series TEXT,
+            series_lower TEXT,
+            series_number TEXT,
+            published_at TEXT,
+            isbn TEXT,
+            year TEXT,
+            publisher TEXT,
+            file_url TEXT,
+            file_type TEXT,
+            file_hash TEXT UNIQUE,
+            file_size INTEGER,
+            over18 INTEGER DEFAULT 0,
+            ipfs_cid TEXT UNIQUE
+        );
+        
+        CREATE TABLE IF NOT EXISTS authors (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            full_name TEXT UNIQUE,  -- Для отображения, UNIQUE для предотвращения дубликатов
+            full_name_lower TEXT,    -- Для поиска
+            last_name_lower TEXT   -- Для сортировки
+        );
+        
+        CREATE TABLE IF NOT EXISTS book_authors (
+            book_id INTEGER,
+            author_id INTEGER,
+            FOREIGN KEY(book_id) REFERENCES books(id),
+            FOREIGN KEY(author_id) REFERENCES authors(id),
+            UNIQUE(book_id, author_id)
+        );
+
+        CREATE TABLE IF NOT EXISTS tags (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL CHECK(LENGTH(name) <= 24)
+        );
+
+        CREATE TABLE IF NOT EXISTS book_tags (
+            book_id INTEGER,
+            tag_id INTEGER,
+            FOREIGN KEY(book_id) REFERENCES books(id) ON DELETE CASCADE,
+            FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+            UNIQUE(book_id, tag_id)
+        );
+
+        -- Таблица для хранения входящих запросов книг через Nostr (kind 8698)
+        CREATE TABLE IF NOT EXISTS nostr_book_requests (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            event_id TEXT UNIQUE NOT NULL, -- ID события Nostr (hex)
+            pubkey TEXT NOT NULL,           -- Публичный ключ отправителя (hex)
+            author TEXT,                    -- Автор из запроса
+            series TEXT,                    -- Серия из запроса
+            title TEXT,                     -- Название из запроса
+            file_hash TEXT,                 -- Хеш файла из запроса
+            isbn TEXT,                      -- ISBN из запроса
+            created_at INTEGER NOT NULL,    -- Время создания события (UNIX timestamp)
+            processed BOOLEAN NOT NULL DEFAULT FALSE, -- Флаг обработки
+            sent BOOLEAN NOT NULL DEFAULT FALSE,      -- Флаг отправки ответа
+            UNIQUE(event_id)                -- Гарантируем уникальность события
+        );
+
+        -- Таблица связи между запросами и найденными книгами
+        CREATE TABLE IF NOT EXISTS nostr_request_books (
+            request_id INTEGER NOT NULL,
+            book_id INTEGER NOT NULL,
+            file_hash TEXT,
+            FOREIGN KEY (request_id) REFERENCES nostr_book_requests (id) ON DELETE CASCADE,
+            FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+            UNIQUE(request_id, book_id) -- Гарантируем уникальность связи
+        );
+
+        -- Таблица для хранения ответов, полученных на наши запросы (kind 8699)
+        CREATE TABLE IF NOT EXISTS nostr_received_responses (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            event_id TEXT UNIQUE NOT NULL,           -- ID события ответа Nostr (hex)
+            responder_pubkey TEXT NOT NULL,          -- Публичный ключ отправителя ответа (hex)
+            request_event_id TEXT NOT NULL,          -- ID события запроса, на который дан ответ
+            received_at INTEGER NOT NULL,            -- Время получения события (UNIX timestamp)
+            content TEXT NOT NULL,                   -- Содержимое события (JSON с массивом BookResponseData)
+            processed BOOLEAN NOT NULL DEFAULT FALSE -- Флаг обработки/отображения пользователю
+        );
+
+        -- Таблица для хранения данных о книгах из полученных ответов
+        CREATE TABLE IF NOT EXISTS nostr_response_books (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            response_id INTEGER NOT NULL,           -- Ссылка на ответ, в котором получена книга
+            book_id INTEGER,                        -- ID книги в нашей локальной БД (если найдена)
+            title TEXT NOT NULL,                    -- Название книги из ответа
+            authors TEXT NOT NULL,                  -- Авторы книги из ответа (строка, разделенная запятыми)
+            series TEXT,                            -- Серия из ответа
+            series_number TEXT,                     -- Номер в серии из ответа
+            file_type TEXT NOT NULL,                -- Тип файла из ответа
+            file_hash TEXT,                         -- Хеш файла из ответа
+            file_size INTEGER,                      -- Размер файла из ответа
+            ipfs_cid TEXT,                          -- IPFS CID из ответа (если есть)
+            raw_data TEXT NOT NULL,                 -- Полные необработанные данные книги (JSON BookResponseData)
+            FOREIGN KEY (response_id) REFERENCES nostr_received_responses (id) ON DELETE CASCADE
+        );
+
+        -- Таблица для хранения друзей (источников книг)
+        CREATE TABLE IF NOT EXISTS friends (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            pubkey TEXT UNIQUE NOT NULL,            -- Публичный ключ друга
+            name TEXT,                              -- Имя друга (опционально)
+            download_count INTEGER DEFAULT 0,       -- Количество скачанных книг от этого друга
+            last_download_at INTEGER,               -- Время последнего скачивания
+            created_at INTEGER NOT NULL,            -- Время добавления в друзья
+            updated_at INTEGER NOT NULL             -- Время последнего обновления
+        );
+
+        -- Создаем триггер для автоматического удаления неиспользуемых тегов
+        CREATE TRIGGER IF NOT EXISTS delete_unused_tag_after_book_tag_delete
+        AFTER DELETE ON book_tags
+        FOR EACH ROW
+        WHEN NOT EXISTS (SELECT 1 FROM book_tags WHERE tag_id = OLD.tag_id)
+        BEGIN
+            DELETE FROM tags WHERE id = OLD.tag_id;
+        END;
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблиц: %w", err)
+	}
+	return nil
+}
+
+// migrateLowercaseFields переводит базу v0.1 на формат v0.2:
+// убирает устаревший authors.last_name, добавляет и заполняет *_lower поля,
+// создаёт индексы для них и добавляет isbn в nostr_book_requests.
+func migrateLowercaseFields(tx *sql.Tx) error {
+	hasOldLastName, err := columnExists(tx, "authors", "last_name")
+	if err != nil {
+		return fmt.Errorf("ошибка проверки колонки authors.last_name: %w", err)
+	}
+	if hasOldLastName {
+		// Пересоздаём таблицу: создаём новую, копируем данные и подменяем старую.
+		// Переименование исходной таблицы не используется, чтобы SQLite не переписал
+		// внешние ключи book_authors на временное имя.
+		_, err = tx.Exec(`
+            CREATE TABLE authors_new (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                full_name TEXT UNIQUE,
+                full_name_lower TEXT,
+                last_name_lower TEXT
+            );
+            INSERT INTO authors_new (id, full_name) SELECT id, full_name FROM authors;
+            DROP TABLE authors;
+            ALTER TABLE authors_new RENAME TO authors;
+        `)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления колонки authors.last_name: %w", err)
+		}
+	}
+
+	if err := addColumnIfMissing(tx, "books", "title_lower", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "books", "series_lower", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "authors", "full_name_lower", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "authors", "last_name_lower", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "nostr_book_requests", "isbn", "TEXT"); err != nil {
+		return err
+	}
+
+	if err := fillBooksLowerFields(tx); err != nil {
+		return err
+	}
+	if err := fillAuthorsLowerFields(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_books_title_lower ON books(title_lower);
+        CREATE INDEX IF NOT EXISTS idx_books_series_lower ON books(series_lower);
+        CREATE INDEX IF NOT EXISTS idx_authors_last_name_lower ON authors(last_name_lower);
+        CREATE INDEX IF NOT EXISTS idx_authors_full_name_lower ON authors(full_name_lower);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания индексов для lower-полей: %w", err)
+	}
+
+	return nil
+}
+
+// fillBooksLowerFields заполняет title_lower и series_lower там, где они пусты.
+// strings.ToLower используется вместо LOWER() SQLite, который не понимает кириллицу.
+func fillBooksLowerFields(tx *sql.Tx) error {
+	type bookRow struct {
+		id     int64
+		title  sql.NullString
+		series sql.NullString
+	}
+
+	rows, err := tx.Query("SELECT id, title, series FROM books WHERE title_lower IS NULL OR series_lower IS NULL")
+	if err != nil {
+		return fmt.Errorf("ошибка запроса книг: %w", err)
+	}
+	var books []bookRow
+	for rows.Next() {
+		var b bookRow
+		if err := rows.Scan(&b.id, &b.title, &b.series); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка сканирования книги: %w", err)
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+
+	if len(books) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare("UPDATE books SET title_lower = ?, series_lower = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("ошибка подготовки обновления книг: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, b := range books {
+		if _, err := stmt.Exec(strings.ToLower(b.title.String), strings.ToLower(b.series.String), b.id); err != nil {
+			return fmt.Errorf("ошибка обновления lower-полей книги ID %d: %w", b.id, err)
+		}
+	}
+	return nil
+}
+
+// fillAuthorsLowerFields заполняет full_name_lower и last_name_lower там, где они пусты.
+// Фамилией считается последнее слово полного имени.
+func fillAuthorsLowerFields(tx *sql.Tx) error {
+	type authorRow struct {
+		id       int64
+		fullName sql.NullString
+	}
+
+	rows, err := tx.Query("SELECT id, full_name FROM authors WHERE full_name_lower IS NULL OR last_name_lower IS NULL")
+	if err != nil {
+		return fmt.Errorf("ошибка запроса авторов: %w", err)
+	}
+	var authors []authorRow
+	for rows.Next() {
+		var a authorRow
+		if err := rows.Scan(&a.id, &a.fullName); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка сканирования автора: %w", err)
+		}
+		authors = append(authors, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения авторов: %w", err)
+	}
+
+	if len(authors) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare("UPDATE authors SET full_name_lower = ?, last_name_lower = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("ошибка подготовки обновления авторов: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, a := range authors {
+		fullNameLower := strings.ToLower(a.fullName.String)
+		lastNameLower := fullNameLower
+		if parts := strings.Fields(fullNameLower); len(parts) > 0 {
+			lastNameLower = parts[len(parts)-1]
+		}
+		if _, err := stmt.Exec(fullNameLower, lastNameLower, a.id); err != nil {
+			return fmt.Errorf("ошибка обновления lower-полей автора ID %d: %w", a.id, err)
+		}
+	}
+	return nil
+}
+
+// migrateFullTextSearch создаёт индекс FTS5 с триггерами и заполняет его.
+// Аннотации подтягиваются позже, при ревизии библиотеки.
+func migrateFullTextSearch(tx *sql.Tx) error {
+	if _, err := tx.Exec(ftsSchemaV3); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			return fmt.Errorf("SQLite собран без FTS5, соберите программу с тегом sqlite_fts5: %w", err)
+		}
+		return fmt.Errorf("ошибка создания поискового индекса: %w", err)
+	}
+	if _, err := tx.Exec(ftsPopulateV3); err != nil {
+		return fmt.Errorf("ошибка заполнения поискового индекса: %w", err)
+	}
+	return nil
+}
+
+// migrateWorks добавляет таблицу произведений и группирует существующие книги
+func migrateWorks(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS works (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            title TEXT,                     -- Название для отображения
+            match_key TEXT UNIQUE,          -- Нормализованные авторы+название+серия; NULL для ручных групп
+            created_at INTEGER NOT NULL     -- Время создания (UNIX timestamp)
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы works: %w", err)
+	}
+
+	if err := addColumnIfMissing(tx, "books", "work_id", "INTEGER REFERENCES works(id)"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_books_work_id ON books(work_id);
+
+        -- Удаляем произведение, когда из него ушла последняя книга
+        CREATE TRIGGER IF NOT EXISTS delete_empty_work_after_book_delete
+        AFTER DELETE ON books
+        FOR EACH ROW
+        WHEN OLD.work_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM books WHERE work_id = OLD.work_id)
+        BEGIN
+            DELETE FROM works WHERE id = OLD.work_id;
+        END;
+
+        CREATE TRIGGER IF NOT EXISTS delete_empty_work_after_book_move
+        AFTER UPDATE OF work_id ON books
+        FOR EACH ROW
+        WHEN OLD.work_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM books WHERE work_id = OLD.work_id)
+        BEGIN
+            DELETE FROM works WHERE id = OLD.work_id;
+        END;
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания индексов и триггеров works: %w", err)
+	}
+
+	if err := assignWorksV4(tx); err != nil {
+		return fmt.Errorf("ошибка группировки книг: %w", err)
+	}
+	return nil
+}
+
+// migrateEditHistory добавляет журнал изменений метаданных книг
+func migrateEditHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS edit_history (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            operation_id TEXT NOT NULL,     -- Общий идентификатор изменений одной операции
+            book_id INTEGER NOT NULL,
+            field TEXT NOT NULL,            -- Изменённое поле: title, authors, series, tags...
+            old_value TEXT,
+            new_value TEXT,
+            origin TEXT NOT NULL,           -- Источник: web, revision, nostr, import, undo
+            created_at INTEGER NOT NULL,    -- Время изменения (UNIX timestamp)
+            undone_at INTEGER               -- Время отмены, NULL если изменение действует
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_edit_history_book ON edit_history(book_id, id);
+        CREATE INDEX IF NOT EXISTS idx_edit_history_operation ON edit_history(operation_id);
+
+        -- История удалённой книги больше не нужна
+        CREATE TRIGGER IF NOT EXISTS delete_history_after_book_delete
+        AFTER DELETE ON books
+        FOR EACH ROW
+        BEGIN
+            DELETE FROM edit_history WHERE book_id = OLD.id;
+        END;
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы edit_history: %w", err)
+	}
+	return nil
+}
+
+// migrateTrash добавляет корзину: удалённая книга хранится в ней вместе с метаданными
+// до восстановления или очистки. История изменений больше не удаляется вместе с книгой,
+// чтобы пережить её восстановление из корзины; при очистке корзины она удаляется явно.
+func migrateTrash(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS trash (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            book_id INTEGER NOT NULL,       -- ID книги до удаления, при восстановлении используется снова
+            title TEXT,
+            file_hash TEXT,
+            file_type TEXT,
+            file_size INTEGER,
+            original_path TEXT,             -- Где лежал файл книги
+            trash_path TEXT,                -- Где файл лежит в корзине, пусто если файла не было
+            book_data TEXT NOT NULL,        -- Строка books, авторы и теги в JSON
+            deleted_at INTEGER NOT NULL     -- Время удаления (UNIX timestamp)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_trash_deleted_at ON trash(deleted_at);
+
+        DROP TRIGGER IF EXISTS delete_history_after_book_delete;
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы trash: %w", err)
+	}
+	return nil
+}
+
+// migrateUsers добавляет учётные записи пользователей с ролями вместо единого пароля из turanga.conf.
+// Прежний password_hash переносится в учётную запись admin при запуске (users.ImportLegacyPassword).
+func migrateUsers(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS users (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            login TEXT NOT NULL,
+            login_lower TEXT NOT NULL UNIQUE,  -- Для входа без учёта регистра
+            password_hash TEXT NOT NULL,
+            role TEXT NOT NULL CHECK(role IN ('admin', 'reader', 'child')),
+            created_at INTEGER NOT NULL       -- Время создания (UNIX timestamp)
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы users: %w", err)
+	}
+	return nil
+}
+
+// migrateSessions добавляет серверные сессии. В cookie хранится случайный токен,
+// в базе — только его SHA-256, поэтому утечка базы не даёт войти чужой сессией.
+func migrateSessions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS sessions (
+            token_hash TEXT PRIMARY KEY,       -- SHA-256 токена из cookie (hex)
+            user_id INTEGER NOT NULL,
+            csrf_token TEXT NOT NULL,          -- Токен для проверки изменяющих запросов
+            user_agent TEXT,
+            created_at INTEGER NOT NULL,       -- Время входа (UNIX timestamp)
+            expires_at INTEGER NOT NULL        -- Время окончания сессии (UNIX timestamp)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+        CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы sessions: %w", err)
+	}
+	return nil
+}
+
+// migrateDevices добавляет устройства (читалки) с отдельными токенами для входа в OPDS-каталог.
+// Как и у сессий, хранится только SHA-256 токена.
+func migrateDevices(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS devices (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,                -- Название, которое дал пользователь
+            token_hash TEXT NOT NULL UNIQUE,   -- SHA-256 токена (hex)
+            created_at INTEGER NOT NULL,       -- Время создания (UNIX timestamp)
+            last_used_at INTEGER               -- Время последнего запроса (UNIX timestamp)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы devices: %w", err)
+	}
+	return nil
+}
+
+// migrateRestrictedTags добавляет пометку закрытого тега: книги с таким тегом
+// скрываются так же, как книги 18+ (см. пакет access)
+func migrateRestrictedTags(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE tags ADD COLUMN restricted INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки tags.restricted: %w", err)
+	}
+	return nil
+}
+
+// migrateSharing добавляет книгам и тегам настройку shared: отвечать на запросы
+// Nostr всем (shared), только друзьям (friends) или никому (never)
+func migrateSharing(tx *sql.Tx) error {
+	for _, table := range []string{"books", "tags"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN shared TEXT NOT NULL DEFAULT 'shared'"); err != nil {
+			return fmt.Errorf("ошибка добавления колонки %s.shared: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// generationTables — таблицы, изменение которых меняет содержимое каталога
+var generationTables = []string{"books", "authors", "book_authors", "tags", "book_tags", "works"}
+
+// migrateLibraryGeneration добавляет счётчик изменений библиотеки. Триггеры увеличивают его
+// при любой записи в таблицы каталога; по нему строятся ETag и Last-Modified (пакет generation).
+func migrateLibraryGeneration(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS library_generation (
+            id INTEGER PRIMARY KEY CHECK (id = 1),
+            value INTEGER NOT NULL,            -- Номер изменения, растёт при каждой записи
+            modified_at INTEGER NOT NULL       -- Время последнего изменения (UNIX timestamp)
+        );
+
+        INSERT OR IGNORE INTO library_generation (id, value, modified_at) VALUES (1, 1, strftime('%s', 'now'));
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы library_generation: %w", err)
+	}
+
+	for _, table := range generationTables {
+		if err := createGenerationTriggers(tx, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createGenerationTriggers создаёт триггеры, увеличивающие счётчик изменений библиотеки
+// при записи в таблицу
+func createGenerationTriggers(tx *sql.Tx, table string) error {
+	for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+		_, err := tx.Exec(fmt.Sprintf(`
+        CREATE TRIGGER IF NOT EXISTS bump_generation_after_%[1]s_%[2]s
+        AFTER %[3]s ON %[1]s
+        BEGIN
+            UPDATE library_generation SET value = value + 1, modified_at = strftime('%%s', 'now') WHERE id = 1;
+        END;`, table, strings.ToLower(event), event))
+		if err != nil {
+			return fmt.Errorf("ошибка создания триггера счётчика изменений для %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// migratePageCounts добавляет число страниц файлов PDF и DJVU для постраничного
+// просмотра в opds (OPDS-PSE). Заполняется при первом показе книги в opds.
+func migratePageCounts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS page_counts (
+            file_hash TEXT PRIMARY KEY,        -- Хеш файла книги
+            pages INTEGER NOT NULL             -- Число страниц
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы page_counts: %w", err)
+	}
+	return nil
+}
+
+// migrateReadingProgress добавляет сервер синхронизации KOReader (пакет kosync): ключ
+// синхронизации устройств, прогресс чтения пользователей и дайджесты файлов, по которым
+// KOReader узнаёт книгу. В KOReader ключ — MD5 пароля, паролем служит токен устройства,
+// поэтому в базе, как и для токена, хранится только SHA-256 ключа.
+func migrateReadingProgress(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE devices ADD COLUMN sync_key_hash TEXT"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки devices.sync_key_hash: %w", err)
+	}
+
+	_, err := tx.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_devices_sync_key_hash ON devices(sync_key_hash);
+
+        CREATE TABLE IF NOT EXISTS reading_progress (
+            user_id INTEGER NOT NULL,
+            document TEXT NOT NULL,            -- Дайджест файла, который прислал KOReader
+            progress TEXT NOT NULL,            -- Позиция в книге (xpointer или номер страницы)
+            percentage REAL NOT NULL,          -- Доля прочитанного, от 0 до 1
+            device TEXT,                       -- Название устройства в KOReader
+            device_id TEXT,
+            updated_at INTEGER NOT NULL,       -- Время сохранения (UNIX timestamp)
+            PRIMARY KEY (user_id, document)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_reading_progress_updated_at ON reading_progress(user_id, updated_at);
+
+        CREATE TABLE IF NOT EXISTS document_digests (
+            digest TEXT PRIMARY KEY,           -- Частичный MD5 файла, как его считает KOReader
+            file_hash TEXT NOT NULL,           -- Хеш файла книги
+            converted INTEGER NOT NULL DEFAULT 0 -- 1 — дайджест EPUB, сконвертированного из fb2
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_document_digests_file_hash ON document_digests(file_hash);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблиц синхронизации чтения: %w", err)
+	}
+	return nil
+}
+
+// migrateMailDelivery добавляет почтовые адреса устройств пользователей (Kindle, PocketBook)
+// и журнал отправки книг на них с повторными попытками (пакет mailer)
+func migrateMailDelivery(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS mail_devices (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,                -- Название, которое дал пользователь
+            email TEXT NOT NULL,
+            convert_fb2 INTEGER NOT NULL DEFAULT 1, -- 1 — отправлять fb2 в EPUB
+            created_at INTEGER NOT NULL        -- Время добавления (UNIX timestamp)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_mail_devices_user_id ON mail_devices(user_id);
+
+        CREATE TABLE IF NOT EXISTS mail_deliveries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            book_id INTEGER NOT NULL,
+            title TEXT NOT NULL,               -- Название книги на момент отправки
+            email TEXT NOT NULL,               -- Адрес, на который отправляется книга
+            convert_fb2 INTEGER NOT NULL DEFAULT 1,
+            status TEXT NOT NULL CHECK(status IN ('pending', 'sent', 'failed')),
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT,
+            created_at INTEGER NOT NULL,       -- Время постановки в очередь (UNIX timestamp)
+            next_attempt_at INTEGER NOT NULL,  -- Когда пробовать отправить (UNIX timestamp)
+            sent_at INTEGER                    -- Время успешной отправки (UNIX timestamp)
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_mail_deliveries_user_id ON mail_deliveries(user_id, created_at);
+        CREATE INDEX IF NOT EXISTS idx_mail_deliveries_status ON mail_deliveries(status, next_attempt_at);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблиц отправки книг по почте: %w", err)
+	}
+	return nil
+}
+
+// migrateReaderPositions добавляет места чтения книг во встроенной читалке (пакет reader)
+func migrateReaderPositions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS reader_positions (
+            user_id INTEGER NOT NULL,
+            book_id INTEGER NOT NULL,
+            chapter INTEGER NOT NULL,          -- Номер главы, с нуля
+            scroll REAL NOT NULL DEFAULT 0,    -- Доля прокрутки главы
+            updated_at INTEGER NOT NULL,       -- Время последнего чтения (UNIX timestamp)
+            PRIMARY KEY (user_id, book_id)
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы мест чтения: %w", err)
+	}
+	return nil
+}
+
+// migrateLanguages добавляет книгам язык и язык оригинала (коды ISO 639-1, пакет language).
+// NULL означает, что язык ещё не извлекался из файла: его заполнит ревизия
+// (scanner.FillMissingLanguages); пустая строка — язык в файле не указан.
+func migrateLanguages(tx *sql.Tx) error {
+	for _, column := range []string{"language", "source_language"} {
+		if _, err := tx.Exec("ALTER TABLE books ADD COLUMN " + column + " TEXT"); err != nil {
+			return fmt.Errorf("ошибка добавления колонки books.%s: %w", column, err)
+		}
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_books_language ON books(language)"); err != nil {
+		return fmt.Errorf("ошибка создания индекса по языку книг: %w", err)
+	}
+	// Необязательный язык в запросах книг через Nostr
+	if _, err := tx.Exec("ALTER TABLE nostr_book_requests ADD COLUMN language TEXT"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки nostr_book_requests.language: %w", err)
+	}
+	return nil
+}
+
+// migrateGenres добавляет жанры книг по классификатору FB2 (пакет genres).
+// genres_scanned = 0 означает, что жанры ещё не извлекались из файла:
+// их заполнит ревизия (scanner.FillMissingGenres).
+func migrateGenres(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS book_genres (
+            book_id INTEGER NOT NULL,
+            genre TEXT NOT NULL,               -- Код жанра fb2 (sf_fantasy, det_classic)
+            PRIMARY KEY (book_id, genre),
+            FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_book_genres_genre ON book_genres(genre);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы жанров книг: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE books ADD COLUMN genres_scanned INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки books.genres_scanned: %w", err)
+	}
+	// Жанры показываются в каталоге: их изменение меняет ETag так же, как изменение тегов
+	return createGenerationTriggers(tx, "book_genres")
+}
+
+// migrateSeries выносит серии в отдельную таблицу. books.series остаётся
+// каноническим названием серии, books.series_id ссылается на неё, а
+// books.series_index хранит номер тома числом для сортировки. Существующие книги
+// связывает с сериями ревизия (series.SyncAll).
+func migrateSeries(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS series (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,                -- Каноническое название
+            name_lower TEXT NOT NULL UNIQUE,
+            expected_count INTEGER,            -- Ожидаемое число томов, NULL — неизвестно
+            created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+        );
+
+        CREATE TABLE IF NOT EXISTS series_aliases (
+            alias_lower TEXT PRIMARY KEY,
+            alias TEXT NOT NULL,               -- Другое написание названия (перевод, старое название)
+            series_id INTEGER NOT NULL,
+            FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE CASCADE
+        );
+
+        CREATE INDEX IF NOT EXISTS idx_series_aliases_series_id ON series_aliases(series_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблиц серий: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE books ADD COLUMN series_id INTEGER REFERENCES series(id)"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки books.series_id: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE books ADD COLUMN series_index REAL"); err != nil {
+		return fmt.Errorf("ошибка добавления колонки books.series_index: %w", err)
+	}
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_books_series_id ON books(series_id, series_index)"); err != nil {
+		return fmt.Errorf("ошибка создания индекса books.series_id: %w", err)
+	}
+	// Число томов и псевдонимы показываются на странице серии
+	if err := createGenerationTriggers(tx, "series"); err != nil {
+		return err
+	}
+	return createGenerationTriggers(tx, "series_aliases")
+}
+
+// migrateKeepTagSettings пересоздаёт триггер удаления тегов без книг так, чтобы закрытые
+// теги и теги с настройкой доступа через Nostr сохранялись. Иначе после корзины или
+// повторной пометки книги тегом он возвращался бы открытым и доступным всем.
+func migrateKeepTagSettings(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        DROP TRIGGER IF EXISTS delete_unused_tag_after_book_tag_delete;
+
+        CREATE TRIGGER delete_unused_tag_after_book_tag_delete
+        AFTER DELETE ON book_tags
+        FOR EACH ROW
+        WHEN NOT EXISTS (SELECT 1 FROM book_tags WHERE tag_id = OLD.tag_id)
+        BEGIN
+            DELETE FROM tags WHERE id = OLD.tag_id AND restricted = 0 AND shared = 'shared';
+        END;
+    `)
+	if err != nil {
+		return fmt.Errorf("ошибка пересоздания триггера удаления тегов: %w", err)
+	}
+	return nil
+}
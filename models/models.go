@@ -22,9 +22,10 @@ type Book struct {
 }
 
 type BookFile struct {
-	URL      string `json:"url"`
-	Type     string `json:"type"`
-	FileHash string `json:"file_hash"`
+	URL       string `json:"url"`
+	Type      string `json:"type"`
+	FileHash  string `json:"file_hash"`
+	PageCount int    `json:"page_count,omitempty"` // Число страниц PDF/DJVU для OPDS-PSE
 }
 
 // Author представляет автора книги
@@ -53,6 +54,8 @@ type BookWeb struct {
 	ISBN         string        `json:"isbn"`
 	Year         string        `json:"year"`
 	Publisher    string        `json:"publisher"`
+	Language     string        `json:"language"`
+	SourceLang   string        `json:"source_language"`
 	Files        []BookFileWeb `json:"files"`
 	TagsStr      string        `json:"-"`
 	FileType     string        `json:"file_type"`
@@ -64,21 +67,29 @@ type BookWeb struct {
 
 // BookFileWeb структура для файла книги в веб-интерфейсе
 type BookFileWeb struct {
+	BookID   int    `json:"book_id"` // Книга, которой принадлежит файл (у форматов одного произведения разные)
 	URL      string `json:"url"`
 	Type     string `json:"type"`
 	FileHash string `json:"file_hash"`
 	FileSize int64  `json:"file_size"`
+	IPFSCID  string `json:"ipfs_cid"`
+	// Converted — EPUB, который сервер сконвертирует из fb2 при скачивании
+	Converted bool `json:"converted,omitempty"`
 }
 
 // Feed представляет собой OPDS каталог
 type Feed struct {
 	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
-	Title   string   `xml:"title"`
-	ID      string   `xml:"id"`
-	Updated string   `xml:"updated"`
-	Icon    string   `xml:"icon,omitempty"`
-	Links   []Link   `xml:"link"`
-	Entries []Entry  `xml:"entry"`
+	// Пространства имён opds и thr нужны для ссылок-фасетов
+	XmlnsOpds string  `xml:"xmlns:opds,attr,omitempty"`
+	XmlnsThr  string  `xml:"xmlns:thr,attr,omitempty"`
+	XmlnsPSE  string  `xml:"xmlns:pse,attr,omitempty"` // Для ссылок постраничного просмотра (OPDS-PSE)
+	Title     string  `xml:"title"`
+	ID        string  `xml:"id"`
+	Updated   string  `xml:"updated"`
+	Icon      string  `xml:"icon,omitempty"`
+	Links     []Link  `xml:"link"`
+	Entries   []Entry `xml:"entry"`
 }
 
 // AuthorInfoForOPDS представляет автора для OPDS фида
@@ -106,9 +117,18 @@ type Content struct {
 
 // Link представляет собой ссылку на ресурс
 type Link struct {
-	Href string `xml:"href,attr"`
-	Type string `xml:"type,attr"`
-	Rel  string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Rel   string `xml:"rel,attr"`
+	Title string `xml:"title,attr,omitempty"`
+
+	// Поля ссылок-фасетов OPDS (rel="http://opds-spec.org/facet")
+	FacetGroup  string `xml:"opds:facetGroup,attr,omitempty"`
+	ActiveFacet string `xml:"opds:activeFacet,attr,omitempty"`
+	Count       int    `xml:"thr:count,attr,omitempty"`
+
+	// Число страниц у ссылки постраничного просмотра OPDS-PSE
+	PSECount int `xml:"pse:count,attr,omitempty"`
 }
 
 // BookDetailPageData содержит данные для страницы деталей книги
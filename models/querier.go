@@ -0,0 +1,12 @@
+// models/querier.go
+package models
+
+import "database/sql"
+
+// Querier — общее для *sql.DB и *sql.Tx подмножество методов: функции хранилищ
+// (works, history, genres, series) принимают его, чтобы работать и внутри транзакции
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
@@ -7,8 +7,11 @@ import (
 	"log"
 	"strings"
 	"time"
+	"turanga/access"
 	"turanga/config"
+	"turanga/language"
 	"turanga/scanner"
+	"turanga/search"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -162,9 +165,12 @@ func (sm *SubscriptionManager) handleIncomingBookRequest(event *nostr.Event) {
 		}
 	}
 
+	// Язык необязателен; непонятный язык не сужает поиск
+	requestData.Language = language.Normalize(requestData.Language)
+
 	if cfg.Debug {
-		log.Printf("Детали запроса: Автор='%s', Серия='%s', Название='%s', Хеш='%s', ISBN='%s', Источник='%s'",
-			requestData.Author, requestData.Series, requestData.Title, requestData.FileHash, requestData.ISBN, requestData.Source)
+		log.Printf("Детали запроса: Автор='%s', Серия='%s', Название='%s', Хеш='%s', ISBN='%s', Язык='%s', Источник='%s'",
+			requestData.Author, requestData.Series, requestData.Title, requestData.FileHash, requestData.ISBN, requestData.Language, requestData.Source)
 	}
 
 	// 6. Сохраняем запрос в БД
@@ -178,9 +184,9 @@ func (sm *SubscriptionManager) handleIncomingBookRequest(event *nostr.Event) {
 	defer tx.Rollback() // Откат в случае ошибки
 
 	result, err := tx.Exec(`
-        INSERT INTO nostr_book_requests (event_id, pubkey, author, series, title, file_hash, isbn, created_at, processed, sent)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-    `, event.ID, event.PubKey, requestData.Author, requestData.Series, requestData.Title, requestData.FileHash, requestData.ISBN, event.CreatedAt, false, false)
+        INSERT INTO nostr_book_requests (event_id, pubkey, author, series, title, file_hash, isbn, language, created_at, processed, sent)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, event.ID, event.PubKey, requestData.Author, requestData.Series, requestData.Title, requestData.FileHash, requestData.ISBN, requestData.Language, event.CreatedAt, false, false)
 	if err != nil {
 		if cfg.Debug {
 			log.Printf("Ошибка сохранения запроса %s в БД: %v", event.ID, err)
@@ -196,69 +202,31 @@ func (sm *SubscriptionManager) handleIncomingBookRequest(event *nostr.Event) {
 		return
 	}
 
-	// 7. Ищем книги в локальной БД по критериям запроса
+	// 7. Ищем книги в локальной БД по критериям запроса.
+	// Короткие (меньше 5 символов) название и серия должны совпадать целиком,
+	// иначе запросы вроде "Мы" или "SPQR" перегружают ответ совпадениями.
 	var bookIDs []int64
-	query := "SELECT DISTINCT b.id FROM books b WHERE 1=1"
-	args := []interface{}{}
-
-	if requestData.Title != "" {
-		// Используем title_lower для регистронезависимого поиска
-		if len(requestData.Title) < 5 {
-			// Если длина меньше 5, ищем точное совпадение (целиком)
-			query += " AND b.title_lower = ?"
-			args = append(args, strings.ToLower(requestData.Title))
-		} else {
-			// Если длина 5 или больше, ищем как подстроку
-			query += " AND b.title_lower LIKE ?"
-			args = append(args, "%"+strings.ToLower(requestData.Title)+"%")
-		}
-	}
-	if requestData.Series != "" {
-		// Используем series_lower для регистронезависимого поиска
-		if len(requestData.Series) < 5 {
-			// Если длина меньше 5, ищем точное совпадение (целиком)
-			query += " AND b.series_lower = ?"
-			args = append(args, strings.ToLower(requestData.Series))
-		} else {
-			// Если длина 5 или больше, ищем как подстроку
-			query += " AND b.series_lower LIKE ?"
-			args = append(args, "%"+strings.ToLower(requestData.Series)+"%")
-		}
-	}
-	if requestData.Author != "" {
-		// Используем last_name_lower или full_name_lower для регистронезависимого поиска
-		// Сначала пробуем last_name_lower
-		query += " AND EXISTS (SELECT 1 FROM book_authors ba JOIN authors a ON ba.author_id = a.id WHERE ba.book_id = b.id AND a.last_name_lower = ?)"
-		args = append(args, strings.ToLower(requestData.Author))
-	}
-	if requestData.FileHash != "" {
-		query += " AND b.file_hash = ?"
-		args = append(args, requestData.FileHash)
-	}
-	if requestData.ISBN != "" {
-		// Убираем тире и пробелы из ISBN для поиска
-		cleanedISBN := strings.ReplaceAll(strings.ReplaceAll(requestData.ISBN, "-", ""), " ", "")
-		query += " AND REPLACE(REPLACE(b.isbn, '-', ''), ' ', '') LIKE ?"
-		args = append(args, "%"+cleanedISBN+"%")
-	}
-
-	rows, err := tx.Query(query, args...)
+	found, err := search.Books(sm.db, search.Query{
+		Title:       requestData.Title,
+		TitleExact:  len(requestData.Title) < 5,
+		Series:      requestData.Series,
+		SeriesExact: len(requestData.Series) < 5,
+		Author:      requestData.Author,
+		ISBN:        requestData.ISBN,
+		FileHash:    requestData.FileHash,
+		Language:    requestData.Language,
+		// Ответы публикуются в открытой сети: закрытые книги не отдаём никому
+		Access: access.Guest,
+	})
 	if err != nil {
 		if cfg.Debug {
 			log.Printf("Ошибка поиска книг для запроса %s: %v", event.ID, err)
 		}
 	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var bookID int64
-			if err := rows.Scan(&bookID); err == nil {
-				bookIDs = append(bookIDs, bookID)
-			}
-		}
-		if err = rows.Err(); err != nil {
-			if cfg.Debug {
-				log.Printf("Ошибка итерации по результатам поиска книг для запроса %s: %v", event.ID, err)
-			}
+		// Книги с настройкой shared = friends отправляются только друзьям, never — никому
+		bookIDs, err = access.FilterShared(sm.db, found.IDs, sm.isFriend(event.PubKey))
+		if err != nil && cfg.Debug {
+			log.Printf("Ошибка проверки доступа к найденным книгам для запроса %s: %v", event.ID, err)
 		}
 	}
 
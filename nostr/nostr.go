@@ -34,6 +34,7 @@ type BookRequestEventContent struct {
 	FileHash string `json:"file_hash,omitempty"`
 	Source   string `json:"source"`
 	ISBN     string `json:"isbn,omitempty"`
+	Language string `json:"language,omitempty"` // Код ISO 639-1; только уточняет запрос, сам по себе ничего не ищет
 }
 
 // Mетоды для доступа к чёрному списку:
@@ -220,14 +221,15 @@ func NewClient(cfg *config.Config, db *sql.DB) (*Client, error) {
 	}, nil
 }
 
-// PublishBookRequestEvent публикует событие запроса книги (kind 8698)
-func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, title, fileHash, isbn string) error {
+// PublishBookRequestEvent публикует событие запроса книги (kind 8698) и возвращает его ID.
+// Если запрос не был опубликован (клиент выключен, поля не прошли проверку), ID пустой.
+func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, title, fileHash, isbn, lang string) (string, error) {
 	cfg := config.GetConfig()
 	if !c.IsEnabled() {
 		if cfg.Debug {
 			log.Println("Nostr клиент не включен, публикация запроса книги пропущена")
 		}
-		return nil // Не ошибка, просто не публикуем
+		return "", nil // Не ошибка, просто не публикуем
 	}
 
 	// Валидация запроса перед публикацией
@@ -236,7 +238,7 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 		if cfg.Debug {
 			log.Println("Игнорируем публикацию запроса: все поля пустые")
 		}
-		return nil // Не ошибка, просто не публикуем
+		return "", nil // Не ошибка, просто не публикуем
 	}
 
 	// Проверяем формат хеша (если задан)
@@ -246,7 +248,7 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 			if cfg.Debug {
 				log.Printf("Игнорируем публикацию запроса: неверная длина хеша %d", len(fileHash))
 			}
-			return nil // Не ошибка, просто не публикуем
+			return "", nil // Не ошибка, просто не публикуем
 		}
 
 		// Проверяем, что хеш содержит только допустимые символы
@@ -262,7 +264,7 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 			if cfg.Debug {
 				log.Printf("Игнорируем публикацию запроса: неверные символы в хеше '%s'", fileHash)
 			}
-			return nil // Не ошибка, просто не публикуем
+			return "", nil // Не ошибка, просто не публикуем
 		}
 	}
 
@@ -273,7 +275,7 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 			if cfg.Debug {
 				log.Printf("Игнорируем публикацию запроса: неверный ISBN '%s'", isbn)
 			}
-			return nil // Не ошибка, просто не публикуем
+			return "", nil // Не ошибка, просто не публикуем
 		}
 	}
 
@@ -285,12 +287,13 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 		FileHash: fileHash,
 		Source:   "turanga",
 		ISBN:     isbn, // <-- Добавляем ISBN
+		Language: lang,
 	}
 
 	// Сериализуем содержимое в JSON
 	contentBytes, err := json.Marshal(content)
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации содержимого запроса книги в JSON: %w", err)
+		return "", fmt.Errorf("ошибка сериализации содержимого запроса книги в JSON: %w", err)
 	}
 
 	// Создаем теги
@@ -310,15 +313,15 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 	// Подписываем событие
 	err = ev.Sign(c.privateKey)
 	if err != nil {
-		return fmt.Errorf("ошибка подписания события запроса книги: %w", err)
+		return "", fmt.Errorf("ошибка подписания события запроса книги: %w", err)
 	}
 
 	// Сохраняем запрос в БД перед публикацией
 	if c.db != nil {
 		_, err = c.db.Exec(`
-            INSERT INTO nostr_book_requests (event_id, pubkey, author, series, title, file_hash, isbn, created_at, processed, sent)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-        `, ev.ID, c.publicKey, author, series, title, fileHash, isbn, ev.CreatedAt, 1, 1) // processed=1, sent=1
+            INSERT INTO nostr_book_requests (event_id, pubkey, author, series, title, file_hash, isbn, language, created_at, processed, sent)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        `, ev.ID, c.publicKey, author, series, title, fileHash, isbn, lang, ev.CreatedAt, 1, 1) // processed=1, sent=1
 		if err != nil {
 			if cfg.Debug {
 				log.Printf("Предупреждение: ошибка сохранения запроса в БД: %v", err)
@@ -368,11 +371,11 @@ func (c *Client) PublishBookRequestEvent(ctx context.Context, author, series, ti
 	}
 
 	if successCount == 0 {
-		return fmt.Errorf("не удалось опубликовать запрос ни в одном релay")
+		return "", fmt.Errorf("не удалось опубликовать запрос ни в одном релay")
 	}
 
 	log.Printf("Запрос книги опубликован успешно в %d релee(-ях)", successCount)
-	return nil
+	return ev.ID, nil
 }
 
 // Mетод для перезагрузки чёрного списка:
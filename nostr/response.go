@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"turanga/access"
 	"turanga/config"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -59,15 +60,17 @@ func (sm *SubscriptionManager) sendResponse(requesterPubKey string, bookIDs []in
 		return
 	}
 
-	// 1. Получаем данные найденных книг из БД
+	// 1. Получаем данные найденных книг из БД.
+	// Книга могла стать закрытой или недоступной через nostr после поиска,
+	// поэтому правила видимости и настройку shared проверяем ещё раз.
+	friend := sm.isFriend(requesterPubKey)
 	var booksData []BookResponseData
 	for _, bookID := range bookIDs {
 		var book BookResponseData
 		err := sm.db.QueryRow(`
-			SELECT b.id, b.title, b.series, b.series_number, b.file_type, b.file_hash, b.file_size, b.ipfs_cid
+			SELECT b.id, b.title, b.series, b.series_number, b.file_type, b.file_hash, b.file_size, b.ipfs_cid, IFNULL(b.language, '')
 			FROM books b
-			WHERE b.id = ?
-		`, bookID).Scan(&book.ID, &book.Title, &book.Series, &book.SeriesNumber, &book.FileType, &book.FileHash, &book.FileSize, &book.IPFSCID)
+			WHERE b.id = ? `+access.Guest.Filter("b")+" "+access.SharingFilter("b", friend), bookID).Scan(&book.ID, &book.Title, &book.Series, &book.SeriesNumber, &book.FileType, &book.FileHash, &book.FileSize, &book.IPFSCID, &book.Language)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
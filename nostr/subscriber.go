@@ -30,6 +30,7 @@ type BookResponseData struct {
 	FileHash     string   `json:"file_hash"`
 	FileSize     int64    `json:"file_size"`
 	IPFSCID      string   `json:"ipfs_cid,omitempty"`
+	Language     string   `json:"language,omitempty"`
 }
 
 // NewSubscriptionManager создает новый экземпляр SubscriptionManager.
@@ -312,3 +313,16 @@ func (sm *SubscriptionManager) IncrementFriendDownloadCount(pubkey string) error
 	}
 	return nil
 }
+
+// isFriend проверяет, есть ли pubkey в друзьях — у него уже скачивались книги.
+// Друзьям отправляются и книги с настройкой доступа friends.
+func (sm *SubscriptionManager) isFriend(pubkey string) bool {
+	var exists bool
+	if err := sm.db.QueryRow("SELECT EXISTS(SELECT 1 FROM friends WHERE pubkey = ?)", pubkey).Scan(&exists); err != nil {
+		if config.GetConfig().Debug {
+			log.Printf("Ошибка проверки друга %s: %v", pubkey, err)
+		}
+		return false
+	}
+	return exists
+}
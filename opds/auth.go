@@ -0,0 +1,85 @@
+// opds/auth.go
+package opds
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// Читалки входят в каталог токеном устройства, созданным на странице учётной записи:
+// HTTP Basic (логин пользователя и токен вместо пароля) или "Authorization: Bearer <токен>".
+// Из браузера каталог доступен и по обычной сессии веб-интерфейса.
+// При opds_auth = off вход необязателен, гость видит каталог без закрытых книг (см. пакет access).
+
+// RequireAuth определяет пользователя OPDS-запроса и кладёт его в контекст.
+// Неверные данные входа отклоняются всегда, запрос без них — только при opds_auth = on.
+func RequireAuth(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return deviceAuth(db, next, false)
+}
+
+// OptionalAuth определяет пользователя по токену устройства, если он передан,
+// но не требует входа. Нужен для ресурсов, общих с веб-интерфейсом, например обложек.
+func OptionalAuth(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return deviceAuth(db, next, true)
+}
+
+// deviceAuth кладёт в контекст пользователя по токену устройства.
+// public — ресурс доступен без входа даже при opds_auth = on.
+func deviceAuth(db *sql.DB, next http.HandlerFunc, public bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Пользователь уже определён по сессии веб-интерфейса
+		if users.FromContext(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+
+		login, token, ok := deviceCredentials(r)
+		if !ok {
+			if !public && config.GetConfig().OPDSAuth {
+				requestAuth(w)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		user, err := users.AuthenticateDevice(db, login, token)
+		if errors.Is(err, users.ErrInvalidCredentials) {
+			if config.GetConfig().Debug {
+				log.Printf("OPDS: неверный токен устройства для %s %s", r.Method, r.URL.Path)
+			}
+			requestAuth(w)
+			return
+		} else if err != nil {
+			log.Printf("Ошибка проверки токена устройства: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		next(w, r.WithContext(users.NewContext(r.Context(), user)))
+	}
+}
+
+// deviceCredentials извлекает логин и токен из заголовка Authorization
+func deviceCredentials(r *http.Request) (login, token string, ok bool) {
+	if login, token, ok = r.BasicAuth(); ok {
+		return login, token, true
+	}
+	scheme, value, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return "", strings.TrimSpace(value), true
+	}
+	return "", "", false
+}
+
+// requestAuth отвечает 401 с предложением войти через HTTP Basic
+func requestAuth(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Turanga OPDS", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"turanga/access"
 	"turanga/config"
 	"turanga/models"
 )
@@ -25,6 +26,7 @@ func NewAuthorHandler(database *sql.DB, cfg *config.Config) *AuthorHandler {
 
 // AuthorsHandler обрабатывает запрос к /authors
 func (ah *AuthorHandler) AuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	path := strings.TrimPrefix(r.URL.Path, "/authors")
 
 	if path != "" && path != "/" {
@@ -44,8 +46,8 @@ func (ah *AuthorHandler) AuthorsHandler(w http.ResponseWriter, r *http.Request)
         JOIN book_authors ba ON a.id = ba.author_id
         JOIN books b ON ba.book_id = b.id
         WHERE a.full_name IS NOT NULL AND a.full_name != ''
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
     `)
 	if err != nil {
 		log.Printf("Ошибка подсчета авторов: %v", err)
@@ -65,6 +67,7 @@ func (ah *AuthorHandler) AuthorsHandler(w http.ResponseWriter, r *http.Request)
 
 // AuthorsLettersHandler показывает каталог с буквами алфавита
 func (ah *AuthorHandler) AuthorsLettersHandler(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	query := `
         SELECT DISTINCT 
             CASE 
@@ -78,8 +81,8 @@ func (ah *AuthorHandler) AuthorsLettersHandler(w http.ResponseWriter, r *http.Re
         JOIN book_authors ba ON a.id = ba.author_id
         JOIN books b ON ba.book_id = b.id
         WHERE a.full_name IS NOT NULL AND a.full_name != ''
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         GROUP BY 
             CASE 
                 WHEN SUBSTR(a.last_name_lower, 1, 1) BETWEEN 'a' AND 'z' THEN SUBSTR(a.last_name_lower, 1, 1)
@@ -112,7 +115,7 @@ func (ah *AuthorHandler) AuthorsLettersHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	ah.RenderNavigationFeed(w, "Авторы по алфавиту", entries)
+	ah.RenderNavigationFeed(w, r, "Авторы по алфавиту", entries)
 }
 
 // AuthorsByLetterHandler показывает авторов на определенную букву
@@ -124,26 +127,26 @@ func (ah *AuthorHandler) AuthorsByLetterHandler(w http.ResponseWriter, r *http.R
 		letter = string([]rune(path)[0])
 	}
 
-	authors, bookCounts, err := ah.getAuthorsByLetter(letter)
+	authors, bookCounts, err := ah.getAuthorsByLetter(letter, access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса авторов на букву %s: %v", letter, err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ah.renderAuthorsFeed(w, fmt.Sprintf("Авторы на букву \"%s\"", letter), authors, bookCounts)
+	ah.renderAuthorsFeed(w, r, fmt.Sprintf("Авторы на букву \"%s\"", letter), authors, bookCounts)
 }
 
 // AuthorsListHandler показывает всех авторов (для случаев, когда их <= 60)
 func (ah *AuthorHandler) AuthorsListHandler(w http.ResponseWriter, r *http.Request) {
-	authors, bookCounts, err := ah.getAllAuthors()
+	authors, bookCounts, err := ah.getAllAuthors(access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса списка авторов к БД: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ah.renderAuthorsFeed(w, "Авторы", authors, bookCounts)
+	ah.renderAuthorsFeed(w, r, "Авторы", authors, bookCounts)
 }
 
 // AuthorBooksHandler обрабатывает запрос к конкретному автору
@@ -155,19 +158,25 @@ func (ah *AuthorHandler) AuthorBooksHandler(w http.ResponseWriter, r *http.Reque
 		authorName = strings.TrimPrefix(r.URL.Path, "/authors/")
 	}
 
-	books, err := ah.getBooksByAuthor(authorName)
+	// Книги, у которых есть автор с таким именем или именем, содержащим его
+	lowerAuthorName := strings.ToLower(authorName)
+	page, err := ah.ListBooks(access.ForRequest(r), `b.id IN (
+            SELECT ba.book_id
+            FROM book_authors ba
+            JOIN authors a ON ba.author_id = a.id
+            WHERE a.full_name_lower = ? OR a.full_name_lower LIKE ?)`,
+		[]interface{}{lowerAuthorName, "%" + lowerAuthorName + "%"}, ParseBookListing(r, SortTitle))
 	if err != nil {
 		log.Printf("Ошибка запроса книг автора к БД: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sortedBooks := ah.sortBooksByTitle(books)
-	ah.RenderAcquisitionFeed(w, "Книги автора: "+authorName, sortedBooks)
+	ah.RenderBookPage(w, r, "Книги автора: "+authorName, page)
 }
 
 // getAuthorsByLetter получает авторов на определенную букву
-func (ah *AuthorHandler) getAuthorsByLetter(letter string) ([]*models.Author, map[string]int, error) {
+func (ah *AuthorHandler) getAuthorsByLetter(letter string, policy access.Policy) ([]*models.Author, map[string]int, error) {
 	cfg := config.GetConfig()
 	var authorRows *sql.Rows
 	var err error
@@ -185,8 +194,8 @@ func (ah *AuthorHandler) getAuthorsByLetter(letter string) ([]*models.Author, ma
             JOIN books b ON ba.book_id = b.id
             WHERE a.full_name IS NOT NULL AND a.full_name != '' -- Достаточно проверить full_name
               AND SUBSTR(a.last_name_lower, 1, 1) = ? -- Используем last_name_lower для поиска
-              AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b.over18 IS NULL OR b.over18 = 0)
+              AND b.file_type IN (`+opdsFormatList()+`)
+              `+policy.Filter("b")+`
             GROUP BY a.last_name_lower, a.full_name -- Группируем по last_name_lower
             ORDER BY a.last_name_lower, a.full_name_lower
         `, lowerLetter)
@@ -198,8 +207,8 @@ func (ah *AuthorHandler) getAuthorsByLetter(letter string) ([]*models.Author, ma
             JOIN books b ON ba.book_id = b.id
             WHERE a.full_name IS NOT NULL AND a.full_name != '' -- Достаточно проверить full_name
               AND (SUBSTR(a.last_name_lower, 1, 1) = 'ё' OR SUBSTR(a.last_name_lower, 1, 1) = 'е') -- Используем last_name_lower
-              AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b.over18 IS NULL OR b.over18 = 0)
+              AND b.file_type IN (` + opdsFormatList() + `)
+              ` + policy.Filter("b") + `
             GROUP BY a.last_name_lower, a.full_name -- Группируем по last_name_lower
             ORDER BY a.last_name_lower, a.full_name_lower
         `)
@@ -211,8 +220,8 @@ func (ah *AuthorHandler) getAuthorsByLetter(letter string) ([]*models.Author, ma
             JOIN books b ON ba.book_id = b.id
             WHERE a.full_name IS NOT NULL AND a.full_name != '' -- Достаточно проверить full_name
               AND SUBSTR(a.last_name_lower, 1, 1) = ? -- Используем last_name_lower
-              AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b.over18 IS NULL OR b.over18 = 0)
+              AND b.file_type IN (`+opdsFormatList()+`)
+              `+policy.Filter("b")+`
             GROUP BY a.last_name_lower, a.full_name -- Группируем по last_name_lower
             ORDER BY a.last_name_lower, a.full_name_lower
         `, lowerLetter)
@@ -248,7 +257,7 @@ func (ah *AuthorHandler) getAuthorsByLetter(letter string) ([]*models.Author, ma
 }
 
 // getAllAuthors получает всех авторов
-func (ah *AuthorHandler) getAllAuthors() ([]*models.Author, map[string]int, error) {
+func (ah *AuthorHandler) getAllAuthors(policy access.Policy) ([]*models.Author, map[string]int, error) {
 	cfg := config.GetConfig()
 	authorRows, err := ah.db.Query(`
         SELECT a.last_name_lower, a.full_name, COUNT(DISTINCT b.id) as book_count 
@@ -256,8 +265,8 @@ func (ah *AuthorHandler) getAllAuthors() ([]*models.Author, map[string]int, erro
         JOIN book_authors ba ON a.id = ba.author_id
         JOIN books b ON ba.book_id = b.id
         WHERE a.full_name IS NOT NULL AND a.full_name != '' -- Достаточно проверить full_name
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         GROUP BY a.last_name_lower, a.full_name -- Группируем по last_name_lower
         ORDER BY a.last_name_lower, a.full_name_lower -- Сортируем по last_name_lower и full_name_lower
     `)
@@ -298,123 +307,8 @@ func (ah *AuthorHandler) getAllAuthors() ([]*models.Author, map[string]int, erro
 	return authors, bookCounts, authorRows.Err()
 }
 
-// getBooksByAuthor получает книги автора
-func (ah *AuthorHandler) getBooksByAuthor(authorName string) (map[int]*models.Book, error) {
-	cfg := config.GetConfig()
-	// Используем lower-версию имени автора для поиска
-	lowerAuthorName := strings.ToLower(authorName)
-	query := `
-        SELECT DISTINCT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
-               b.isbn, b.year, b.publisher,
-               b.file_url, b.file_type, b.file_hash
-        FROM books b
-        WHERE b.id IN (
-            SELECT DISTINCT b2.id
-            FROM books b2
-            JOIN book_authors ba2 ON b2.id = ba2.book_id
-            JOIN authors a2 ON ba2.author_id = a2.id
-            WHERE (a2.full_name_lower = ? OR a2.full_name_lower LIKE ?)
-              AND b2.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b2.over18 IS NULL OR b2.over18 = 0)
-        )
-        AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-        AND (b.over18 IS NULL OR b.over18 = 0)
-        ORDER BY b.title_lower, 
-                 (SELECT MIN(a3.last_name_lower) 
-                  FROM book_authors ba3 
-                  JOIN authors a3 ON ba3.author_id = a3.id 
-                  WHERE ba3.book_id = b.id)
-    `
-
-	bookRows, err := ah.db.Query(query, lowerAuthorName, "%"+lowerAuthorName+"%")
-	if err != nil {
-		return nil, err
-	}
-	defer bookRows.Close()
-
-	booksMap := make(map[int]*models.Book)
-	var bookIDs []int
-
-	for bookRows.Next() {
-		var bookID int
-		var title, series, seriesNumber, publishedAt, isbn, year, publisher sql.NullString
-		var fileURL, fileType, fileHash sql.NullString
-
-		err := bookRows.Scan(&bookID, &title, &series, &seriesNumber, &publishedAt,
-			&isbn, &year, &publisher,
-			&fileURL, &fileType, &fileHash)
-		if err != nil {
-			if cfg.Debug {
-				log.Printf("Ошибка сканирования строки для автора: %v", err)
-			}
-			continue
-		}
-
-		annotation := readAnnotation(fileHash.String)
-
-		book := &models.Book{
-			ID:           bookID,
-			Title:        title.String,
-			Authors:      []models.Author{},
-			Series:       series.String,
-			SeriesNumber: seriesNumber.String,
-			PublishedAt:  publishedAt.String,
-			Annotation:   annotation,
-			ISBN:         isbn.String,
-			Year:         year.String,
-			Publisher:    publisher.String,
-			Files:        []models.BookFile{},
-		}
-
-		bookFile := models.BookFile{
-			URL:      fmt.Sprintf("/opds-download/%d/%s", bookID, url.QueryEscape(title.String+"."+GetFileExtension(fileType.String))),
-			Type:     fileType.String,
-			FileHash: fileHash.String,
-		}
-		book.Files = append(book.Files, bookFile)
-
-		booksMap[bookID] = book
-		bookIDs = append(bookIDs, bookID)
-	}
-
-	// Получаем всех авторов одним запросом
-	if len(bookIDs) > 0 {
-		placeholders := ah.CreatePlaceholders(len(bookIDs))
-		authorQuery := fmt.Sprintf(`
-            SELECT ba.book_id, a.full_name
-            FROM book_authors ba
-            JOIN authors a ON ba.author_id = a.id
-            WHERE ba.book_id IN (%s)
-            ORDER BY ba.book_id, a.full_name
-        `, placeholders)
-
-		authorArgs := ah.ConvertToInterfaceSlice(bookIDs)
-		authorRows, err := ah.db.Query(authorQuery, authorArgs...)
-		if err == nil {
-			defer authorRows.Close()
-
-			for authorRows.Next() {
-				var bookID int
-				var fullName string
-				if err := authorRows.Scan(&bookID, &fullName); err != nil {
-					continue
-				}
-
-				if book, exists := booksMap[bookID]; exists {
-					author := models.Author{
-						FullName: fullName,
-					}
-					book.Authors = append(book.Authors, author)
-				}
-			}
-		}
-	}
-
-	return booksMap, nil
-}
-
 // renderAuthorsFeed создает и отправляет OPDS фид с авторами
-func (ah *AuthorHandler) renderAuthorsFeed(w http.ResponseWriter, title string, authors []*models.Author, bookCounts map[string]int) {
+func (ah *AuthorHandler) renderAuthorsFeed(w http.ResponseWriter, r *http.Request, title string, authors []*models.Author, bookCounts map[string]int) {
 	var entries []models.Entry
 
 	for _, author := range authors {
@@ -432,10 +326,5 @@ func (ah *AuthorHandler) renderAuthorsFeed(w http.ResponseWriter, title string,
 		entries = append(entries, entry)
 	}
 
-	ah.RenderNavigationFeed(w, title, entries)
-}
-
-// sortBooksByTitle сортирует книги по названию
-func (ah *AuthorHandler) sortBooksByTitle(booksMap map[int]*models.Book) []*models.Book {
-	return SortBooksByTitle(booksMap)
+	ah.RenderNavigationFeed(w, r, title, entries)
 }
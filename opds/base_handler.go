@@ -3,8 +3,9 @@ package opds
 import (
 	"database/sql"
 	"net/http"
-	"time"
+	"turanga/access"
 	"turanga/config"
+	"turanga/generation"
 	"turanga/models"
 )
 
@@ -43,13 +44,21 @@ func (bh *BaseHandler) CountItems(query string, args ...interface{}) (int, error
 	return count, err
 }
 
-// RenderNavigationFeed рендерит навигационный фид
-func (bh *BaseHandler) RenderNavigationFeed(w http.ResponseWriter, title string, entries []models.Entry) {
+// RenderNavigationFeed рендерит навигационный фид в формате, который запросил клиент (Atom или OPDS 2.0)
+func (bh *BaseHandler) RenderNavigationFeed(w http.ResponseWriter, r *http.Request, title string, entries []models.Entry) {
+	if IsOPDS2(r) {
+		RenderOPDS2Navigation(w, r, title, entries)
+		return
+	}
 	RenderOPDSFeed(w, title, "", entries, false)
 }
 
-// RenderAcquisitionFeed рендерит фид с книгами для скачивания
-func (bh *BaseHandler) RenderAcquisitionFeed(w http.ResponseWriter, title string, books []*models.Book) {
+// RenderAcquisitionFeed рендерит фид с книгами для скачивания (Atom или OPDS 2.0)
+func (bh *BaseHandler) RenderAcquisitionFeed(w http.ResponseWriter, r *http.Request, title string, books []*models.Book) {
+	if IsOPDS2(r) {
+		RenderOPDS2Publications(w, r, title, books)
+		return
+	}
 	var entries []models.Entry
 	for _, book := range books {
 		entry := CreateAcquisitionEntry(book)
@@ -66,13 +75,13 @@ func (bh *BaseHandler) ShouldPaginate(count int) bool {
 }
 
 // GetBooksByIDs получает книги по ID
-func (bh *BaseHandler) GetBooksByIDs(bookIDs []int) (map[int]*models.Book, error) {
-	return GetBooksByIDs(bh.db, bookIDs)
+func (bh *BaseHandler) GetBooksByIDs(bookIDs []int, policy access.Policy) (map[int]*models.Book, error) {
+	return GetBooksByIDs(bh.db, bookIDs, policy)
 }
 
 // GetBooksWithAuthors получает книги с авторами
-func (bh *BaseHandler) GetBooksWithAuthors(query string, args ...interface{}) (map[int]*models.Book, error) {
-	return GetBooksWithAuthors(bh.db, query, args...)
+func (bh *BaseHandler) GetBooksWithAuthors(policy access.Policy, query string, args ...interface{}) (map[int]*models.Book, error) {
+	return GetBooksWithAuthors(bh.db, policy, query, args...)
 }
 
 // SortBooksByTitle сортирует книги по названию
@@ -95,7 +104,7 @@ func (bh *BaseHandler) ConvertToInterfaceSlice(slice []int) []interface{} {
 	return ConvertToInterfaceSlice(slice)
 }
 
-// GetCurrentTime возвращает текущее время в формате OPDS
+// GetCurrentTime возвращает время последнего изменения библиотеки в формате OPDS
 func (bh *BaseHandler) GetCurrentTime() string {
-	return time.Now().Format("2006-01-02T15:04:05+00:00")
+	return generation.LastModified().Format("2006-01-02T15:04:05+00:00")
 }
@@ -8,7 +8,9 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"turanga/access"
 	"turanga/config"
+	"turanga/models"
 )
 
 // BookHandler отвечает за обработку запросов к /books
@@ -25,6 +27,7 @@ func NewBookHandler(database *sql.DB, cfg *config.Config) *BookHandler {
 
 // BooksHandler обрабатывает запрос к /books
 func (bh *BookHandler) BooksHandler(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	path := strings.TrimPrefix(r.URL.Path, "/books")
 
 	if path != "" && path != "/" {
@@ -39,8 +42,8 @@ func (bh *BookHandler) BooksHandler(w http.ResponseWriter, r *http.Request) {
 	bookCount, err := bh.CountItems(`
         SELECT COUNT(*)
         FROM books b
-        WHERE b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+        WHERE b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
     `)
 	if err != nil {
 		log.Printf("Ошибка подсчета книг: %v", err)
@@ -60,6 +63,7 @@ func (bh *BookHandler) BooksHandler(w http.ResponseWriter, r *http.Request) {
 
 // showBooksLetters показывает каталог с буквами алфавита для книг
 func (bh *BookHandler) showBooksLetters(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	query := `
         SELECT DISTINCT 
             CASE 
@@ -70,8 +74,8 @@ func (bh *BookHandler) showBooksLetters(w http.ResponseWriter, r *http.Request)
             END as first_letter, 
             COUNT(*) as book_count
         FROM books b
-        WHERE b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+        WHERE b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         GROUP BY 
             CASE 
                 WHEN SUBSTR(b.title_lower, 1, 1) BETWEEN 'a' AND 'z' THEN SUBSTR(b.title_lower, 1, 1)
@@ -104,7 +108,7 @@ func (bh *BookHandler) showBooksLetters(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	bh.RenderNavigationFeed(w, "Книги по алфавиту", entries)
+	bh.RenderNavigationFeed(w, r, "Книги по алфавиту", entries)
 }
 
 // showBooksForLetter показывает книги на определенную букву
@@ -114,59 +118,56 @@ func (bh *BookHandler) showBooksForLetter(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	booksMap, err := GetBooksByLetter(bh.db, letter, "title")
+	page, err := bh.ListBooks(access.ForRequest(r), "SUBSTR(b.title_lower, 1, 1) = ?",
+		[]interface{}{strings.ToLower(letter)}, ParseBookListing(r, SortTitle))
 	if err != nil {
 		log.Printf("Ошибка получения книг на букву %s: %v", letter, err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sortedBooks := bh.SortBooksByTitle(booksMap)
-	bh.RenderAcquisitionFeed(w, fmt.Sprintf("Книги на букву \"%s\"", letter), sortedBooks)
+	bh.RenderBookPage(w, r, fmt.Sprintf("Книги на букву \"%s\"", letter), page)
 }
 
-// showAllBooks показывает все книги (для случаев, когда их <= 60)
+// showAllBooks показывает все книги постранично (для случаев, когда их <= 60, а также с фильтрами)
 func (bh *BookHandler) showAllBooks(w http.ResponseWriter, r *http.Request) {
-	query := `
-        SELECT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
-               b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
-        FROM books b
-        WHERE b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
-        ORDER BY b.title_lower
-        LIMIT 1000
-    `
-
-	booksMap, err := bh.GetBooksWithAuthors(query)
+	page, err := bh.ListBooks(access.ForRequest(r), "1 = 1", nil, ParseBookListing(r, SortTitle))
 	if err != nil {
 		log.Printf("Ошибка получения книг: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sortedBooks := bh.SortBooksByTitle(booksMap)
-	bh.RenderAcquisitionFeed(w, "Все книги", sortedBooks)
+	bh.RenderBookPage(w, r, "Все книги", page)
 }
 
 // RecentHandler обрабатывает запрос к /recent
 func (bh *BookHandler) RecentHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := bh.ListBooks(access.ForRequest(r), "1 = 1", nil, ParseBookListing(r, SortAdded))
+	if err != nil {
+		log.Printf("Ошибка получения последних книг: %v", err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bh.RenderBookPage(w, r, "Новые поступления", page)
+}
+
+// recentBooks возвращает последние добавленные книги, новые первыми
+func recentBooks(db *sql.DB, policy access.Policy, limit int) ([]*models.Book, error) {
 	query := `
         SELECT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
                b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
         FROM books b
-        WHERE b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+        WHERE b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         ORDER BY b.id DESC
-        LIMIT 60
+        LIMIT ?
     `
 
-	booksMap, err := bh.GetBooksWithAuthors(query)
+	booksMap, err := GetBooksWithAuthors(db, policy, query, limit)
 	if err != nil {
-		log.Printf("Ошибка получения последних книг: %v", err)
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-
-	sortedBooks := bh.SortBooksByID(booksMap)
-	bh.RenderAcquisitionFeed(w, "Новые поступления", sortedBooks)
+	return SortBooksByID(booksMap), nil
 }
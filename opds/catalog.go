@@ -13,16 +13,22 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"turanga/access"
 	"turanga/config"
+	"turanga/convert"
+	"turanga/generation"
 	"turanga/models"
+	"turanga/search"
 	"turanga/web"
+	"turanga/works"
 )
 
 // Вспомогательные структуры для XML (только для поиска)
 type Link struct {
-	Rel  string `xml:"rel,attr"`
-	Type string `xml:"type,attr"`
-	Href string `xml:"href,attr"`
+	Rel      string `xml:"rel,attr"`
+	Type     string `xml:"type,attr"`
+	Href     string `xml:"href,attr"`
+	PSECount int    `xml:"pse:count,attr,omitempty"`
 }
 
 type Content struct {
@@ -51,11 +57,19 @@ type SearchFeed struct {
 	Xmlns     string   `xml:"xmlns,attr"`
 	XmlnsDc   string   `xml:"xmlns:dc,attr,omitempty"`
 	XmlnsOpds string   `xml:"xmlns:opds,attr,omitempty"`
+	XmlnsOS   string   `xml:"xmlns:opensearch,attr,omitempty"`
+	XmlnsPSE  string   `xml:"xmlns:pse,attr,omitempty"`
 	Title     string   `xml:"title"`
 	ID        string   `xml:"id"`
 	Updated   string   `xml:"updated"`
 	Links     []Link   `xml:"link"`
-	Entries   []Entry  `xml:"entry"`
+
+	// Сведения о странице результатов по OpenSearch 1.1
+	TotalResults int `xml:"opensearch:totalResults"`
+	ItemsPerPage int `xml:"opensearch:itemsPerPage"`
+	StartIndex   int `xml:"opensearch:startIndex"`
+
+	Entries []Entry `xml:"entry"`
 }
 
 // IndexHandler обрабатывает корневой маршрут "/"
@@ -67,13 +81,40 @@ func IndexHandler(webInterface *web.WebInterface, cfg *config.Config) http.Handl
 			return
 		}
 		// Для OPDS запросов показываем каталог
-		ShowOPDSCatalog(w, r)
+		db := webInterface.GetDB()
+		RequireAuth(db, ShowOPDSCatalogHandler(db))(w, r)
 	}
 }
 
 // ShowOPDSCatalogHandler обрабатывает маршрут "/feed"
-func ShowOPDSCatalogHandler(w http.ResponseWriter, r *http.Request) {
-	ShowOPDSCatalog(w, r)
+func ShowOPDSCatalogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IsOPDS2(r) {
+			ShowOPDS2Catalog(w, r, db)
+			return
+		}
+		ShowOPDSCatalog(w, r)
+	}
+}
+
+// catalogSection — раздел корневого каталога
+type catalogSection struct {
+	title         string
+	id            string
+	content       string
+	href          string
+	rel           string
+	thumbnailPath string
+}
+
+// catalogSections — разделы корневого каталога, общие для Atom и OPDS 2.0
+var catalogSections = []catalogSection{
+	{"Авторы", "turanga:authors", "Книги по фамилии автора", "/authors", "subsection", "/static/opds-icons/authors.png"},
+	{"Серии", "turanga:series", "Книги по названию серии", "/series", "subsection", "/static/opds-icons/series.png"},
+	{"Все книги", "turanga:books", "Книги по названию", "/books", "subsection", "/static/opds-icons/books.png"},
+	{"Теги", "turanga:tags", "Книги по тегам", "/tags", "subsection", "/static/opds-icons/tags.png"},
+	{"Жанры", "turanga:genres", "Книги по жанрам fb2", "/genres", "subsection", "/static/opds-icons/tags.png"},
+	{"Новые поступления", "turanga:recent", "Последние добавленные книги", "/recent", "http://opds-spec.org/sort/new", "/static/opds-icons/recent.png"},
 }
 
 // ShowOPDSCatalog отображает корневой OPDS каталог
@@ -81,36 +122,26 @@ func ShowOPDSCatalog(w http.ResponseWriter, r *http.Request) {
 
 	// Создаем OPDS фид
 	feed := models.NewFeed("Каталог книг")
-	feed.Updated = time.Now().Format("2006-01-02T15:04:05+00:00")
+	feed.Updated = generation.LastModified().Format("2006-01-02T15:04:05+00:00")
 	feed.Icon = "/static/opds-icons/leela.png"
 
-	// Ссылка на поисковый механизм OPDS
+	// Ссылки на поиск: описание OpenSearch с расширенным поиском по полям
+	// и шаблон для ридеров, которые не читают описание
 	feed.Links = append(feed.Links, models.Link{
 		Rel:  "search",
-		Type: "application/opds.search+xml",
+		Type: "application/opensearchdescription+xml",
+		Href: "/opds-search.xml",
+	}, models.Link{
+		Rel:  "search",
+		Type: "application/atom+xml",
 		Href: "/opds-search/{searchTerms}",
 	})
 
-	// Добавляем категории поиска
-	categories := []struct {
-		title         string
-		id            string
-		content       string
-		href          string
-		rel           string
-		thumbnailPath string
-	}{
-		{"Авторы", "turanga:authors", "Книги по фамилии автора", "/authors", "subsection", "/static/opds-icons/authors.png"},
-		{"Серии", "turanga:series", "Книги по названию серии", "/series", "subsection", "/static/opds-icons/series.png"},
-		{"Все книги", "turanga:books", "Книги по названию", "/books", "subsection", "/static/opds-icons/books.png"},
-		{"Теги", "turanga:tags", "Книги по тегам", "/tags", "subsection", "/static/opds-icons/tags.png"},
-		{"Новые поступления", "turanga:recent", "Последние добавленные книги", "/recent", "http://opds-spec.org/sort/new", "/static/opds-icons/recent.png"},
-	}
-
-	for _, cat := range categories {
+	// Добавляем разделы каталога
+	for _, cat := range sectionsFor(r) {
 		entry := models.Entry{
 			Title:   cat.title,
-			Updated: time.Now().Format("2006-01-02T15:04:05+00:00"),
+			Updated: generation.LastModified().Format("2006-01-02T15:04:05+00:00"),
 			ID:      cat.id,
 			Content: models.Content{Type: "text", Text: cat.content},
 			Links: []models.Link{{
@@ -140,24 +171,20 @@ func ShowOPDSCatalog(w http.ResponseWriter, r *http.Request) {
 }
 
 // OPDSSearchHandler обрабатывает поисковые запросы OPDS
-// URL: /opds-search/{searchTerms}
+// URL: /opds-search/{searchTerms} или /opds-search?q=...&author=...&title=...&page=N
 func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
 	cfg := config.GetConfig()
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Извлекаем поисковый запрос из URL
-		query := strings.TrimPrefix(r.URL.Path, "/opds-search/")
-		query, err := url.QueryUnescape(query)
-		if err != nil {
-			if cfg.Debug {
-				log.Printf("Ошибка декодирования поискового запроса: %v", err)
-			}
-			query = strings.TrimPrefix(r.URL.Path, "/opds-search/")
-		}
-
-		if query == "" {
+		// Извлекаем поисковый запрос и номер страницы из URL
+		q, params, page := parseSearchRequest(r)
+		if q.IsEmpty() {
 			http.Error(w, "Search term is required", http.StatusBadRequest)
 			return
 		}
+		query := describeSearch(params)
+		if cfg.Debug {
+			log.Printf("OPDS поиск: %s, страница %d", query, page)
+		}
 
 		// Выполнить поиск в БД
 		db := webInterface.GetDB()
@@ -167,7 +194,28 @@ func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
 			return
 		}
 
-		searchPattern := "%" + query + "%"
+		// Ищем по полнотекстовому индексу, книги 18+ выдаются только тем, кому они разрешены
+		policy := access.ForRequest(r)
+		q.Access = policy
+		q.Limit = searchPageSize
+		q.Offset = (page - 1) * searchPageSize
+		result, err := search.Books(db, q)
+		if err != nil {
+			log.Printf("Ошибка поиска в OPDS: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if IsOPDS2(r) {
+			renderOPDS2Search(w, r, db, fmt.Sprintf("Результаты поиска для '%s'", query), result, params, page, policy)
+			return
+		}
+
+		ids := result.IDs
+		if len(ids) == 0 {
+			// Пустой список IN () недопустим, подставляем заведомо несуществующий ID
+			ids = []int64{0}
+		}
+		placeholders, args := search.Placeholders(ids)
 
 		rows, err := db.Query(`
         SELECT b.id, b.title, b.file_type, b.file_hash, b.published_at,
@@ -180,17 +228,7 @@ func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
                 LEFT JOIN authors a ON ba.author_id = a.id
                 WHERE ba.book_id = b.id) as authors_str
         FROM books b
-        WHERE b.title LIKE ? COLLATE ICU_NOCASE 
-           OR EXISTS (
-                SELECT 1 FROM book_authors ba
-                JOIN authors a ON ba.author_id = a.id
-                WHERE ba.book_id = b.id AND a.full_name LIKE ? COLLATE ICU_NOCASE
-           )
-           OR IFNULL(b.series, '') LIKE ? COLLATE ICU_NOCASE
-        GROUP BY b.id, b.title, b.file_type, b.file_hash, b.published_at
-        ORDER BY b.title
-        LIMIT 50`,
-			searchPattern, searchPattern, searchPattern)
+        WHERE b.id IN (`+placeholders+`)`, args...)
 
 		if err != nil {
 			log.Printf("Ошибка БД в OPDS поиске: %v", err)
@@ -204,24 +242,35 @@ func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
 			Xmlns:     "http://www.w3.org/2005/Atom",
 			XmlnsDc:   "http://purl.org/dc/terms/",
 			XmlnsOpds: "http://opds-spec.org/2010/catalog",
+			XmlnsOS:   "http://a9.com/-/spec/opensearch/1.1/",
 			Title:     fmt.Sprintf("Результаты поиска для '%s'", html.EscapeString(query)),
 			ID:        fmt.Sprintf("urn:uuid:%s", time.Now().Format("20060102150405")),
-			Updated:   time.Now().Format(time.RFC3339),
-			Links: []Link{
-				{
-					Rel:  "self",
-					Type: "application/atom+xml;profile=opds-catalog;kind=acquisition",
-					Href: "/opds-search/" + url.QueryEscape(query),
-				},
-				{
+			Updated:   generation.LastModified().Format(time.RFC3339),
+			Links: append(searchPageLinks(params, page, result.Total),
+				Link{
 					Rel:  "start",
 					Type: "application/atom+xml;profile=opds-catalog;kind=navigation",
 					Href: "/feed",
 				},
-			},
+				Link{
+					Rel:  "search",
+					Type: "application/opensearchdescription+xml",
+					Href: "/opds-search.xml",
+				},
+			),
+			TotalResults: result.Total,
+			ItemsPerPage: searchPageSize,
+			StartIndex:   q.Offset + 1,
 		}
 
 		// Генерируем записи для найденных книг
+		type foundEntry struct {
+			id       int64
+			entry    Entry
+			fileHash string
+		}
+		var found []foundEntry
+		var pageHashes []string
 		for rows.Next() {
 			var id int
 			var title, fileType, fileHash, publishedAt, authorsStr sql.NullString
@@ -236,7 +285,10 @@ func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
 
 			// Формируем запись книги в формате OPDS
 			entry := generateOPDSEntry(webInterface, id, title.String, authorsStr.String, fileType.String, fileHash.String, publishedAt.String)
-			feed.Entries = append(feed.Entries, entry)
+			found = append(found, foundEntry{id: int64(id), entry: entry, fileHash: fileHash.String})
+			if isPageFormat(fileType.String) {
+				pageHashes = append(pageHashes, fileHash.String)
+			}
 		}
 
 		if err = rows.Err(); err != nil {
@@ -245,9 +297,32 @@ func OPDSSearchHandler(webInterface *web.WebInterface) http.HandlerFunc {
 			return
 		}
 
+		// Выводим записи в порядке релевантности; форматы одного произведения
+		// собираем в первую найденную запись дополнительными ссылками
+		workOf, err := works.Group(db, result.IDs)
+		if err != nil && cfg.Debug {
+			log.Printf("Ошибка получения произведений в OPDS поиске: %v", err)
+		}
+		pages := pageCounts(db, pageHashes)
+		seenWorks := make(map[int64]bool)
+		for _, f := range search.SortByIDs(found, result.IDs, func(f foundEntry) int64 { return f.id }) {
+			if n := pages[f.fileHash]; n > 0 {
+				link := pseLink(f.fileHash, n)
+				f.entry.Links = append(f.entry.Links, Link{Rel: link.Rel, Type: link.Type, Href: link.Href, PSECount: link.PSECount})
+				feed.XmlnsPSE = pseNamespace
+			}
+			if workID, ok := workOf[f.id]; ok {
+				if seenWorks[workID] {
+					continue
+				}
+				seenWorks[workID] = true
+				f.entry.Links = append(f.entry.Links, workAcquisitionLinks(db, int(f.id), policy)...)
+			}
+			feed.Entries = append(feed.Entries, f.entry)
+		}
+
 		// Устанавливаем заголовки и отправляем ответ
 		w.Header().Set("Content-Type", "application/atom+xml;profile=opds-catalog;kind=acquisition; charset=utf-8")
-		w.Header().Set("Cache-Control", "public, max-age=300") // Кэширование на 5 минут
 
 		if err := xml.NewEncoder(w).Encode(feed); err != nil {
 			log.Printf("Ошибка кодирования XML в OPDS поиске: %v", err)
@@ -284,11 +359,12 @@ func OPDSDownloadBookHandler(db *sql.DB, rootPath string) http.HandlerFunc {
 		}
 
 		// Получаем информацию о книге из БД
+		// Закрытая книга для того, кому она не разрешена, как будто отсутствует
 		var fileURL, fileType, fileHash sql.NullString
 		err = db.QueryRow(`
-            SELECT file_url, file_type, file_hash 
-            FROM books 
-            WHERE id = ?`, id).Scan(&fileURL, &fileType, &fileHash)
+            SELECT b.file_url, b.file_type, b.file_hash
+            FROM books b
+            WHERE b.id = ? `+access.ForRequest(r).Filter("b"), id).Scan(&fileURL, &fileType, &fileHash)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -326,18 +402,7 @@ func OPDSDownloadBookHandler(db *sql.DB, rootPath string) http.HandlerFunc {
 		w.Header().Set("Content-Type", mimeType)
 
 		// Используем оригинальное имя файла из пути, а не формируем новое
-		originalFilename := filepath.Base(filePath)
-
-		userAgent := r.Header.Get("User-Agent")
-		if strings.Contains(strings.ToLower(userAgent), "fbreader") ||
-			strings.Contains(strings.ToLower(userAgent), "reader") ||
-			strings.Contains(strings.ToLower(userAgent), "opds") {
-			// Для ридеров используем inline для прямого открытия
-			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", url.QueryEscape(originalFilename)))
-		} else {
-			// Для браузеров используем attachment
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", url.QueryEscape(originalFilename)))
-		}
+		setContentDisposition(w, r, filepath.Base(filePath))
 
 		// Добавляем заголовки для кэширования
 		w.Header().Set("Cache-Control", "public, max-age=3600")
@@ -347,6 +412,39 @@ func OPDSDownloadBookHandler(db *sql.DB, rootPath string) http.HandlerFunc {
 	}
 }
 
+// workAcquisitionLinks возвращает ссылки на остальные форматы произведения книги
+func workAcquisitionLinks(db *sql.DB, bookID int, policy access.Policy) []Link {
+	files, err := works.Files(db, int64(bookID), policy, nil)
+	if err != nil {
+		return nil
+	}
+	var links []Link
+	for _, f := range files {
+		if f.BookID == int64(bookID) {
+			continue
+		}
+		links = append(links, Link{
+			Rel:  "http://opds-spec.org/acquisition",
+			Type: GetMimeType(f.FileType),
+			Href: fmt.Sprintf("/opds-download/%d/%s", f.BookID, url.QueryEscape(f.Title+"."+GetFileExtension(f.FileType))),
+		})
+	}
+	return links
+}
+
+// setContentDisposition задаёт имя файла для скачивания: ридеры открывают книгу сразу (inline),
+// браузеры сохраняют её (attachment)
+func setContentDisposition(w http.ResponseWriter, r *http.Request, filename string) {
+	userAgent := strings.ToLower(r.Header.Get("User-Agent"))
+	if strings.Contains(userAgent, "fbreader") ||
+		strings.Contains(userAgent, "reader") ||
+		strings.Contains(userAgent, "opds") {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", url.QueryEscape(filename)))
+	} else {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", url.QueryEscape(filename)))
+	}
+}
+
 // generateOPDSEntry генерирует структурированную запись книги для OPDS
 func generateOPDSEntry(webInterface *web.WebInterface, id int, title, authors, fileType, fileHash, publishedAt string) Entry {
 	// Получаем URL обложки
@@ -378,7 +476,7 @@ func generateOPDSEntry(webInterface *web.WebInterface, id int, title, authors, f
 	entry := Entry{
 		Title:    html.EscapeString(title),
 		ID:       fmt.Sprintf("urn:book:%d", id),
-		Updated:  time.Now().Format(time.RFC3339),
+		Updated:  generation.LastModified().Format(time.RFC3339),
 		Author:   Author{Name: html.EscapeString(authors)},
 		Language: "ru",
 		Issued:   publishedAt,
@@ -395,6 +493,15 @@ func generateOPDSEntry(webInterface *web.WebInterface, id int, title, authors, f
 		},
 	}
 
+	// Ридерам без поддержки FB2 — EPUB, сконвертированный из fb2
+	if convert.CanConvert(fileType) && fileHash != "" {
+		entry.Links = append(entry.Links, Link{
+			Rel:  "http://opds-spec.org/acquisition",
+			Type: "application/epub+zip",
+			Href: convert.URL(fileHash, title),
+		})
+	}
+
 	// Добавляем ссылку на обложку, если есть
 	if coverURL != "" {
 		imageType := "image/jpeg"
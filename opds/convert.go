@@ -0,0 +1,93 @@
+// opds/convert.go
+package opds
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/convert"
+	"turanga/models"
+)
+
+// Книги fb2 и fb2.zip можно скачать в EPUB для ридеров, которые не знают FB2
+// (Kobo, Apple Books и др.). Конвертирует пакет convert, готовые файлы хранятся
+// в каталоге converted, исходный файл не меняется.
+
+// convertLink возвращает ссылку на скачивание книги в EPUB
+func convertLink(fileHash, title string) models.Link {
+	return models.Link{
+		Href:  convert.URL(fileHash, title),
+		Type:  "application/epub+zip",
+		Rel:   "http://opds-spec.org/acquisition",
+		Title: "EPUB (из FB2)",
+	}
+}
+
+// needsConversion сообщает, нужно ли предлагать EPUB из fb2: только если у книги нет своего epub
+func needsConversion(files []models.BookFile) (models.BookFile, bool) {
+	var source models.BookFile
+	found := false
+	for _, file := range files {
+		if file.Type == "epub" {
+			return models.BookFile{}, false
+		}
+		if !found && convert.CanConvert(file.Type) && file.FileHash != "" {
+			source, found = file, true
+		}
+	}
+	return source, found
+}
+
+// OPDSConvertHandler отдаёт книгу fb2/fb2.zip в EPUB
+// URL: /opds-convert/{хеш файла}/{название}.epub
+func OPDSConvertHandler(db *sql.DB, rootPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig()
+
+		fileHash, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, convert.Prefix), "/")
+		if fileHash == "" {
+			http.Error(w, "Invalid URL", http.StatusBadRequest)
+			return
+		}
+
+		// Закрытая книга для того, кому она не разрешена, как будто отсутствует
+		var filePath, fileType string
+		err := db.QueryRow(`
+            SELECT b.file_url, b.file_type
+            FROM books b
+            WHERE b.file_hash = ? AND b.file_type IN ('fb2', 'fb2.zip') `+access.ForRequest(r).Filter("b")+`
+            LIMIT 1`, fileHash).Scan(&filePath, &fileType)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("Ошибка получения книги для конвертации %s: %v", fileHash, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		epubPath, err := convert.CachedEPUB(filepath.Join(rootPath, "converted"), filePath, fileType, fileHash)
+		if err != nil {
+			log.Printf("Ошибка конвертации в EPUB: %v", err)
+			http.Error(w, "Conversion error", http.StatusInternalServerError)
+			return
+		}
+
+		if cfg.Debug {
+			log.Printf("OPDS: EPUB из %s", filePath)
+		}
+
+		// Имя файла — из адреса, как его сформировала лента, иначе по имени исходного файла
+		if name == "" {
+			name = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filePath), ".zip"), ".fb2") + ".epub"
+		}
+		w.Header().Set("Content-Type", "application/epub+zip")
+		setContentDisposition(w, r, name)
+		http.ServeFile(w, r, epubPath)
+	}
+}
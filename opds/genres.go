@@ -0,0 +1,135 @@
+// opds/genres.go
+package opds
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/genres"
+	"turanga/models"
+)
+
+// genresAll — адрес всех книг раздела вместо кода жанра: /genres/{раздел}/all
+const genresAll = "all"
+
+// GenreHandler отвечает за обработку запросов к /genres
+type GenreHandler struct {
+	*BaseHandler
+}
+
+// NewGenreHandler создает новый экземпляр GenreHandler
+func NewGenreHandler(database *sql.DB, cfg *config.Config) *GenreHandler {
+	return &GenreHandler{
+		BaseHandler: NewBaseHandler(database, cfg),
+	}
+}
+
+// GenresHandler обрабатывает запросы к разделам и жанрам классификатора fb2
+// URL: /genres, /genres/{раздел}, /genres/{раздел}/{жанр}, /genres/{раздел}/all
+func (gh *GenreHandler) GenresHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/genres"), "/")
+	if rest == "" {
+		gh.showGroups(w, r)
+		return
+	}
+
+	groupCode, genreCode, _ := strings.Cut(rest, "/")
+	group, ok := genres.LookupGroup(groupCode)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case genreCode == "":
+		gh.showGenres(w, r, group)
+	case genreCode == genresAll:
+		gh.showBooks(w, r, fmt.Sprintf("Раздел \"%s\"", group.Name), group.Codes())
+	default:
+		genre, ok := genres.Lookup(genreCode)
+		if !ok || genre.Group != group.Code {
+			http.NotFound(w, r)
+			return
+		}
+		gh.showBooks(w, r, fmt.Sprintf("Жанр \"%s\"", genre.Name), []string{genre.Code})
+	}
+}
+
+// showGroups показывает разделы классификатора, в которых есть книги
+func (gh *GenreHandler) showGroups(w http.ResponseWriter, r *http.Request) {
+	counts, err := genres.GroupCounts(gh.db, access.ForRequest(r))
+	if err != nil {
+		log.Printf("Ошибка подсчёта книг по разделам жанров: %v", err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var entries []models.Entry
+	for _, group := range genres.Tree {
+		if counts[group.Code] == 0 {
+			continue
+		}
+		entries = append(entries, CreateNavigationEntry(
+			group.Name,
+			"turanga:genres:"+group.Code,
+			fmt.Sprintf("%s (%d шт.)", group.NameEn, counts[group.Code]),
+			"/genres/"+group.Code,
+			"subsection",
+			"",
+		))
+	}
+
+	gh.RenderNavigationFeed(w, r, "Жанры", entries)
+}
+
+// showGenres показывает жанры раздела, в которых есть книги
+func (gh *GenreHandler) showGenres(w http.ResponseWriter, r *http.Request, group genres.Group) {
+	policy := access.ForRequest(r)
+	counts, err := genres.Counts(gh.db, policy)
+	if err != nil {
+		log.Printf("Ошибка подсчёта книг по жанрам: %v", err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := []models.Entry{CreateNavigationEntry(
+		"Все книги раздела",
+		"turanga:genres:"+group.Code+":"+genresAll,
+		fmt.Sprintf("Книги всех жанров раздела \"%s\"", group.Name),
+		"/genres/"+group.Code+"/"+genresAll,
+		"subsection",
+		"",
+	)}
+	for _, genre := range group.Genres {
+		if counts[genre.Code] == 0 {
+			continue
+		}
+		entries = append(entries, CreateNavigationEntry(
+			genre.Name,
+			"turanga:genre:"+genre.Code,
+			fmt.Sprintf("%s (%d шт.)", genre.NameEn, counts[genre.Code]),
+			"/genres/"+group.Code+"/"+genre.Code,
+			"subsection",
+			"",
+		))
+	}
+
+	gh.RenderNavigationFeed(w, r, group.Name, entries)
+}
+
+// showBooks показывает книги с любым из жанров
+func (gh *GenreHandler) showBooks(w http.ResponseWriter, r *http.Request, title string, codes []string) {
+	condition, args := genres.BookCondition(codes)
+	page, err := gh.ListBooks(access.ForRequest(r), condition, args, ParseBookListing(r, SortTitle))
+	if err != nil {
+		log.Printf("Ошибка запроса книг жанров %v: %v", codes, err)
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gh.RenderBookPage(w, r, title, page)
+}
@@ -1,6 +1,7 @@
 package opds
 
 import (
+	"turanga/access"
 	"turanga/models"
 )
 
@@ -19,8 +20,8 @@ type ItemHandler interface {
 // BookProvider интерфейс для получения книг
 type BookProvider interface {
 	// GetBooksByIDs возвращает книги по ID
-	GetBooksByIDs(ids []int) (map[int]*models.Book, error)
+	GetBooksByIDs(ids []int, policy access.Policy) (map[int]*models.Book, error)
 	
 	// GetBooksWithAuthors возвращает книги с авторами
-	GetBooksWithAuthors(query string, args ...interface{}) (map[int]*models.Book, error)
+	GetBooksWithAuthors(policy access.Policy, query string, args ...interface{}) (map[int]*models.Book, error)
 }
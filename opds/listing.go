@@ -0,0 +1,379 @@
+package opds
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"turanga/access"
+	"turanga/config"
+	"turanga/language"
+	"turanga/models"
+	"turanga/search"
+)
+
+// Порядок книг в ленте
+const (
+	SortTitle  = "title"  // по названию
+	SortAdded  = "added"  // новые первыми
+	SortSeries = "series" // по сериям и номерам в серии
+)
+
+// facetRel — rel ссылок-фасетов OPDS
+const facetRel = "http://opds-spec.org/facet"
+
+// acquisitionType — тип ленты с книгами
+const acquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+
+// facetTagLimit — сколько самых частых тегов предлагать в фильтре
+const facetTagLimit = 20
+
+// sortOrders задаёт ORDER BY и название для каждого порядка книг
+var sortOrders = []struct {
+	key, title, orderBy string
+}{
+	{SortTitle, "По названию", "b.title_lower, b.id"},
+	{SortAdded, "Новые первыми", "b.id DESC"},
+	{SortSeries, "По сериям", `IFNULL(b.series_lower, '') = '', b.series_lower,
+            b.series_index IS NULL, b.series_index, b.series_number, b.title_lower`},
+}
+
+// bookFormats — форматы книг, которые отдаются в opds
+var bookFormats = []string{"epub", "fb2", "fb2.zip"}
+
+// pageFormats — форматы, которые читаются в opds постранично (OPDS-PSE, см. pse.go);
+// показываются, только если pse_width больше нуля
+var pageFormats = []string{"pdf", "djvu"}
+
+// opdsFormats возвращает форматы книг, которые показываются в opds
+func opdsFormats() []string {
+	if config.GetConfig().PSEWidth > 0 {
+		return append(append([]string{}, bookFormats...), pageFormats...)
+	}
+	return bookFormats
+}
+
+// opdsFormatList возвращает форматы opds для подстановки в SQL: 'epub', 'fb2', ...
+func opdsFormatList() string {
+	formats := opdsFormats()
+	quoted := make([]string, len(formats))
+	for i, format := range formats {
+		quoted[i] = "'" + format + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// BookListing — параметры выдачи книг из адреса: порядок, фильтры и страница
+type BookListing struct {
+	Sort     string
+	Format   string
+	Tag      string
+	Language string
+	Page     int
+
+	defaultSort string
+}
+
+// FacetCount — значение фильтра и число книг с ним
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// BookPage — страница ленты с книгами
+type BookPage struct {
+	Books     []*models.Book
+	Total     int
+	PageSize  int
+	Listing   BookListing
+	Formats   []FacetCount
+	Tags      []FacetCount
+	Languages []FacetCount
+}
+
+// ParseBookListing читает из адреса порядок (sort), фильтры (format, tag, lang) и страницу (page)
+func ParseBookListing(r *http.Request, defaultSort string) BookListing {
+	params := r.URL.Query()
+	l := BookListing{
+		Sort:        params.Get("sort"),
+		Format:      params.Get("format"),
+		Tag:         params.Get("tag"),
+		Language:    language.Normalize(params.Get("lang")),
+		defaultSort: defaultSort,
+	}
+	if sortOrderBy(l.Sort) == "" {
+		l.Sort = defaultSort
+	}
+	l.Page, _ = strconv.Atoi(params.Get("page"))
+	if l.Page < 1 {
+		l.Page = 1
+	}
+	return l
+}
+
+// sortOrderBy возвращает ORDER BY для порядка книг или пустую строку для неизвестного
+func sortOrderBy(key string) string {
+	for _, order := range sortOrders {
+		if order.key == key {
+			return order.orderBy
+		}
+	}
+	return ""
+}
+
+// where собирает условие выборки: книги раздела (condition), правила видимости и фильтры.
+// withFormat, withTag и withLanguage позволяют не учитывать фильтр при подсчёте его собственных значений.
+func (l BookListing) where(condition string, args []interface{}, policy access.Policy, withFormat, withTag, withLanguage bool) (string, []interface{}) {
+	formats := opdsFormats()
+	placeholders := CreatePlaceholders(len(formats))
+	clause := "WHERE b.file_type IN (" + placeholders + ") AND (" + condition + ") " + policy.Filter("b")
+	all := make([]interface{}, 0, len(formats)+len(args)+2)
+	for _, format := range formats {
+		all = append(all, format)
+	}
+	all = append(all, args...)
+
+	if withFormat && l.Format != "" {
+		clause += " AND b.file_type = ?"
+		all = append(all, l.Format)
+	}
+	if withTag && l.Tag != "" {
+		clause += " AND b.id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name = ?)"
+		all = append(all, l.Tag)
+	}
+	if withLanguage && l.Language != "" {
+		clause += " AND b.language = ?"
+		all = append(all, l.Language)
+	}
+	return clause, all
+}
+
+// ListBooks возвращает страницу книг раздела. condition — условие SQL на таблицу books с псевдонимом b.
+func (bh *BaseHandler) ListBooks(policy access.Policy, condition string, args []interface{}, l BookListing) (*BookPage, error) {
+	page := &BookPage{PageSize: bh.GetPaginationThreshold(), Listing: l}
+
+	where, whereArgs := l.where(condition, args, policy, true, true, true)
+	if err := bh.db.QueryRow("SELECT COUNT(*) FROM books b "+where, whereArgs...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта книг: %w", err)
+	}
+
+	rows, err := bh.db.Query("SELECT b.id FROM books b "+where+" ORDER BY "+sortOrderBy(l.Sort)+" LIMIT ? OFFSET ?",
+		append(whereArgs, page.PageSize, (l.Page-1)*page.PageSize)...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выборки книг: %w", err)
+	}
+	var ids []int
+	var order []int64
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка чтения книги: %w", err)
+		}
+		ids = append(ids, id)
+		order = append(order, int64(id))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+
+	// Книги загружаются так же, как в остальных лентах; форматы одного произведения сворачиваются в одну запись
+	booksMap, err := GetBooksByIDs(bh.db, ids, policy)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения книг: %w", err)
+	}
+	books := make([]*models.Book, 0, len(booksMap))
+	for _, book := range booksMap {
+		books = append(books, book)
+	}
+	page.Books = search.SortByIDs(books, order, func(b *models.Book) int64 { return int64(b.ID) })
+
+	if page.Formats, err = bh.countFormats(policy, condition, args, l); err != nil {
+		return nil, err
+	}
+	if page.Tags, err = bh.countTags(policy, condition, args, l); err != nil {
+		return nil, err
+	}
+	if page.Languages, err = bh.countLanguages(policy, condition, args, l); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// countFormats считает книги раздела по форматам
+func (bh *BaseHandler) countFormats(policy access.Policy, condition string, args []interface{}, l BookListing) ([]FacetCount, error) {
+	where, whereArgs := l.where(condition, args, policy, false, true, true)
+	rows, err := bh.db.Query("SELECT b.file_type, COUNT(*) FROM books b "+where+" GROUP BY b.file_type ORDER BY b.file_type", whereArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта форматов: %w", err)
+	}
+	return scanFacetCounts(rows)
+}
+
+// countTags возвращает самые частые теги книг раздела
+func (bh *BaseHandler) countTags(policy access.Policy, condition string, args []interface{}, l BookListing) ([]FacetCount, error) {
+	where, whereArgs := l.where(condition, args, policy, true, false, true)
+	rows, err := bh.db.Query(`
+        SELECT t.name, COUNT(*)
+        FROM books b
+        JOIN book_tags bt ON bt.book_id = b.id
+        JOIN tags t ON t.id = bt.tag_id
+        `+where+" "+policy.TagFilter("t")+`
+        GROUP BY t.name
+        ORDER BY COUNT(*) DESC, t.name
+        LIMIT ?`, append(whereArgs, facetTagLimit)...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта тегов: %w", err)
+	}
+	return scanFacetCounts(rows)
+}
+
+// countLanguages считает книги раздела по языкам; книги без языка не учитываются
+func (bh *BaseHandler) countLanguages(policy access.Policy, condition string, args []interface{}, l BookListing) ([]FacetCount, error) {
+	where, whereArgs := l.where(condition, args, policy, true, true, false)
+	rows, err := bh.db.Query(`
+        SELECT b.language, COUNT(*) FROM books b
+        `+where+` AND IFNULL(b.language, '') != ''
+        GROUP BY b.language
+        ORDER BY COUNT(*) DESC, b.language`, whereArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта языков: %w", err)
+	}
+	return scanFacetCounts(rows)
+}
+
+// scanFacetCounts читает пары «значение — число книг» и закрывает rows
+func scanFacetCounts(rows *sql.Rows) ([]FacetCount, error) {
+	defer rows.Close()
+	var counts []FacetCount
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, fmt.Errorf("ошибка чтения фасета: %w", err)
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+// href возвращает адрес ленты path с параметрами выдачи; параметры по умолчанию не пишутся
+func (l BookListing) href(path string) string {
+	values := url.Values{}
+	if l.Sort != "" && l.Sort != l.defaultSort {
+		values.Set("sort", l.Sort)
+	}
+	if l.Format != "" {
+		values.Set("format", l.Format)
+	}
+	if l.Tag != "" {
+		values.Set("tag", l.Tag)
+	}
+	if l.Language != "" {
+		values.Set("lang", l.Language)
+	}
+	if l.Page > 1 {
+		values.Set("page", strconv.Itoa(l.Page))
+	}
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+// Links возвращает ссылки на соседние страницы и ссылки-фасеты для ленты по адресу path
+func (p *BookPage) Links(path string) []models.Link {
+	var links []models.Link
+	l := p.Listing
+
+	// Постраничная навигация
+	lastPage := (p.Total + p.PageSize - 1) / p.PageSize
+	if lastPage < 1 {
+		lastPage = 1
+	}
+	pageLink := func(rel string, n int) models.Link {
+		target := l
+		target.Page = n
+		return models.Link{Rel: rel, Type: acquisitionType, Href: target.href(path)}
+	}
+	links = append(links, pageLink("self", l.Page), pageLink("first", 1))
+	if l.Page > 1 {
+		links = append(links, pageLink("previous", l.Page-1))
+	}
+	if l.Page < lastPage {
+		links = append(links, pageLink("next", l.Page+1))
+	}
+	links = append(links, pageLink("last", lastPage))
+
+	// Фасеты: при смене порядка или фильтра выдача начинается с первой страницы
+	facet := func(group, title string, count int, active bool, change func(*BookListing)) models.Link {
+		target := l
+		target.Page = 1
+		change(&target)
+		link := models.Link{
+			Rel:        facetRel,
+			Type:       acquisitionType,
+			Href:       target.href(path),
+			Title:      title,
+			FacetGroup: group,
+			Count:      count,
+		}
+		if active {
+			link.ActiveFacet = "true"
+		}
+		return link
+	}
+
+	for _, order := range sortOrders {
+		key := order.key
+		links = append(links, facet("Порядок", order.title, 0, l.Sort == key, func(t *BookListing) { t.Sort = key }))
+	}
+
+	if len(p.Formats) > 1 || l.Format != "" {
+		links = append(links, facet("Формат", "Все форматы", 0, l.Format == "", func(t *BookListing) { t.Format = "" }))
+		for _, fc := range p.Formats {
+			value := fc.Value
+			links = append(links, facet("Формат", value, fc.Count, l.Format == value, func(t *BookListing) { t.Format = value }))
+		}
+	}
+
+	if len(p.Tags) > 0 || l.Tag != "" {
+		links = append(links, facet("Тег", "Все теги", 0, l.Tag == "", func(t *BookListing) { t.Tag = "" }))
+		for _, fc := range p.Tags {
+			value := fc.Value
+			links = append(links, facet("Тег", value, fc.Count, l.Tag == value, func(t *BookListing) { t.Tag = value }))
+		}
+	}
+
+	if len(p.Languages) > 1 || l.Language != "" {
+		links = append(links, facet("Язык", "Все языки", 0, l.Language == "", func(t *BookListing) { t.Language = "" }))
+		for _, fc := range p.Languages {
+			value := fc.Value
+			links = append(links, facet("Язык", language.Name(value), fc.Count, l.Language == value, func(t *BookListing) { t.Language = value }))
+		}
+	}
+	return links
+}
+
+// RenderBookPage рендерит страницу книг с навигацией по страницам и фасетами (Atom или OPDS 2.0)
+func (bh *BaseHandler) RenderBookPage(w http.ResponseWriter, r *http.Request, title string, page *BookPage) {
+	links := page.Links(r.URL.EscapedPath())
+	if IsOPDS2(r) {
+		RenderOPDS2Page(w, r, title, page, links)
+		return
+	}
+
+	var entries []models.Entry
+	for _, book := range page.Books {
+		entry := CreateAcquisitionEntry(book)
+		if entry.Title != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if page.Total > page.PageSize {
+		title = fmt.Sprintf("%s (стр. %d)", title, page.Listing.Page)
+	}
+	RenderOPDSFeed(w, title, "", entries, true, links...)
+}
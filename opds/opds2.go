@@ -0,0 +1,407 @@
+package opds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"turanga/access"
+	"turanga/config"
+	"turanga/generation"
+	"turanga/models"
+	"turanga/search"
+)
+
+// OPDS 2.0 (JSON) отдаётся теми же обработчиками, что и Atom: данные выбираются одинаково,
+// отличается только вывод. Формат выбирается по префиксу /opds2/ или заголовку Accept.
+
+// opds2Type — тип ленты OPDS 2.0
+const opds2Type = "application/opds+json"
+
+// opds2Prefix — префикс адресов каталога в формате OPDS 2.0
+const opds2Prefix = "/opds2"
+
+// opds2Routes — разделы каталога, доступные с префиксом /opds2
+var opds2Routes = map[string]bool{
+	"":            true,
+	"feed":        true,
+	"authors":     true,
+	"series":      true,
+	"tags":        true,
+	"books":       true,
+	"recent":      true,
+	"genres":      true,
+	"reading":     true,
+	"opds-search": true,
+}
+
+// opds2Key — ключ контекста запроса, в котором клиент выбрал OPDS 2.0 префиксом адреса
+type opds2Key struct{}
+
+// OPDS2Feed — лента OPDS 2.0
+type OPDS2Feed struct {
+	Metadata     OPDS2Metadata      `json:"metadata"`
+	Links        []OPDS2Link        `json:"links"`
+	Navigation   []OPDS2Link        `json:"navigation,omitempty"`
+	Publications []OPDS2Publication `json:"publications,omitempty"`
+	Facets       []OPDS2Group       `json:"facets,omitempty"`
+	Groups       []OPDS2Group       `json:"groups,omitempty"`
+}
+
+// OPDS2Metadata — сведения о ленте или группе
+type OPDS2Metadata struct {
+	Title         string `json:"title"`
+	Modified      string `json:"modified,omitempty"`
+	NumberOfItems int    `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+// OPDS2Link — ссылка OPDS 2.0
+type OPDS2Link struct {
+	Href       string           `json:"href"`
+	Type       string           `json:"type,omitempty"`
+	Rel        string           `json:"rel,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Templated  bool             `json:"templated,omitempty"`
+	Properties *OPDS2Properties `json:"properties,omitempty"`
+}
+
+// OPDS2Properties — дополнительные сведения ссылки
+type OPDS2Properties struct {
+	NumberOfItems int `json:"numberOfItems,omitempty"`
+}
+
+// OPDS2Group — группа публикаций или навигации внутри ленты
+type OPDS2Group struct {
+	Metadata     OPDS2Metadata      `json:"metadata"`
+	Links        []OPDS2Link        `json:"links,omitempty"`
+	Navigation   []OPDS2Link        `json:"navigation,omitempty"`
+	Publications []OPDS2Publication `json:"publications,omitempty"`
+}
+
+// OPDS2Publication — книга в ленте OPDS 2.0
+type OPDS2Publication struct {
+	Metadata OPDS2PublicationMetadata `json:"metadata"`
+	Links    []OPDS2Link              `json:"links"`
+	Images   []OPDS2Link              `json:"images,omitempty"`
+}
+
+// OPDS2PublicationMetadata — метаданные книги
+type OPDS2PublicationMetadata struct {
+	Type        string             `json:"@type"`
+	Identifier  string             `json:"identifier"`
+	Title       string             `json:"title"`
+	Author      []OPDS2Contributor `json:"author,omitempty"`
+	Publisher   string             `json:"publisher,omitempty"`
+	Published   string             `json:"published,omitempty"`
+	Description string             `json:"description,omitempty"`
+	BelongsTo   *OPDS2BelongsTo    `json:"belongsTo,omitempty"`
+}
+
+// OPDS2Contributor — автор книги со ссылкой на его книги
+type OPDS2Contributor struct {
+	Name  string      `json:"name"`
+	Links []OPDS2Link `json:"links,omitempty"`
+}
+
+// OPDS2BelongsTo — серии, в которые входит книга
+type OPDS2BelongsTo struct {
+	Series []OPDS2Series `json:"series"`
+}
+
+// OPDS2Series — серия и номер книги в ней
+type OPDS2Series struct {
+	Name     string      `json:"name"`
+	Position float64     `json:"position,omitempty"`
+	Links    []OPDS2Link `json:"links,omitempty"`
+}
+
+// OPDS2Handler обслуживает адреса /opds2/...: отмечает запрос как OPDS 2.0,
+// убирает префикс и передаёт его обычным обработчикам каталога
+func OPDS2Handler(mux http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, opds2Prefix)
+		section, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if !opds2Routes[section] {
+			http.NotFound(w, r)
+			return
+		}
+		if section == "" {
+			path = "/feed"
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), opds2Key{}, true))
+		r2.URL.Path = path
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, opds2Prefix)
+		mux.ServeHTTP(w, r2)
+	}
+}
+
+// IsOPDS2 сообщает, что клиент ждёт OPDS 2.0: адрес с префиксом /opds2 или Accept: application/opds+json
+func IsOPDS2(r *http.Request) bool {
+	if on, _ := r.Context().Value(opds2Key{}).(bool); on {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), opds2Type)
+}
+
+// opds2Href переводит адрес раздела каталога в адрес OPDS 2.0
+func opds2Href(href string) string {
+	if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, opds2Prefix+"/") {
+		return href
+	}
+	section, _, _ := strings.Cut(strings.TrimPrefix(href, "/"), "/")
+	section, _, _ = strings.Cut(section, "?")
+	if !opds2Routes[section] {
+		return href
+	}
+	return opds2Prefix + href
+}
+
+// opds2Self возвращает ссылку на текущую ленту в формате OPDS 2.0
+func opds2Self(r *http.Request) OPDS2Link {
+	href := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		href += "?" + r.URL.RawQuery
+	}
+	return OPDS2Link{Rel: "self", Type: opds2Type, Href: opds2Href(href)}
+}
+
+// opds2CommonLinks возвращает ссылки на начало каталога и поиск
+func opds2CommonLinks() []OPDS2Link {
+	return []OPDS2Link{
+		{Rel: "start", Type: opds2Type, Href: opds2Prefix + "/feed"},
+		{Rel: "search", Type: opds2Type, Href: opds2Prefix + "/opds-search{?q,author,title,series,tag}", Templated: true},
+	}
+}
+
+// ShowOPDS2Catalog отображает корневой каталог в формате OPDS 2.0:
+// разделы каталога и группу последних поступлений
+func ShowOPDS2Catalog(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	cfg := config.GetConfig()
+
+	feed := OPDS2Feed{
+		Metadata: OPDS2Metadata{Title: "Каталог книг", Modified: generation.LastModified().Format(time.RFC3339)},
+		Links:    append([]OPDS2Link{opds2Self(r)}, opds2CommonLinks()...),
+	}
+	for _, cat := range sectionsFor(r) {
+		feed.Navigation = append(feed.Navigation, OPDS2Link{
+			Href:  opds2Href(cat.href),
+			Type:  opds2Type,
+			Rel:   cat.rel,
+			Title: cat.title,
+		})
+	}
+
+	books, err := recentBooks(db, access.ForRequest(r), 12)
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("Ошибка получения последних книг для OPDS 2.0: %v", err)
+		}
+	} else if len(books) > 0 {
+		group := OPDS2Group{
+			Metadata: OPDS2Metadata{Title: "Новые поступления", NumberOfItems: len(books)},
+			Links:    []OPDS2Link{{Rel: "self", Type: opds2Type, Href: opds2Href("/recent")}},
+		}
+		group.Publications = opds2Publications(books)
+		feed.Groups = append(feed.Groups, group)
+	}
+
+	renderOPDS2(w, feed)
+}
+
+// RenderOPDS2Navigation рендерит навигационную ленту OPDS 2.0 из записей Atom
+func RenderOPDS2Navigation(w http.ResponseWriter, r *http.Request, title string, entries []models.Entry) {
+	feed := OPDS2Feed{
+		Metadata: OPDS2Metadata{Title: title, Modified: generation.LastModified().Format(time.RFC3339)},
+		Links:    append([]OPDS2Link{opds2Self(r)}, opds2CommonLinks()...),
+	}
+	for _, entry := range entries {
+		if len(entry.Links) == 0 {
+			continue
+		}
+		feed.Navigation = append(feed.Navigation, OPDS2Link{
+			Href:  opds2Href(entry.Links[0].Href),
+			Type:  opds2Type,
+			Rel:   entry.Links[0].Rel,
+			Title: entry.Title,
+		})
+	}
+	renderOPDS2(w, feed)
+}
+
+// RenderOPDS2Publications рендерит ленту OPDS 2.0 с книгами
+func RenderOPDS2Publications(w http.ResponseWriter, r *http.Request, title string, books []*models.Book) {
+	feed := OPDS2Feed{
+		Metadata: OPDS2Metadata{Title: title, Modified: generation.LastModified().Format(time.RFC3339), NumberOfItems: len(books)},
+		Links:    append([]OPDS2Link{opds2Self(r)}, opds2CommonLinks()...),
+	}
+	feed.Publications = opds2Publications(books)
+	renderOPDS2(w, feed)
+}
+
+// RenderOPDS2Page рендерит страницу книг OPDS 2.0: ссылки на страницы идут в links,
+// ссылки-фасеты собираются в группы facets
+func RenderOPDS2Page(w http.ResponseWriter, r *http.Request, title string, page *BookPage, links []models.Link) {
+	feed := OPDS2Feed{
+		Metadata: OPDS2Metadata{
+			Title:         title,
+			Modified:      generation.LastModified().Format(time.RFC3339),
+			NumberOfItems: page.Total,
+			ItemsPerPage:  page.PageSize,
+			CurrentPage:   page.Listing.Page,
+		},
+		Links: opds2CommonLinks(),
+	}
+
+	facetIndex := make(map[string]int)
+	for _, link := range links {
+		converted := OPDS2Link{Href: opds2Href(link.Href), Type: opds2Type, Rel: link.Rel, Title: link.Title}
+		if link.FacetGroup == "" {
+			feed.Links = append(feed.Links, converted)
+			continue
+		}
+		// Выбранное значение фасета помечается rel="self"
+		converted.Rel = ""
+		if link.ActiveFacet == "true" {
+			converted.Rel = "self"
+		}
+		if link.Count > 0 {
+			converted.Properties = &OPDS2Properties{NumberOfItems: link.Count}
+		}
+		i, ok := facetIndex[link.FacetGroup]
+		if !ok {
+			i = len(feed.Facets)
+			facetIndex[link.FacetGroup] = i
+			feed.Facets = append(feed.Facets, OPDS2Group{Metadata: OPDS2Metadata{Title: link.FacetGroup}})
+		}
+		feed.Facets[i].Links = append(feed.Facets[i].Links, converted)
+	}
+
+	feed.Publications = opds2Publications(page.Books)
+	renderOPDS2(w, feed)
+}
+
+// renderOPDS2Search рендерит страницу результатов поиска в формате OPDS 2.0
+func renderOPDS2Search(w http.ResponseWriter, r *http.Request, db *sql.DB, title string, result *search.Result, params url.Values, page int, policy access.Policy) {
+	var books []*models.Book
+	if len(result.IDs) > 0 {
+		placeholders, args := search.Placeholders(result.IDs)
+		booksMap, err := GetBooksWithAuthors(db, policy, `
+            SELECT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
+                   b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
+            FROM books b
+            WHERE b.id IN (`+placeholders+`)
+              `+policy.Filter("b"), args...)
+		if err != nil {
+			log.Printf("Ошибка получения книг для поиска OPDS 2.0: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for _, book := range booksMap {
+			books = append(books, book)
+		}
+		books = search.SortByIDs(books, result.IDs, func(b *models.Book) int64 { return int64(b.ID) })
+	}
+
+	feed := OPDS2Feed{
+		Metadata: OPDS2Metadata{
+			Title:         title,
+			Modified:      generation.LastModified().Format(time.RFC3339),
+			NumberOfItems: result.Total,
+			ItemsPerPage:  searchPageSize,
+			CurrentPage:   page,
+		},
+		Links: opds2CommonLinks(),
+	}
+	for _, link := range searchPageLinks(params, page, result.Total) {
+		feed.Links = append(feed.Links, OPDS2Link{Rel: link.Rel, Type: opds2Type, Href: opds2Href(link.Href)})
+	}
+	feed.Publications = opds2Publications(books)
+	renderOPDS2(w, feed)
+}
+
+// opds2Publications переводит книги в публикации OPDS 2.0, пропуская книги без файлов
+func opds2Publications(books []*models.Book) []OPDS2Publication {
+	var publications []OPDS2Publication
+	for _, book := range books {
+		if len(book.Files) == 0 {
+			continue
+		}
+		publications = append(publications, opds2Publication(book))
+	}
+	return publications
+}
+
+// opds2Publication переводит книгу в публикацию OPDS 2.0
+func opds2Publication(book *models.Book) OPDS2Publication {
+	pub := OPDS2Publication{
+		Metadata: OPDS2PublicationMetadata{
+			Type:        "http://schema.org/Book",
+			Identifier:  fmt.Sprintf("turanga:book:%d", book.ID),
+			Title:       book.Title,
+			Publisher:   book.Publisher,
+			Published:   book.Year,
+			Description: strings.TrimSpace(book.Annotation),
+		},
+		Links: []OPDS2Link{},
+	}
+	if isbn := search.NormalizeISBN(book.ISBN); isbn != "" {
+		pub.Metadata.Identifier = "urn:isbn:" + isbn
+	}
+
+	for _, author := range book.Authors {
+		if author.FullName == "" {
+			continue
+		}
+		pub.Metadata.Author = append(pub.Metadata.Author, OPDS2Contributor{
+			Name:  author.FullName,
+			Links: []OPDS2Link{{Href: opds2Href("/authors/" + url.QueryEscape(author.FullName)), Type: opds2Type}},
+		})
+	}
+
+	if book.Series != "" {
+		series := OPDS2Series{
+			Name:  book.Series,
+			Links: []OPDS2Link{{Href: opds2Href("/series/" + url.QueryEscape(book.Series)), Type: opds2Type}},
+		}
+		if position, err := strconv.ParseFloat(strings.TrimSpace(book.SeriesNumber), 64); err == nil {
+			series.Position = position
+		}
+		pub.Metadata.BelongsTo = &OPDS2BelongsTo{Series: []OPDS2Series{series}}
+	}
+
+	if book.Files[0].FileHash != "" {
+		cover := "/covers/" + book.Files[0].FileHash + ".jpg"
+		pub.Images = append(pub.Images, OPDS2Link{Href: cover, Type: "image/jpeg"})
+	}
+
+	// По одной ссылке на каждый формат произведения
+	for _, file := range book.Files {
+		pub.Links = append(pub.Links, OPDS2Link{
+			Href: file.URL,
+			Type: GetMimeType(file.Type),
+			Rel:  "http://opds-spec.org/acquisition",
+		})
+	}
+	if source, ok := needsConversion(book.Files); ok {
+		link := convertLink(source.FileHash, book.Title)
+		pub.Links = append(pub.Links, OPDS2Link{Href: link.Href, Type: link.Type, Rel: link.Rel, Title: link.Title})
+	}
+	return pub
+}
+
+// renderOPDS2 отправляет ленту OPDS 2.0
+func renderOPDS2(w http.ResponseWriter, feed OPDS2Feed) {
+	w.Header().Set("Content-Type", opds2Type+"; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Ошибка кодирования OPDS 2.0 для '%s': %v", feed.Metadata.Title, err)
+	}
+}
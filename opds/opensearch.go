@@ -0,0 +1,185 @@
+package opds
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"turanga/search"
+)
+
+// searchPageSize — число книг на одной странице результатов поиска
+const searchPageSize = 50
+
+// searchFeedType — тип ленты с результатами поиска
+const searchFeedType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+
+// searchTemplate — шаблон поискового запроса OpenSearch.
+// Параметры atom:author и atom:title заполняют ридеры с расширенным поиском (KOReader, FBReader).
+const searchTemplate = "/opds-search?q={searchTerms}&author={atom:author?}&title={atom:title?}&page={startPage?}"
+
+// searchFields — параметры запроса, которые ищутся по отдельному полю книги
+var searchFields = []string{"author", "title", "series", "tag", "isbn", "hash"}
+
+// OpenSearchURL описывает шаблон адреса поиска
+type OpenSearchURL struct {
+	Type       string `xml:"type,attr"`
+	Template   string `xml:"template,attr"`
+	PageOffset int    `xml:"pageOffset,attr,omitempty"`
+}
+
+// OpenSearchQuery — пример запроса для клиента
+type OpenSearchQuery struct {
+	Role        string `xml:"role,attr"`
+	SearchTerms string `xml:"searchTerms,attr"`
+}
+
+// OpenSearchDescription — документ описания поиска OpenSearch 1.1
+type OpenSearchDescription struct {
+	XMLName        xml.Name          `xml:"OpenSearchDescription"`
+	Xmlns          string            `xml:"xmlns,attr"`
+	XmlnsAtom      string            `xml:"xmlns:atom,attr"`
+	ShortName      string            `xml:"ShortName"`
+	Description    string            `xml:"Description"`
+	InputEncoding  string            `xml:"InputEncoding"`
+	OutputEncoding string            `xml:"OutputEncoding"`
+	URLs           []OpenSearchURL   `xml:"Url"`
+	Queries        []OpenSearchQuery `xml:"Query"`
+}
+
+// OpenSearchDescriptionHandler отдаёт описание поиска OpenSearch
+// URL: /opds-search.xml
+func OpenSearchDescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	desc := OpenSearchDescription{
+		Xmlns:          "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsAtom:      "http://www.w3.org/2005/Atom",
+		ShortName:      "Turanga",
+		Description:    "Поиск книг по названию, автору, серии, тегу, ISBN и хешу файла",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs: []OpenSearchURL{
+			{Type: searchFeedType, Template: searchTemplate, PageOffset: 1},
+			{Type: "application/atom+xml", Template: searchTemplate, PageOffset: 1},
+		},
+		Queries: []OpenSearchQuery{
+			{Role: "example", SearchTerms: `author:Стругацкий title:"Пикник на обочине"`},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(desc); err != nil {
+		log.Printf("Ошибка кодирования описания OpenSearch: %v", err)
+	}
+}
+
+// parseSearchRequest собирает поисковый запрос из адреса. Строка поиска берётся из пути
+// (/opds-search/{searchTerms}) или параметра q и может содержать условия по полям (author:, title:, ...),
+// параметры author, title, series, tag, isbn и hash добавляют условия к ней.
+// Возвращает запрос, нормализованные параметры для ссылок на страницы и номер страницы.
+func parseSearchRequest(r *http.Request) (search.Query, url.Values, int) {
+	params := r.URL.Query()
+
+	terms := params.Get("q")
+	if rest := strings.TrimPrefix(r.URL.Path, "/opds-search/"); rest != r.URL.Path && rest != "" {
+		if unescaped, err := url.QueryUnescape(rest); err == nil {
+			rest = unescaped
+		}
+		terms = rest
+	}
+	terms = strings.TrimSpace(unfilledTemplate(terms))
+
+	q := search.ParseQuery(terms)
+	canonical := url.Values{}
+	if terms != "" {
+		canonical.Set("q", terms)
+	}
+	for _, field := range searchFields {
+		value := strings.TrimSpace(unfilledTemplate(params.Get(field)))
+		if value == "" {
+			continue
+		}
+		canonical.Set(field, value)
+		switch field {
+		case "author":
+			q.Author = strings.TrimSpace(q.Author + " " + value)
+		case "title":
+			q.Title = strings.TrimSpace(q.Title + " " + value)
+		case "series":
+			q.Series = strings.TrimSpace(q.Series + " " + value)
+		case "tag":
+			q.Tag = strings.TrimSpace(q.Tag + " " + value)
+		case "isbn":
+			q.ISBN = value
+		case "hash":
+			q.FileHash = strings.ToLower(value)
+		}
+	}
+
+	page, err := strconv.Atoi(unfilledTemplate(params.Get("page")))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return q, canonical, page
+}
+
+// unfilledTemplate отбрасывает параметр шаблона, который клиент оставил незаполненным ({atom:author?})
+func unfilledTemplate(value string) string {
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+		return ""
+	}
+	return value
+}
+
+// searchPageURL возвращает адрес страницы результатов поиска
+func searchPageURL(params url.Values, page int) string {
+	values := url.Values{}
+	for key, v := range params {
+		values[key] = v
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return "/opds-search?" + values.Encode()
+}
+
+// searchPageLinks возвращает ссылки self, first, previous, next и last для страницы результатов
+func searchPageLinks(params url.Values, page, total int) []Link {
+	lastPage := (total + searchPageSize - 1) / searchPageSize
+	if lastPage < 1 {
+		lastPage = 1
+	}
+	links := []Link{
+		{Rel: "self", Type: searchFeedType, Href: searchPageURL(params, page)},
+		{Rel: "first", Type: searchFeedType, Href: searchPageURL(params, 1)},
+	}
+	if page > 1 {
+		links = append(links, Link{Rel: "previous", Type: searchFeedType, Href: searchPageURL(params, page-1)})
+	}
+	if page < lastPage {
+		links = append(links, Link{Rel: "next", Type: searchFeedType, Href: searchPageURL(params, page+1)})
+	}
+	links = append(links, Link{Rel: "last", Type: searchFeedType, Href: searchPageURL(params, lastPage)})
+	return links
+}
+
+// describeSearch возвращает запрос в виде строки поиска для заголовка ленты
+func describeSearch(params url.Values) string {
+	parts := []string{}
+	if terms := params.Get("q"); terms != "" {
+		parts = append(parts, terms)
+	}
+	for _, field := range searchFields {
+		value := params.Get(field)
+		if value == "" {
+			continue
+		}
+		if strings.ContainsAny(value, " \t") {
+			value = `"` + value + `"`
+		}
+		parts = append(parts, field+":"+value)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,216 @@
+// opds/pse.go
+package opds
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/models"
+	"turanga/scanner"
+)
+
+// Постраничный просмотр PDF и DJVU (OPDS Page Streaming Extension 1.x).
+// У книги в ленте появляется ссылка rel=".../opds-pse/stream" с шаблоном адреса
+// и числом страниц pse:count; ридер подставляет номер страницы (с нуля) и ширину
+// экрана и получает страницу в JPEG. Страницы рисует пакет scanner и хранит на диске.
+
+const (
+	pseRel       = "http://vaemendis.net/opds-pse/stream"
+	pseNamespace = "http://vaemendis.net/opds-pse/ns"
+	psePrefix    = "/opds-pse/"
+	pseMinWidth  = 200 // Меньше рисовать нет смысла
+	pseWidthStep = 100 // Ширина округляется, чтобы не хранить страницы под каждый экран
+)
+
+// isPageFormat сообщает, читается ли формат постранично
+func isPageFormat(fileType string) bool {
+	for _, format := range pageFormats {
+		if format == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+// pseLink возвращает ссылку постраничного просмотра файла
+func pseLink(fileHash string, pages int) models.Link {
+	return models.Link{
+		Href:     psePrefix + fileHash + "/{pageNumber}?width={maxWidth}",
+		Type:     "image/jpeg",
+		Rel:      pseRel,
+		PSECount: pages,
+	}
+}
+
+// hasPSELinks проверяет, есть ли в записях ссылки постраничного просмотра
+func hasPSELinks(entries []models.Entry) bool {
+	for _, entry := range entries {
+		for _, link := range entry.Links {
+			if link.Rel == pseRel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pageCounts возвращает число страниц файлов PDF/DJVU по хешам. Недостающие
+// значения считаются утилитами poppler/djvulibre и сохраняются в page_counts.
+func pageCounts(db *sql.DB, hashes []string) map[string]int {
+	cfg := config.GetConfig()
+	counts := make(map[string]int)
+	if len(hashes) == 0 || cfg.PSEWidth <= 0 {
+		return counts
+	}
+
+	args := make([]interface{}, 0, len(hashes))
+	for _, hash := range hashes {
+		args = append(args, hash)
+	}
+	rows, err := db.Query(`
+        SELECT b.file_hash, MIN(b.file_url), MIN(b.file_type), pc.pages
+        FROM books b
+        LEFT JOIN page_counts pc ON pc.file_hash = b.file_hash
+        WHERE b.file_hash IN (`+CreatePlaceholders(len(hashes))+`)
+          AND b.file_type IN ('pdf', 'djvu')
+        GROUP BY b.file_hash`, args...)
+	if err != nil {
+		log.Printf("Ошибка получения числа страниц: %v", err)
+		return counts
+	}
+
+	type missingCount struct {
+		hash, filePath, fileType string
+	}
+	var missing []missingCount
+	for rows.Next() {
+		var hash, filePath, fileType string
+		var pages sql.NullInt64
+		if err := rows.Scan(&hash, &filePath, &fileType, &pages); err != nil {
+			continue
+		}
+		if pages.Valid {
+			counts[hash] = int(pages.Int64)
+		} else {
+			missing = append(missing, missingCount{hash, filePath, fileType})
+		}
+	}
+	rows.Close()
+
+	// Не удалось посчитать (нет утилит, повреждённый файл) — не сохраняем, попробуем в следующий раз
+	for _, m := range missing {
+		pages, err := scanner.PageCount(m.filePath, m.fileType)
+		if err != nil {
+			if cfg.Debug {
+				log.Printf("Не удалось посчитать страницы %s: %v", m.filePath, err)
+			}
+			continue
+		}
+		if _, err := db.Exec("INSERT OR REPLACE INTO page_counts (file_hash, pages) VALUES (?, ?)", m.hash, pages); err != nil {
+			log.Printf("Ошибка сохранения числа страниц %s: %v", m.filePath, err)
+		}
+		counts[m.hash] = pages
+	}
+	return counts
+}
+
+// attachPageCounts заполняет число страниц у файлов PDF/DJVU
+func attachPageCounts(db *sql.DB, booksMap map[int]*models.Book) {
+	var hashes []string
+	for _, book := range booksMap {
+		for _, file := range book.Files {
+			if isPageFormat(file.Type) && file.FileHash != "" {
+				hashes = append(hashes, file.FileHash)
+			}
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	counts := pageCounts(db, hashes)
+	for _, book := range booksMap {
+		for i := range book.Files {
+			book.Files[i].PageCount = counts[book.Files[i].FileHash]
+		}
+	}
+}
+
+// pseWidth приводит ширину, запрошенную ридером, к допустимой
+func pseWidth(requested string) int {
+	maxWidth := config.GetConfig().PSEWidth
+	width, err := strconv.Atoi(requested)
+	if err != nil || width <= 0 || width > maxWidth {
+		return maxWidth
+	}
+	width = (width + pseWidthStep - 1) / pseWidthStep * pseWidthStep
+	if width < pseMinWidth {
+		width = pseMinWidth
+	}
+	if width > maxWidth {
+		width = maxWidth
+	}
+	return width
+}
+
+// PSEPageHandler отдаёт страницу PDF/DJVU в JPEG
+// URL: /opds-pse/{хеш файла}/{номер страницы с нуля}?width={ширина}
+func PSEPageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig()
+		if cfg.PSEWidth <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		fileHash, pageStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, psePrefix), "/")
+		pageNumber, err := strconv.Atoi(pageStr)
+		if fileHash == "" || err != nil || pageNumber < 0 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+
+		// Закрытая книга для того, кому она не разрешена, как будто отсутствует
+		var filePath, fileType string
+		err = db.QueryRow(`
+            SELECT b.file_url, b.file_type
+            FROM books b
+            WHERE b.file_hash = ? AND b.file_type IN ('pdf', 'djvu') `+access.ForRequest(r).Filter("b")+`
+            LIMIT 1`, fileHash).Scan(&filePath, &fileType)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("Ошибка получения книги для постраничного просмотра %s: %v", fileHash, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		if pages := pageCounts(db, []string{fileHash})[fileHash]; pageNumber >= pages {
+			http.NotFound(w, r)
+			return
+		}
+
+		width := pseWidth(r.URL.Query().Get("width"))
+		pagePath, err := scanner.RenderPage(filePath, fileType, fileHash, pageNumber+1, width)
+		if err != nil {
+			log.Printf("Ошибка отрисовки страницы %d файла %s: %v", pageNumber+1, filePath, err)
+			http.Error(w, "Page rendering error", http.StatusInternalServerError)
+			return
+		}
+
+		if cfg.Debug {
+			log.Printf("OPDS-PSE: страница %d файла %s, ширина %d", pageNumber+1, filePath, width)
+		}
+
+		// Страница с тем же хешем файла и шириной не меняется
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		http.ServeFile(w, r, pagePath)
+	}
+}
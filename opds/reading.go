@@ -0,0 +1,109 @@
+// opds/reading.go
+package opds
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/models"
+	"turanga/search"
+	"turanga/users"
+)
+
+// Раздел «Читаю сейчас»: книги, позицию в которых KOReader сохранил на сервере
+// синхронизации (пакет kosync), последние прочитанные первыми. Прогресс не меняет
+// счётчик изменений библиотеки, поэтому лента не кэшируется (см. main.go).
+
+// readingSection — раздел корневого каталога для вошедшего пользователя
+var readingSection = catalogSection{"Читаю сейчас", "turanga:reading", "Книги, которые вы читаете в KOReader", "/reading", "subsection", ""}
+
+// sectionsFor возвращает разделы корневого каталога для пользователя запроса
+func sectionsFor(r *http.Request) []catalogSection {
+	if users.FromContext(r.Context()) == nil {
+		return catalogSections
+	}
+	return append(append([]catalogSection{}, catalogSections...), readingSection)
+}
+
+// ReadingHandler отдаёт ленту книг, которые пользователь читает и ещё не дочитал
+// URL: /reading
+func ReadingHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig()
+		user := users.FromContext(r.Context())
+		if user == nil {
+			requestAuth(w)
+			return
+		}
+		policy := access.ForRequest(r)
+
+		rows, err := db.Query(`
+            SELECT b.id, rp.percentage
+            FROM reading_progress rp
+            JOIN document_digests dd ON dd.digest = rp.document
+            JOIN books b ON b.file_hash = dd.file_hash
+            WHERE rp.user_id = ? AND rp.percentage < 1
+              AND b.file_type IN (`+opdsFormatList()+`) `+policy.Filter("b")+`
+            ORDER BY rp.updated_at DESC
+            LIMIT ?`, user.ID, cfg.PaginationThreshold)
+		if err != nil {
+			log.Printf("Ошибка получения читаемых книг: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		var ids []int
+		var order []int64
+		percents := make(map[int]float64)
+		for rows.Next() {
+			var id int
+			var percentage float64
+			if err := rows.Scan(&id, &percentage); err != nil {
+				continue
+			}
+			// Книгу могли читать в нескольких форматах — берём последнюю позицию
+			if _, seen := percents[id]; seen {
+				continue
+			}
+			percents[id] = percentage
+			ids = append(ids, id)
+			order = append(order, int64(id))
+		}
+		rows.Close()
+
+		booksMap, err := GetBooksByIDs(db, ids, policy)
+		if err != nil {
+			log.Printf("Ошибка получения читаемых книг: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		books := make([]*models.Book, 0, len(booksMap))
+		for _, book := range booksMap {
+			books = append(books, book)
+		}
+		books = search.SortByIDs(books, order, func(b *models.Book) int64 { return int64(b.ID) })
+
+		if cfg.Debug {
+			log.Printf("OPDS: читаемых книг пользователя %s: %d", user.Login, len(books))
+		}
+
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if IsOPDS2(r) {
+			RenderOPDS2Publications(w, r, readingSection.title, books)
+			return
+		}
+		var entries []models.Entry
+		for _, book := range books {
+			entry := CreateAcquisitionEntry(book)
+			if entry.Title == "" {
+				continue
+			}
+			entry.Content.Text = fmt.Sprintf("Прочитано %d%%\n\n%s", int(percents[book.ID]*100+0.5), entry.Content.Text)
+			entries = append(entries, entry)
+		}
+		RenderOPDSFeed(w, readingSection.title, "", entries, true)
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"sort"
 	"strings"
+	"turanga/access"
 	"turanga/config"
 	"turanga/models"
 )
@@ -26,6 +26,7 @@ func NewSeriesHandler(database *sql.DB, cfg *config.Config) *SeriesHandler {
 
 // SeriesHandler обрабатывает запрос к /series
 func (sh *SeriesHandler) SeriesHandler(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	if r.URL.Path != "/series" && r.URL.Path != "/series/" {
 		path := strings.TrimPrefix(r.URL.Path, "/series/")
 		path = strings.TrimPrefix(path, "/")
@@ -44,8 +45,8 @@ func (sh *SeriesHandler) SeriesHandler(w http.ResponseWriter, r *http.Request) {
         SELECT COUNT(DISTINCT b.series)
         FROM books b 
         WHERE b.series != '' AND b.series IS NOT NULL 
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
     `)
 	if err != nil {
 		log.Printf("Ошибка подсчета серий: %v", err)
@@ -64,6 +65,7 @@ func (sh *SeriesHandler) SeriesHandler(w http.ResponseWriter, r *http.Request) {
 
 // SeriesLettersHandler показывает каталог с буквами алфавита для серий
 func (sh *SeriesHandler) SeriesLettersHandler(w http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
 	query := `
         SELECT DISTINCT 
             CASE 
@@ -75,8 +77,8 @@ func (sh *SeriesHandler) SeriesLettersHandler(w http.ResponseWriter, r *http.Req
             COUNT(DISTINCT b.series) as series_count
         FROM books b
         WHERE b.series_lower != '' AND b.series_lower IS NOT NULL 
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         GROUP BY 
             CASE 
                 WHEN SUBSTR(b.series_lower, 1, 1) BETWEEN 'a' AND 'z' THEN SUBSTR(b.series_lower, 1, 1)
@@ -109,7 +111,7 @@ func (sh *SeriesHandler) SeriesLettersHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	sh.RenderNavigationFeed(w, "Серии по алфавиту", entries)
+	sh.RenderNavigationFeed(w, r, "Серии по алфавиту", entries)
 }
 
 // SeriesByLetterHandler показывает серии на определенную букву
@@ -128,26 +130,26 @@ func (sh *SeriesHandler) SeriesByLetterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	seriesList, err := sh.getSeriesByLetter(letter)
+	seriesList, err := sh.getSeriesByLetter(letter, access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса серий на букву %s: %v", letter, err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sh.renderSeriesFeed(w, fmt.Sprintf("Серии на букву \"%s\"", letter), seriesList)
+	sh.renderSeriesFeed(w, r, fmt.Sprintf("Серии на букву \"%s\"", letter), seriesList)
 }
 
 // SeriesListHandler показывает все серии (для случаев, когда их <= 60)
 func (sh *SeriesHandler) SeriesListHandler(w http.ResponseWriter, r *http.Request) {
-	seriesList, err := sh.getAllSeries()
+	seriesList, err := sh.getAllSeries(access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса серий к БД: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sh.renderSeriesFeed(w, "Серии", seriesList)
+	sh.renderSeriesFeed(w, r, "Серии", seriesList)
 }
 
 // SeriesBooksHandler обрабатывает запрос к конкретной серии
@@ -159,19 +161,19 @@ func (sh *SeriesHandler) SeriesBooksHandler(w http.ResponseWriter, r *http.Reque
 		seriesName = strings.TrimPrefix(r.URL.Path, "/series/")
 	}
 
-	books, err := sh.getBooksBySeries(seriesName)
+	page, err := sh.ListBooks(access.ForRequest(r), "b.series_lower = ?",
+		[]interface{}{strings.ToLower(seriesName)}, ParseBookListing(r, SortSeries))
 	if err != nil {
 		log.Printf("Ошибка запроса книг серии к БД: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sortedBooks := sh.sortBooksBySeries(books)
-	sh.RenderAcquisitionFeed(w, "Серия: "+seriesName, sortedBooks)
+	sh.RenderBookPage(w, r, "Серия: "+seriesName, page)
 }
 
 // getSeriesByLetter получает серии на определенную букву
-func (sh *SeriesHandler) getSeriesByLetter(letter string) ([]*SeriesInfo, error) {
+func (sh *SeriesHandler) getSeriesByLetter(letter string, policy access.Policy) ([]*SeriesInfo, error) {
 	var seriesRows *sql.Rows
 	var err error
 
@@ -187,8 +189,8 @@ func (sh *SeriesHandler) getSeriesByLetter(letter string) ([]*SeriesInfo, error)
                 FROM books b 
                 WHERE b.series_lower != '' AND b.series_lower IS NOT NULL 
                   AND (SUBSTR(b.series_lower, 1, 1) = 'ё' OR SUBSTR(b.series_lower, 1, 1) = 'е')
-                  AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-                  AND (b.over18 IS NULL OR b.over18 = 0)
+                  AND b.file_type IN (` + opdsFormatList() + `)
+                  ` + policy.Filter("b") + `
                 GROUP BY b.series 
                 ORDER BY b.series_lower
             `)
@@ -198,8 +200,8 @@ func (sh *SeriesHandler) getSeriesByLetter(letter string) ([]*SeriesInfo, error)
                 FROM books b 
                 WHERE b.series_lower != '' AND b.series_lower IS NOT NULL 
                   AND SUBSTR(b.series_lower, 1, 1) = ?
-                  AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-                  AND (b.over18 IS NULL OR b.over18 = 0)
+                  AND b.file_type IN (`+opdsFormatList()+`)
+                  `+policy.Filter("b")+`
                 GROUP BY b.series 
                 ORDER BY b.series_lower
             `, lowerLetter)
@@ -210,8 +212,8 @@ func (sh *SeriesHandler) getSeriesByLetter(letter string) ([]*SeriesInfo, error)
             FROM books b 
             WHERE b.series_lower != '' AND b.series_lower IS NOT NULL 
               AND SUBSTR(b.series_lower, 1, 1) = ?
-              AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b.over18 IS NULL OR b.over18 = 0)
+              AND b.file_type IN (`+opdsFormatList()+`)
+              `+policy.Filter("b")+`
             GROUP BY b.series 
             ORDER BY b.series_lower
         `, lowerLetter)
@@ -241,13 +243,13 @@ func (sh *SeriesHandler) getSeriesByLetter(letter string) ([]*SeriesInfo, error)
 }
 
 // getAllSeries получает все серии
-func (sh *SeriesHandler) getAllSeries() ([]*SeriesInfo, error) {
+func (sh *SeriesHandler) getAllSeries(policy access.Policy) ([]*SeriesInfo, error) {
 	seriesRows, err := sh.db.Query(`
         SELECT b.series, COUNT(*) as book_count 
         FROM books b 
         WHERE b.series_lower != '' AND b.series_lower IS NOT NULL 
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (` + opdsFormatList() + `)
+          ` + policy.Filter("b") + `
         GROUP BY b.series 
         ORDER BY b.series_lower
     `)
@@ -274,117 +276,8 @@ func (sh *SeriesHandler) getAllSeries() ([]*SeriesInfo, error) {
 	return seriesList, seriesRows.Err()
 }
 
-// getBooksBySeries получает книги серии с авторами
-func (sh *SeriesHandler) getBooksBySeries(seriesName string) (map[int]*models.Book, error) {
-	cfg := config.GetConfig()
-	// Используем lower-версию имени серии для поиска
-	lowerSeriesName := strings.ToLower(seriesName)
-	query := `
-        SELECT DISTINCT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
-               b.isbn, b.year, b.publisher,
-               b.file_url, b.file_type, b.file_hash
-        FROM books b
-        WHERE b.series_lower = ?
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
-        ORDER BY 
-            CASE 
-                WHEN b.series_number GLOB '[0-9]*' THEN CAST(b.series_number AS INTEGER)
-                ELSE 0
-            END,
-            b.series_number,
-            b.title_lower
-    `
-
-	bookRows, err := sh.db.Query(query, lowerSeriesName)
-	if err != nil {
-		return nil, err
-	}
-	defer bookRows.Close()
-
-	booksMap := make(map[int]*models.Book)
-	var bookIDs []int
-
-	for bookRows.Next() {
-		var bookID int
-		var title, series, seriesNumber, publishedAt, isbn, year, publisher sql.NullString
-		var fileURL, fileType, fileHash sql.NullString
-
-		err := bookRows.Scan(&bookID, &title, &series, &seriesNumber, &publishedAt,
-			&isbn, &year, &publisher,
-			&fileURL, &fileType, &fileHash)
-		if err != nil {
-			if cfg.Debug {
-				log.Printf("Ошибка сканирования строки для серии: %v", err)
-			}
-			continue
-		}
-
-		annotationText := readAnnotation(fileHash.String)
-
-		book := &models.Book{
-			ID:           bookID,
-			Title:        title.String,
-			Authors:      []models.Author{},
-			Series:       series.String,
-			SeriesNumber: seriesNumber.String,
-			PublishedAt:  publishedAt.String,
-			Annotation:   annotationText,
-			ISBN:         isbn.String,
-			Year:         year.String,
-			Publisher:    publisher.String,
-			Files:        []models.BookFile{},
-		}
-
-		bookFile := models.BookFile{
-			URL:      fmt.Sprintf("/opds-download/%d/%s", bookID, url.QueryEscape(title.String+"."+GetFileExtension(fileType.String))),
-			Type:     fileType.String,
-			FileHash: fileHash.String,
-		}
-		book.Files = append(book.Files, bookFile)
-
-		booksMap[bookID] = book
-		bookIDs = append(bookIDs, bookID)
-	}
-
-	// Получаем всех авторов одним запросом
-	if len(bookIDs) > 0 {
-		placeholders := sh.CreatePlaceholders(len(bookIDs))
-		authorQuery := fmt.Sprintf(`
-            SELECT ba.book_id, a.full_name
-            FROM book_authors ba
-            JOIN authors a ON ba.author_id = a.id
-            WHERE ba.book_id IN (%s)
-            ORDER BY ba.book_id, a.full_name
-        `, placeholders)
-
-		authorArgs := sh.ConvertToInterfaceSlice(bookIDs)
-		authorRows, err := sh.db.Query(authorQuery, authorArgs...)
-		if err == nil {
-			defer authorRows.Close()
-
-			for authorRows.Next() {
-				var bookID int
-				var fullName string
-				if err := authorRows.Scan(&bookID, &fullName); err != nil {
-					continue
-				}
-
-				if book, exists := booksMap[bookID]; exists {
-					author := models.Author{
-						FullName: fullName,
-					}
-					book.Authors = append(book.Authors, author)
-				}
-			}
-		}
-	}
-
-	return booksMap, nil
-}
-
 // renderSeriesFeed создает и отправляет OPDS фид с сериями
-func (sh *SeriesHandler) renderSeriesFeed(w http.ResponseWriter, title string, seriesList []*SeriesInfo) {
+func (sh *SeriesHandler) renderSeriesFeed(w http.ResponseWriter, r *http.Request, title string, seriesList []*SeriesInfo) {
 	var entries []models.Entry
 
 	for _, seriesInfo := range seriesList {
@@ -399,24 +292,7 @@ func (sh *SeriesHandler) renderSeriesFeed(w http.ResponseWriter, title string, s
 		entries = append(entries, entry)
 	}
 
-	sh.RenderNavigationFeed(w, title, entries)
-}
-
-// sortBooksBySeries сортирует книги по серии и номеру
-func (sh *SeriesHandler) sortBooksBySeries(booksMap map[int]*models.Book) []*models.Book {
-	var books []*models.Book
-	for _, book := range booksMap {
-		books = append(books, book)
-	}
-
-	sort.Slice(books, func(i, j int) bool {
-		if books[i].Series != books[j].Series {
-			return books[i].Series < books[j].Series
-		}
-		return books[i].SeriesNumber < books[j].SeriesNumber
-	})
-
-	return books
+	sh.RenderNavigationFeed(w, r, title, entries)
 }
 
 // SeriesInfo вспомогательная структура для информации о серии
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"turanga/access"
 	"turanga/config"
 	"turanga/models"
 )
@@ -44,8 +45,8 @@ func (th *TagHandler) TagsHandler(w http.ResponseWriter, r *http.Request) {
 	// Подсчитываем теги
 	tagCount, err := th.CountItems(`
         SELECT COUNT(*)
-        FROM tags
-    `)
+        FROM tags t
+        WHERE 1 = 1 ` + access.ForRequest(r).TagFilter("t"))
 	if err != nil {
 		log.Printf("Ошибка подсчета тегов: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
@@ -73,6 +74,7 @@ func (th *TagHandler) showTagsLetters(w http.ResponseWriter, r *http.Request) {
             END as first_letter, 
             COUNT(*) as tag_count
         FROM tags t
+        WHERE 1 = 1 ` + access.ForRequest(r).TagFilter("t") + `
         GROUP BY 
             CASE 
                 WHEN UPPER(SUBSTR(t.name, 1, 1)) BETWEEN 'A' AND 'Z' THEN UPPER(SUBSTR(t.name, 1, 1))
@@ -105,7 +107,7 @@ func (th *TagHandler) showTagsLetters(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	th.RenderNavigationFeed(w, "Теги по алфавиту", entries)
+	th.RenderNavigationFeed(w, r, "Теги по алфавиту", entries)
 }
 
 // showTagsForLetter показывает теги на определенную букву
@@ -115,26 +117,26 @@ func (th *TagHandler) showTagsForLetter(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	tags, err := th.getTagsByLetter(letter)
+	tags, err := th.getTagsByLetter(letter, access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса тегов на букву %s: %v", letter, err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	th.renderTagsFeed(w, fmt.Sprintf("Теги на букву \"%s\"", letter), tags)
+	th.renderTagsFeed(w, r, fmt.Sprintf("Теги на букву \"%s\"", letter), tags)
 }
 
 // showAllTags показывает все теги (для случаев, когда их <= 60)
 func (th *TagHandler) showAllTags(w http.ResponseWriter, r *http.Request) {
-	tags, err := th.getAllTags()
+	tags, err := th.getAllTags(access.ForRequest(r))
 	if err != nil {
 		log.Printf("Ошибка запроса тегов: %v", err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	th.renderTagsFeed(w, "Все теги", tags)
+	th.renderTagsFeed(w, r, "Все теги", tags)
 }
 
 // showBooksForTag показывает книги с определенным тегом
@@ -144,19 +146,27 @@ func (th *TagHandler) showBooksForTag(w http.ResponseWriter, r *http.Request, ta
 		return
 	}
 
-	books, err := th.getBooksWithTag(tagName)
+	page, err := th.ListBooks(access.ForRequest(r),
+		"b.id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON bt.tag_id = t.id WHERE t.name = ?)",
+		[]interface{}{tagName}, ParseBookListing(r, SortTitle))
 	if err != nil {
 		log.Printf("Ошибка запроса книг с тегом %s: %v", tagName, err)
 		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sortedBooks := th.SortBooksByTitle(books)
-	th.RenderAcquisitionFeed(w, fmt.Sprintf("Книги с тегом \"%s\"", tagName), sortedBooks)
+	th.RenderBookPage(w, r, fmt.Sprintf("Книги с тегом \"%s\"", tagName), page)
 }
 
 // getTagsByLetter получает теги на определенную букву
-func (th *TagHandler) getTagsByLetter(letter string) ([]*TagInfo, error) {
+func (th *TagHandler) getTagsByLetter(letter string, policy access.Policy) ([]*TagInfo, error) {
+	// Закрытые теги и закрытые книги не видны тем, кому они не разрешены
+	baseQuery := `
+                SELECT t.name, COUNT(b.id) as book_count
+                FROM tags t
+                LEFT JOIN book_tags bt ON t.id = bt.tag_id
+                LEFT JOIN books b ON b.id = bt.book_id ` + policy.Filter("b") + `
+                WHERE 1 = 1 ` + policy.TagFilter("t")
 	var tagRows *sql.Rows
 	var err error
 
@@ -164,40 +174,28 @@ func (th *TagHandler) getTagsByLetter(letter string) ([]*TagInfo, error) {
 	case "А", "Б", "В", "Г", "Д", "Е", "Ж", "З", "И", "Й", "К", "Л", "М",
 		"Н", "О", "П", "Р", "С", "Т", "У", "Ф", "Х", "Ц", "Ч", "Ш", "Щ", "Ъ", "Ы", "Ь", "Э", "Ю", "Я":
 		if strings.ToUpper(letter) == "Ё" {
-			tagRows, err = th.db.Query(`
-                SELECT t.name, COUNT(bt.book_id) as book_count
-                FROM tags t
-                LEFT JOIN book_tags bt ON t.id = bt.tag_id
-                WHERE (SUBSTR(t.name, 1, 1) = 'Ё' OR SUBSTR(t.name, 1, 1) = 'Е')
+			tagRows, err = th.db.Query(baseQuery + `
+                  AND (SUBSTR(t.name, 1, 1) = 'Ё' OR SUBSTR(t.name, 1, 1) = 'Е')
                 GROUP BY t.name
                 ORDER BY t.name
             `)
 		} else {
-			tagRows, err = th.db.Query(`
-                SELECT t.name, COUNT(bt.book_id) as book_count
-                FROM tags t
-                LEFT JOIN book_tags bt ON t.id = bt.tag_id
-                WHERE SUBSTR(t.name, 1, 1) = ?
+			tagRows, err = th.db.Query(baseQuery+`
+                  AND SUBSTR(t.name, 1, 1) = ?
                 GROUP BY t.name
                 ORDER BY t.name
             `, letter)
 		}
 	case "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M",
 		"N", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z":
-		tagRows, err = th.db.Query(`
-            SELECT t.name, COUNT(bt.book_id) as book_count
-            FROM tags t
-            LEFT JOIN book_tags bt ON t.id = bt.tag_id
-            WHERE UPPER(SUBSTR(t.name, 1, 1)) = ?
+		tagRows, err = th.db.Query(baseQuery+`
+                  AND UPPER(SUBSTR(t.name, 1, 1)) = ?
             GROUP BY t.name
             ORDER BY t.name
         `, strings.ToUpper(letter))
 	default:
-		tagRows, err = th.db.Query(`
-            SELECT t.name, COUNT(bt.book_id) as book_count
-            FROM tags t
-            LEFT JOIN book_tags bt ON t.id = bt.tag_id
-            WHERE UPPER(SUBSTR(t.name, 1, 1)) = UPPER(?)
+		tagRows, err = th.db.Query(baseQuery+`
+                  AND UPPER(SUBSTR(t.name, 1, 1)) = UPPER(?)
             GROUP BY t.name
             ORDER BY t.name
         `, letter)
@@ -227,11 +225,13 @@ func (th *TagHandler) getTagsByLetter(letter string) ([]*TagInfo, error) {
 }
 
 // getAllTags получает все теги
-func (th *TagHandler) getAllTags() ([]*TagInfo, error) {
+func (th *TagHandler) getAllTags(policy access.Policy) ([]*TagInfo, error) {
 	tagRows, err := th.db.Query(`
-        SELECT t.name, COUNT(bt.book_id) as book_count
+        SELECT t.name, COUNT(b.id) as book_count
         FROM tags t
         LEFT JOIN book_tags bt ON t.id = bt.tag_id
+        LEFT JOIN books b ON b.id = bt.book_id ` + policy.Filter("b") + `
+        WHERE 1 = 1 ` + policy.TagFilter("t") + `
         GROUP BY t.name
         ORDER BY t.name
     `)
@@ -258,145 +258,8 @@ func (th *TagHandler) getAllTags() ([]*TagInfo, error) {
 	return tags, tagRows.Err()
 }
 
-// getBooksWithTag получает книги с определенным тегом
-func (th *TagHandler) getBooksWithTag(tagName string) (map[int]*models.Book, error) {
-	cfg := config.GetConfig()
-	query := `
-        SELECT DISTINCT b.id as book_id, b.title, b.series, b.series_number, b.published_at,
-               b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
-        FROM books b
-        JOIN book_tags bt ON b.id = bt.book_id
-        JOIN tags t ON bt.tag_id = t.id
-        WHERE t.name = ?
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
-        ORDER BY b.title
-    `
-
-	bookRows, err := th.db.Query(query, tagName)
-	if err != nil {
-		return nil, err
-	}
-	defer bookRows.Close()
-
-	booksMap := make(map[int]*models.Book)
-	var bookIDs []int
-
-	for bookRows.Next() {
-		var bookID int
-		var title, series, seriesNumber, publishedAt, isbn, year, publisher sql.NullString
-		var fileURL, fileType, fileHash sql.NullString
-
-		err := bookRows.Scan(&bookID, &title, &series, &seriesNumber, &publishedAt,
-			&isbn, &year, &publisher, &fileURL, &fileType, &fileHash)
-		if err != nil {
-			if cfg.Debug {
-				log.Printf("Ошибка сканирования строки книги: %v", err)
-			}
-			continue
-		}
-
-		annotationText := readAnnotation(fileHash.String)
-
-		book := &models.Book{
-			ID:           bookID,
-			Title:        title.String,
-			Authors:      []models.Author{},
-			Series:       series.String,
-			SeriesNumber: seriesNumber.String,
-			PublishedAt:  publishedAt.String,
-			Annotation:   annotationText,
-			ISBN:         isbn.String,
-			Year:         year.String,
-			Publisher:    publisher.String,
-			Files:        []models.BookFile{},
-			Tags:         []string{tagName},
-		}
-
-		bookFile := models.BookFile{
-			URL:      fmt.Sprintf("/opds-download/%d/%s", bookID, url.QueryEscape(title.String+"."+GetFileExtension(fileType.String))),
-			Type:     fileType.String,
-			FileHash: fileHash.String,
-		}
-		book.Files = append(book.Files, bookFile)
-
-		booksMap[bookID] = book
-		bookIDs = append(bookIDs, bookID)
-	}
-
-	// Получаем всех авторов одним запросом
-	if len(bookIDs) > 0 {
-		placeholders := th.CreatePlaceholders(len(bookIDs))
-		authorQuery := fmt.Sprintf(`
-            SELECT ba.book_id, a.full_name
-            FROM book_authors ba
-            JOIN authors a ON ba.author_id = a.id
-            WHERE ba.book_id IN (%s)
-            ORDER BY ba.book_id, a.full_name
-        `, placeholders)
-
-		authorArgs := th.ConvertToInterfaceSlice(bookIDs)
-		authorRows, err := th.db.Query(authorQuery, authorArgs...)
-		if err == nil {
-			defer authorRows.Close()
-
-			for authorRows.Next() {
-				var bookID int
-				var fullName string
-				if err := authorRows.Scan(&bookID, &fullName); err != nil {
-					continue
-				}
-
-				if book, exists := booksMap[bookID]; exists {
-					author := models.Author{
-						FullName: fullName,
-					}
-					book.Authors = append(book.Authors, author)
-				}
-			}
-		}
-	}
-
-	// Получаем все теги для книг одним запросом
-	if len(bookIDs) > 0 {
-		placeholders := th.CreatePlaceholders(len(bookIDs))
-		tagQuery := fmt.Sprintf(`
-            SELECT bt.book_id, t.name
-            FROM book_tags bt
-            JOIN tags t ON bt.tag_id = t.id
-            WHERE bt.book_id IN (%s)
-            ORDER BY bt.book_id, t.name
-        `, placeholders)
-
-		tagArgs := th.ConvertToInterfaceSlice(bookIDs)
-		tagRows, err := th.db.Query(tagQuery, tagArgs...)
-		if err == nil {
-			defer tagRows.Close()
-
-			tagMap := make(map[int][]string)
-			for tagRows.Next() {
-				var bookID int
-				var tagName string
-				if err := tagRows.Scan(&bookID, &tagName); err != nil {
-					continue
-				}
-
-				tagMap[bookID] = append(tagMap[bookID], tagName)
-			}
-
-			for bookID, tags := range tagMap {
-				if book, exists := booksMap[bookID]; exists {
-					book.Tags = tags
-				}
-			}
-		}
-	}
-
-	return booksMap, nil
-}
-
 // renderTagsFeed создает и отправляет OPDS фид с тегами
-func (th *TagHandler) renderTagsFeed(w http.ResponseWriter, title string, tags []*TagInfo) {
+func (th *TagHandler) renderTagsFeed(w http.ResponseWriter, r *http.Request, title string, tags []*TagInfo) {
 	var entries []models.Entry
 
 	for _, tagInfo := range tags {
@@ -411,7 +274,7 @@ func (th *TagHandler) renderTagsFeed(w http.ResponseWriter, title string, tags [
 		entries = append(entries, entry)
 	}
 
-	th.RenderNavigationFeed(w, title, entries)
+	th.RenderNavigationFeed(w, r, title, entries)
 }
 
 // TagInfo вспомогательная структура для информации о теге
@@ -442,9 +305,8 @@ func (th *TagHandler) GetBookTagsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Проверяем, существует ли книга
-	var exists bool
-	err = th.db.QueryRow("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)", bookID).Scan(&exists)
+	// Проверяем, существует ли книга и видна ли она пользователю
+	exists, err := access.ForRequest(r).CanSeeBook(th.db, int64(bookID))
 	if err != nil {
 		log.Printf("Database error checking book existence: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -13,10 +13,13 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/generation"
 	"turanga/models"
+	"turanga/search"
+	"turanga/works"
 )
 
 // Global variable to store root path
@@ -183,11 +186,22 @@ type OPDSResponse struct {
 	IsAcquisition bool
 }
 
-// RenderOPDSFeed рендерит OPDS фид
-func RenderOPDSFeed(w http.ResponseWriter, title, description string, entries []models.Entry, isAcquisition bool) {
+// RenderOPDSFeed рендерит OPDS фид; links — ссылки фида (страницы, фасеты)
+func RenderOPDSFeed(w http.ResponseWriter, title, description string, entries []models.Entry, isAcquisition bool, links ...models.Link) {
 	feed := models.NewFeed(title)
-	feed.Updated = time.Now().Format("2006-01-02T15:04:05+00:00")
+	feed.Updated = generation.LastModified().Format("2006-01-02T15:04:05+00:00")
 	feed.Entries = entries
+	feed.Links = links
+	for _, link := range links {
+		if link.FacetGroup != "" {
+			feed.XmlnsOpds = "http://opds-spec.org/2010/catalog"
+			feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
+			break
+		}
+	}
+	if hasPSELinks(entries) {
+		feed.XmlnsPSE = pseNamespace
+	}
 
 	contentType := "application/atom+xml;profile=opds-catalog"
 	if isAcquisition {
@@ -208,7 +222,7 @@ func RenderOPDSFeed(w http.ResponseWriter, title, description string, entries []
 func CreateNavigationEntry(title, id, content, href, rel string, thumbnailPath string) models.Entry {
 	entry := models.Entry{
 		Title:   title,
-		Updated: time.Now().Format("2006-01-02T15:04:05+00:00"),
+		Updated: generation.LastModified().Format("2006-01-02T15:04:05+00:00"),
 		ID:      id,
 		Content: models.Content{Type: "text", Text: content},
 		Links: []models.Link{{
@@ -237,7 +251,7 @@ func CreateAcquisitionEntry(book *models.Book) models.Entry {
 	}
 
 	content := FormatBookContentForOPDS(book)
-	updatedTime := time.Now().Format("2006-01-02T15:04:05+00:00")
+	updatedTime := generation.LastModified().Format("2006-01-02T15:04:05+00:00")
 
 	entry := models.Entry{
 		Title:   book.Title,
@@ -273,16 +287,30 @@ func CreateAcquisitionEntry(book *models.Book) models.Entry {
 		entry.Links = append(entry.Links, thumbnailLink)
 	}
 
-	// Добавляем ссылки на файлы для скачивания через OPDS обработчик
+	// Добавляем ссылки на файлы для скачивания через OPDS обработчик,
+	// по одной на каждый формат произведения
 	for _, file := range book.Files {
-		// Формируем правильный URL для скачивания
-		downloadURL := fmt.Sprintf("/opds-download/%d/%s", book.ID, url.QueryEscape(book.Title+"."+GetFileExtension(file.Type)))
+		downloadURL := file.URL
+		if downloadURL == "" {
+			// Формируем правильный URL для скачивания
+			downloadURL = fmt.Sprintf("/opds-download/%d/%s", book.ID, url.QueryEscape(book.Title+"."+GetFileExtension(file.Type)))
+		}
 
 		entry.Links = append(entry.Links, models.Link{
 			Href: downloadURL,
 			Type: GetMimeType(file.Type),
 			Rel:  "http://opds-spec.org/acquisition",
 		})
+
+		// PDF и DJVU можно читать постранично, не скачивая файл целиком
+		if file.PageCount > 0 {
+			entry.Links = append(entry.Links, pseLink(file.FileHash, file.PageCount))
+		}
+	}
+
+	// Ридерам без поддержки FB2 — EPUB, сконвертированный из fb2
+	if source, ok := needsConversion(book.Files); ok {
+		entry.Links = append(entry.Links, convertLink(source.FileHash, book.Title))
 	}
 
 	return entry
@@ -338,7 +366,7 @@ func SortBooksByID(booksMap map[int]*models.Book) []*models.Book {
 }
 
 // GetBooksByIDs получает книги по списку ID с авторами одним оптимизированным запросом
-func GetBooksByIDs(db *sql.DB, bookIDs []int) (map[int]*models.Book, error) {
+func GetBooksByIDs(db *sql.DB, bookIDs []int, policy access.Policy) (map[int]*models.Book, error) {
 	cfg := config.GetConfig()
 	if len(bookIDs) == 0 {
 		return make(map[int]*models.Book), nil
@@ -350,8 +378,8 @@ func GetBooksByIDs(db *sql.DB, bookIDs []int) (map[int]*models.Book, error) {
                b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
         FROM books b
         WHERE b.id IN (%s)
-          AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-          AND (b.over18 IS NULL OR b.over18 = 0)
+          AND b.file_type IN (`+opdsFormatList()+`)
+          `+policy.Filter("b")+`
         ORDER BY b.title_lower
     `, placeholders)
 
@@ -441,11 +469,14 @@ func GetBooksByIDs(db *sql.DB, bookIDs []int) (map[int]*models.Book, error) {
 		}
 	}
 
+	attachWorkFiles(db, booksMap, policy)
+	attachPageCounts(db, booksMap)
+
 	return booksMap, nil
 }
 
 // GetBooksWithAuthors получает книги с авторами по произвольному запросу
-func GetBooksWithAuthors(db *sql.DB, query string, args ...interface{}) (map[int]*models.Book, error) {
+func GetBooksWithAuthors(db *sql.DB, policy access.Policy, query string, args ...interface{}) (map[int]*models.Book, error) {
 	cfg := config.GetConfig()
 	// Получаем книги
 	bookRows, err := db.Query(query, args...)
@@ -535,11 +566,89 @@ func GetBooksWithAuthors(db *sql.DB, query string, args ...interface{}) (map[int
 		}
 	}
 
+	attachWorkFiles(db, booksMap, policy)
+	attachPageCounts(db, booksMap)
+
 	return booksMap, nil
 }
 
+// attachWorkFiles сворачивает книги одного произведения в одну запись:
+// в выдаче остаётся книга с наименьшим ID, остальные форматы добавляются к ней как файлы
+func attachWorkFiles(db *sql.DB, booksMap map[int]*models.Book, policy access.Policy) {
+	cfg := config.GetConfig()
+	if len(booksMap) == 0 {
+		return
+	}
+
+	ids := make([]int64, 0, len(booksMap))
+	for id := range booksMap {
+		ids = append(ids, int64(id))
+	}
+	workOf, err := works.Group(db, ids)
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("Ошибка получения произведений для OPDS: %v", err)
+		}
+		return
+	}
+
+	// Выбираем представителя для каждого произведения и убираем остальные книги из выдачи
+	representative := make(map[int64]int)
+	for bookID, workID := range workOf {
+		if current, ok := representative[workID]; !ok || int(bookID) < current {
+			representative[workID] = int(bookID)
+		}
+	}
+	for bookID, workID := range workOf {
+		if representative[workID] != int(bookID) {
+			delete(booksMap, int(bookID))
+		}
+	}
+	if len(representative) == 0 {
+		return
+	}
+
+	workIDs := make([]int64, 0, len(representative))
+	for workID := range representative {
+		workIDs = append(workIDs, workID)
+	}
+	placeholders, args := search.Placeholders(workIDs)
+	rows, err := db.Query(`
+        SELECT b.id, b.work_id, b.title, b.file_type, b.file_hash
+        FROM books b
+        WHERE b.work_id IN (`+placeholders+`)
+          AND b.file_type IN (`+opdsFormatList()+`)
+          `+policy.Filter("b")+`
+        ORDER BY b.work_id, b.file_type, b.id`, args...)
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("Ошибка получения файлов произведений для OPDS: %v", err)
+		}
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int
+		var workID int64
+		var title, fileType, fileHash sql.NullString
+		if err := rows.Scan(&bookID, &workID, &title, &fileType, &fileHash); err != nil {
+			continue
+		}
+		book, ok := booksMap[representative[workID]]
+		if !ok || bookID == book.ID {
+			continue
+		}
+		book.Files = append(book.Files, models.BookFile{
+			URL:      fmt.Sprintf("/opds-download/%d/%s", bookID, url.QueryEscape(title.String+"."+GetFileExtension(fileType.String))),
+			Type:     fileType.String,
+			FileHash: fileHash.String,
+		})
+	}
+}
+
 // GetBooksByLetter получает книги на определенную букву
-func GetBooksByLetter(db *sql.DB, letter string, field string) (map[int]*models.Book, error) {
+func GetBooksByLetter(db *sql.DB, letter string, field string, policy access.Policy) (map[int]*models.Book, error) {
 	var query string
 	var args []interface{}
 
@@ -557,8 +666,8 @@ func GetBooksByLetter(db *sql.DB, letter string, field string) (map[int]*models.
                        b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
                 FROM books b
                 WHERE (SUBSTR(b.title_lower, 1, 1) = 'ё' OR SUBSTR(b.title_lower, 1, 1) = 'е')
-                  AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-                  AND (b.over18 IS NULL OR b.over18 = 0)
+                  AND b.file_type IN (` + opdsFormatList() + `)
+                  ` + policy.Filter("b") + `
                 ORDER BY b.title_lower
             `
 		} else {
@@ -567,8 +676,8 @@ func GetBooksByLetter(db *sql.DB, letter string, field string) (map[int]*models.
                        b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
                 FROM books b
                 WHERE SUBSTR(b.title_lower, 1, 1) = ?
-                  AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-                  AND (b.over18 IS NULL OR b.over18 = 0)
+                  AND b.file_type IN (` + opdsFormatList() + `)
+                  ` + policy.Filter("b") + `
                 ORDER BY b.title_lower
             `
 			args = append(args, lowerLetter)
@@ -580,14 +689,14 @@ func GetBooksByLetter(db *sql.DB, letter string, field string) (map[int]*models.
                    b.isbn, b.year, b.publisher, b.file_url, b.file_type, b.file_hash
             FROM books b
             WHERE SUBSTR(b.title_lower, 1, 1) = ?
-              AND b.file_type IN ('epub', 'fb2', 'fb2.zip')
-              AND (b.over18 IS NULL OR b.over18 = 0)
+              AND b.file_type IN (` + opdsFormatList() + `)
+              ` + policy.Filter("b") + `
             ORDER BY b.title_lower
         `
 		args = append(args, lowerLetter)
 	}
 
-	return GetBooksWithAuthors(db, query, args...)
+	return GetBooksWithAuthors(db, policy, query, args...)
 }
 
 // CreateAlphabetEntries создает записи для алфавитной навигации
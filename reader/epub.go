@@ -0,0 +1,316 @@
+// reader/epub.go
+package reader
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Чтение книг в браузере. Книга fb2 сначала конвертируется в EPUB (пакет convert),
+// поэтому читалка работает только с EPUB: разбирает OPF и оглавление и отдаёт главы
+// по одной — документы из spine, очищенные от скриптов, стилей и чужой разметки (sanitize.go).
+
+// Chapter — документ книги из spine
+type Chapter struct {
+	Href  string // Путь внутри архива
+	Title string
+}
+
+// TOCEntry — пункт оглавления
+type TOCEntry struct {
+	Title    string
+	Chapter  int    // Номер главы (документа spine)
+	Fragment string // Якорь внутри главы
+	Level    int    // Уровень вложенности, с нуля
+}
+
+// manifestItem — файл книги из манифеста OPF
+type manifestItem struct {
+	href       string // Путь внутри архива
+	mediaType  string
+	properties string
+}
+
+// Book — открытая книга EPUB
+type Book struct {
+	Title    string
+	Chapters []Chapter
+	TOC      []TOCEntry
+
+	archive  *zip.ReadCloser
+	files    map[string]*zip.File
+	manifest map[string]manifestItem // По id
+	byHref   map[string]manifestItem // По пути внутри архива
+	chapters map[string]int          // Номер главы по пути внутри архива
+}
+
+// Open открывает книгу EPUB и читает её оглавление
+func Open(filePath string) (*Book, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия EPUB: %w", err)
+	}
+	b := &Book{
+		archive:  archive,
+		files:    make(map[string]*zip.File, len(archive.File)),
+		manifest: make(map[string]manifestItem),
+		byHref:   make(map[string]manifestItem),
+		chapters: make(map[string]int),
+	}
+	for _, f := range archive.File {
+		b.files[f.Name] = f
+	}
+	if err := b.readPackage(); err != nil {
+		archive.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close закрывает архив книги
+func (b *Book) Close() error {
+	return b.archive.Close()
+}
+
+// open открывает файл внутри архива
+func (b *Book) open(name string) (io.ReadCloser, error) {
+	f, ok := b.files[name]
+	if !ok {
+		return nil, fmt.Errorf("в книге нет файла %s", name)
+	}
+	return f.Open()
+}
+
+// decode разбирает XML-файл архива в v
+func (b *Book) decode(name string, v interface{}) error {
+	rc, err := b.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	decoder := xml.NewDecoder(rc)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("ошибка разбора %s: %w", name, err)
+	}
+	return nil
+}
+
+// resolve возвращает путь внутри архива для ссылки href из документа base
+func resolve(base, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if strings.HasPrefix(href, "/") {
+		return strings.TrimPrefix(path.Clean(href), "/")
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+// readPackage читает OPF: название, манифест, spine и оглавление
+func (b *Book) readPackage() error {
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := b.decode("META-INF/container.xml", &container); err != nil {
+		return err
+	}
+	if len(container.Rootfiles) == 0 {
+		return fmt.Errorf("в container.xml не указан файл OPF")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Titles   []string `xml:"metadata>title"`
+		Manifest []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine struct {
+			Toc   string `xml:"toc,attr"`
+			Items []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+	if err := b.decode(opfPath, &pkg); err != nil {
+		return err
+	}
+	if len(pkg.Titles) > 0 {
+		b.Title = strings.TrimSpace(pkg.Titles[0])
+	}
+
+	var navHref, ncxHref string
+	for _, item := range pkg.Manifest {
+		mi := manifestItem{href: resolve(opfPath, item.Href), mediaType: item.MediaType, properties: item.Properties}
+		b.manifest[item.ID] = mi
+		b.byHref[mi.href] = mi
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navHref = mi.href
+		}
+	}
+	if ncx, ok := b.manifest[pkg.Spine.Toc]; ok {
+		ncxHref = ncx.href
+	}
+
+	for _, ref := range pkg.Spine.Items {
+		item, ok := b.manifest[ref.IDRef]
+		if !ok || !isDocument(item.mediaType) {
+			continue
+		}
+		if _, dup := b.chapters[item.href]; dup {
+			continue
+		}
+		b.chapters[item.href] = len(b.Chapters)
+		b.Chapters = append(b.Chapters, Chapter{Href: item.href})
+	}
+	if len(b.Chapters) == 0 {
+		return fmt.Errorf("в книге нет глав")
+	}
+
+	// Оглавление EPUB 3, иначе toc.ncx; ошибка в оглавлении не мешает читать
+	if navHref != "" {
+		b.readNav(navHref)
+	}
+	if len(b.TOC) == 0 && ncxHref != "" {
+		b.readNCX(ncxHref)
+	}
+	for _, entry := range b.TOC {
+		if b.Chapters[entry.Chapter].Title == "" {
+			b.Chapters[entry.Chapter].Title = entry.Title
+		}
+	}
+	for i := range b.Chapters {
+		if b.Chapters[i].Title == "" {
+			b.Chapters[i].Title = fmt.Sprintf("Глава %d", i+1)
+		}
+	}
+	return nil
+}
+
+// isDocument сообщает, что файл манифеста — документ для чтения
+func isDocument(mediaType string) bool {
+	return mediaType == "application/xhtml+xml" || mediaType == "text/html"
+}
+
+// addTOCEntry добавляет пункт оглавления, если он ведёт в главу книги
+func (b *Book) addTOCEntry(base, title, href string, level int) {
+	title = strings.Join(strings.Fields(title), " ")
+	target, fragment, _ := strings.Cut(href, "#")
+	chapter, ok := b.chapters[resolve(base, target)]
+	if !ok || title == "" {
+		return
+	}
+	b.TOC = append(b.TOC, TOCEntry{Title: title, Chapter: chapter, Fragment: fragment, Level: level})
+}
+
+// navList — список оглавления nav.xhtml (ol/li/a)
+type navList struct {
+	Items []navItem `xml:"li"`
+}
+
+type navItem struct {
+	Link struct {
+		Href string `xml:"href,attr"`
+		Text string `xml:",chardata"`
+		Span string `xml:"span"`
+	} `xml:"a"`
+	Children *navList `xml:"ol"`
+}
+
+// readNav читает оглавление EPUB 3
+func (b *Book) readNav(navHref string) {
+	var doc struct {
+		Navs []struct {
+			Type string   `xml:"type,attr"`
+			List *navList `xml:"ol"`
+		} `xml:"body>nav"`
+		Sections []struct {
+			Navs []struct {
+				Type string   `xml:"type,attr"`
+				List *navList `xml:"ol"`
+			} `xml:"nav"`
+		} `xml:"body>section"`
+	}
+	if err := b.decode(navHref, &doc); err != nil {
+		return
+	}
+	navs := doc.Navs
+	for _, section := range doc.Sections {
+		navs = append(navs, section.Navs...)
+	}
+
+	var walk func(list *navList, level int)
+	walk = func(list *navList, level int) {
+		if list == nil {
+			return
+		}
+		for _, item := range list.Items {
+			b.addTOCEntry(navHref, item.Link.Text+" "+item.Link.Span, item.Link.Href, level)
+			walk(item.Children, level+1)
+		}
+	}
+	for _, nav := range navs {
+		if nav.Type == "" || strings.Contains(nav.Type, "toc") {
+			walk(nav.List, 0)
+			break
+		}
+	}
+}
+
+// ncxPoint — пункт оглавления toc.ncx
+type ncxPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []ncxPoint `xml:"navPoint"`
+}
+
+// readNCX читает оглавление EPUB 2
+func (b *Book) readNCX(ncxHref string) {
+	var doc struct {
+		Points []ncxPoint `xml:"navMap>navPoint"`
+	}
+	if err := b.decode(ncxHref, &doc); err != nil {
+		return
+	}
+	var walk func(points []ncxPoint, level int)
+	walk = func(points []ncxPoint, level int) {
+		for _, p := range points {
+			b.addTOCEntry(ncxHref, p.Label, p.Content.Src, level)
+			walk(p.Children, level+1)
+		}
+	}
+	walk(doc.Points, 0)
+}
+
+// Resource открывает картинку книги по пути внутри архива. Отдаются только картинки
+// из манифеста: остальные файлы (стили, шрифты, скрипты) читалке не нужны.
+func (b *Book) Resource(name string) (io.ReadCloser, string, error) {
+	item, ok := b.byHref[name]
+	if !ok || !strings.HasPrefix(item.mediaType, "image/") {
+		return nil, "", fmt.Errorf("в книге нет картинки %s", name)
+	}
+	rc, err := b.open(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return rc, item.mediaType, nil
+}
+
+// isImage сообщает, что путь внутри архива — картинка из манифеста
+func (b *Book) isImage(name string) bool {
+	item, ok := b.byHref[name]
+	return ok && strings.HasPrefix(item.mediaType, "image/")
+}
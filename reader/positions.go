@@ -0,0 +1,45 @@
+// reader/positions.go
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Position — место, на котором пользователь остановился в читалке
+type Position struct {
+	Chapter   int     // Номер главы, с нуля
+	Offset    float64 // Доля прокрутки главы, от 0 до 1
+	UpdatedAt time.Time
+}
+
+// SavePosition запоминает место чтения книги пользователем
+func SavePosition(db *sql.DB, userID, bookID int64, p Position) error {
+	_, err := db.Exec(`
+        INSERT OR REPLACE INTO reader_positions (user_id, book_id, chapter, scroll, updated_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		userID, bookID, p.Chapter, p.Offset, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения места чтения книги %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// GetPosition возвращает место чтения книги пользователем или nil, если он её не открывал
+func GetPosition(db *sql.DB, userID, bookID int64) (*Position, error) {
+	var p Position
+	var updatedAt int64
+	err := db.QueryRow(`
+        SELECT chapter, scroll, updated_at
+        FROM reader_positions
+        WHERE user_id = ? AND book_id = ?`, userID, bookID).Scan(&p.Chapter, &p.Offset, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения места чтения книги %d: %w", bookID, err)
+	}
+	p.UpdatedAt = time.Unix(updatedAt, 0)
+	return &p, nil
+}
@@ -0,0 +1,328 @@
+// reader/sanitize.go
+package reader
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Глава выводится прямо в страницу читалки, поэтому от разметки книги остаются
+// только безопасные элементы и атрибуты: скрипты, стили, формы и обработчики событий
+// отбрасываются, ссылки и картинки переписываются на адреса читалки. Идентификаторы
+// получают префикс, чтобы не совпасть с идентификаторами самой страницы.
+
+// idPrefix — префикс идентификаторов из книги
+const idPrefix = "r-"
+
+// URLs строит адреса читалки для ссылок из книги
+type URLs struct {
+	Resource func(name string) string                // Картинка по пути внутри архива
+	Chapter  func(chapter int, anchor string) string // Глава и якорь на странице (Anchor)
+}
+
+// Anchor возвращает идентификатор на странице читалки для якоря из книги
+func Anchor(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+	return idPrefix + fragment
+}
+
+// allowedElements — элементы, которые выводятся как есть; остальные разворачиваются (остаётся содержимое)
+var allowedElements = map[string]bool{
+	"p": true, "div": true, "span": true, "br": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"em": true, "i": true, "strong": true, "b": true, "u": true, "s": true, "del": true, "ins": true,
+	"sub": true, "sup": true, "small": true, "mark": true, "abbr": true, "q": true, "cite": true,
+	"blockquote": true, "pre": true, "code": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "th": true, "td": true,
+	"caption": true, "colgroup": true, "col": true,
+	"figure": true, "figcaption": true, "section": true, "article": true, "aside": true,
+	"header": true, "footer": true, "ruby": true, "rt": true, "rp": true,
+	"a": true, "img": true,
+}
+
+// droppedElements — элементы, которые отбрасываются вместе с содержимым
+var droppedElements = map[string]bool{
+	"head": true, "title": true, "script": true, "style": true, "noscript": true, "template": true,
+	"iframe": true, "object": true, "embed": true, "applet": true, "canvas": true,
+	"audio": true, "video": true, "form": true, "input": true, "button": true,
+	"select": true, "textarea": true, "link": true, "meta": true, "base": true,
+}
+
+// voidElements — элементы без закрывающего тега
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true, "col": true}
+
+// elementAttrs — атрибуты, разрешённые отдельным элементам (кроме id, title, lang, dir)
+var elementAttrs = map[string][]string{
+	"td":  {"colspan", "rowspan"},
+	"th":  {"colspan", "rowspan"},
+	"ol":  {"start"},
+	"col": {"span"},
+}
+
+// RenderChapter возвращает очищенный HTML главы n
+func (b *Book) RenderChapter(n int, urls URLs) (string, error) {
+	if n < 0 || n >= len(b.Chapters) {
+		return "", fmt.Errorf("в книге нет главы %d", n+1)
+	}
+	rc, err := b.open(b.Chapters[n].Href)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	s := &sanitizer{book: b, chapter: n, urls: urls}
+	if err := s.run(rc); err != nil {
+		return "", fmt.Errorf("ошибка разбора главы %s: %w", b.Chapters[n].Href, err)
+	}
+	return s.out.String(), nil
+}
+
+// sanitizer переписывает документ главы в безопасный HTML
+type sanitizer struct {
+	book    *Book
+	chapter int
+	urls    URLs
+	out     strings.Builder
+
+	stack  []openElement // Открытые элементы документа
+	skip   int           // Глубина внутри отбрасываемого элемента
+	svg    int           // Глубина внутри svg: из него берутся только картинки
+	inBody bool
+}
+
+// openElement — открытый элемент документа и выведенный для него тег ("" — развёрнут или отброшен)
+type openElement struct {
+	name string
+	tag  string
+}
+
+// autoCloseElements — элементы HTML без закрывающего тега, они закрываются сразу после открытия
+var autoCloseElements = func() map[string]bool {
+	m := make(map[string]bool, len(xml.HTMLAutoClose))
+	for _, name := range xml.HTMLAutoClose {
+		m[name] = true
+	}
+	return m
+}()
+
+// run разбирает документ без проверки парности тегов: книги нередко содержат
+// перепутанные или лишние закрывающие теги, и из-за одного такого тега глава
+// не должна пропадать. Закрывающий тег закрывает ближайший открытый элемент
+// с тем же именем вместе со всеми вложенными, тег без пары пропускается.
+func (s *sanitizer) run(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			// Незакрытые элементы закрываем в конце документа
+			for len(s.stack) > 0 {
+				s.pop()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			s.start(t)
+			if autoCloseElements[strings.ToLower(t.Name.Local)] {
+				s.pop()
+			}
+		case xml.EndElement:
+			s.end(t)
+		case xml.CharData:
+			if s.inBody && s.skip == 0 && s.svg == 0 {
+				s.out.WriteString(html.EscapeString(string(t)))
+			}
+		}
+	}
+}
+
+func (s *sanitizer) start(t xml.StartElement) {
+	name := strings.ToLower(t.Name.Local)
+	switch {
+	case name == "body":
+		s.inBody = true
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	case s.skip > 0 || droppedElements[name]:
+		s.skip++
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	case name == "svg" || s.svg > 0:
+		// Обложки часто вставлены как svg с картинкой внутри
+		s.svg++
+		if name == "image" {
+			if src := s.imageURL(attr(t, "href")); src != "" && s.inBody {
+				s.out.WriteString(`<img src="` + html.EscapeString(src) + `" alt="">`)
+			}
+		}
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	case !s.inBody:
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	}
+
+	tag := name
+	if name == "center" {
+		tag = "div"
+	}
+	if !allowedElements[tag] {
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	}
+
+	attrs := s.attributes(tag, t)
+	if tag == "img" && attrs == nil {
+		// Картинка не из книги — не выводим
+		s.stack = append(s.stack, openElement{name: name})
+		return
+	}
+	s.out.WriteString("<" + tag)
+	for _, a := range attrs {
+		s.out.WriteString(" " + a[0] + `="` + html.EscapeString(a[1]) + `"`)
+	}
+	s.out.WriteString(">")
+	if voidElements[tag] {
+		tag = ""
+	}
+	s.stack = append(s.stack, openElement{name: name, tag: tag})
+}
+
+func (s *sanitizer) end(t xml.EndElement) {
+	name := strings.ToLower(t.Name.Local)
+	i := len(s.stack) - 1
+	for i >= 0 && s.stack[i].name != name {
+		i--
+	}
+	if i < 0 {
+		// Закрывающий тег без пары, в том числе у элемента без закрывающего тега
+		return
+	}
+	for len(s.stack) > i {
+		s.pop()
+	}
+}
+
+// pop закрывает последний открытый элемент
+func (s *sanitizer) pop() {
+	el := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+
+	switch {
+	case el.name == "body":
+		s.inBody = false
+	case s.skip > 0:
+		s.skip--
+	case s.svg > 0:
+		s.svg--
+	case el.tag != "":
+		s.out.WriteString("</" + el.tag + ">")
+	}
+}
+
+// attributes возвращает разрешённые атрибуты элемента с переписанными адресами.
+// Для картинки без допустимого src возвращает nil.
+func (s *sanitizer) attributes(tag string, t xml.StartElement) [][2]string {
+	var attrs [][2]string
+	if id := attr(t, "id"); id != "" {
+		attrs = append(attrs, [2]string{"id", Anchor(id)})
+	}
+	for _, name := range []string{"title", "lang", "dir"} {
+		if v := attr(t, name); v != "" {
+			attrs = append(attrs, [2]string{name, v})
+		}
+	}
+	for _, name := range elementAttrs[tag] {
+		if v := attr(t, name); v != "" {
+			attrs = append(attrs, [2]string{name, v})
+		}
+	}
+
+	switch tag {
+	case "a":
+		href, external := s.linkURL(attr(t, "href"))
+		if href != "" {
+			attrs = append(attrs, [2]string{"href", href})
+		}
+		if external {
+			attrs = append(attrs, [2]string{"target", "_blank"}, [2]string{"rel", "noopener noreferrer"})
+		}
+	case "img":
+		src := s.imageURL(attr(t, "src"))
+		if src == "" {
+			return nil
+		}
+		attrs = append(attrs, [2]string{"src", src}, [2]string{"alt", attr(t, "alt")})
+	}
+	return attrs
+}
+
+// linkURL переписывает ссылку из книги; external — ссылка ведёт за пределы книги
+func (s *sanitizer) linkURL(href string) (string, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return "", false
+	}
+	if strings.HasPrefix(href, "#") {
+		return "#" + Anchor(href[1:]), false
+	}
+	lower := strings.ToLower(href)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:") {
+		return href, true
+	}
+	if scheme, _, ok := strings.Cut(lower, ":"); ok && !strings.Contains(scheme, "/") {
+		// javascript: и прочие схемы
+		return "", false
+	}
+
+	target, fragment, _ := strings.Cut(href, "#")
+	name := resolve(s.book.Chapters[s.chapter].Href, target)
+	if chapter, ok := s.book.chapters[name]; ok {
+		if chapter == s.chapter {
+			return "#" + Anchor(fragment), false
+		}
+		return s.urls.Chapter(chapter, Anchor(fragment)), false
+	}
+	if s.book.isImage(name) {
+		return s.urls.Resource(name), false
+	}
+	return "", false
+}
+
+// imageURL возвращает адрес картинки из книги или пустую строку для недопустимой
+func (s *sanitizer) imageURL(src string) string {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(strings.ToLower(src), "data:image/") {
+		return src
+	}
+	if src == "" || strings.Contains(src, ":") {
+		return ""
+	}
+	name := resolve(s.book.Chapters[s.chapter].Href, src)
+	if !s.book.isImage(name) {
+		return ""
+	}
+	return s.urls.Resource(name)
+}
+
+// attr возвращает значение атрибута по локальному имени (xlink:href — просто href)
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
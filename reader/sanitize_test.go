@@ -0,0 +1,81 @@
+// reader/sanitize_test.go
+package reader
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testBook возвращает книгу из двух глав с одной картинкой, без архива
+func testBook() *Book {
+	return &Book{
+		Chapters: []Chapter{{Href: "OEBPS/ch1.xhtml"}, {Href: "OEBPS/ch2.xhtml"}},
+		byHref: map[string]manifestItem{
+			"OEBPS/img/c.jpg": {href: "OEBPS/img/c.jpg", mediaType: "image/jpeg"},
+		},
+		chapters: map[string]int{"OEBPS/ch1.xhtml": 0, "OEBPS/ch2.xhtml": 1},
+	}
+}
+
+// sanitize очищает тело первой главы так же, как RenderChapter
+func sanitize(body string) (string, error) {
+	s := &sanitizer{book: testBook(), urls: URLs{
+		Resource: func(name string) string { return "/res/" + name },
+		Chapter:  func(chapter int, anchor string) string { return fmt.Sprintf("/ch/%d#%s", chapter, anchor) },
+	}}
+	err := s.run(strings.NewReader("<html><head><title>t</title></head><body>" + body + "</body></html>"))
+	return s.out.String(), err
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"script", `<p>a<script>alert(1)</script>b</p>`, `<p>ab</p>`, false},
+		{"unterminated script", `<p>a</p><script>alert(1)`, `<p>a</p>`, false},
+		{"event handlers", `<p onclick="x()" ONMOUSEOVER="y" style="color:red">t</p>`, `<p>t</p>`, false},
+		{"img onerror", `<p><img src="img/c.jpg" alt="c" onerror="x()"/></p>`, `<p><img src="/res/OEBPS/img/c.jpg" alt="c"></p>`, false},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`, false},
+		{"javascript href mixed case", `<a href=" JaVaScRiPt:alert(1)">x</a>`, `<a>x</a>`, false},
+		{"data text href", `<a href="data:text/html,&lt;script&gt;alert(1)&lt;/script&gt;">x</a>`, `<a>x</a>`, false},
+		{"data text img", `<p><img src="data:text/html;base64,PHNjcmlwdD4="/>t</p>`, `<p>t</p>`, false},
+		{"svg script", `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><script>alert(1)</script><image xlink:href="img/c.jpg"/></svg>`, `<img src="/res/OEBPS/img/c.jpg" alt="">`, false},
+		{"svg foreign object", `<svg><foreignObject><p onclick="x()">y</p></foreignObject></svg><p>z</p>`, `<p>z</p>`, false},
+		{"remote img", `<p><img src="http://evil.example/x.png"/>t</p>`, `<p>t</p>`, false},
+		{"img outside book", `<p><img src="../../etc/passwd"/>t</p>`, `<p>t</p>`, false},
+		{"embedded content", `<iframe src="http://evil.example"></iframe><object data="x"><p>z</p></object><form><input name="q"/></form><p>k</p>`, `<p>k</p>`, false},
+		{"unknown element unwrapped", `<foo bar="1">t</foo>`, `t`, false},
+		{"attribute escaped", `<p title='"&gt;&lt;script&gt;'>t</p>`, `<p title="&#34;&gt;&lt;script&gt;">t</p>`, false},
+		{"text escaped", `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`, `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`, false},
+		{"unclosed tags", `<p><b>x</p><p>y`, `<p><b>x</b></p><p>y</p>`, false},
+		{"auto-closed void", `<p>a<br>b<hr></p>`, `<p>a<br>b<hr></p>`, false},
+		{"misnested tags", `<div><i>a</div></i><p>b</p>`, `<div><i>a</i></div><p>b</p>`, false},
+		{"stray end tag", `<p>a</b>c</p></span><p>d</p>`, `<p>ac</p><p>d</p>`, false},
+		{"unterminated comment rejected", `<p>a<!-- x`, ``, true},
+		{"self-closed void", `<p>a<br/>b<img src="img/c.jpg"></img></p>`, `<p>a<br>b<img src="/res/OEBPS/img/c.jpg" alt=""></p>`, false},
+		{"links rewritten", `<a href="#n1">n</a><p id="n1">x</p><a href="ch2.xhtml#f">f</a>`, `<a href="#r-n1">n</a><p id="r-n1">x</p><a href="/ch/1#r-f">f</a>`, false},
+		{"external link", `<a href="https://example.org/">e</a>`, `<a href="https://example.org/" target="_blank" rel="noopener noreferrer">e</a>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitize(tt.in)
+			if tt.wantErr {
+				// RenderChapter не выводит главу, которую не удалось разобрать
+				if err == nil {
+					t.Fatalf("ожидалась ошибка разбора, получено %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ошибка: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("получено %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
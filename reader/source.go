@@ -0,0 +1,46 @@
+// reader/source.go
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"turanga/convert"
+	"turanga/works"
+)
+
+// Formats — форматы файлов, которые можно читать в браузере
+var Formats = []string{"epub", "fb2", "fb2.zip"}
+
+// OpenFile открывает файл произведения для чтения: EPUB как есть, fb2/fb2.zip —
+// через EPUB из каталога converted (тот же, что отдаётся при скачивании)
+func OpenFile(db *sql.DB, rootPath string, f works.File) (*Book, error) {
+	var filePath string
+	if err := db.QueryRow("SELECT file_url FROM books WHERE id = ?", f.BookID).Scan(&filePath); err != nil {
+		return nil, fmt.Errorf("ошибка получения файла книги %d: %w", f.BookID, err)
+	}
+	if convert.CanConvert(f.FileType) {
+		var err error
+		filePath, err = convert.CachedEPUB(filepath.Join(rootPath, "converted"), filePath, f.FileType, f.FileHash)
+		if err != nil {
+			return nil, err
+		}
+	} else if f.FileType != "epub" {
+		return nil, fmt.Errorf("формат %s нельзя читать в браузере", f.FileType)
+	}
+	return Open(filePath)
+}
+
+// PickFile выбирает файл для чтения: EPUB произведения, иначе fb2. Файлы книги
+// (works.Files) идут первыми, поэтому при равном формате выбирается сама книга.
+func PickFile(files []works.File) (works.File, bool) {
+	for _, format := range Formats {
+		for _, f := range files {
+			if f.FileType == format {
+				return f, true
+			}
+		}
+	}
+	return works.File{}, false
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"turanga/config"
+	"turanga/series"
 )
 
 // sanitizeFilename очищает строку от недопустимых символов для имен файлов
@@ -304,6 +305,15 @@ func CleanupMissingFiles() error {
 		if err != nil {
 			return fmt.Errorf("ошибка удаления книг из БД: %w", err)
 		}
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM book_genres WHERE book_id IN (%s)", placeholderStr), args...)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления жанров книг: %w", err)
+		}
+		// История изменений книг, файлов которых больше нет, не нужна
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM edit_history WHERE book_id IN (%s)", placeholderStr), args...)
+		if err != nil {
+			return fmt.Errorf("ошибка удаления истории изменений: %w", err)
+		}
 		deletedBooks = len(booksToDelete)
 
 		// Фиксируем транзакцию
@@ -359,8 +369,11 @@ func CleanupMissingAnnotations() error {
 		log.Println("Начинаю проверку файлов аннотаций...")
 	}
 
-	// Получаем все хеши файлов из БД
-	rows, err := db.Query("SELECT file_hash FROM books WHERE file_hash IS NOT NULL AND file_hash != ''")
+	// Получаем все хеши файлов из БД, включая книги в корзине: их аннотации нужны для восстановления
+	rows, err := db.Query(`
+        SELECT file_hash FROM books WHERE file_hash IS NOT NULL AND file_hash != ''
+        UNION
+        SELECT file_hash FROM trash WHERE file_hash IS NOT NULL AND file_hash != ''`)
 	if err != nil {
 		return fmt.Errorf("ошибка получения хешей из БД: %w", err)
 	}
@@ -494,8 +507,13 @@ func CleanupOrphanedData() error {
 		fmt.Printf("Удалено неиспользуемых тегов: %d\n", deletedTags)
 	}
 
-	// Примечание: серии не очищаются, так как они хранятся в таблице books
-	// и автоматически становятся "непривязанными" при удалении книг.
+	// Удаляем серии без книг, псевдонимов и ожидаемого числа томов
+	deletedSeries, err := series.Cleanup(db)
+	if err != nil {
+		fmt.Printf("Ошибка очистки серий: %v\n", err)
+	} else {
+		fmt.Printf("Удалено неиспользуемых серий: %d\n", deletedSeries)
+	}
 
 	fmt.Println("Очистка неиспользуемых данных завершена.")
 	return nil
@@ -567,7 +585,9 @@ func cleanupOrphanedAuthors() (int64, error) {
 
 // cleanupOrphanedTags удаляет теги, которые не связаны ни с одной книгой
 func cleanupOrphanedTags() (int64, error) {
-	// Удаляем теги, у которых нет связей в таблице book_tags
+	// Удаляем теги, у которых нет связей в таблице book_tags.
+	// Закрытые теги и теги с настройкой доступа через Nostr сохраняются,
+	// чтобы настройки не терялись, пока у тега нет книг
 	result, err := db.Exec(`
         DELETE FROM tags 
         WHERE id NOT IN (
@@ -575,6 +595,7 @@ func cleanupOrphanedTags() (int64, error) {
             FROM book_tags 
             WHERE tag_id IS NOT NULL
         )
+        AND restricted = 0 AND shared = 'shared'
     `)
 	if err != nil {
 		return 0, fmt.Errorf("ошибка удаления неиспользуемых тегов: %w", err)
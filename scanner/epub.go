@@ -9,6 +9,8 @@ import (
 	"io"
 	"strconv"
 	"strings"
+
+	"turanga/language"
 )
 
 // EPUBContainer структура для парсинга container.xml
@@ -40,6 +42,7 @@ type EPUBPackage struct {
 		Publisher []struct {
 			Text string `xml:",chardata"`
 		} `xml:"publisher"`
+		Language []string `xml:"language"`
 		// Для извлечения серии и номера серии из Dublin Core или кастомных мета-тегов
 		// Используем более гибкий подход с Meta
 		Meta []struct {
@@ -58,11 +61,11 @@ type EPUBPackage struct {
 }
 
 // ExtractEPUBMetadata извлекает метаданные из EPUB файла
-// Обновлена сигнатура для возврата seriesNumber
-func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber string, err error) {
+// Обновлена сигнатура для возврата seriesNumber, языка книги и языка оригинала
+func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang string, err error) {
 	reader, err := zip.OpenReader(filePath)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 	defer reader.Close()
 
@@ -77,7 +80,7 @@ func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year
 	}
 
 	if containerPath == "" {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("container.xml не найден")
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("container.xml не найден")
 	}
 
 	// Читаем container.xml
@@ -90,28 +93,28 @@ func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year
 	}
 
 	if containerFile == nil {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("container.xml не найден по пути: %s", containerPath)
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("container.xml не найден по пути: %s", containerPath)
 	}
 
 	rc, err := containerFile.Open()
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 	defer rc.Close()
 
 	containerData, err := io.ReadAll(rc)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 
 	var container EPUBContainer
 	err = xml.Unmarshal(containerData, &container)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("container.xml parse error: %v", err)
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("container.xml parse error: %v", err)
 	}
 
 	if len(container.Rootfiles) == 0 {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("rootfile не найден в container.xml")
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("rootfile не найден в container.xml")
 	}
 
 	// Ищем OPF файл
@@ -135,26 +138,26 @@ func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year
 			}
 		}
 		if opfFile == nil {
-			return "", "", "", "", "", "", "", "", fmt.Errorf("OPF файл не найден по пути: %s", opfPath)
+			return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("OPF файл не найден по пути: %s", opfPath)
 		}
 	}
 
 	// Читаем OPF файл
 	rc, err = opfFile.Open()
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 	defer rc.Close()
 
 	opfData, err := io.ReadAll(rc)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 
 	var pkg EPUBPackage
 	err = xml.Unmarshal(opfData, &pkg)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("OPF parse error: %v", err)
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("OPF parse error: %v", err)
 	}
 
 	// Извлекаем название
@@ -341,9 +344,22 @@ func ExtractEPUBMetadata(filePath string) (author, title, annotation, isbn, year
 		// Но для простоты оставим как есть, так как в БД оно хранится как TEXT
 	}
 
+	// Язык: первый распознанный dc:language. Языка оригинала в OPF нет, кроме EPUB,
+	// сконвертированных из FB2 (meta FB2.title-info.src-lang)
+	for _, l := range pkg.Metadata.Language {
+		if lang = language.Normalize(l); lang != "" {
+			break
+		}
+	}
+	for _, meta := range pkg.Metadata.Meta {
+		if strings.EqualFold(meta.Name, "FB2.title-info.src-lang") {
+			srcLang = language.Normalize(meta.Content)
+		}
+	}
+
 	if title == "" {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
 	}
 
-	return author, title, annotation, isbn, year, publisher, series, seriesNumber, nil
+	return author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang, nil
 }
@@ -16,6 +16,7 @@ import (
 	"unicode/utf8"
 
 	"turanga/config"
+	"turanga/language"
 
 	"golang.org/x/text/encoding/charmap"
 )
@@ -42,7 +43,8 @@ type FB2Description struct {
 				Name   string `xml:"name,attr"`
 				Number string `xml:"number,attr"`
 			} `xml:"sequence"`
-			Lang string `xml:"lang"`
+			Lang    string `xml:"lang"`
+			SrcLang string `xml:"src-lang"` // Язык оригинала для переводов
 		} `xml:"title-info"`
 		PublishInfo struct {
 			BookName  string `xml:"book-name"`
@@ -110,13 +112,13 @@ func needsRecoding(content []byte) bool {
 }
 
 // ExtractFB2Metadata извлекает метаданные из FB2 файла с правильной обработкой кодировки
-func ExtractFB2Metadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber string, err error) {
+func ExtractFB2Metadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang string, err error) {
 	cfg := config.GetConfig()
 
 	// Читаем файл как байты
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("не удалось прочитать файл: %w", err)
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("не удалось прочитать файл: %w", err)
 	}
 
 	var xmlContent string
@@ -211,7 +213,7 @@ func ExtractFB2Metadata(filePath string) (author, title, annotation, isbn, year,
 }
 
 // processFB2Metadata обрабатывает извлеченные метаданные
-func processFB2Metadata(fb2 FB2Description) (author, title, annotation, isbn, year, publisher, series, seriesNumber string, err error) {
+func processFB2Metadata(fb2 FB2Description) (author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang string, err error) {
 	// Декодируем HTML-сущности в извлеченных данных
 	title = html.UnescapeString(strings.TrimSpace(fb2.Description.TitleInfo.BookTitle))
 
@@ -276,16 +278,20 @@ func processFB2Metadata(fb2 FB2Description) (author, title, annotation, isbn, ye
 		seriesNumber = html.UnescapeString(strings.TrimSpace(fb2.Description.TitleInfo.Sequence[0].Number))
 	}
 
+	// Язык книги и язык оригинала
+	lang = language.Normalize(fb2.Description.TitleInfo.Lang)
+	srcLang = language.Normalize(fb2.Description.TitleInfo.SrcLang)
+
 	// Проверка результатов
 	if title == "" {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
 	}
 
-	return author, title, annotation, isbn, year, publisher, series, seriesNumber, nil
+	return author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang, nil
 }
 
 // extractFB2MetadataFlexible - гибкий парсинг FB2 метаданных с регулярными выражениями
-func extractFB2MetadataFlexible(xmlContent string) (author, title, annotation, isbn, year, publisher, series, seriesNumber string, err error) {
+func extractFB2MetadataFlexible(xmlContent string) (author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang string, err error) {
 	cfg := config.GetConfig()
 
 	// Извлекаем название книги
@@ -407,9 +413,20 @@ func extractFB2MetadataFlexible(xmlContent string) (author, title, annotation, i
 		}
 	}
 
+	// Язык книги и язык оригинала указываются только в title-info
+	titleInfoRegex := regexp.MustCompile(`(?s)<title-info[^>]*>(.*?)</title-info>`)
+	if titleInfoMatches := titleInfoRegex.FindStringSubmatch(xmlContent); len(titleInfoMatches) > 1 {
+		if m := regexp.MustCompile(`<lang[^>]*>(.*?)</lang>`).FindStringSubmatch(titleInfoMatches[1]); len(m) > 1 {
+			lang = language.Normalize(html.UnescapeString(m[1]))
+		}
+		if m := regexp.MustCompile(`<src-lang[^>]*>(.*?)</src-lang>`).FindStringSubmatch(titleInfoMatches[1]); len(m) > 1 {
+			srcLang = language.Normalize(html.UnescapeString(m[1]))
+		}
+	}
+
 	// Проверка результатов
 	if title == "" {
-		return "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
+		return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("пустое название")
 	}
 
 	if annotation != "" {
@@ -419,18 +436,18 @@ func extractFB2MetadataFlexible(xmlContent string) (author, title, annotation, i
 	}
 
 	if cfg.Debug {
-		log.Printf("Гибкий парсинг FB2 метаданных: title='%s', author='%s', series='%s', number='%s'",
-			title, author, series, seriesNumber)
+		log.Printf("Гибкий парсинг FB2 метаданных: title='%s', author='%s', series='%s', number='%s', lang='%s'",
+			title, author, series, seriesNumber, lang)
 	}
 
-	return author, title, annotation, isbn, year, publisher, series, seriesNumber, nil
+	return author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang, nil
 }
 
 // ExtractFB2ZipMetadata извлекает метаданные из FB2.ZIP файла
-func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber string, err error) {
+func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang string, err error) {
 	reader, err := zip.OpenReader(filePath)
 	if err != nil {
-		return "", "", "", "", "", "", "", "", err
+		return "", "", "", "", "", "", "", "", "", "", err
 	}
 	defer reader.Close()
 
@@ -439,14 +456,14 @@ func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, ye
 		if strings.HasSuffix(strings.ToLower(file.Name), ".fb2") {
 			rc, err := file.Open()
 			if err != nil {
-				return "", "", "", "", "", "", "", "", err
+				return "", "", "", "", "", "", "", "", "", "", err
 			}
 
 			// Создаем временный файл
 			tempDir, err := os.MkdirTemp("", "fb2zip")
 			if err != nil {
 				rc.Close()
-				return "", "", "", "", "", "", "", "", err
+				return "", "", "", "", "", "", "", "", "", "", err
 			}
 			defer os.RemoveAll(tempDir)
 
@@ -454,7 +471,7 @@ func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, ye
 			tempFile, err := os.Create(tempPath)
 			if err != nil {
 				rc.Close()
-				return "", "", "", "", "", "", "", "", err
+				return "", "", "", "", "", "", "", "", "", "", err
 			}
 
 			_, err = io.Copy(tempFile, rc)
@@ -462,7 +479,7 @@ func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, ye
 			rc.Close()
 
 			if err != nil {
-				return "", "", "", "", "", "", "", "", err
+				return "", "", "", "", "", "", "", "", "", "", err
 			}
 
 			// Обрабатываем временный файл
@@ -470,7 +487,7 @@ func ExtractFB2ZipMetadata(filePath string) (author, title, annotation, isbn, ye
 		}
 	}
 
-	return "", "", "", "", "", "", "", "", fmt.Errorf("fb2 файл не найден в архиве")
+	return "", "", "", "", "", "", "", "", "", "", fmt.Errorf("fb2 файл не найден в архиве")
 }
 
 // Простая функция для очистки HTML тегов
@@ -318,8 +318,8 @@ func GenerateMissingAnnotations() error {
 
 		// Извлекаем метаданные, включая аннотацию, используя существующую логику
 		// extractMetadata возвращает много полей, но нам нужна только аннотация (и fileHash для saveAnnotationToFile)
-		// Поля: fileType, author, title, annotation, isbn, year, publisher, series, series_number, err
-		_, _, _, annotation, _, _, _, _, _, err := extractMetadata(filePath, fileInfo)
+		// Поля: fileType, author, title, annotation, isbn, year, publisher, series, series_number, lang, srcLang, err
+		_, _, _, annotation, _, _, _, _, _, _, _, err := extractMetadata(filePath, fileInfo)
 		if err != nil {
 			if cfg.Debug {
 				log.Printf("Ошибка извлечения метаданных (включая аннотацию) для книги ID %d (файл: %s): %v", bookID, filePath, err)
@@ -380,6 +380,62 @@ func GenerateMissingAnnotations() error {
 	return nil
 }
 
+// FillMissingLanguages извлекает язык и язык оригинала для книг, добавленных до появления
+// этих полей (language IS NULL). У книг без языка в файле и у pdf/djvu остаётся пустая
+// строка, чтобы следующая ревизия не открывала их файлы снова.
+func FillMissingLanguages() error {
+	cfg := config.GetConfig()
+	if db == nil {
+		return fmt.Errorf("база данных не инициализирована")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, file_url
+		FROM books
+		WHERE language IS NULL AND file_url IS NOT NULL AND file_url != ''
+	`)
+	if err != nil {
+		return fmt.Errorf("ошибка получения книг без языка: %w", err)
+	}
+	type book struct {
+		id      int
+		fileURL string
+	}
+	var books []book
+	for rows.Next() {
+		var b book
+		if err := rows.Scan(&b.id, &b.fileURL); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка чтения книги без языка: %w", err)
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка итерации по книгам без языка: %w", err)
+	}
+
+	filledCount := 0
+	for _, b := range books {
+		var lang, srcLang string
+		if info, statErr := os.Stat(b.fileURL); statErr == nil {
+			// Ошибку разбора не считаем ошибкой ревизии: язык просто останется неизвестным
+			_, _, _, _, _, _, _, _, _, lang, srcLang, _ = extractMetadata(b.fileURL, info)
+		} else if cfg.Debug {
+			log.Printf("Файл книги ID %d не найден, язык не определён: %s", b.id, b.fileURL)
+		}
+		if _, err := db.Exec("UPDATE books SET language = ?, source_language = ? WHERE id = ?", lang, srcLang, b.id); err != nil {
+			return fmt.Errorf("ошибка сохранения языка книги ID %d: %w", b.id, err)
+		}
+		if lang != "" {
+			filledCount++
+		}
+	}
+
+	log.Printf("Определение языка книг завершено: обработано %d, язык найден у %d", len(books), filledCount)
+	return nil
+}
+
 // RenameBooksAccordingToConfig переименовывает книги согласно настройкам конфигурации
 func RenameBooksAccordingToConfig() error {
 	cfg := config.GetConfig()
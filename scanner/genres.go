@@ -0,0 +1,217 @@
+// scanner/genres.go
+package scanner
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"turanga/config"
+	"turanga/genres"
+)
+
+// fb2GenreHeadSize — сколько байт от начала fb2 читается в поисках жанров:
+// description стоит в начале файла, картинки в конце читать незачем
+const fb2GenreHeadSize = 512 << 10
+
+var (
+	fb2TitleInfoRe = regexp.MustCompile(`(?s)<title-info[^>]*>(.*?)</title-info>`)
+	fb2GenreRe     = regexp.MustCompile(`<genre(?:\s[^>]*)?>\s*([^<\s]+)\s*</genre>`)
+)
+
+// ExtractGenres возвращает коды жанров книги по классификатору FB2. Для fb2 берутся
+// коды из title-info, для epub — dc:subject, сопоставленные с жанрами. У pdf и djvu
+// жанров нет. Жанры не из классификатора пропускаются.
+func ExtractGenres(filePath, fileType string) ([]string, error) {
+	switch fileType {
+	case "fb2":
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return fb2Genres(f)
+	case "fb2.zip":
+		archive, err := zip.OpenReader(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			if strings.HasSuffix(strings.ToLower(file.Name), ".fb2") {
+				rc, err := file.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return fb2Genres(rc)
+			}
+		}
+		return nil, fmt.Errorf("в архиве нет файла fb2")
+	case "epub":
+		return epubGenres(filePath)
+	}
+	return nil, nil
+}
+
+// fb2Genres читает коды жанров из начала файла fb2. Коды записаны латиницей,
+// поэтому кодировку файла можно не учитывать.
+func fb2Genres(r io.Reader) ([]string, error) {
+	head, err := io.ReadAll(io.LimitReader(r, fb2GenreHeadSize))
+	if err != nil {
+		return nil, err
+	}
+	// Жанры src-title-info относятся к оригиналу, берём только title-info
+	if m := fb2TitleInfoRe.FindSubmatch(head); m != nil {
+		head = m[1]
+	}
+	var codes []string
+	for _, m := range fb2GenreRe.FindAllSubmatch(head, -1) {
+		codes = appendGenre(codes, genres.Normalize(string(m[1])))
+	}
+	return codes, nil
+}
+
+// epubGenres сопоставляет с жанрами dc:subject из OPF книги EPUB
+func epubGenres(filePath string) ([]string, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	decode := func(f *zip.File, v interface{}) error {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		decoder := xml.NewDecoder(rc)
+		decoder.Strict = false
+		return decoder.Decode(v)
+	}
+
+	// OPF по container.xml, а если его нет — первый .opf в архиве
+	var opfFile *zip.File
+	var container EPUBContainer
+	if f, ok := files["META-INF/container.xml"]; ok && decode(f, &container) == nil && len(container.Rootfiles) > 0 {
+		opfFile = files[path.Clean(container.Rootfiles[0].Path)]
+	}
+	if opfFile == nil {
+		for _, f := range archive.File {
+			if strings.HasSuffix(strings.ToLower(f.Name), ".opf") {
+				opfFile = f
+				break
+			}
+		}
+	}
+	if opfFile == nil {
+		return nil, fmt.Errorf("OPF файл не найден")
+	}
+
+	var pkg struct {
+		Subjects []string `xml:"metadata>subject"`
+	}
+	if err := decode(opfFile, &pkg); err != nil {
+		return nil, fmt.Errorf("ошибка разбора OPF: %w", err)
+	}
+	var codes []string
+	for _, subject := range pkg.Subjects {
+		codes = appendGenre(codes, genres.FromSubject(subject))
+	}
+	return codes, nil
+}
+
+// appendGenre добавляет код жанра без повторов
+func appendGenre(codes []string, code string) []string {
+	if code == "" {
+		return codes
+	}
+	for _, c := range codes {
+		if c == code {
+			return codes
+		}
+	}
+	return append(codes, code)
+}
+
+// saveBookGenres извлекает и сохраняет жанры книги. Ошибка чтения файла не мешает
+// добавить книгу: жанры просто останутся пустыми.
+func saveBookGenres(bookID int64, filePath, fileType string) error {
+	cfg := config.GetConfig()
+
+	codes, err := ExtractGenres(filePath, fileType)
+	if err != nil && cfg.Debug {
+		log.Printf("Не удалось извлечь жанры из %s: %v", filePath, err)
+	}
+	return genres.Set(db, bookID, codes)
+}
+
+// FillMissingGenres извлекает жанры книг, добавленных до появления жанров
+// (genres_scanned = 0). Книги без жанров в файле тоже отмечаются, чтобы следующая
+// ревизия не открывала их файлы снова.
+func FillMissingGenres() error {
+	cfg := config.GetConfig()
+	if db == nil {
+		return fmt.Errorf("база данных не инициализирована")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, file_url, file_type
+		FROM books
+		WHERE genres_scanned = 0 AND file_url IS NOT NULL AND file_url != ''
+	`)
+	if err != nil {
+		return fmt.Errorf("ошибка получения книг без жанров: %w", err)
+	}
+	type book struct {
+		id       int64
+		fileURL  string
+		fileType string
+	}
+	var books []book
+	for rows.Next() {
+		var b book
+		if err := rows.Scan(&b.id, &b.fileURL, &b.fileType); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка чтения книги без жанров: %w", err)
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка итерации по книгам без жанров: %w", err)
+	}
+
+	filledCount := 0
+	for _, b := range books {
+		var codes []string
+		if _, statErr := os.Stat(b.fileURL); statErr == nil {
+			codes, err = ExtractGenres(b.fileURL, b.fileType)
+			if err != nil && cfg.Debug {
+				log.Printf("Не удалось извлечь жанры книги ID %d: %v", b.id, err)
+			}
+		} else if cfg.Debug {
+			log.Printf("Файл книги ID %d не найден, жанры не определены: %s", b.id, b.fileURL)
+		}
+		if err := genres.Set(db, b.id, codes); err != nil {
+			return fmt.Errorf("ошибка сохранения жанров книги ID %d: %w", b.id, err)
+		}
+		if len(codes) > 0 {
+			filledCount++
+		}
+	}
+
+	log.Printf("Определение жанров книг завершено: обработано %d, жанры найдены у %d", len(books), filledCount)
+	return nil
+}
@@ -0,0 +1,168 @@
+// scanner/pages.go
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+
+	"github.com/disintegration/imaging"
+)
+
+// Постраничный просмотр PDF и DJVU (OPDS-PSE): число страниц и отрисовка одной
+// страницы в JPEG нужной ширины теми же утилитами, что и обложки (poppler, djvulibre).
+// Готовые страницы хранятся в каталоге pages/{хеш файла}/ и повторно не рисуются.
+
+// renderSlots ограничивает число одновременно запущенных pdftoppm/ddjvu
+var renderSlots = make(chan struct{}, 2)
+
+// PageCount возвращает число страниц PDF (pdfinfo) или DJVU (djvused)
+func PageCount(filePath, fileType string) (int, error) {
+	switch fileType {
+	case "pdf":
+		if _, err := exec.LookPath("pdfinfo"); err != nil {
+			return 0, fmt.Errorf("pdfinfo не найден")
+		}
+		output, err := exec.Command("pdfinfo", filePath).Output()
+		if err != nil {
+			return 0, fmt.Errorf("pdfinfo ошибка: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		for scanner.Scan() {
+			if value, ok := strings.CutPrefix(scanner.Text(), "Pages:"); ok {
+				return strconv.Atoi(strings.TrimSpace(value))
+			}
+		}
+		return 0, fmt.Errorf("pdfinfo не сообщил число страниц")
+	case "djvu":
+		if _, err := exec.LookPath("djvused"); err != nil {
+			return 0, fmt.Errorf("djvused не найден")
+		}
+		output, err := exec.Command("djvused", "-e", "n", filePath).Output()
+		if err != nil {
+			return 0, fmt.Errorf("djvused ошибка: %w", err)
+		}
+		return strconv.Atoi(strings.TrimSpace(string(output)))
+	}
+	return 0, fmt.Errorf("постраничный просмотр не поддерживается для формата %s", fileType)
+}
+
+// pagesDir возвращает каталог готовых страниц
+func pagesDir() string {
+	if rootPath != "" {
+		return filepath.Join(rootPath, "pages")
+	}
+	return "./pages"
+}
+
+// RenderPage возвращает путь к JPEG со страницей page (с 1) шириной width пикселей,
+// при необходимости рисуя её
+func RenderPage(filePath, fileType, fileHash string, page, width int) (string, error) {
+	cfg := config.GetConfig()
+
+	dir := filepath.Join(pagesDir(), fileHash)
+	pagePath := filepath.Join(dir, fmt.Sprintf("%d-%d.jpg", width, page))
+	if _, err := os.Stat(pagePath); err == nil {
+		return pagePath, nil
+	}
+
+	renderSlots <- struct{}{}
+	defer func() { <-renderSlots }()
+
+	// Пока ждали очереди, страницу мог нарисовать другой запрос
+	if _, err := os.Stat(pagePath); err == nil {
+		return pagePath, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("не удалось создать каталог страниц: %w", err)
+	}
+	tempDir, err := os.MkdirTemp("", "pse_page")
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать временный каталог: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var img image.Image
+	switch fileType {
+	case "pdf":
+		img, err = renderPDFPage(filePath, tempDir, page, width)
+	case "djvu":
+		img, err = renderDJVUPage(filePath, tempDir, page, width)
+	default:
+		err = fmt.Errorf("постраничный просмотр не поддерживается для формата %s", fileType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if img.Bounds().Dx() > width {
+		img = imaging.Resize(img, width, 0, imaging.Lanczos)
+	}
+
+	// Пишем во временный файл рядом и переименовываем, чтобы параллельный запрос не отдал недописанную страницу
+	f, err := os.CreateTemp(dir, "page-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать файл страницы: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := imaging.Encode(f, img, imaging.JPEG, imaging.JPEGQuality(85)); err != nil {
+		f.Close()
+		return "", fmt.Errorf("не удалось сохранить страницу: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("ошибка закрытия файла страницы: %w", err)
+	}
+	if err := os.Rename(f.Name(), pagePath); err != nil {
+		return "", fmt.Errorf("не удалось сохранить страницу %s: %w", pagePath, err)
+	}
+
+	if cfg.Debug {
+		log.Printf("Страница %d файла %s нарисована: %s", page, filePath, pagePath)
+	}
+	return pagePath, nil
+}
+
+// renderPDFPage рисует страницу PDF через pdftoppm
+func renderPDFPage(filePath, tempDir string, page, width int) (image.Image, error) {
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		return nil, fmt.Errorf("pdftoppm не найден")
+	}
+
+	// -singlefile: имя выходного файла без номера страницы
+	// -scale-to-x / -scale-to-y -1: заданная ширина с сохранением пропорций
+	outputBasePath := filepath.Join(tempDir, "page")
+	n := strconv.Itoa(page)
+	cmd := exec.Command("pdftoppm", "-jpeg", "-f", n, "-l", n, "-singlefile",
+		"-scale-to-x", strconv.Itoa(width), "-scale-to-y", "-1", filePath, outputBasePath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pdftoppm ошибка: %w, вывод: %s", err, string(output))
+	}
+	return imaging.Open(outputBasePath + ".jpg")
+}
+
+// renderDJVUPage рисует страницу DJVU через ddjvu. Размер задаётся рамкой,
+// в которую ddjvu вписывает страницу с сохранением пропорций.
+func renderDJVUPage(filePath, tempDir string, page, width int) (image.Image, error) {
+	ddjvuPath, err := exec.LookPath("ddjvu")
+	if err != nil {
+		return nil, fmt.Errorf("ddjvu не найден: %w", err)
+	}
+
+	outputPath := filepath.Join(tempDir, "page.ppm")
+	cmd := exec.Command(ddjvuPath, "--format=ppm", fmt.Sprintf("--page=%d", page),
+		fmt.Sprintf("--size=%dx%d", width, width*4), filePath, outputPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ddjvu ошибка: %w, вывод: %s", err, string(output))
+	}
+	return decodeImageFile(outputPath, config.GetConfig().Debug)
+}
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"time"
 	"turanga/config"
+	"turanga/search"
+	"turanga/works"
 
 	xxhash "github.com/cespare/xxhash/v2"
 	ipfsapi "github.com/ipfs/go-ipfs-api"
@@ -204,7 +206,7 @@ func processBookFile(filePath string, info os.FileInfo) error {
 	// Сохраняем оригинальный путь для потенциального переименования
 	originalFilePath := filePath
 	// Определяем тип файла и извлекаем метаданные
-	fileType, authorName, title, annotation, isbn, year, publisher, series, seriesNumber, err := extractMetadata(filePath, info)
+	fileType, authorName, title, annotation, isbn, year, publisher, series, seriesNumber, lang, srcLang, err := extractMetadata(filePath, info)
 	if err != nil {
 		if cfg.Debug {
 			log.Printf("Не удалось определить тип файла для %s: %v", filePath, err)
@@ -274,10 +276,11 @@ func processBookFile(filePath string, info os.FileInfo) error {
 	// Добавляем lower-поля
 	result, err := db.Exec(
 		`INSERT INTO books 
-		(title, series, series_number, published_at, isbn, year, publisher, file_url, file_type, file_hash, file_size, over18, ipfs_cid, title_lower, series_lower) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		(title, series, series_number, published_at, isbn, year, publisher, file_url, file_type, file_hash, file_size, over18, ipfs_cid, title_lower, series_lower, language, source_language) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		title, series, seriesNumber, publishedAt, isbn, year, publisher, absoluteFilePath, fileType, fileHash, fileSize, false, nil, // false = не 18+, ipfs_cid = nil -> NULL в БД
 		strings.ToLower(title), strings.ToLower(series), // для lower-полей
+		lang, srcLang, // пустая строка — язык в файле не указан
 	)
 	if err != nil {
 		return fmt.Errorf("ошибка вставки новой книги '%s': %w", title, err)
@@ -344,7 +347,7 @@ func processBookFile(filePath string, info os.FileInfo) error {
 	}
 	if cfg.Debug {
 		log.Printf("Добавлена новая книга: %s (ID: %d)", title, bookID)
-		log.Printf("  ISBN: %s, Год: %s, Издатель: %s, Серия: %s", isbn, year, publisher, series)
+		log.Printf("  ISBN: %s, Год: %s, Издатель: %s, Серия: %s, Язык: %s", isbn, year, publisher, series, lang)
 		log.Printf("  Файл: %s (%s), хеш: %s", relPath, fileType, fileHash)
 		if ipfsCID != "" {
 			log.Printf("  IPFS CID: %s", ipfsCID)
@@ -358,6 +361,27 @@ func processBookFile(filePath string, info os.FileInfo) error {
 		}
 		// Не прерываем процесс из-за ошибки авторов
 	}
+	// Связываем с серией до группировки: псевдоним серии заменяется каноническим названием
+	err = linkBookSeries(int64(bookID))
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("⚠️ ошибка связывания с серией для %s: %v", filePath, err)
+		}
+	}
+	// Объединяем с другими форматами того же произведения (по авторам, названию и серии)
+	err = works.Assign(db, int64(bookID))
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("⚠️ ошибка группировки книги %d с другими форматами: %v", bookID, err)
+		}
+	}
+	// Жанры по классификатору fb2
+	err = saveBookGenres(int64(bookID), filePath, fileType)
+	if err != nil {
+		if cfg.Debug {
+			log.Printf("⚠️ ошибка сохранения жанров для %s: %v", filePath, err)
+		}
+	}
 	// Извлекаем обложку
 	err = extractAndSaveCover(filePath, fileType, bookID, fileHash)
 	if err != nil {
@@ -384,7 +408,7 @@ func extractAndSaveCover(filePath, fileType string, bookID int, fileHash string)
 }
 
 // extractMetadata извлекает метаданные из файла книги
-func extractMetadata(filePath string, info os.FileInfo) (fileType, author, title, annotation, isbn, year, publisher, series, series_number string, err error) {
+func extractMetadata(filePath string, info os.FileInfo) (fileType, author, title, annotation, isbn, year, publisher, series, series_number, lang, srcLang string, err error) {
 	cfg := config.GetConfig()
 
 	ext := strings.ToLower(filepath.Ext(filePath))
@@ -396,7 +420,7 @@ func extractMetadata(filePath string, info os.FileInfo) (fileType, author, title
 	switch ext {
 	case ".fb2":
 		fileType = "fb2"
-		author, title, annotation, isbn, year, publisher, series, series_number, err = ExtractFB2Metadata(filePath)
+		author, title, annotation, isbn, year, publisher, series, series_number, lang, srcLang, err = ExtractFB2Metadata(filePath)
 		if err != nil {
 			if cfg.Debug {
 				log.Printf("FB2 ошибка: %v для %s", err, filePath)
@@ -410,7 +434,7 @@ func extractMetadata(filePath string, info os.FileInfo) (fileType, author, title
 		}
 	case ".epub":
 		fileType = "epub"
-		author, title, annotation, isbn, year, publisher, series, series_number, err = ExtractEPUBMetadata(filePath)
+		author, title, annotation, isbn, year, publisher, series, series_number, lang, srcLang, err = ExtractEPUBMetadata(filePath)
 		if err != nil {
 			if cfg.Debug {
 				log.Printf("EPUB ошибка: %v для %s", err, filePath)
@@ -451,7 +475,7 @@ func extractMetadata(filePath string, info os.FileInfo) (fileType, author, title
 	case ".zip":
 		if strings.Contains(strings.ToLower(strings.TrimSuffix(info.Name(), ext)), ".fb2") {
 			fileType = "fb2.zip"
-			author, title, annotation, isbn, year, publisher, series, series_number, err = ExtractFB2ZipMetadata(filePath)
+			author, title, annotation, isbn, year, publisher, series, series_number, lang, srcLang, err = ExtractFB2ZipMetadata(filePath)
 			if err != nil {
 				if cfg.Debug {
 					log.Printf("FB2.ZIP ошибка: %v для %s", err, filePath)
@@ -691,9 +715,36 @@ func saveAnnotationToFile(bookID int, annotation, fileHash string) error {
 			bookID, notePath, len(annotation))
 	}
 
+	if err := search.SetAnnotation(db, int64(bookID), annotation); err != nil {
+		log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+	}
+
+	return nil
+}
+
+// AssignMissingWorks привязывает к произведениям книги, оставшиеся без группы
+func AssignMissingWorks() error {
+	if db == nil {
+		return fmt.Errorf("база данных не инициализирована")
+	}
+	count, err := works.AssignAll(db)
+	if err != nil {
+		return err
+	}
+	if cfg.Debug && count > 0 {
+		log.Printf("Привязано к произведениям книг: %d", count)
+	}
 	return nil
 }
 
+// RebuildSearchIndex перестраивает полнотекстовый индекс вместе с аннотациями из каталога notes
+func RebuildSearchIndex() error {
+	if db == nil {
+		return fmt.Errorf("база данных не инициализирована")
+	}
+	return search.RebuildIndex(db, filepath.Join(rootPath, "notes"))
+}
+
 // GetConfig возвращает текущую конфигурацию приложения
 func GetConfig() *config.Config {
 	return cfg // Возвращает глобальную переменную cfg пакета scanner
@@ -716,6 +767,11 @@ func calculateFileHash(filePath string) (string, error) {
 	return fmt.Sprintf("%016x", h.Sum64()), nil
 }
 
+// FileHash возвращает хеш файла в том виде, в каком он хранится в books.file_hash
+func FileHash(filePath string) (string, error) {
+	return calculateFileHash(filePath)
+}
+
 // Вспомогательная функция для получения значения из sql.NullString
 func getValueOrDefault(nullString sql.NullString) string {
 	if nullString.Valid {
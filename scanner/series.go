@@ -0,0 +1,34 @@
+// scanner/series.go
+package scanner
+
+import (
+	"fmt"
+	"log"
+
+	"turanga/series"
+)
+
+// linkBookSeries связывает новую книгу с серией и разбирает номер тома
+func linkBookSeries(bookID int64) error {
+	return series.Sync(db, bookID)
+}
+
+// FillMissingSeries связывает с сериями книги, добавленные до появления таблицы
+// серий, и удаляет серии, у которых не осталось ни книг, ни введённых вручную данных
+func FillMissingSeries() error {
+	if db == nil {
+		return fmt.Errorf("база данных не инициализирована")
+	}
+	count, err := series.SyncAll(db)
+	if err != nil {
+		return err
+	}
+	deleted, err := series.Cleanup(db)
+	if err != nil {
+		return err
+	}
+	if cfg.Debug && (count > 0 || deleted > 0) {
+		log.Printf("Упорядочивание серий завершено: обработано книг %d, удалено пустых серий %d", count, deleted)
+	}
+	return nil
+}
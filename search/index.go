@@ -0,0 +1,124 @@
+// search/index.go
+package search
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"turanga/config"
+)
+
+// Таблица индекса и триггеры создаются миграциями схемы (пакет migrations).
+// Поля названия, серии, авторов, тегов и ISBN поддерживаются в индексе триггерами БД.
+// Аннотации хранятся в файлах notes/{hash}.txt, поэтому попадают в индекс из кода.
+
+// SetAnnotation обновляет текст аннотации книги в поисковом индексе
+func SetAnnotation(db *sql.DB, bookID int64, annotation string) error {
+	if db == nil {
+		return fmt.Errorf("БД не инициализирована")
+	}
+	_, err := db.Exec("UPDATE books_fts SET annotation = ? WHERE rowid = ?", Fold(annotation), bookID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления аннотации в поисковом индексе: %w", err)
+	}
+	return nil
+}
+
+// RebuildIndex полностью перестраивает поисковый индекс,
+// подтягивая аннотации из каталога notesDir
+func RebuildIndex(db *sql.DB, notesDir string) error {
+	cfg := config.GetConfig()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM books_fts"); err != nil {
+		return fmt.Errorf("ошибка очистки поискового индекса: %w", err)
+	}
+	if _, err := tx.Exec(PopulateSQL); err != nil {
+		return fmt.Errorf("ошибка заполнения поискового индекса: %w", err)
+	}
+
+	rows, err := tx.Query("SELECT id, file_hash FROM books WHERE file_hash IS NOT NULL AND file_hash != ''")
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка книг: %w", err)
+	}
+	type bookHash struct {
+		id   int64
+		hash string
+	}
+	var books []bookHash
+	for rows.Next() {
+		var b bookHash
+		if err := rows.Scan(&b.id, &b.hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка чтения списка книг: %w", err)
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+
+	stmt, err := tx.Prepare("UPDATE books_fts SET annotation = ? WHERE rowid = ?")
+	if err != nil {
+		return fmt.Errorf("ошибка подготовки обновления аннотаций: %w", err)
+	}
+	defer stmt.Close()
+
+	annotated := 0
+	for _, b := range books {
+		content, err := os.ReadFile(filepath.Join(notesDir, b.hash+".txt"))
+		if err != nil {
+			continue
+		}
+		if _, err := stmt.Exec(Fold(string(content)), b.id); err != nil {
+			return fmt.Errorf("ошибка индексации аннотации книги ID %d: %w", b.id, err)
+		}
+		annotated++
+	}
+
+	if _, err := tx.Exec("INSERT INTO books_fts(books_fts) VALUES('optimize')"); err != nil {
+		return fmt.Errorf("ошибка оптимизации поискового индекса: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения поискового индекса: %w", err)
+	}
+
+	if cfg != nil && cfg.Debug {
+		log.Printf("Поисковый индекс перестроен: %d книг, %d аннотаций", len(books), annotated)
+	}
+	return nil
+}
+
+// foldSQL — SQL-аналог Fold для использования в триггерах.
+// Регистр приводит сам токенизатор unicode61, здесь остаётся только «ё».
+const foldSQL = "REPLACE(REPLACE(IFNULL(%s, ''), 'ё', 'е'), 'Ё', 'Е')"
+
+func fold(expr string) string {
+	return fmt.Sprintf(foldSQL, expr)
+}
+
+func authorsOf(bookID string) string {
+	return fold("(SELECT GROUP_CONCAT(a.full_name, ' ') FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = " + bookID + ")")
+}
+
+func tagsOf(bookID string) string {
+	return fold("(SELECT GROUP_CONCAT(t.name, ' ') FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.book_id = " + bookID + ")")
+}
+
+func isbnOf(expr string) string {
+	return "REPLACE(REPLACE(IFNULL(" + expr + ", ''), '-', ''), ' ', '')"
+}
+
+// PopulateSQL заполняет индекс по данным таблиц (без аннотаций)
+var PopulateSQL = `
+    INSERT INTO books_fts(rowid, title, series, authors, tags, isbn, annotation)
+    SELECT b.id, ` + fold("b.title") + `, ` + fold("b.series") + `, ` + authorsOf("b.id") + `, ` + tagsOf("b.id") + `, ` + isbnOf("b.isbn") + `, ''
+    FROM books b;
+`
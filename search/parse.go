@@ -0,0 +1,98 @@
+// search/parse.go
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Поисковая строка может содержать условия по полям: author:Стругацкий title:"Пикник на обочине"
+// series:, tag:, isbn:, hash:, lang:. Значение с пробелами берётся в кавычки. Поддерживаются и
+// русские названия полей (автор:, название:, серия:, тег:, язык:). Всё, что не относится
+// к полям, ищется по всем индексируемым полям.
+
+// fieldAliases сопоставляет названия полей в строке поиска полям запроса
+var fieldAliases = map[string]string{
+	"author":   "author",
+	"автор":    "author",
+	"title":    "title",
+	"название": "title",
+	"series":   "series",
+	"серия":    "series",
+	"tag":      "tag",
+	"тег":      "tag",
+	"isbn":     "isbn",
+	"hash":     "hash",
+	"хеш":      "hash",
+	"lang":     "lang",
+	"язык":     "lang",
+}
+
+// ParseQuery разбирает поисковую строку с условиями по полям в Query.
+// Неизвестный префикс вида «слово:» остаётся частью общего текста.
+func ParseQuery(s string) Query {
+	var q Query
+	var text []string
+
+	for _, token := range splitQuery(s) {
+		name, value, found := strings.Cut(token, ":")
+		field := fieldAliases[strings.ToLower(name)]
+		if !found || field == "" {
+			text = append(text, strings.Trim(token, `"`))
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch field {
+		case "author":
+			q.Author = joinValue(q.Author, value)
+		case "title":
+			q.Title = joinValue(q.Title, value)
+		case "series":
+			q.Series = joinValue(q.Series, value)
+		case "tag":
+			q.Tag = joinValue(q.Tag, value)
+		case "isbn":
+			q.ISBN = value
+		case "hash":
+			q.FileHash = strings.ToLower(value)
+		case "lang":
+			q.Language = value
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q
+}
+
+// splitQuery делит строку на слова по пробелам, не разрывая значения в кавычках
+func splitQuery(s string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// joinValue добавляет значение к уже заданному условию по тому же полю
+func joinValue(existing, value string) string {
+	if existing == "" {
+		return value
+	}
+	return existing + " " + value
+}
@@ -0,0 +1,250 @@
+// search/search.go
+package search
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"turanga/access"
+	"turanga/language"
+)
+
+// Query описывает поисковый запрос к каталогу.
+// Text ищется по всем индексируемым полям, остальные поля ограничивают поиск конкретной колонкой.
+type Query struct {
+	Text   string
+	Title  string
+	Series string
+	Author string
+	Tag    string
+	ISBN   string
+
+	// FileHash проверяется точным сравнением, в полнотекстовый индекс хеш не входит
+	FileHash string
+
+	// Language — язык книги (ru, en или название языка); тоже проверяется точным сравнением
+	Language string
+
+	// TitleExact и SeriesExact требуют полного совпадения названия/серии (без учёта регистра)
+	TitleExact  bool
+	SeriesExact bool
+
+	// Access задаёт правила видимости; нулевое значение скрывает закрытые книги (18+ и с закрытыми тегами)
+	Access access.Policy
+
+	Limit  int
+	Offset int
+}
+
+// Result содержит идентификаторы найденных книг в порядке релевантности
+type Result struct {
+	IDs   []int64
+	Total int
+}
+
+// IsEmpty сообщает, что в запросе нет ни одного условия поиска
+func (q Query) IsEmpty() bool {
+	return MatchExpression(q) == "" && strings.TrimSpace(q.FileHash) == "" && language.Normalize(q.Language) == ""
+}
+
+// Fold приводит строку к виду, в котором она хранится в индексе:
+// нижний регистр и «ё» заменена на «е».
+func Fold(s string) string {
+	s = strings.ToLower(s)
+	return strings.ReplaceAll(s, "ё", "е")
+}
+
+// NormalizeISBN убирает из ISBN дефисы и пробелы
+func NormalizeISBN(isbn string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn))
+}
+
+// tokenize разбивает строку на слова, пригодные для FTS5.
+// Все символы, кроме букв и цифр, считаются разделителями, поэтому
+// спецсимволы синтаксиса FTS5 в запрос не попадают.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// phrase формирует условие для набора слов: все слова должны присутствовать.
+// При prefix слова ищутся по началу, что заменяет прежний поиск подстроки.
+func phrase(words []string, prefix bool) string {
+	parts := make([]string, 0, len(words))
+	for _, w := range words {
+		term := `"` + w + `"`
+		if prefix {
+			term += "*"
+		}
+		parts = append(parts, term)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// looksLikeISBN проверяет, похожа ли строка на ISBN-10 или ISBN-13
+func looksLikeISBN(s string) bool {
+	s = NormalizeISBN(s)
+	if len(s) != 10 && len(s) != 13 {
+		return false
+	}
+	for i, r := range s {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+		if i == len(s)-1 && (r == 'x' || r == 'X') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// MatchExpression строит выражение FTS5 MATCH для запроса.
+// Пустая строка означает, что искать нечего.
+func MatchExpression(q Query) string {
+	var clauses []string
+
+	if text := strings.TrimSpace(q.Text); text != "" {
+		if looksLikeISBN(text) {
+			clauses = append(clauses, `{isbn} : "`+Fold(NormalizeISBN(text))+`"*`)
+		} else if words := tokenize(text); len(words) > 0 {
+			clauses = append(clauses, "("+phrase(words, true)+")")
+		}
+	}
+
+	columns := []struct {
+		name  string
+		value string
+	}{
+		{"title", q.Title},
+		{"series", q.Series},
+		{"authors", q.Author},
+		{"tags", q.Tag},
+	}
+	for _, c := range columns {
+		if words := tokenize(c.value); len(words) > 0 {
+			clauses = append(clauses, "{"+c.name+"} : ("+phrase(words, false)+")")
+		}
+	}
+
+	if isbn := Fold(NormalizeISBN(q.ISBN)); isbn != "" {
+		if words := tokenize(isbn); len(words) > 0 {
+			clauses = append(clauses, "{isbn} : ("+phrase(words, false)+")")
+		}
+	}
+
+	return strings.Join(clauses, " AND ")
+}
+
+// Books выполняет поиск книг по полнотекстовому индексу.
+// Результаты упорядочены по релевантности (bm25 с весами колонок).
+func Books(db *sql.DB, q Query) (*Result, error) {
+	if q.IsEmpty() {
+		return &Result{}, nil
+	}
+
+	from := "FROM books b"
+	order := "b.id DESC"
+	where := "WHERE 1=1"
+	var args []interface{}
+
+	if match := MatchExpression(q); match != "" {
+		from = "FROM books_fts f JOIN books b ON b.id = f.rowid"
+		order = "f.rank, b.id DESC"
+		where += " AND f.books_fts MATCH ?"
+		args = append(args, match)
+	}
+	if filter := q.Access.Filter("b"); filter != "" {
+		where += " " + filter
+	}
+	if q.TitleExact && strings.TrimSpace(q.Title) != "" {
+		where += " AND b.title_lower = ?"
+		args = append(args, strings.ToLower(strings.TrimSpace(q.Title)))
+	}
+	if q.SeriesExact && strings.TrimSpace(q.Series) != "" {
+		where += " AND b.series_lower = ?"
+		args = append(args, strings.ToLower(strings.TrimSpace(q.Series)))
+	}
+	if hash := strings.TrimSpace(q.FileHash); hash != "" {
+		where += " AND b.file_hash = ?"
+		args = append(args, hash)
+	}
+	if lang := language.Normalize(q.Language); lang != "" {
+		where += " AND b.language = ?"
+		args = append(args, lang)
+	}
+
+	from += " " + where
+
+	result := &Result{}
+	if err := db.QueryRow("SELECT COUNT(*) "+from, args...).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта результатов поиска: %w", err)
+	}
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	selectQuery := "SELECT b.id " + from + " ORDER BY " + order
+	selectArgs := append([]interface{}{}, args...)
+	if q.Limit > 0 {
+		selectQuery += " LIMIT ? OFFSET ?"
+		selectArgs = append(selectArgs, q.Limit, q.Offset)
+	}
+
+	rows, err := db.Query(selectQuery, selectArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска книг: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка чтения результатов поиска: %w", err)
+		}
+		result.IDs = append(result.IDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения результатов поиска: %w", err)
+	}
+
+	return result, nil
+}
+
+// SortByIDs упорядочивает элементы items в порядке следования их идентификаторов в ids.
+// Нужна после выборки подробностей через WHERE id IN (...), которая порядок не сохраняет.
+func SortByIDs[T any](items []T, ids []int64, idOf func(T) int64) []T {
+	position := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		position[id] = i
+	}
+	sorted := make([]T, len(ids))
+	filled := make([]bool, len(ids))
+	for _, item := range items {
+		if pos, ok := position[idOf(item)]; ok && !filled[pos] {
+			sorted[pos] = item
+			filled[pos] = true
+		}
+	}
+	out := sorted[:0]
+	for i, item := range sorted {
+		if filled[i] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Placeholders возвращает строку "?, ?, ..." для n параметров и сами параметры
+func Placeholders(ids []int64) (string, []interface{}) {
+	marks := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		marks[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(marks, ", "), args
+}
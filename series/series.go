@@ -0,0 +1,164 @@
+// series/series.go
+package series
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Серия — отдельная сущность с каноническим названием, псевдонимами и ожидаемым
+// числом томов. Книги ссылаются на неё через books.series_id, а books.series
+// хранит каноническое название, чтобы поиск, OPDS и ссылки /s/{название} работали
+// как раньше. Номер тома в books.series_number остаётся строкой в том виде,
+// как он записан в книге, а books.series_index — его числовое значение для
+// сортировки: 2 < 2.5 < 10.
+
+// maxVolumes — предел номера тома при поиске пропусков: номера вроде «2005»
+// обычно означают год, а не тысячи недостающих томов
+const maxVolumes = 500
+
+var (
+	numberRe = regexp.MustCompile(`\d+(?:[.,]\d+)?`)
+	rangeRe  = regexp.MustCompile(`^\s*(\d+)\s*[-–—]\s*(\d+)\s*$`)
+	// Римские номера томов — только из I, V, X (до XXXIX): одиночные «C» или «D»
+	// чаще означают букву, а не число
+	romanRe = regexp.MustCompile(`^(?i)X{0,3}(IX|IV|V?I{0,3})$`)
+)
+
+var romanDigits = map[rune]int{'I': 1, 'V': 5, 'X': 10}
+
+// ParseNumber возвращает числовое значение номера тома: «3», «03», «2.5», «2,5»,
+// «Книга 4», «IV». Для диапазона «1-3» берётся первое число. false — в номере
+// нет числа.
+func ParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if n, ok := parseRoman(s); ok {
+		return float64(n), true
+	}
+	m := numberRe.FindString(s)
+	if m == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.Replace(m, ",", ".", 1), 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseRoman разбирает номер, записанный римскими цифрами
+func parseRoman(s string) (int, bool) {
+	if !romanRe.MatchString(s) {
+		return 0, false
+	}
+	s = strings.ToUpper(s)
+	total := 0
+	for i, r := range s {
+		v := romanDigits[r]
+		if i+1 < len(s) && v < romanDigits[rune(s[i+1])] {
+			total -= v
+		} else {
+			total += v
+		}
+	}
+	return total, total > 0
+}
+
+// Volumes возвращает целые номера томов, которые закрывает книга: «3» — том 3,
+// «1-3» — тома 1, 2 и 3. Дробный номер («2.5») — это вставной рассказ, он не
+// закрывает ни одного тома.
+func Volumes(number string) []int {
+	if m := rangeRe.FindStringSubmatch(number); m != nil {
+		from, _ := strconv.Atoi(m[1])
+		to, _ := strconv.Atoi(m[2])
+		if from > 0 && from <= to && to <= maxVolumes {
+			volumes := make([]int, 0, to-from+1)
+			for v := from; v <= to; v++ {
+				volumes = append(volumes, v)
+			}
+			return volumes
+		}
+	}
+	f, ok := ParseNumber(number)
+	if !ok || f < 1 || f != float64(int(f)) {
+		return nil
+	}
+	return []int{int(f)}
+}
+
+// Missing возвращает номера томов от 1 до большего из expected и наибольшего
+// имеющегося номера, для которых нет ни одной книги. expected = 0 означает, что
+// число томов неизвестно.
+func Missing(numbers []string, expected int) []int {
+	have := make(map[int]bool)
+	last := expected
+	for _, n := range numbers {
+		for _, v := range Volumes(n) {
+			have[v] = true
+			if v > last {
+				last = v
+			}
+		}
+	}
+	if last > maxVolumes {
+		return nil
+	}
+	var missing []int
+	for v := 1; v <= last; v++ {
+		if !have[v] {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// Present возвращает число разных целых томов среди номеров
+func Present(numbers []string) int {
+	have := make(map[int]bool)
+	for _, n := range numbers {
+		for _, v := range Volumes(n) {
+			have[v] = true
+		}
+	}
+	return len(have)
+}
+
+// FormatVolumes записывает номера томов коротко: «1, 3–5, 8»
+func FormatVolumes(volumes []int) string {
+	sorted := append([]int(nil), volumes...)
+	sort.Ints(sorted)
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		switch {
+		case j == i:
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		case j == i+1:
+			parts = append(parts, strconv.Itoa(sorted[i]), strconv.Itoa(sorted[j]))
+		default:
+			parts = append(parts, fmt.Sprintf("%d–%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SplitAliases разбирает список псевдонимов, записанный через запятую или с новой строки
+func SplitAliases(s string) []string {
+	var out []string
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
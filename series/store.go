@@ -0,0 +1,306 @@
+// series/store.go
+package series
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"turanga/models"
+)
+
+// ErrNotFound — серии с таким названием или псевдонимом нет
+var ErrNotFound = errors.New("серия не найдена")
+
+// ErrAliasTaken — псевдоним совпадает с названием другой серии; такие серии
+// сначала объединяются переименованием
+var ErrAliasTaken = errors.New("псевдоним совпадает с названием другой серии")
+
+// Series — серия с каноническим названием
+type Series struct {
+	ID       int64
+	Name     string
+	Expected int // Ожидаемое число томов, 0 — неизвестно
+	Aliases  []string
+}
+
+// lookup ищет серию по названию или псевдониму без учёта регистра
+func lookup(q models.Querier, name string) (int64, string, error) {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	var id int64
+	var canonical string
+	err := q.QueryRow("SELECT id, name FROM series WHERE name_lower = ?", lower).Scan(&id, &canonical)
+	if err == sql.ErrNoRows {
+		err = q.QueryRow(`
+            SELECT s.id, s.name FROM series_aliases sa
+            JOIN series s ON s.id = sa.series_id
+            WHERE sa.alias_lower = ?`, lower).Scan(&id, &canonical)
+	}
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("ошибка поиска серии %q: %w", name, err)
+	}
+	return id, canonical, nil
+}
+
+// Resolve возвращает ID и каноническое название серии по названию или псевдониму,
+// создавая серию, если её ещё нет
+func Resolve(q models.Querier, name string) (int64, string, error) {
+	name = strings.TrimSpace(name)
+	id, canonical, err := lookup(q, name)
+	if !errors.Is(err, ErrNotFound) {
+		return id, canonical, err
+	}
+	res, err := q.Exec("INSERT INTO series (name, name_lower) VALUES (?, ?)", name, strings.ToLower(name))
+	if err != nil {
+		return 0, "", fmt.Errorf("ошибка создания серии %q: %w", name, err)
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, "", fmt.Errorf("ошибка получения ID серии %q: %w", name, err)
+	}
+	return id, name, nil
+}
+
+// Sync связывает книгу с серией по books.series и пересчитывает числовой номер
+// тома. Название, записанное псевдонимом или в другом регистре, заменяется
+// каноническим. Вызывается после любого изменения серии или номера книги.
+func Sync(q models.Querier, bookID int64) error {
+	var name, number sql.NullString
+	err := q.QueryRow("SELECT series, series_number FROM books WHERE id = ?", bookID).Scan(&name, &number)
+	if err != nil {
+		return fmt.Errorf("ошибка получения серии книги ID %d: %w", bookID, err)
+	}
+
+	var index interface{}
+	if n, ok := ParseNumber(number.String); ok {
+		index = n
+	}
+	if strings.TrimSpace(name.String) == "" {
+		_, err = q.Exec("UPDATE books SET series_id = NULL, series_index = ? WHERE id = ?", index, bookID)
+	} else {
+		id, canonical, resolveErr := Resolve(q, name.String)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		_, err = q.Exec("UPDATE books SET series = ?, series_lower = ?, series_id = ?, series_index = ? WHERE id = ?",
+			canonical, strings.ToLower(canonical), id, index, bookID)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка связывания книги ID %d с серией: %w", bookID, err)
+	}
+	return nil
+}
+
+// SyncAll связывает с сериями книги, добавленные до появления таблицы серий,
+// и книги с ещё не разобранным номером. Возвращает число обработанных книг.
+func SyncAll(q models.Querier) (int, error) {
+	rows, err := q.Query(`
+        SELECT id FROM books
+        WHERE (IFNULL(series, '') != '' AND series_id IS NULL)
+           OR (IFNULL(series, '') = '' AND series_id IS NOT NULL)
+           OR (IFNULL(series_number, '') != '' AND series_index IS NULL)`)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения книг без серии: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("ошибка чтения книги без серии: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("ошибка итерации по книгам без серии: %w", err)
+	}
+
+	for _, id := range ids {
+		if err := Sync(q, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// Find возвращает серию по названию или псевдониму
+func Find(q models.Querier, name string) (*Series, error) {
+	id, _, err := lookup(q, name)
+	if err != nil {
+		return nil, err
+	}
+	return Get(q, id)
+}
+
+// Get возвращает серию с псевдонимами
+func Get(q models.Querier, id int64) (*Series, error) {
+	s := &Series{ID: id}
+	var expected sql.NullInt64
+	err := q.QueryRow("SELECT name, expected_count FROM series WHERE id = ?", id).Scan(&s.Name, &expected)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения серии ID %d: %w", id, err)
+	}
+	s.Expected = int(expected.Int64)
+
+	rows, err := q.Query("SELECT alias FROM series_aliases WHERE series_id = ? ORDER BY alias_lower", id)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения псевдонимов серии ID %d: %w", id, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, fmt.Errorf("ошибка чтения псевдонима серии: %w", err)
+		}
+		s.Aliases = append(s.Aliases, alias)
+	}
+	return s, rows.Err()
+}
+
+// SetExpected задаёт ожидаемое число томов; 0 — неизвестно
+func SetExpected(q models.Querier, id int64, expected int) error {
+	var value interface{}
+	if expected > 0 {
+		value = expected
+	}
+	if _, err := q.Exec("UPDATE series SET expected_count = ? WHERE id = ?", value, id); err != nil {
+		return fmt.Errorf("ошибка сохранения числа томов серии: %w", err)
+	}
+	return nil
+}
+
+// SetAliases заменяет псевдонимы серии. Псевдоним, принадлежавший другой серии,
+// переходит к этой; совпадающий с названием другой серии — ошибка ErrAliasTaken.
+func SetAliases(q models.Querier, id int64, aliases []string) error {
+	var name string
+	if err := q.QueryRow("SELECT name FROM series WHERE id = ?", id).Scan(&name); err != nil {
+		return fmt.Errorf("ошибка получения серии ID %d: %w", id, err)
+	}
+	if _, err := q.Exec("DELETE FROM series_aliases WHERE series_id = ?", id); err != nil {
+		return fmt.Errorf("ошибка удаления псевдонимов серии: %w", err)
+	}
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		lower := strings.ToLower(alias)
+		if alias == "" || lower == strings.ToLower(name) {
+			continue
+		}
+		var other int64
+		err := q.QueryRow("SELECT id FROM series WHERE name_lower = ? AND id != ?", lower, id).Scan(&other)
+		if err == nil {
+			return fmt.Errorf("%w: %s", ErrAliasTaken, alias)
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("ошибка проверки псевдонима %q: %w", alias, err)
+		}
+		_, err = q.Exec("INSERT OR REPLACE INTO series_aliases (alias_lower, alias, series_id) VALUES (?, ?, ?)", lower, alias, id)
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения псевдонима %q: %w", alias, err)
+		}
+	}
+	return nil
+}
+
+// Rename переименовывает серию во всех её книгах. Если новое название уже занято
+// другой серией (или её псевдонимом), книги переходят в неё, а псевдонимы и число
+// томов объединяются. Возвращает каноническое название после переименования.
+// При объединении прежняя серия удаляется, поэтому q должен быть транзакцией.
+func Rename(q models.Querier, oldName, newName string) (string, error) {
+	oldName = strings.TrimSpace(oldName)
+	newName = strings.TrimSpace(newName)
+	id, _, err := Resolve(q, oldName)
+	if err != nil {
+		return "", err
+	}
+
+	targetID, canonical, err := lookup(q, newName)
+	switch {
+	case err == nil && targetID != id:
+		if err := merge(q, id, targetID); err != nil {
+			return "", err
+		}
+	case err == nil || errors.Is(err, ErrNotFound):
+		// Новое название — свободное или прежнее в другом регистре
+		canonical = newName
+		if _, err := q.Exec("DELETE FROM series_aliases WHERE alias_lower = ?", strings.ToLower(newName)); err != nil {
+			return "", fmt.Errorf("ошибка удаления псевдонима %q: %w", newName, err)
+		}
+		if _, err := q.Exec("UPDATE series SET name = ?, name_lower = ? WHERE id = ?", newName, strings.ToLower(newName), id); err != nil {
+			return "", fmt.Errorf("ошибка переименования серии: %w", err)
+		}
+		targetID = id
+	default:
+		return "", err
+	}
+
+	// Книги, ещё не связанные с серией, переименовываются по старому названию
+	_, err = q.Exec("UPDATE books SET series = ?, series_lower = ?, series_id = ? WHERE series_id = ? OR series = ?",
+		canonical, strings.ToLower(canonical), targetID, id, oldName)
+	if err != nil {
+		return "", fmt.Errorf("ошибка переименования серии в книгах: %w", err)
+	}
+	return canonical, nil
+}
+
+// merge переносит псевдонимы и число томов серии from в серию into и удаляет from.
+// Книги переносит вызывающий.
+func merge(q models.Querier, from, into int64) error {
+	if _, err := q.Exec("UPDATE OR IGNORE series_aliases SET series_id = ? WHERE series_id = ?", into, from); err != nil {
+		return fmt.Errorf("ошибка переноса псевдонимов серии: %w", err)
+	}
+	_, err := q.Exec(`
+        UPDATE series SET expected_count = COALESCE(expected_count,
+            (SELECT expected_count FROM series WHERE id = ?))
+        WHERE id = ?`, from, into)
+	if err != nil {
+		return fmt.Errorf("ошибка переноса числа томов серии: %w", err)
+	}
+	if _, err := q.Exec("DELETE FROM series_aliases WHERE series_id = ?", from); err != nil {
+		return fmt.Errorf("ошибка удаления псевдонимов серии: %w", err)
+	}
+	if _, err := q.Exec("DELETE FROM series WHERE id = ?", from); err != nil {
+		return fmt.Errorf("ошибка удаления объединённой серии: %w", err)
+	}
+	return nil
+}
+
+// Cleanup удаляет серии без книг, псевдонимов и ожидаемого числа томов.
+// Серии с данными, введёнными вручную, сохраняются: книги могут вернуться.
+func Cleanup(q models.Querier) (int64, error) {
+	res, err := q.Exec(`
+        DELETE FROM series
+        WHERE expected_count IS NULL
+          AND id NOT IN (SELECT series_id FROM books WHERE series_id IS NOT NULL)
+          AND id NOT IN (SELECT series_id FROM series_aliases)`)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления серий без книг: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Numbers возвращает номера томов книг серии (псевдоним b) с дополнительным
+// условием на видимость, например policy.Filter("b")
+func Numbers(q models.Querier, id int64, filter string) ([]string, error) {
+	rows, err := q.Query("SELECT IFNULL(b.series_number, '') FROM books b WHERE b.series_id = ? "+filter, id)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения номеров томов серии: %w", err)
+	}
+	defer rows.Close()
+	var numbers []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, fmt.Errorf("ошибка чтения номера тома: %w", err)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, rows.Err()
+}
@@ -0,0 +1,646 @@
+// trash/trash.go
+package trash
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"turanga/config"
+	"turanga/genres"
+	"turanga/history"
+	"turanga/search"
+	"turanga/series"
+	"turanga/works"
+)
+
+// Удалённая книга не стирается сразу, а попадает в корзину: строка books со всеми
+// полями, авторами, тегами и жанрами сохраняется в таблице trash, файл переносится
+// в каталог deleted. Обложка и аннотация остаются на месте до очистки корзины.
+// Так как строки в books больше нет, книга пропадает из веб-интерфейса, OPDS,
+// поиска и ответов Nostr без дополнительных проверок.
+
+// DirName — каталог корзины в корне приложения
+const DirName = "deleted"
+
+// ErrBookNotFound означает, что удаляемой книги нет в каталоге
+var ErrBookNotFound = errors.New("книга не найдена")
+
+// ErrHashExists означает, что в библиотеке уже есть книга с тем же хешем
+var ErrHashExists = errors.New("книга с таким хешем уже есть в библиотеке")
+
+// Item — книга в корзине
+type Item struct {
+	ID           int64
+	BookID       int64
+	Title        string
+	Authors      []string
+	FileHash     string
+	FileType     string
+	FileSize     int64
+	OriginalPath string
+	TrashPath    string
+	DeletedAt    time.Time
+}
+
+// bookData — содержимое колонки book_data
+type bookData struct {
+	Columns map[string]interface{} `json:"columns"`
+	Authors []string               `json:"authors"`
+	Tags    []string               `json:"tags"`
+	Genres  []string               `json:"genres,omitempty"`
+}
+
+// Move переносит книгу в корзину и возвращает ID записи корзины
+func Move(db *sql.DB, rootPath string, bookID int64) (int64, error) {
+	cfg := config.GetConfig()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	columns, err := snapshotRow(tx, bookID)
+	if err != nil {
+		return 0, err
+	}
+	data := bookData{Columns: columns}
+	if data.Authors, err = listNames(tx, `
+        SELECT a.full_name FROM book_authors ba
+        JOIN authors a ON a.id = ba.author_id
+        WHERE ba.book_id = ?
+        ORDER BY ba.rowid`, bookID); err != nil {
+		return 0, fmt.Errorf("ошибка получения авторов книги: %w", err)
+	}
+	if data.Tags, err = listNames(tx, `
+        SELECT t.name FROM book_tags bt
+        JOIN tags t ON t.id = bt.tag_id
+        WHERE bt.book_id = ?
+        ORDER BY t.name`, bookID); err != nil {
+		return 0, fmt.Errorf("ошибка получения тегов книги: %w", err)
+	}
+	if data.Genres, err = listNames(tx, "SELECT genre FROM book_genres WHERE book_id = ? ORDER BY genre", bookID); err != nil {
+		return 0, fmt.Errorf("ошибка получения жанров книги: %w", err)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сериализации книги: %w", err)
+	}
+
+	title := stringColumn(columns, "title")
+	fileHash := stringColumn(columns, "file_hash")
+	originalPath := stringColumn(columns, "file_url")
+
+	// Связи с авторами и тегами удаляются вместе с осиротевшими авторами,
+	// связи с запросами Nostr — чтобы книга не попала в ответ
+	if err := history.SetAuthors(tx, bookID, nil); err != nil {
+		return 0, err
+	}
+	if err := history.SetTags(tx, bookID, nil); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM book_genres WHERE book_id = ?", bookID); err != nil {
+		return 0, fmt.Errorf("ошибка удаления жанров книги: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM nostr_request_books WHERE book_id = ?", bookID); err != nil {
+		return 0, fmt.Errorf("ошибка удаления связей с запросами Nostr: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM books WHERE id = ?", bookID); err != nil {
+		return 0, fmt.Errorf("ошибка удаления книги из каталога: %w", err)
+	}
+
+	// Файл переносится до фиксации транзакции: если перенос не удался, книга остаётся на месте
+	trashPath := ""
+	if originalPath != "" {
+		if _, err := os.Stat(originalPath); err == nil {
+			trashPath, err = moveToTrash(rootPath, originalPath, fileHash, bookID)
+			if err != nil {
+				return 0, err
+			}
+		} else if cfg != nil && cfg.Debug {
+			log.Printf("Файл книги ID %d не найден, в корзину попадут только метаданные: %s", bookID, originalPath)
+		}
+	}
+
+	res, err := tx.Exec(`
+        INSERT INTO trash (book_id, title, file_hash, file_type, file_size, original_path, trash_path, book_data, deleted_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		bookID, title, fileHash, stringColumn(columns, "file_type"), intColumn(columns, "file_size"),
+		originalPath, trashPath, string(encoded), time.Now().Unix())
+	if err != nil {
+		restoreFile(trashPath, originalPath)
+		return 0, fmt.Errorf("ошибка записи в корзину: %w", err)
+	}
+	trashID, err := res.LastInsertId()
+	if err != nil {
+		restoreFile(trashPath, originalPath)
+		return 0, fmt.Errorf("ошибка получения ID записи корзины: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		restoreFile(trashPath, originalPath)
+		return 0, fmt.Errorf("ошибка фиксации переноса в корзину: %w", err)
+	}
+
+	if cfg != nil && cfg.Debug {
+		log.Printf("Книга ID %d '%s' перенесена в корзину (запись %d)", bookID, title, trashID)
+	}
+	return trashID, nil
+}
+
+// List возвращает содержимое корзины, недавно удалённые первыми
+func List(db *sql.DB) ([]Item, error) {
+	rows, err := db.Query(`
+        SELECT id, book_id, IFNULL(title, ''), IFNULL(file_hash, ''), IFNULL(file_type, ''), IFNULL(file_size, 0),
+               IFNULL(original_path, ''), IFNULL(trash_path, ''), book_data, deleted_at
+        FROM trash
+        ORDER BY deleted_at DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения содержимого корзины: %w", err)
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		var encoded string
+		var deletedAt int64
+		if err := rows.Scan(&item.ID, &item.BookID, &item.Title, &item.FileHash, &item.FileType, &item.FileSize,
+			&item.OriginalPath, &item.TrashPath, &encoded, &deletedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения записи корзины: %w", err)
+		}
+		var data bookData
+		if err := json.Unmarshal([]byte(encoded), &data); err == nil {
+			item.Authors = data.Authors
+		}
+		item.DeletedAt = time.Unix(deletedAt, 0)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Restore возвращает книгу из корзины в библиотеку и возвращает её ID.
+// По возможности книга получает прежний ID, чтобы сохранились ссылки и история изменений.
+func Restore(db *sql.DB, rootPath string, trashID int64) (int64, error) {
+	cfg := config.GetConfig()
+
+	item, data, err := load(db, rootPath, trashID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if item.FileHash != "" {
+		var existingID int64
+		err := tx.QueryRow("SELECT id FROM books WHERE file_hash = ?", item.FileHash).Scan(&existingID)
+		if err == nil {
+			return 0, fmt.Errorf("%w (ID %d)", ErrHashExists, existingID)
+		} else if err != sql.ErrNoRows {
+			return 0, fmt.Errorf("ошибка проверки хеша книги: %w", err)
+		}
+	}
+
+	// Схема могла измениться с момента удаления: вставляем только существующие колонки
+	existing, err := bookColumns(tx)
+	if err != nil {
+		return 0, err
+	}
+	var idTaken bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM books WHERE id = ?)", item.BookID).Scan(&idTaken); err != nil {
+		return 0, fmt.Errorf("ошибка проверки ID книги: %w", err)
+	}
+
+	var names, placeholders []string
+	var args []interface{}
+	for name, value := range data.Columns {
+		if !existing[name] || name == "work_id" || (name == "id" && idTaken) {
+			continue
+		}
+		if name == "ipfs_cid" && value != nil {
+			// CID уникален: если его успела получить другая книга, восстанавливаем без него
+			var cidTaken bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM books WHERE ipfs_cid = ?)", value).Scan(&cidTaken); err != nil {
+				return 0, fmt.Errorf("ошибка проверки IPFS CID: %w", err)
+			}
+			if cidTaken {
+				continue
+			}
+		}
+		if name == "file_url" && item.TrashPath != "" {
+			value = item.OriginalPath
+		}
+		names = append(names, name)
+		placeholders = append(placeholders, "?")
+		args = append(args, value)
+	}
+	if len(names) == 0 {
+		return 0, fmt.Errorf("в записи корзины %d нет данных книги", trashID)
+	}
+
+	res, err := tx.Exec("INSERT INTO books ("+strings.Join(names, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка восстановления книги: %w", err)
+	}
+	bookID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID восстановленной книги: %w", err)
+	}
+
+	if err := history.SetAuthors(tx, bookID, data.Authors); err != nil {
+		return 0, err
+	}
+	if err := history.SetTags(tx, bookID, data.Tags); err != nil {
+		return 0, err
+	}
+	if err := genres.Add(tx, bookID, data.Genres); err != nil {
+		return 0, err
+	}
+	// Серия могла быть удалена или объединена с другой, пока книга лежала в корзине
+	if err := series.Sync(tx, bookID); err != nil {
+		return 0, err
+	}
+	if err := works.Assign(tx, bookID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM trash WHERE id = ?", trashID); err != nil {
+		return 0, fmt.Errorf("ошибка удаления записи корзины: %w", err)
+	}
+
+	if item.TrashPath != "" {
+		if err := os.MkdirAll(filepath.Dir(item.OriginalPath), 0755); err != nil {
+			return 0, fmt.Errorf("ошибка создания каталога %s: %w", filepath.Dir(item.OriginalPath), err)
+		}
+		if _, err := os.Stat(item.OriginalPath); err == nil {
+			return 0, fmt.Errorf("файл %s уже существует", item.OriginalPath)
+		}
+		if err := moveFile(item.TrashPath, item.OriginalPath); err != nil {
+			return 0, fmt.Errorf("ошибка возврата файла книги: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		restoreFile(item.OriginalPath, item.TrashPath)
+		return 0, fmt.Errorf("ошибка фиксации восстановления: %w", err)
+	}
+
+	// Аннотация лежала в notes всё это время, возвращаем её в поисковый индекс
+	if item.FileHash != "" {
+		if note, err := os.ReadFile(filepath.Join(rootPath, "notes", item.FileHash+".txt")); err == nil {
+			if err := search.SetAnnotation(db, bookID, strings.TrimSpace(string(note))); err != nil {
+				log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+			}
+		}
+	}
+
+	if cfg != nil && cfg.Debug {
+		log.Printf("Книга '%s' восстановлена из корзины с ID %d", item.Title, bookID)
+	}
+	return bookID, nil
+}
+
+// Purge окончательно удаляет книгу из корзины: файл, обложку, аннотацию и историю изменений.
+// Если включено remove_from_ipfs_on_delete, файл открепляется в локальном узле IPFS.
+func Purge(db *sql.DB, cfg *config.Config, rootPath string, trashID int64) error {
+	item, data, err := load(db, rootPath, trashID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM trash WHERE id = ?", trashID); err != nil {
+		return fmt.Errorf("ошибка удаления записи корзины: %w", err)
+	}
+	// ID книги мог достаться новой книге, тогда её история не трогается
+	if _, err := tx.Exec("DELETE FROM edit_history WHERE book_id = ? AND NOT EXISTS (SELECT 1 FROM books WHERE id = ?)",
+		item.BookID, item.BookID); err != nil {
+		return fmt.Errorf("ошибка удаления истории изменений: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации очистки: %w", err)
+	}
+
+	if item.TrashPath != "" {
+		if err := os.Remove(item.TrashPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Ошибка удаления файла %s: %v", item.TrashPath, err)
+		}
+	}
+
+	// Обложка, аннотация, страницы и EPUB из fb2 общие для всех записей с тем же хешем
+	if item.FileHash != "" && !hashInUse(db, item.FileHash) {
+		for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
+			coverPath := filepath.Join(rootPath, "covers", item.FileHash+ext)
+			if err := os.Remove(coverPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Ошибка удаления обложки %s: %v", coverPath, err)
+			}
+		}
+		notePath := filepath.Join(rootPath, "notes", item.FileHash+".txt")
+		if err := os.Remove(notePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Ошибка удаления аннотации %s: %v", notePath, err)
+		}
+		pagesPath := filepath.Join(rootPath, "pages", item.FileHash)
+		if err := os.RemoveAll(pagesPath); err != nil {
+			log.Printf("Ошибка удаления страниц %s: %v", pagesPath, err)
+		}
+		epubPath := filepath.Join(rootPath, "converted", item.FileHash+".epub")
+		if err := os.Remove(epubPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Ошибка удаления сконвертированной книги %s: %v", epubPath, err)
+		}
+		if _, err := db.Exec("DELETE FROM page_counts WHERE file_hash = ?", item.FileHash); err != nil {
+			log.Printf("Ошибка удаления числа страниц %s: %v", item.FileHash, err)
+		}
+		if _, err := db.Exec("DELETE FROM document_digests WHERE file_hash = ?", item.FileHash); err != nil {
+			log.Printf("Ошибка удаления дайджестов KOReader %s: %v", item.FileHash, err)
+		}
+	}
+	if _, err := db.Exec("DELETE FROM reader_positions WHERE book_id = ?", item.BookID); err != nil {
+		log.Printf("Ошибка удаления мест чтения книги %d: %v", item.BookID, err)
+	}
+
+	if cid := stringColumn(data.Columns, "ipfs_cid"); cid != "" && cfg != nil && cfg.RemoveFromIPFSOnDelete {
+		var inUse bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM books WHERE ipfs_cid = ?)", cid).Scan(&inUse); err == nil && !inUse {
+			unpin(cfg, cid)
+		}
+	}
+
+	if cfg != nil && cfg.Debug {
+		log.Printf("Книга '%s' окончательно удалена из корзины", item.Title)
+	}
+	return nil
+}
+
+// PurgeExpired удаляет книги, пролежавшие в корзине дольше trash_days дней
+func PurgeExpired(db *sql.DB, cfg *config.Config, rootPath string) (int, error) {
+	if cfg == nil || cfg.TrashDays <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -cfg.TrashDays).Unix()
+	rows, err := db.Query("SELECT id FROM trash WHERE deleted_at < ?", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска устаревших записей корзины: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	purged := 0
+	for _, id := range ids {
+		if err := Purge(db, cfg, rootPath, id); err != nil {
+			log.Printf("Ошибка очистки записи корзины %d: %v", id, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// load читает запись корзины вместе с сохранёнными данными книги
+func load(db *sql.DB, rootPath string, trashID int64) (*Item, *bookData, error) {
+	var item Item
+	var encoded string
+	var deletedAt int64
+	err := db.QueryRow(`
+        SELECT id, book_id, IFNULL(title, ''), IFNULL(file_hash, ''), IFNULL(original_path, ''), IFNULL(trash_path, ''),
+               book_data, deleted_at
+        FROM trash WHERE id = ?`, trashID).Scan(&item.ID, &item.BookID, &item.Title, &item.FileHash,
+		&item.OriginalPath, &item.TrashPath, &encoded, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, fmt.Errorf("запись корзины %d не найдена", trashID)
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("ошибка получения записи корзины %d: %w", trashID, err)
+	}
+	item.DeletedAt = time.Unix(deletedAt, 0)
+
+	// После восстановления из резервной копии в другой каталог файл лежит в deleted нового корня
+	if item.TrashPath != "" {
+		if _, err := os.Stat(item.TrashPath); os.IsNotExist(err) {
+			moved := filepath.Join(rootPath, DirName, filepath.Base(item.TrashPath))
+			if _, err := os.Stat(moved); err == nil {
+				item.TrashPath = moved
+			}
+		}
+	}
+
+	var data bookData
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber() // Большие целые не должны превращаться в float
+	if err := decoder.Decode(&data); err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения данных книги из корзины: %w", err)
+	}
+	item.Authors = data.Authors
+	return &item, &data, nil
+}
+
+// snapshotRow читает все колонки строки books в карту «колонка → значение»
+func snapshotRow(tx *sql.Tx, bookID int64) (map[string]interface{}, error) {
+	rows, err := tx.Query("SELECT * FROM books WHERE id = ?", bookID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения колонок books: %w", err)
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+		}
+		return nil, fmt.Errorf("%w: ID %d", ErrBookNotFound, bookID)
+	}
+
+	values := make([]interface{}, len(names))
+	pointers := make([]interface{}, len(names))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, fmt.Errorf("ошибка чтения книги ID %d: %w", bookID, err)
+	}
+
+	columns := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		columns[name] = values[i]
+	}
+	return columns, nil
+}
+
+// bookColumns возвращает множество колонок таблицы books
+func bookColumns(tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.Query("PRAGMA table_info(books)")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения колонок books: %w", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("ошибка чтения колонок books: %w", err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// listNames выполняет запрос, возвращающий один строковый столбец
+func listNames(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// hashInUse проверяет, нужен ли ещё хеш книге в библиотеке или в корзине
+func hashInUse(db *sql.DB, fileHash string) bool {
+	var inUse bool
+	err := db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM books WHERE file_hash = ?)
+            OR EXISTS (SELECT 1 FROM trash WHERE file_hash = ?)`, fileHash, fileHash).Scan(&inUse)
+	return err != nil || inUse
+}
+
+// unpin открепляет CID в локальном узле IPFS
+func unpin(cfg *config.Config, cid string) {
+	ipfsShell, err := cfg.GetIPFSShell()
+	if err != nil {
+		log.Printf("Предупреждение: не удалось подключиться к IPFS для открепления %s: %v", cid, err)
+		return
+	}
+	if err := ipfsShell.Unpin(cid); err != nil {
+		log.Printf("Предупреждение: не удалось открепить IPFS CID %s: %v", cid, err)
+		return
+	}
+	if cfg.Debug {
+		log.Printf("IPFS CID %s откреплён", cid)
+	}
+}
+
+// moveToTrash переносит файл книги в каталог корзины и возвращает новый путь
+func moveToTrash(rootPath, filePath, fileHash string, bookID int64) (string, error) {
+	trashDir := filepath.Join(rootPath, DirName)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return "", fmt.Errorf("ошибка создания каталога корзины: %w", err)
+	}
+
+	prefix := fileHash
+	if prefix == "" {
+		prefix = fmt.Sprintf("%d", bookID)
+	}
+	target := filepath.Join(trashDir, prefix+"_"+filepath.Base(filePath))
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(trashDir, fmt.Sprintf("%s_%d_%s", prefix, time.Now().UnixNano(), filepath.Base(filePath)))
+	}
+
+	if err := moveFile(filePath, target); err != nil {
+		return "", fmt.Errorf("ошибка переноса файла в корзину: %w", err)
+	}
+	return target, nil
+}
+
+// restoreFile возвращает файл на место после неудачной операции
+func restoreFile(from, to string) {
+	if from == "" || to == "" {
+		return
+	}
+	if err := moveFile(from, to); err != nil {
+		log.Printf("Ошибка возврата файла %s в %s: %v", from, to, err)
+	}
+}
+
+// moveFile переносит файл, копируя его, если каталоги на разных файловых системах
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		in.Close()
+		return err
+	}
+	_, err = io.Copy(out, in)
+	in.Close()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// stringColumn возвращает строковое значение колонки из снимка строки
+func stringColumn(columns map[string]interface{}, name string) string {
+	switch v := columns[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// intColumn возвращает целое значение колонки из снимка строки
+func intColumn(columns map[string]interface{}, name string) int64 {
+	switch v := columns[name].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,18 @@
+// users/context.go
+package users
+
+import "context"
+
+// contextKey — ключ пользователя в контексте запроса
+type contextKey struct{}
+
+// NewContext возвращает контекст с вошедшим пользователем
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// FromContext возвращает пользователя из контекста запроса или nil для гостя
+func FromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(contextKey{}).(*User)
+	return u
+}
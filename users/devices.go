@@ -0,0 +1,161 @@
+// users/devices.go
+package users
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Устройство — читалка, которой выдан собственный токен для OPDS-каталога.
+// Токен передаётся как пароль в HTTP Basic (логин — логин пользователя)
+// или в заголовке "Authorization: Bearer". Отзыв токена не затрагивает
+// остальные устройства и вход в веб-интерфейс.
+// Тот же токен служит паролем сервера синхронизации KOReader (пакет kosync):
+// KOReader передаёт не пароль, а его MD5, поэтому для устройства хранится и SHA-256 этого ключа.
+
+// Device — устройство пользователя для OPDS
+type Device struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time // Нулевое значение, если устройство ещё не использовалось
+}
+
+// CreateDevice добавляет устройство и возвращает его токен. Токен нигде
+// не сохраняется в открытом виде, поэтому показать его можно только сейчас.
+func CreateDevice(db *sql.DB, userID int64, name string) (string, *Device, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("название устройства не может быть пустым")
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	res, err := db.Exec("INSERT INTO devices (user_id, name, token_hash, sync_key_hash, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, name, hashToken(token), hashToken(SyncKey(token)), now.Unix())
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка создания устройства '%s': %w", name, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка получения ID устройства '%s': %w", name, err)
+	}
+	return token, &Device{ID: id, UserID: userID, Name: name, CreatedAt: now}, nil
+}
+
+// ListDevices возвращает устройства пользователя в порядке добавления
+func ListDevices(db *sql.DB, userID int64) ([]Device, error) {
+	rows, err := db.Query("SELECT id, name, created_at, last_used_at FROM devices WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка устройств: %w", err)
+	}
+	defer rows.Close()
+
+	var list []Device
+	for rows.Next() {
+		d := Device{UserID: userID}
+		var createdAt int64
+		var lastUsedAt sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.Name, &createdAt, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения устройства: %w", err)
+		}
+		d.CreatedAt = time.Unix(createdAt, 0)
+		if lastUsedAt.Valid {
+			d.LastUsedAt = time.Unix(lastUsedAt.Int64, 0)
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// DeleteDevice отзывает токен устройства пользователя
+func DeleteDevice(db *sql.DB, userID, id int64) error {
+	res, err := db.Exec("DELETE FROM devices WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления устройства: %w", err)
+	}
+	return checkAffected(res)
+}
+
+// AuthenticateDevice возвращает пользователя по токену устройства. Если login
+// не пустой (HTTP Basic), он должен совпадать с логином владельца устройства.
+func AuthenticateDevice(db *sql.DB, login, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidCredentials
+	}
+	var deviceID, userID int64
+	var lastUsedAt sql.NullInt64
+	err := db.QueryRow("SELECT id, user_id, last_used_at FROM devices WHERE token_hash = ?", hashToken(token)).
+		Scan(&deviceID, &userID, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка проверки токена устройства: %w", err)
+	}
+
+	return deviceOwner(db, deviceID, userID, lastUsedAt, login)
+}
+
+// SyncKey возвращает ключ синхронизации KOReader для токена устройства: MD5 в hex,
+// как KOReader передаёт пароль в заголовке x-auth-key
+func SyncKey(token string) string {
+	sum := md5.Sum([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateSyncKey возвращает пользователя по ключу синхронизации KOReader.
+// Логин должен совпадать с логином владельца устройства.
+func AuthenticateSyncKey(db *sql.DB, login, key string) (*User, error) {
+	if login == "" || key == "" {
+		return nil, ErrInvalidCredentials
+	}
+	var deviceID, userID int64
+	var lastUsedAt sql.NullInt64
+	err := db.QueryRow("SELECT id, user_id, last_used_at FROM devices WHERE sync_key_hash = ?", hashToken(strings.ToLower(key))).
+		Scan(&deviceID, &userID, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка проверки ключа синхронизации: %w", err)
+	}
+	return deviceOwner(db, deviceID, userID, lastUsedAt, login)
+}
+
+// deviceOwner возвращает владельца устройства, проверяет логин (если передан)
+// и отмечает время использования устройства
+func deviceOwner(db *sql.DB, deviceID, userID int64, lastUsedAt sql.NullInt64, login string) (*User, error) {
+	u, err := Get(db, userID)
+	if err == ErrNotFound {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if login != "" && !strings.EqualFold(strings.TrimSpace(login), u.Login) {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Читалки делают много запросов подряд, время использования обновляем не чаще раза в минуту
+	now := time.Now()
+	if !lastUsedAt.Valid || now.Unix()-lastUsedAt.Int64 >= 60 {
+		if _, err := db.Exec("UPDATE devices SET last_used_at = ? WHERE id = ?", now.Unix(), deviceID); err != nil {
+			return nil, fmt.Errorf("ошибка обновления времени использования устройства: %w", err)
+		}
+	}
+	return u, nil
+}
+
+// DeleteUserDevices отзывает токены всех устройств пользователя
+func DeleteUserDevices(db *sql.DB, userID int64) error {
+	if _, err := db.Exec("DELETE FROM devices WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("ошибка удаления устройств пользователя %d: %w", userID, err)
+	}
+	return nil
+}
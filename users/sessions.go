@@ -0,0 +1,154 @@
+// users/sessions.go
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Сессия создаётся при входе в веб-интерфейс. Браузер получает случайный токен,
+// в таблице sessions хранится только его SHA-256. Вместе с сессией создаётся
+// CSRF-токен, которым подтверждаются все изменяющие запросы.
+
+// Session — сессия входа
+type Session struct {
+	TokenHash string
+	UserID    int64
+	CSRFToken string
+	UserAgent string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// CreateSession начинает сессию пользователя и возвращает токен для cookie
+func CreateSession(db *sql.DB, userID int64, ttl time.Duration, userAgent string) (string, *Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		CSRFToken: csrf,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	_, err = db.Exec(`INSERT INTO sessions (token_hash, user_id, csrf_token, user_agent, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		s.TokenHash, s.UserID, s.CSRFToken, s.UserAgent, s.CreatedAt.Unix(), s.ExpiresAt.Unix())
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка создания сессии: %w", err)
+	}
+	return token, s, nil
+}
+
+// SessionUser возвращает пользователя и сессию по токену из cookie.
+// Для неизвестной или истёкшей сессии возвращает ErrNotFound.
+func SessionUser(db *sql.DB, token string) (*User, *Session, error) {
+	if token == "" {
+		return nil, nil, ErrNotFound
+	}
+	s := &Session{TokenHash: hashToken(token)}
+	var createdAt, expiresAt int64
+	var userAgent sql.NullString
+	err := db.QueryRow(`SELECT user_id, csrf_token, user_agent, created_at, expires_at
+        FROM sessions WHERE token_hash = ? AND expires_at > ?`, s.TokenHash, time.Now().Unix()).
+		Scan(&s.UserID, &s.CSRFToken, &userAgent, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения сессии: %w", err)
+	}
+	s.UserAgent = userAgent.String
+	s.CreatedAt = time.Unix(createdAt, 0)
+	s.ExpiresAt = time.Unix(expiresAt, 0)
+
+	u, err := Get(db, s.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, s, nil
+}
+
+// ListSessions возвращает действующие сессии пользователя, новые первыми
+func ListSessions(db *sql.DB, userID int64) ([]Session, error) {
+	rows, err := db.Query(`SELECT token_hash, csrf_token, user_agent, created_at, expires_at
+        FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка сессий: %w", err)
+	}
+	defer rows.Close()
+
+	var list []Session
+	for rows.Next() {
+		s := Session{UserID: userID}
+		var createdAt, expiresAt int64
+		var userAgent sql.NullString
+		if err := rows.Scan(&s.TokenHash, &s.CSRFToken, &userAgent, &createdAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения сессии: %w", err)
+		}
+		s.UserAgent = userAgent.String
+		s.CreatedAt = time.Unix(createdAt, 0)
+		s.ExpiresAt = time.Unix(expiresAt, 0)
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// DeleteSession завершает сессию с данным токеном
+func DeleteSession(db *sql.DB, token string) error {
+	if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token)); err != nil {
+		return fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserSessions завершает все сессии пользователя
+func DeleteUserSessions(db *sql.DB, userID int64) error {
+	if _, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("ошибка удаления сессий пользователя %d: %w", userID, err)
+	}
+	return nil
+}
+
+// PurgeExpiredSessions удаляет истёкшие сессии и возвращает их число
+func PurgeExpiredSessions(db *sql.DB) (int64, error) {
+	res, err := db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления истёкших сессий: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// CheckCSRF сравнивает переданный токен с токеном сессии
+func (s *Session) CheckCSRF(token string) bool {
+	return s != nil && token != "" && subtle.ConstantTimeCompare([]byte(s.CSRFToken), []byte(token)) == 1
+}
+
+// randomToken возвращает 32 случайных байта в base64 для URL
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ошибка генерации токена: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken возвращает SHA-256 токена в hex
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,371 @@
+// users/users.go
+package users
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"turanga/config"
+)
+
+// Учётные записи хранятся в таблице users. У каждой записи одна роль,
+// набор разрешённых действий определяется ролью (см. rolePermissions).
+// Гость без входа может только просматривать каталог и скачивать книги без пометки 18+.
+// Пароли хранятся в виде bcrypt; хеши xxhash прежних версий заменяются на bcrypt
+// при первом успешном входе (см. Authenticate).
+
+// Роли пользователей
+const (
+	RoleAdmin  = "admin"  // Всё: редактирование, загрузка, ревизия, запросы Nostr, управление
+	RoleReader = "reader" // Просмотр и скачивание, включая книги 18+
+	RoleChild  = "child"  // Просмотр и скачивание без книг 18+
+)
+
+// Roles — все роли в порядке отображения
+var Roles = []string{RoleAdmin, RoleReader, RoleChild}
+
+// RoleLabels — подписи ролей для веб-интерфейса
+var RoleLabels = map[string]string{
+	RoleAdmin:  "Администратор",
+	RoleReader: "Читатель",
+	RoleChild:  "Детский профиль",
+}
+
+// Permission — действие, требующее разрешения
+type Permission int
+
+const (
+	PermOver18   Permission = iota // Видеть и скачивать закрытые книги: 18+ и с закрытыми тегами (см. пакет access)
+	PermEdit                       // Редактировать и удалять книги, работать с корзиной и историей
+	PermUpload                     // Добавлять книги
+	PermRevision                   // Запускать ревизию библиотеки
+	PermNostr                      // Запрашивать книги через Nostr и скачивать их из IPFS
+	PermManage                     // Управлять пользователями, резервными копиями и метаданными
+)
+
+// rolePermissions — разрешения каждой роли
+var rolePermissions = map[string]map[Permission]bool{
+	RoleAdmin: {
+		PermOver18: true, PermEdit: true, PermUpload: true,
+		PermRevision: true, PermNostr: true, PermManage: true,
+	},
+	RoleReader: {PermOver18: true},
+	RoleChild:  {},
+}
+
+var (
+	// ErrInvalidCredentials — неверный логин или пароль
+	ErrInvalidCredentials = errors.New("неверный логин или пароль")
+	// ErrLoginTaken — логин уже занят
+	ErrLoginTaken = errors.New("пользователь с таким логином уже есть")
+	// ErrLastAdmin — нельзя удалить или понизить последнего администратора
+	ErrLastAdmin = errors.New("должен остаться хотя бы один администратор")
+	// ErrNotFound — пользователь не найден
+	ErrNotFound = errors.New("пользователь не найден")
+)
+
+// User — учётная запись
+type User struct {
+	ID           int64
+	Login        string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// Can проверяет, разрешено ли пользователю действие. Для гостя (nil) всегда false.
+func (u *User) Can(p Permission) bool {
+	if u == nil {
+		return false
+	}
+	return rolePermissions[u.Role][p]
+}
+
+// IsAdmin сообщает, является ли пользователь администратором
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
+// ValidRole проверяет название роли
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HashPassword создаёт bcrypt-хеш пароля со случайной солью
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("ошибка хеширования пароля: %w", err)
+	}
+	return string(hash), nil
+}
+
+// isLegacyHash сообщает, что хеш создан прежними версиями (xxhash, 16 hex-символов)
+func isLegacyHash(hash string) bool {
+	if len(hash) != 16 {
+		return false
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPasswordHash сравнивает пароль с сохранённым хешем любого поддерживаемого вида
+func checkPasswordHash(hash, password string) bool {
+	if isLegacyHash(hash) {
+		legacy := fmt.Sprintf("%016x", xxhash.Sum64String(password))
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(legacy)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Count возвращает число учётных записей
+func Count(db *sql.DB) (int, error) {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта пользователей: %w", err)
+	}
+	return n, nil
+}
+
+// List возвращает все учётные записи, отсортированные по логину
+func List(db *sql.DB) ([]User, error) {
+	rows, err := db.Query("SELECT id, login, password_hash, role, created_at FROM users ORDER BY login_lower")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var list []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *u)
+	}
+	return list, rows.Err()
+}
+
+// Get возвращает пользователя по ID
+func Get(db *sql.DB, id int64) (*User, error) {
+	return scanUser(db.QueryRow("SELECT id, login, password_hash, role, created_at FROM users WHERE id = ?", id))
+}
+
+// GetByLogin возвращает пользователя по логину без учёта регистра
+func GetByLogin(db *sql.DB, login string) (*User, error) {
+	return scanUser(db.QueryRow("SELECT id, login, password_hash, role, created_at FROM users WHERE login_lower = ?",
+		strings.ToLower(strings.TrimSpace(login))))
+}
+
+// Authenticate проверяет логин и пароль. Устаревший хеш пароля при успешной
+// проверке заменяется на bcrypt.
+func Authenticate(db *sql.DB, login, password string) (*User, error) {
+	u, err := GetByLogin(db, login)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if !checkPasswordHash(u.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if isLegacyHash(u.PasswordHash) {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, u.ID); err != nil {
+			return nil, fmt.Errorf("ошибка обновления хеша пароля: %w", err)
+		}
+		u.PasswordHash = hash
+		log.Printf("Хеш пароля пользователя %s обновлён до bcrypt", u.Login)
+	}
+	return u, nil
+}
+
+// Create создаёт учётную запись
+func Create(db *sql.DB, login, password, role string) (*User, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return nil, fmt.Errorf("логин не может быть пустым")
+	}
+	if password == "" {
+		return nil, fmt.Errorf("пароль не может быть пустым")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return create(db, login, hash, role)
+}
+
+// create добавляет запись с уже посчитанным хешем пароля
+func create(db *sql.DB, login, passwordHash, role string) (*User, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("неизвестная роль: %s", role)
+	}
+	if _, err := GetByLogin(db, login); err == nil {
+		return nil, ErrLoginTaken
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	res, err := db.Exec("INSERT INTO users (login, login_lower, password_hash, role, created_at) VALUES (?, ?, ?, ?, ?)",
+		login, strings.ToLower(login), passwordHash, role, now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания пользователя '%s': %w", login, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ID пользователя '%s': %w", login, err)
+	}
+	return &User{ID: id, Login: login, PasswordHash: passwordHash, Role: role, CreatedAt: now}, nil
+}
+
+// SetPassword меняет пароль пользователя и завершает все его сессии
+func SetPassword(db *sql.DB, id int64, password string) error {
+	if password == "" {
+		return fmt.Errorf("пароль не может быть пустым")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, id)
+	if err != nil {
+		return fmt.Errorf("ошибка смены пароля: %w", err)
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	return DeleteUserSessions(db, id)
+}
+
+// SetRole меняет роль пользователя. Последнего администратора понизить нельзя.
+func SetRole(db *sql.DB, id int64, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("неизвестная роль: %s", role)
+	}
+	if role != RoleAdmin {
+		if err := checkNotLastAdmin(db, id); err != nil {
+			return err
+		}
+	}
+	res, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return fmt.Errorf("ошибка смены роли: %w", err)
+	}
+	return checkAffected(res)
+}
+
+// Delete удаляет учётную запись вместе с её сессиями и устройствами. Последнего администратора удалить нельзя.
+func Delete(db *sql.DB, id int64) error {
+	if err := checkNotLastAdmin(db, id); err != nil {
+		return err
+	}
+	res, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления пользователя: %w", err)
+	}
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+	if err := DeleteUserDevices(db, id); err != nil {
+		return err
+	}
+	// Прогресс чтения KOReader (пакет kosync), места чтения в читалке (пакет reader)
+	// и почтовые адреса устройств (пакет mailer) без пользователя не нужны
+	for _, table := range []string{"reading_progress", "reader_positions", "mail_devices", "mail_deliveries"} {
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+			return fmt.Errorf("ошибка удаления %s пользователя %d: %w", table, id, err)
+		}
+	}
+	return DeleteUserSessions(db, id)
+}
+
+// ImportLegacyPassword переносит пароль из turanga.conf в учётную запись admin.
+// Выполняется, пока в базе нет ни одного пользователя; после переноса
+// password_hash удаляется из конфигурации. Старый хеш заменяется на bcrypt при первом входе.
+func ImportLegacyPassword(db *sql.DB, cfg *config.Config, configPath string) error {
+	if cfg.PasswordHash == "" {
+		return nil
+	}
+	n, err := Count(db)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := create(db, "admin", cfg.PasswordHash, RoleAdmin); err != nil {
+			return err
+		}
+		log.Println("Пароль из конфигурации перенесён в учётную запись admin")
+	}
+
+	cfg.PasswordHash = ""
+	if err := cfg.SaveConfig(configPath); err != nil {
+		return fmt.Errorf("ошибка сохранения конфигурации: %w", err)
+	}
+	return nil
+}
+
+// checkNotLastAdmin возвращает ErrLastAdmin, если id — единственный администратор
+func checkNotLastAdmin(db *sql.DB, id int64) error {
+	var isAdmin bool
+	var admins int
+	err := db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND role = ?),
+               (SELECT COUNT(*) FROM users WHERE role = ?)`, id, RoleAdmin, RoleAdmin).Scan(&isAdmin, &admins)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки администраторов: %w", err)
+	}
+	if isAdmin && admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// checkAffected возвращает ErrNotFound, если запрос не изменил ни одной строки
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка проверки результата: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanner — общее для *sql.Row и *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser читает учётную запись из строки результата
+func scanUser(row scanner) (*User, error) {
+	var u User
+	var createdAt int64
+	err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.Role, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка чтения пользователя: %w", err)
+	}
+	u.CreatedAt = time.Unix(createdAt, 0)
+	return &u, nil
+}
@@ -0,0 +1,250 @@
+// web/account.go
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/kosync"
+	"turanga/mailer"
+	"turanga/users"
+)
+
+// SessionView — сессия пользователя для шаблона
+type SessionView struct {
+	UserAgent string
+	CreatedAt string
+	ExpiresAt string
+	IsCurrent bool
+}
+
+// DeviceView — устройство OPDS для шаблона
+type DeviceView struct {
+	ID         int64
+	Name       string
+	CreatedAt  string
+	LastUsedAt string
+}
+
+// NewDeviceView — только что созданное устройство, токен показывается один раз
+type NewDeviceView struct {
+	Name  string
+	Login string
+	Token string
+}
+
+// AccountHandler показывает учётную запись текущего пользователя, его сессии и устройства
+// URL: GET /account
+func (w *WebInterface) AccountHandler(wr http.ResponseWriter, r *http.Request) {
+	if w.currentUser(r) == nil {
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.showAccount(wr, r, nil)
+}
+
+// showAccount выводит страницу учётной записи; newDevice — только что созданное устройство или nil
+func (w *WebInterface) showAccount(wr http.ResponseWriter, r *http.Request, newDevice *NewDeviceView) {
+	cfg := config.GetConfig()
+	user := w.currentUser(r)
+
+	sessions, err := users.ListSessions(w.db, user.ID)
+	if err != nil {
+		log.Printf("Ошибка получения сессий пользователя %s: %v", user.Login, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	current := w.currentSession(r)
+	views := make([]SessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, SessionView{
+			UserAgent: s.UserAgent,
+			CreatedAt: s.CreatedAt.Format("02.01.2006 15:04"),
+			ExpiresAt: s.ExpiresAt.Format("02.01.2006"),
+			IsCurrent: current != nil && s.TokenHash == current.TokenHash,
+		})
+	}
+
+	devices, err := users.ListDevices(w.db, user.ID)
+	if err != nil {
+		log.Printf("Ошибка получения устройств пользователя %s: %v", user.Login, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	deviceViews := make([]DeviceView, 0, len(devices))
+	for _, d := range devices {
+		view := DeviceView{
+			ID:        d.ID,
+			Name:      d.Name,
+			CreatedAt: d.CreatedAt.Format("02.01.2006"),
+		}
+		if !d.LastUsedAt.IsZero() {
+			view.LastUsedAt = d.LastUsedAt.Format("02.01.2006 15:04")
+		}
+		deviceViews = append(deviceViews, view)
+	}
+
+	data := struct {
+		CatalogTitle string
+		Message      string
+		ErrorMessage string
+		Login        string
+		RoleLabel    string
+		Sessions     []SessionView
+		Devices      []DeviceView
+		NewDevice    *NewDeviceView
+		OPDSAuth     bool
+		SyncServer   string
+		MailEnabled  bool
+		MailFrom     string
+		MailDevices  []mailer.Device
+		Deliveries   []DeliveryView
+	}{
+		CatalogTitle: cfg.GetCatalogTitle(),
+		Message:      r.URL.Query().Get("message"),
+		ErrorMessage: r.URL.Query().Get("error"),
+		Login:        user.Login,
+		RoleLabel:    users.RoleLabels[user.Role],
+		Sessions:     views,
+		Devices:      deviceViews,
+		NewDevice:    newDevice,
+		OPDSAuth:     cfg.OPDSAuth,
+		SyncServer:   syncServerURL(r),
+		MailEnabled:  mailer.Enabled(cfg),
+		MailFrom:     cfg.SMTPFrom,
+	}
+	if data.MailDevices, err = mailer.ListDevices(w.db, user.ID); err != nil {
+		log.Printf("Ошибка получения почтовых адресов пользователя %s: %v", user.Login, err)
+	}
+	data.Deliveries = w.deliveryViews(user.ID)
+
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "account.html")
+	tmpl, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing account template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if newDevice != nil {
+		wr.Header().Set("Cache-Control", "no-store")
+	}
+	if err := tmpl.ExecuteTemplate(wr, "account", data); err != nil {
+		log.Printf("Error executing account template: %v", err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// syncServerURL возвращает адрес сервера синхронизации KOReader, как его видит браузер
+func syncServerURL(r *http.Request) string {
+	scheme := "http"
+	if isSecureRequest(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + strings.TrimSuffix(kosync.Prefix, "/")
+}
+
+// AccountPasswordHandler меняет пароль текущего пользователя.
+// Остальные сессии пользователя при этом завершаются.
+// URL: POST /account/password
+func (w *WebInterface) AccountPasswordHandler(wr http.ResponseWriter, r *http.Request) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	redirectError := func(message string) {
+		http.Redirect(wr, r, "/account?error="+url.QueryEscape(message), http.StatusSeeOther)
+	}
+
+	password := r.FormValue("new_password")
+	if password == "" {
+		redirectError("Пароль не может быть пустым")
+		return
+	}
+	if password != r.FormValue("new_password_repeat") {
+		redirectError("Пароли не совпадают")
+		return
+	}
+	if _, err := users.Authenticate(w.db, user.Login, r.FormValue("current_password")); err != nil {
+		if !errors.Is(err, users.ErrInvalidCredentials) {
+			log.Printf("Ошибка проверки пароля пользователя %s: %v", user.Login, err)
+		}
+		redirectError("Текущий пароль указан неверно")
+		return
+	}
+
+	if err := users.SetPassword(w.db, user.ID, password); err != nil {
+		log.Printf("Ошибка смены пароля пользователя %s: %v", user.Login, err)
+		redirectError("Не удалось сменить пароль")
+		return
+	}
+	// SetPassword завершает все сессии, текущему устройству выдаём новую
+	if err := w.startSession(wr, r, user); err != nil {
+		log.Printf("Ошибка создания сессии: %v", err)
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Пользователь %s сменил пароль", user.Login)
+	http.Redirect(wr, r, "/account?message="+url.QueryEscape("Пароль изменён, остальные устройства отключены"), http.StatusSeeOther)
+}
+
+// AccountDeviceHandler добавляет и удаляет устройства OPDS текущего пользователя
+// URL: POST /account/devices/add, POST /account/devices/delete/{id}
+func (w *WebInterface) AccountDeviceHandler(wr http.ResponseWriter, r *http.Request) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	action, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/account/devices/"), "/")
+	switch action {
+	case "add":
+		token, device, err := users.CreateDevice(w.db, user.ID, r.FormValue("name"))
+		if err != nil {
+			log.Printf("Ошибка создания устройства пользователя %s: %v", user.Login, err)
+			http.Redirect(wr, r, "/account?error="+url.QueryEscape("Не удалось добавить устройство"), http.StatusSeeOther)
+			return
+		}
+		log.Printf("Пользователь %s добавил устройство OPDS %s", user.Login, device.Name)
+		// Токен не сохраняется в открытом виде, поэтому страницу выводим сразу, без перенаправления
+		w.showAccount(wr, r, &NewDeviceView{Name: device.Name, Login: user.Login, Token: token})
+	case "delete":
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(wr, "Invalid device ID", http.StatusBadRequest)
+			return
+		}
+		if err := users.DeleteDevice(w.db, user.ID, id); err != nil {
+			log.Printf("Ошибка удаления устройства %d пользователя %s: %v", id, user.Login, err)
+			http.Redirect(wr, r, "/account?error="+url.QueryEscape("Не удалось удалить устройство"), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(wr, r, "/account?message="+url.QueryEscape("Устройство удалено, его токен больше не действует"), http.StatusSeeOther)
+	default:
+		http.Error(wr, "Unknown action", http.StatusNotFound)
+	}
+}
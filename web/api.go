@@ -0,0 +1,252 @@
+// web/api.go
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// REST API /api/v1 для сторонних клиентов и скриптов, описание — в API.md.
+// Вход — токеном устройства (Authorization: Bearer или HTTP Basic, см. opds.OptionalAuth)
+// или сессией веб-интерфейса. Без входа доступно только чтение с правилами гостя.
+// Изменяющие запросы принимаются только с Bearer или сессией и только с телом в JSON:
+// Basic браузер подставляет сам, и тогда чужая страница могла бы отправить форму.
+// Ответы — JSON; у каждого ответа есть ETag, GET учитывает If-None-Match,
+// изменяющие запросы — If-Match.
+
+const (
+	apiPrefix         = "/api/v1/"
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 500
+	apiMaxBodySize    = 1 << 20
+)
+
+// apiError — ошибка API с HTTP-статусом, её текст уходит клиенту
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// apiErrorf создаёт ошибку API с указанным статусом
+func apiErrorf(status int, format string, args ...interface{}) error {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// apiPage — страница списка с общим количеством элементов
+type apiPage struct {
+	Items   interface{} `json:"items"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+// APIHandler разбирает путь /api/v1/{ресурс}/... и передаёт запрос обработчику ресурса
+// URL: /api/v1/books, /api/v1/authors, /api/v1/series, /api/v1/tags, /api/v1/revision,
+// /api/v1/nostr/requests, /api/v1/ipfs/downloads
+func (w *WebInterface) APIHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if cfg.Debug {
+		log.Printf("API: %s %s", r.Method, r.URL.Path)
+	}
+
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	rest = strings.Trim(rest, "/")
+
+	if !isSafeMethod(r.Method) {
+		if err := apiCheckWrite(r, resource, rest); err != nil {
+			writeAPIError(wr, r, err)
+			return
+		}
+	}
+
+	var err error
+	switch resource {
+	case "books":
+		err = w.apiBooks(wr, r, rest)
+	case "authors":
+		err = w.apiAuthors(wr, r, rest)
+	case "series":
+		err = w.apiSeries(wr, r, rest)
+	case "tags":
+		err = w.apiTags(wr, r, rest)
+	case "revision":
+		err = w.apiRevision(wr, r, rest)
+	case "nostr":
+		err = w.apiNostr(wr, r, rest)
+	case "ipfs":
+		err = w.apiIPFS(wr, r, rest)
+	default:
+		err = apiErrorf(http.StatusNotFound, "unknown resource: %s", resource)
+	}
+	if err != nil {
+		writeAPIError(wr, r, err)
+	}
+}
+
+// writeAPIError отвечает ошибкой в формате {"error": "..."}; внутренние ошибки
+// пишутся в лог, клиент получает только общий текст
+func writeAPIError(wr http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	message := "internal error"
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.Status
+		message = apiErr.Message
+	} else {
+		log.Printf("API: ошибка %s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	if status == http.StatusUnauthorized {
+		wr.Header().Set("WWW-Authenticate", `Bearer realm="Turanga API"`)
+	}
+	wr.Header().Set("Content-Type", "application/json; charset=utf-8")
+	wr.WriteHeader(status)
+	json.NewEncoder(wr).Encode(map[string]string{"error": message})
+}
+
+// apiRespond отдаёт v в JSON с ETag. На GET с совпадающим If-None-Match отвечает 304.
+func apiRespond(wr http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования ответа: %w", err)
+	}
+	etag := apiETag(body)
+
+	wr.Header().Set("ETag", etag)
+	if status == http.StatusOK && isSafeMethod(r.Method) && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		wr.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	wr.Header().Set("Content-Type", "application/json; charset=utf-8")
+	wr.WriteHeader(status)
+	wr.Write(body)
+	return nil
+}
+
+// apiCheckIfMatch проверяет заголовок If-Match по текущему состоянию ресурса,
+// чтобы изменение не затёрло чужую правку, сделанную после чтения
+func apiCheckIfMatch(r *http.Request, current interface{}) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	body, err := json.Marshal(current)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования ресурса: %w", err)
+	}
+	if !etagMatches(header, apiETag(body)) {
+		return apiErrorf(http.StatusPreconditionFailed, "resource has been modified")
+	}
+	return nil
+}
+
+// apiETag вычисляет ETag по телу ответа
+func apiETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches проверяет, есть ли etag в списке из заголовка If-Match/If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// apiRequire проверяет, что вход выполнен и у пользователя есть разрешение
+func (w *WebInterface) apiRequire(r *http.Request, p users.Permission) error {
+	user := w.currentUser(r)
+	if user == nil {
+		return apiErrorf(http.StatusUnauthorized, "authentication required")
+	}
+	if !user.Can(p) {
+		return apiErrorf(http.StatusForbidden, "permission denied")
+	}
+	return nil
+}
+
+// apiCheckWrite отклоняет изменяющий запрос с HTTP Basic или с телом не в JSON.
+// Исключение — загрузка книги POST /api/v1/books, она принимает multipart/form-data.
+func apiCheckWrite(r *http.Request, resource, rest string) error {
+	if _, _, ok := r.BasicAuth(); ok {
+		return apiErrorf(http.StatusUnauthorized, "write requests require a Bearer token")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return apiErrorf(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	if mediaType == "application/json" {
+		return nil
+	}
+	if mediaType == "multipart/form-data" && r.Method == http.MethodPost && resource == "books" && rest == "" {
+		return nil
+	}
+	return apiErrorf(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+}
+
+// apiPaging читает параметры page и per_page
+func apiPaging(r *http.Request) (page, perPage int, err error) {
+	page, perPage = 1, apiDefaultPerPage
+	if s := r.URL.Query().Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return 0, 0, apiErrorf(http.StatusBadRequest, "invalid page: %s", s)
+		}
+	}
+	if s := r.URL.Query().Get("per_page"); s != "" {
+		if perPage, err = strconv.Atoi(s); err != nil || perPage < 1 {
+			return 0, 0, apiErrorf(http.StatusBadRequest, "invalid per_page: %s", s)
+		}
+		if perPage > apiMaxPerPage {
+			perPage = apiMaxPerPage
+		}
+	}
+	return page, perPage, nil
+}
+
+// apiDecode читает JSON из тела запроса
+func apiDecode(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return apiErrorf(http.StatusBadRequest, "invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// apiID разбирает числовой идентификатор из пути
+func apiID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, apiErrorf(http.StatusBadRequest, "invalid id: %s", s)
+	}
+	return id, nil
+}
+
+// apiMethodNotAllowed сообщает, какие методы поддерживает ресурс
+func apiMethodNotAllowed(wr http.ResponseWriter, allowed ...string) error {
+	wr.Header().Set("Allow", strings.Join(allowed, ", "))
+	return apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,206 @@
+// web/api_actions.go
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// apiBookRequest — запрос книги через Nostr
+type apiBookRequest struct {
+	Author   string `json:"author"`
+	Series   string `json:"series"`
+	Title    string `json:"title"`
+	FileHash string `json:"file_hash"`
+	ISBN     string `json:"isbn"`
+	Language string `json:"language"`
+}
+
+// apiResponseBook — книга из ответа на запрос Nostr
+type apiResponseBook struct {
+	Title           string `json:"title"`
+	Authors         string `json:"authors"`
+	Series          string `json:"series"`
+	SeriesNumber    string `json:"series_number"`
+	FileType        string `json:"file_type"`
+	FileSize        int64  `json:"file_size"`
+	FileHash        string `json:"file_hash"`
+	IPFSCID         string `json:"ipfs_cid"`
+	LocalBookID     int64  `json:"local_book_id,omitempty"` // книга уже есть в библиотеке
+	ResponderPubkey string `json:"responder_pubkey"`
+}
+
+// apiIPFSDownload — тело запроса на скачивание книги из IPFS
+type apiIPFSDownload struct {
+	FileHash string `json:"file_hash"`
+	IPFSCID  string `json:"ipfs_cid"`
+	FileType string `json:"file_type"`
+	Title    string `json:"title"`
+}
+
+// apiRevision обслуживает /api/v1/revision: POST запускает ревизию, GET возвращает её ход
+func (w *WebInterface) apiRevision(wr http.ResponseWriter, r *http.Request, rest string) error {
+	if rest != "" {
+		return apiErrorf(http.StatusNotFound, "unknown resource: revision/%s", rest)
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return apiRespond(wr, r, http.StatusOK, GetRevisionProgress())
+	case http.MethodPost:
+		if err := w.apiRequire(r, users.PermRevision); err != nil {
+			return err
+		}
+		if err := w.startRevision(); errors.Is(err, errRevisionRunning) {
+			return apiErrorf(http.StatusConflict, "revision is already running")
+		} else if err != nil {
+			return err
+		}
+		log.Printf("API: ревизия запущена пользователем %s", w.currentUser(r).Login)
+		wr.Header().Set("Location", apiPrefix+"revision")
+		return apiRespond(wr, r, http.StatusAccepted, GetRevisionProgress())
+	default:
+		return apiMethodNotAllowed(wr, "GET", "POST")
+	}
+}
+
+// apiNostr обслуживает /api/v1/nostr/requests (POST — отправить запрос книги)
+// и /api/v1/nostr/requests/{event_id} (GET — полученные ответы)
+func (w *WebInterface) apiNostr(wr http.ResponseWriter, r *http.Request, rest string) error {
+	collection, eventID, _ := strings.Cut(rest, "/")
+	if collection != "requests" {
+		return apiErrorf(http.StatusNotFound, "unknown resource: nostr/%s", rest)
+	}
+	if err := w.apiRequire(r, users.PermNostr); err != nil {
+		return err
+	}
+	if !w.isNostrAvailable() {
+		return apiErrorf(http.StatusServiceUnavailable, "nostr is not configured")
+	}
+
+	if eventID == "" {
+		if r.Method != http.MethodPost {
+			return apiMethodNotAllowed(wr, "POST")
+		}
+		return w.apiPublishRequest(wr, r)
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return apiMethodNotAllowed(wr, "GET")
+	}
+
+	groups, err := w.getResponsesForSpecificRequest(eventID)
+	if err != nil {
+		return err
+	}
+	books := []apiResponseBook{}
+	for _, group := range groups {
+		for _, b := range group.Books {
+			book := apiResponseBook{
+				Title:           b.Title,
+				Authors:         b.Authors,
+				Series:          b.Series,
+				SeriesNumber:    b.SeriesNumber,
+				FileType:        b.FileType,
+				FileSize:        b.FileSize,
+				FileHash:        b.FileHash,
+				IPFSCID:         b.IPFSCID,
+				ResponderPubkey: b.ResponderPubkey,
+			}
+			if b.LocalID.Valid {
+				book.LocalBookID = b.LocalID.Int64
+			}
+			books = append(books, book)
+		}
+	}
+	return apiRespond(wr, r, http.StatusOK, map[string]interface{}{
+		"event_id":  eventID,
+		"responses": books,
+	})
+}
+
+// apiPublishRequest публикует запрос книги и возвращает ID события для получения ответов
+func (w *WebInterface) apiPublishRequest(wr http.ResponseWriter, r *http.Request) error {
+	var body apiBookRequest
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	info := RequestInfo{
+		Author:   body.Author,
+		Series:   body.Series,
+		Title:    body.Title,
+		FileHash: body.FileHash,
+		ISBN:     body.ISBN,
+		Language: body.Language,
+	}
+	if err := validateBookRequest(&info); err != nil {
+		return apiErrorf(http.StatusBadRequest, "%v", err)
+	}
+	eventID, err := w.publishBookRequest(r.Context(), info)
+	if err != nil {
+		return apiErrorf(http.StatusBadGateway, "error publishing request: %v", err)
+	}
+	if eventID == "" {
+		return apiErrorf(http.StatusServiceUnavailable, "request was not published: nostr client is disabled")
+	}
+
+	wr.Header().Set("Location", apiPrefix+"nostr/requests/"+eventID)
+	return apiRespond(wr, r, http.StatusAccepted, map[string]interface{}{
+		"event_id": eventID,
+		"request":  body,
+	})
+}
+
+// apiIPFS обслуживает /api/v1/ipfs/downloads: скачивание книги из IPFS в библиотеку
+func (w *WebInterface) apiIPFS(wr http.ResponseWriter, r *http.Request, rest string) error {
+	cfg := config.GetConfig()
+
+	if rest != "downloads" {
+		return apiErrorf(http.StatusNotFound, "unknown resource: ipfs/%s", rest)
+	}
+	if r.Method != http.MethodPost {
+		return apiMethodNotAllowed(wr, "POST")
+	}
+	if err := w.apiRequire(r, users.PermNostr); err != nil {
+		return err
+	}
+
+	var body apiIPFSDownload
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	if body.FileHash == "" || body.IPFSCID == "" || body.FileType == "" {
+		return apiErrorf(http.StatusBadRequest, "file_hash, ipfs_cid and file_type are required")
+	}
+
+	filePath, existed, err := w.downloadIPFSBook(body.FileHash, body.IPFSCID, body.FileType, body.Title)
+	if err != nil {
+		if filePath == "" {
+			return apiErrorf(http.StatusBadGateway, "IPFS download failed: %v", err)
+		}
+		return apiErrorf(http.StatusUnprocessableEntity, "file downloaded but not registered: %v", err)
+	}
+
+	bookID := w.bookIDByHash(body.FileHash)
+	if bookID == 0 {
+		return apiErrorf(http.StatusUnprocessableEntity, "file downloaded but not found in the library")
+	}
+	if cfg.Debug {
+		log.Printf("API: книга %s из IPFS — ID %d (уже была: %v)", body.FileHash, bookID, existed)
+	}
+
+	book, err := w.apiBookByID(r, bookID)
+	if err != nil {
+		return err
+	}
+	wr.Header().Set("Location", fmt.Sprintf("%sbooks/%d", apiPrefix, bookID))
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	return apiRespond(wr, r, status, book)
+}
@@ -0,0 +1,519 @@
+// web/api_books.go
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/history"
+	"turanga/language"
+	"turanga/scanner"
+	"turanga/search"
+	"turanga/trash"
+	"turanga/users"
+)
+
+// apiBook — книга в ответах API
+type apiBook struct {
+	ID           int64          `json:"id"`
+	Title        string         `json:"title"`
+	Authors      []apiAuthorRef `json:"authors"`
+	Series       string         `json:"series"`
+	SeriesNumber string         `json:"series_number"`
+	Year         string         `json:"year"`
+	Publisher    string         `json:"publisher"`
+	ISBN         string         `json:"isbn"`
+	Tags         []string       `json:"tags"`
+	Genres       []string       `json:"genres"`               // Коды жанров fb2
+	Annotation   *string        `json:"annotation,omitempty"` // только у отдельной книги
+	FileType     string         `json:"file_type"`
+	FileSize     int64          `json:"file_size"`
+	FileHash     string         `json:"file_hash"`
+	IPFSCID      string         `json:"ipfs_cid"`
+	Over18       bool           `json:"over18"`
+	Shared       string         `json:"shared"`
+	Language     string         `json:"language"`
+	SourceLang   string         `json:"source_language"`
+	DownloadURL  string         `json:"download_url"`
+	CoverURL     string         `json:"cover_url,omitempty"`
+}
+
+// apiAuthorRef — автор в составе книги
+type apiAuthorRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiBookPatch — изменяемые поля книги; отсутствующие в запросе поля не меняются
+type apiBookPatch struct {
+	Title        *string   `json:"title"`
+	Authors      *[]string `json:"authors"`
+	Series       *string   `json:"series"`
+	SeriesNumber *string   `json:"series_number"`
+	Year         *string   `json:"year"`
+	Publisher    *string   `json:"publisher"`
+	ISBN         *string   `json:"isbn"`
+	Tags         *[]string `json:"tags"`
+	Annotation   *string   `json:"annotation"`
+	Over18       *bool     `json:"over18"`
+	Shared       *string   `json:"shared"`
+	Language     *string   `json:"language"`
+	SourceLang   *string   `json:"source_language"`
+}
+
+// apiBooks обслуживает /api/v1/books и /api/v1/books/{id}
+func (w *WebInterface) apiBooks(wr http.ResponseWriter, r *http.Request, rest string) error {
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return w.apiListBooks(wr, r)
+		case http.MethodPost:
+			return w.apiCreateBook(wr, r)
+		default:
+			return apiMethodNotAllowed(wr, "GET", "POST")
+		}
+	}
+
+	bookID, err := apiID(rest)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		book, err := w.apiBookByID(r, bookID)
+		if err != nil {
+			return err
+		}
+		return apiRespond(wr, r, http.StatusOK, book)
+	case http.MethodPatch:
+		return w.apiUpdateBook(wr, r, bookID)
+	case http.MethodDelete:
+		return w.apiDeleteBook(wr, r, bookID)
+	default:
+		return apiMethodNotAllowed(wr, "GET", "PATCH", "DELETE")
+	}
+}
+
+// apiListBooks возвращает страницу книг: все по убыванию ID или результаты поиска.
+// Параметры: q (по всем полям), title, author, series, tag, isbn, page, per_page
+func (w *WebInterface) apiListBooks(wr http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPaging(r)
+	if err != nil {
+		return err
+	}
+	policy := access.ForRequest(r)
+	params := r.URL.Query()
+
+	query := search.Query{
+		Text:     params.Get("q"),
+		Title:    params.Get("title"),
+		Author:   params.Get("author"),
+		Series:   params.Get("series"),
+		Tag:      params.Get("tag"),
+		ISBN:     params.Get("isbn"),
+		Language: params.Get("lang"),
+		Access:   policy,
+		Limit:    perPage,
+		Offset:   (page - 1) * perPage,
+	}
+
+	var ids []int64
+	var total int
+	if !query.IsEmpty() {
+		result, err := search.Books(w.db, query)
+		if err != nil {
+			return err
+		}
+		ids, total = result.IDs, result.Total
+	} else {
+		visible := "WHERE 1=1 " + policy.Filter("b")
+		if err := w.db.QueryRow("SELECT COUNT(*) FROM books b " + visible).Scan(&total); err != nil {
+			return fmt.Errorf("ошибка подсчёта книг: %w", err)
+		}
+		rows, err := w.db.Query("SELECT b.id FROM books b "+visible+" ORDER BY b.id DESC LIMIT ? OFFSET ?", perPage, (page-1)*perPage)
+		if err != nil {
+			return fmt.Errorf("ошибка получения списка книг: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return fmt.Errorf("ошибка чтения списка книг: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("ошибка чтения списка книг: %w", err)
+		}
+	}
+
+	books, err := w.apiLoadBooks(ids)
+	if err != nil {
+		return err
+	}
+	return apiRespond(wr, r, http.StatusOK, apiPage{Items: books, Total: total, Page: page, PerPage: perPage})
+}
+
+// apiBookByID возвращает книгу с аннотацией; закрытая для пользователя книга не находится
+func (w *WebInterface) apiBookByID(r *http.Request, bookID int64) (*apiBook, error) {
+	visible, err := access.ForRequest(r).CanSeeBook(w.db, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, apiErrorf(http.StatusNotFound, "book %d not found", bookID)
+	}
+
+	books, err := w.apiLoadBooks([]int64{bookID})
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, apiErrorf(http.StatusNotFound, "book %d not found", bookID)
+	}
+	book := books[0]
+
+	annotation, err := history.Current(w.db, w.rootPath, bookID, history.FieldAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	book.Annotation = &annotation
+	return &book, nil
+}
+
+// apiLoadBooks загружает книги с авторами, тегами и жанрами в порядке ids
+func (w *WebInterface) apiLoadBooks(ids []int64) ([]apiBook, error) {
+	cfg := config.GetConfig()
+
+	books := []apiBook{}
+	if len(ids) == 0 {
+		return books, nil
+	}
+
+	marks, args := search.Placeholders(ids)
+	rows, err := w.db.Query(`
+		SELECT id, IFNULL(title, ''), IFNULL(series, ''), IFNULL(series_number, ''), IFNULL(year, ''),
+		       IFNULL(publisher, ''), IFNULL(isbn, ''), IFNULL(file_type, ''), IFNULL(file_size, 0),
+		       IFNULL(file_hash, ''), IFNULL(ipfs_cid, ''), IFNULL(over18, 0), shared,
+		       IFNULL(language, ''), IFNULL(source_language, '')
+		FROM books WHERE id IN (`+marks+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения книг: %w", err)
+	}
+	for rows.Next() {
+		var b apiBook
+		if err := rows.Scan(&b.ID, &b.Title, &b.Series, &b.SeriesNumber, &b.Year, &b.Publisher, &b.ISBN,
+			&b.FileType, &b.FileSize, &b.FileHash, &b.IPFSCID, &b.Over18, &b.Shared,
+			&b.Language, &b.SourceLang); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка чтения книги: %w", err)
+		}
+		b.Authors = []apiAuthorRef{}
+		b.Tags = []string{}
+		b.Genres = []string{}
+		b.DownloadURL = fmt.Sprintf("/opds-download/%d/%s", b.ID, url.QueryEscape(b.Title+getFileExtensionByType(b.FileType)))
+		b.CoverURL = w.getCoverURLFromFileHash(b.FileHash, cfg)
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+
+	index := make(map[int64]int, len(books))
+	for i, b := range books {
+		index[b.ID] = i
+	}
+
+	rows, err = w.db.Query(`
+		SELECT ba.book_id, a.id, a.full_name FROM book_authors ba
+		JOIN authors a ON a.id = ba.author_id
+		WHERE ba.book_id IN (`+marks+`) ORDER BY ba.rowid`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения авторов книг: %w", err)
+	}
+	for rows.Next() {
+		var bookID int64
+		var author apiAuthorRef
+		if err := rows.Scan(&bookID, &author.ID, &author.Name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка чтения авторов книг: %w", err)
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Authors = append(books[i].Authors, author)
+		}
+	}
+	rows.Close()
+
+	rows, err = w.db.Query(`
+		SELECT bt.book_id, t.name FROM book_tags bt
+		JOIN tags t ON t.id = bt.tag_id
+		WHERE bt.book_id IN (`+marks+`) ORDER BY t.name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения тегов книг: %w", err)
+	}
+	for rows.Next() {
+		var bookID int64
+		var tag string
+		if err := rows.Scan(&bookID, &tag); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка чтения тегов книг: %w", err)
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Tags = append(books[i].Tags, tag)
+		}
+	}
+	rows.Close()
+
+	rows, err = w.db.Query(`
+		SELECT book_id, genre FROM book_genres
+		WHERE book_id IN (`+marks+`) ORDER BY genre`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения жанров книг: %w", err)
+	}
+	for rows.Next() {
+		var bookID int64
+		var genre string
+		if err := rows.Scan(&bookID, &genre); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка чтения жанров книг: %w", err)
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Genres = append(books[i].Genres, genre)
+		}
+	}
+	rows.Close()
+
+	return search.SortByIDs(books, ids, func(b apiBook) int64 { return b.ID }), nil
+}
+
+// apiCreateBook добавляет книгу из файла multipart-поля file
+func (w *WebInterface) apiCreateBook(wr http.ResponseWriter, r *http.Request) error {
+	cfg := config.GetConfig()
+
+	if err := w.apiRequire(r, users.PermUpload); err != nil {
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(wr, r.Body, maxFileSize+apiMaxBodySize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, "multipart field file is required")
+	}
+	defer file.Close()
+
+	// Сохраняем во временный файл: нужен хеш до того, как книга попадёт в каталог
+	tempFile, err := os.CreateTemp("", "upload_*_"+filepath.Base(header.Filename))
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	_, err = io.Copy(tempFile, file)
+	tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения загруженного файла: %w", err)
+	}
+
+	fileHash, err := scanner.FileHash(tempPath)
+	if err != nil {
+		return err
+	}
+	if existingID := w.bookIDByHash(fileHash); existingID != 0 {
+		wr.Header().Set("Location", fmt.Sprintf("%sbooks/%d", apiPrefix, existingID))
+		return apiErrorf(http.StatusConflict, "book already exists: %d", existingID)
+	}
+
+	fileInfo, err := os.Stat(tempPath)
+	if err != nil {
+		return fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+	if err := w.processUploadedBook(tempPath, fileInfo, header.Filename); err != nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "failed to process book: %v", err)
+	}
+
+	bookID := w.bookIDByHash(fileHash)
+	if bookID == 0 {
+		return apiErrorf(http.StatusUnprocessableEntity, "file was not recognized as a book")
+	}
+	if cfg.Debug {
+		log.Printf("API: добавлена книга ID %d из файла %s", bookID, header.Filename)
+	}
+
+	book, err := w.apiBookByID(r, bookID)
+	if err != nil {
+		return err
+	}
+	wr.Header().Set("Location", fmt.Sprintf("%sbooks/%d", apiPrefix, bookID))
+	return apiRespond(wr, r, http.StatusCreated, book)
+}
+
+// apiUpdateBook изменяет поля книги одной операцией журнала
+func (w *WebInterface) apiUpdateBook(wr http.ResponseWriter, r *http.Request, bookID int64) error {
+	cfg := config.GetConfig()
+
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiBookByID(r, bookID)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+
+	var patch apiBookPatch
+	if err := apiDecode(r, &patch); err != nil {
+		return err
+	}
+	if patch.Title != nil && strings.TrimSpace(*patch.Title) == "" {
+		return apiErrorf(http.StatusBadRequest, "title must not be empty")
+	}
+	if patch.Shared != nil {
+		if _, err := access.ParseSharing(*patch.Shared); err != nil {
+			return apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	for _, lang := range []*string{patch.Language, patch.SourceLang} {
+		if lang != nil && strings.TrimSpace(*lang) != "" && language.Normalize(*lang) == "" {
+			return apiErrorf(http.StatusBadRequest, "unknown language: %s", *lang)
+		}
+	}
+
+	// Поля применяются в порядке журнала; серия раньше номера, иначе пустая серия сбросит номер
+	var changes []struct{ field, value string }
+	add := func(field string, value *string) {
+		if value != nil {
+			changes = append(changes, struct{ field, value string }{field, strings.TrimSpace(*value)})
+		}
+	}
+	add(history.FieldTitle, patch.Title)
+	if patch.Authors != nil {
+		joined := strings.Join(*patch.Authors, ",")
+		add(history.FieldAuthors, &joined)
+	}
+	add(history.FieldSeries, patch.Series)
+	add(history.FieldSeriesNumber, patch.SeriesNumber)
+	add(history.FieldYear, patch.Year)
+	add(history.FieldPublisher, patch.Publisher)
+	add(history.FieldISBN, patch.ISBN)
+	if patch.Tags != nil {
+		joined := strings.Join(*patch.Tags, ",")
+		add(history.FieldTags, &joined)
+	}
+	add(history.FieldAnnotation, patch.Annotation)
+	if patch.Over18 != nil {
+		value := "0"
+		if *patch.Over18 {
+			value = "1"
+		}
+		add(history.FieldOver18, &value)
+	}
+	add(history.FieldShared, patch.Shared)
+	addLanguage := func(field string, value *string) {
+		if value != nil {
+			code := language.Normalize(*value)
+			add(field, &code)
+		}
+	}
+	addLanguage(history.FieldLanguage, patch.Language)
+	addLanguage(history.FieldSourceLang, patch.SourceLang)
+
+	// Все поля, кроме аннотации, записываются одной транзакцией: при ошибке книга не меняется
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	operationID := history.NewOperation()
+	for _, change := range changes {
+		if change.field == history.FieldAnnotation {
+			continue
+		}
+		if err := w.apiSetBookField(tx, operationID, bookID, change.field, change.value); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Аннотация хранится в файле и пишется после фиксации
+	if patch.Annotation != nil {
+		if err := w.apiSetAnnotation(operationID, bookID, strings.TrimSpace(*patch.Annotation)); err != nil {
+			return err
+		}
+	}
+	if cfg.Debug {
+		log.Printf("API: книга ID %d, изменено полей: %d", bookID, len(changes))
+	}
+
+	book, err := w.apiBookByID(r, bookID)
+	if err != nil {
+		return err
+	}
+	return apiRespond(wr, r, http.StatusOK, book)
+}
+
+// apiDeleteBook переносит книгу в корзину
+func (w *WebInterface) apiDeleteBook(wr http.ResponseWriter, r *http.Request, bookID int64) error {
+	cfg := config.GetConfig()
+
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiBookByID(r, bookID)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+
+	trashID, err := trash.Move(w.db, w.rootPath, bookID)
+	if errors.Is(err, trash.ErrBookNotFound) {
+		return apiErrorf(http.StatusNotFound, "book %d not found", bookID)
+	} else if err != nil {
+		return err
+	}
+	if cfg.Debug {
+		log.Printf("API: книга ID %d перенесена в корзину (запись %d)", bookID, trashID)
+	}
+
+	wr.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiBookIDs проверяет, что все книги существуют и видны пользователю
+func (w *WebInterface) apiBookIDs(r *http.Request, ids []int64) error {
+	policy := access.ForRequest(r)
+	for _, id := range ids {
+		visible, err := policy.CanSeeBook(w.db, id)
+		if err != nil {
+			return err
+		}
+		if !visible {
+			return apiErrorf(http.StatusNotFound, "book %d not found", id)
+		}
+	}
+	return nil
+}
+
+// apiNotFound превращает sql.ErrNoRows в ответ 404
+func apiNotFound(err error, format string, args ...interface{}) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiErrorf(http.StatusNotFound, format, args...)
+	}
+	return err
+}
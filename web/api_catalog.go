@@ -0,0 +1,916 @@
+// web/api_catalog.go
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/history"
+	"turanga/series"
+	"turanga/users"
+)
+
+// apiAuthor — автор в ответах API
+type apiAuthor struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	SortName  string  `json:"sort_name"`
+	BookCount int     `json:"book_count"`
+	BookIDs   []int64 `json:"book_ids,omitempty"` // только у отдельного автора
+}
+
+// apiSeriesItem — серия в ответах API
+type apiSeriesItem struct {
+	Name          string   `json:"name"`
+	BookCount     int      `json:"book_count"`
+	BookIDs       []int64  `json:"book_ids,omitempty"`       // только у отдельной серии, по порядку номеров
+	ExpectedCount int      `json:"expected_count,omitempty"` // только у отдельной серии
+	Aliases       []string `json:"aliases,omitempty"`        // только у отдельной серии
+	Missing       []int    `json:"missing,omitempty"`        // только у отдельной серии: номера недостающих томов
+}
+
+// apiTag — тег в ответах API
+type apiTag struct {
+	Name       string  `json:"name"`
+	Restricted bool    `json:"restricted"`
+	Shared     string  `json:"shared"`
+	BookCount  int     `json:"book_count"`
+	BookIDs    []int64 `json:"book_ids,omitempty"` // только у отдельного тега
+}
+
+// apiCatalogCreate — тело POST для автора, серии и тега
+type apiCatalogCreate struct {
+	Name       string  `json:"name"`
+	BookIDs    []int64 `json:"book_ids"`
+	Restricted *bool   `json:"restricted"` // только для тегов
+	Shared     *string `json:"shared"`     // только для тегов
+}
+
+// apiCatalogPatch — тело PATCH для автора, серии и тега
+type apiCatalogPatch struct {
+	Name          *string   `json:"name"`
+	SortName      *string   `json:"sort_name"`      // только для авторов
+	Restricted    *bool     `json:"restricted"`     // только для тегов
+	Shared        *string   `json:"shared"`         // только для тегов
+	ExpectedCount *int      `json:"expected_count"` // только для серий
+	Aliases       *[]string `json:"aliases"`        // только для серий
+}
+
+// apiAuthors обслуживает /api/v1/authors и /api/v1/authors/{id}
+func (w *WebInterface) apiAuthors(wr http.ResponseWriter, r *http.Request, rest string) error {
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return w.apiListAuthors(wr, r)
+		case http.MethodPost:
+			return w.apiCreateAuthor(wr, r)
+		default:
+			return apiMethodNotAllowed(wr, "GET", "POST")
+		}
+	}
+
+	authorID, err := apiID(rest)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		author, err := w.apiAuthorByID(r, authorID)
+		if err != nil {
+			return err
+		}
+		return apiRespond(wr, r, http.StatusOK, author)
+	case http.MethodPatch:
+		return w.apiUpdateAuthor(wr, r, authorID)
+	case http.MethodDelete:
+		return w.apiDeleteAuthor(wr, r, authorID)
+	default:
+		return apiMethodNotAllowed(wr, "GET", "PATCH", "DELETE")
+	}
+}
+
+// apiListAuthors возвращает страницу авторов, у которых есть видимые книги; q — часть имени
+func (w *WebInterface) apiListAuthors(wr http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPaging(r)
+	if err != nil {
+		return err
+	}
+
+	from := `FROM authors a
+		JOIN book_authors ba ON ba.author_id = a.id
+		JOIN books b ON b.id = ba.book_id
+		WHERE 1=1 ` + access.ForRequest(r).Filter("b")
+	var args []interface{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		from += " AND a.full_name_lower LIKE ?"
+		args = append(args, "%"+strings.ToLower(q)+"%")
+	}
+	from += " GROUP BY a.id"
+
+	var total int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM (SELECT a.id "+from+")", args...).Scan(&total); err != nil {
+		return fmt.Errorf("ошибка подсчёта авторов: %w", err)
+	}
+
+	rows, err := w.db.Query(`SELECT a.id, a.full_name, IFNULL(a.last_name_lower, ''), COUNT(DISTINCT b.id) `+from+`
+		ORDER BY a.last_name_lower, a.full_name_lower LIMIT ? OFFSET ?`,
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		return fmt.Errorf("ошибка получения авторов: %w", err)
+	}
+	defer rows.Close()
+
+	authors := []apiAuthor{}
+	for rows.Next() {
+		var a apiAuthor
+		if err := rows.Scan(&a.ID, &a.Name, &a.SortName, &a.BookCount); err != nil {
+			return fmt.Errorf("ошибка чтения автора: %w", err)
+		}
+		authors = append(authors, a)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения авторов: %w", err)
+	}
+	return apiRespond(wr, r, http.StatusOK, apiPage{Items: authors, Total: total, Page: page, PerPage: perPage})
+}
+
+// apiAuthorByID возвращает автора с ID его видимых книг; автор без видимых книг не находится
+func (w *WebInterface) apiAuthorByID(r *http.Request, authorID int64) (*apiAuthor, error) {
+	author := &apiAuthor{ID: authorID}
+	err := w.db.QueryRow("SELECT full_name, IFNULL(last_name_lower, '') FROM authors WHERE id = ?", authorID).
+		Scan(&author.Name, &author.SortName)
+	if err != nil {
+		return nil, apiNotFound(err, "author %d not found", authorID)
+	}
+
+	author.BookIDs, err = w.apiQueryIDs(`SELECT b.id FROM books b
+		JOIN book_authors ba ON ba.book_id = b.id
+		WHERE ba.author_id = ? `+access.ForRequest(r).Filter("b")+` ORDER BY b.series_lower, b.series_index IS NULL, b.series_index, b.title_lower`, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(author.BookIDs) == 0 {
+		return nil, apiErrorf(http.StatusNotFound, "author %d not found", authorID)
+	}
+	author.BookCount = len(author.BookIDs)
+	return author, nil
+}
+
+// apiCreateAuthor добавляет автора к книгам из book_ids; если автора ещё нет, он создаётся
+func (w *WebInterface) apiCreateAuthor(wr http.ResponseWriter, r *http.Request) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	var body apiCatalogCreate
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || strings.Contains(name, ",") {
+		return apiErrorf(http.StatusBadRequest, "name must be non-empty and must not contain commas")
+	}
+	if len(body.BookIDs) == 0 {
+		return apiErrorf(http.StatusBadRequest, "book_ids must not be empty: an author without books is not kept")
+	}
+	if err := w.apiBookIDs(r, body.BookIDs); err != nil {
+		return err
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	operationID := history.NewOperation()
+	for _, bookID := range body.BookIDs {
+		current, err := history.Current(tx, w.rootPath, bookID, history.FieldAuthors)
+		if err != nil {
+			return err
+		}
+		names := history.SplitList(current)
+		if containsFold(names, name) {
+			continue
+		}
+		if err := w.apiSetBookField(tx, operationID, bookID, history.FieldAuthors, strings.Join(append(names, name), ",")); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	var authorID int64
+	if err := w.db.QueryRow("SELECT id FROM authors WHERE full_name = ?", name).Scan(&authorID); err != nil {
+		return fmt.Errorf("ошибка поиска созданного автора %s: %w", name, err)
+	}
+	author, err := w.apiAuthorByID(r, authorID)
+	if err != nil {
+		return err
+	}
+	wr.Header().Set("Location", fmt.Sprintf("%sauthors/%d", apiPrefix, authorID))
+	return apiRespond(wr, r, http.StatusCreated, author)
+}
+
+// apiUpdateAuthor переименовывает автора; при совпадении имени с другим автором они объединяются
+func (w *WebInterface) apiUpdateAuthor(wr http.ResponseWriter, r *http.Request, authorID int64) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiAuthorByID(r, authorID)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+	var patch apiCatalogPatch
+	if err := apiDecode(r, &patch); err != nil {
+		return err
+	}
+
+	name := current.Name
+	if patch.Name != nil {
+		name = strings.TrimSpace(*patch.Name)
+	}
+	sortName := ""
+	if patch.SortName != nil {
+		sortName = strings.TrimSpace(*patch.SortName)
+	} else if patch.Name == nil {
+		sortName = current.SortName
+	}
+	if name == "" {
+		return apiErrorf(http.StatusBadRequest, "name must not be empty")
+	}
+
+	targetID, err := w.renameAuthor(history.OriginAPI, int(authorID), name, sortName)
+	if err != nil {
+		return err
+	}
+	author, err := w.apiAuthorByID(r, int64(targetID))
+	if err != nil {
+		return err
+	}
+	return apiRespond(wr, r, http.StatusOK, author)
+}
+
+// apiDeleteAuthor убирает автора из всех его книг; сами книги остаются
+func (w *WebInterface) apiDeleteAuthor(wr http.ResponseWriter, r *http.Request, authorID int64) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiAuthorByID(r, authorID)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+
+	snapshot := w.snapshotBooks(history.FieldAuthors, "SELECT book_id FROM book_authors WHERE author_id = ?", authorID)
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM book_authors WHERE author_id = ?", authorID); err != nil {
+		return fmt.Errorf("ошибка удаления связей автора %d: %w", authorID, err)
+	}
+	if _, err := tx.Exec("DELETE FROM authors WHERE id = ?", authorID); err != nil {
+		return fmt.Errorf("ошибка удаления автора %d: %w", authorID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	w.logSnapshotChanges(history.OriginAPI, history.FieldAuthors, snapshot)
+
+	wr.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiSeries обслуживает /api/v1/series и /api/v1/series/{name}
+func (w *WebInterface) apiSeries(wr http.ResponseWriter, r *http.Request, rest string) error {
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return w.apiListSeries(wr, r)
+		case http.MethodPost:
+			return w.apiCreateSeries(wr, r)
+		default:
+			return apiMethodNotAllowed(wr, "GET", "POST")
+		}
+	}
+
+	name, err := url.PathUnescape(rest)
+	if err != nil || name == "" {
+		return apiErrorf(http.StatusBadRequest, "invalid series name")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		item, err := w.apiSeriesByName(r, name)
+		if err != nil {
+			return err
+		}
+		return apiRespond(wr, r, http.StatusOK, item)
+	case http.MethodPatch:
+		return w.apiUpdateSeries(wr, r, name)
+	case http.MethodDelete:
+		return w.apiDeleteSeries(wr, r, name)
+	default:
+		return apiMethodNotAllowed(wr, "GET", "PATCH", "DELETE")
+	}
+}
+
+// apiListSeries возвращает страницу серий с видимыми книгами; q — часть названия
+func (w *WebInterface) apiListSeries(wr http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPaging(r)
+	if err != nil {
+		return err
+	}
+
+	from := "FROM books b WHERE IFNULL(b.series, '') != '' " + access.ForRequest(r).Filter("b")
+	var args []interface{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		from += " AND b.series_lower LIKE ?"
+		args = append(args, "%"+strings.ToLower(q)+"%")
+	}
+	from += " GROUP BY b.series"
+
+	var total int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM (SELECT b.series "+from+")", args...).Scan(&total); err != nil {
+		return fmt.Errorf("ошибка подсчёта серий: %w", err)
+	}
+
+	rows, err := w.db.Query("SELECT b.series, COUNT(*) "+from+" ORDER BY MIN(b.series_lower) LIMIT ? OFFSET ?",
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		return fmt.Errorf("ошибка получения серий: %w", err)
+	}
+	defer rows.Close()
+
+	list := []apiSeriesItem{}
+	for rows.Next() {
+		var s apiSeriesItem
+		if err := rows.Scan(&s.Name, &s.BookCount); err != nil {
+			return fmt.Errorf("ошибка чтения серии: %w", err)
+		}
+		list = append(list, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения серий: %w", err)
+	}
+	return apiRespond(wr, r, http.StatusOK, apiPage{Items: list, Total: total, Page: page, PerPage: perPage})
+}
+
+// apiSeriesByName возвращает серию с ID видимых книг по порядку номеров.
+// Псевдоним серии возвращает серию под каноническим названием.
+func (w *WebInterface) apiSeriesByName(r *http.Request, name string) (*apiSeriesItem, error) {
+	info, err := series.Find(w.db, name)
+	if err != nil && !errors.Is(err, series.ErrNotFound) {
+		return nil, err
+	}
+	if info != nil {
+		name = info.Name
+	}
+
+	policy := access.ForRequest(r)
+	ids, err := w.apiQueryIDs("SELECT b.id FROM books b WHERE b.series = ? "+policy.Filter("b")+
+		" ORDER BY b.series_index IS NULL, b.series_index, b.title_lower", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, apiErrorf(http.StatusNotFound, "series %q not found", name)
+	}
+	item := &apiSeriesItem{Name: name, BookCount: len(ids), BookIDs: ids}
+	if info != nil {
+		volumes, err := w.seriesVolumes(info, policy)
+		if err != nil {
+			return nil, err
+		}
+		item.ExpectedCount = volumes.Expected
+		item.Aliases = volumes.Aliases
+		item.Missing = volumes.Missing
+	}
+	return item, nil
+}
+
+// apiCreateSeries помещает книги из book_ids в серию с указанным названием
+func (w *WebInterface) apiCreateSeries(wr http.ResponseWriter, r *http.Request) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	var body apiCatalogCreate
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || strings.Contains(name, "|") {
+		return apiErrorf(http.StatusBadRequest, "name must be non-empty and must not contain '|'")
+	}
+	if len(body.BookIDs) == 0 {
+		return apiErrorf(http.StatusBadRequest, "book_ids must not be empty")
+	}
+	if err := w.apiBookIDs(r, body.BookIDs); err != nil {
+		return err
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	operationID := history.NewOperation()
+	for _, bookID := range body.BookIDs {
+		if err := w.apiSetBookField(tx, operationID, bookID, history.FieldSeries, name); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	item, err := w.apiSeriesByName(r, name)
+	if err != nil {
+		return err
+	}
+	wr.Header().Set("Location", apiPrefix+"series/"+url.PathEscape(item.Name))
+	return apiRespond(wr, r, http.StatusCreated, item)
+}
+
+// apiUpdateSeries переименовывает серию во всех книгах и меняет ожидаемое число
+// томов и псевдонимы
+func (w *WebInterface) apiUpdateSeries(wr http.ResponseWriter, r *http.Request, name string) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiSeriesByName(r, name)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+	var patch apiCatalogPatch
+	if err := apiDecode(r, &patch); err != nil {
+		return err
+	}
+	if patch.Name == nil && patch.ExpectedCount == nil && patch.Aliases == nil {
+		return apiErrorf(http.StatusBadRequest, "nothing to update")
+	}
+	if patch.ExpectedCount != nil && *patch.ExpectedCount < 0 {
+		return apiErrorf(http.StatusBadRequest, "expected_count must not be negative")
+	}
+
+	name = current.Name
+	if patch.Name != nil {
+		newName := strings.TrimSpace(*patch.Name)
+		if newName == "" {
+			return apiErrorf(http.StatusBadRequest, "name must not be empty")
+		}
+		name, err = w.renameSeries(history.OriginAPI, current.Name, newName)
+		if err != nil {
+			return fmt.Errorf("ошибка переименования серии '%s' в '%s': %w", current.Name, newName, err)
+		}
+	}
+	if patch.ExpectedCount != nil || patch.Aliases != nil {
+		expected, aliases := current.ExpectedCount, current.Aliases
+		if patch.ExpectedCount != nil {
+			expected = *patch.ExpectedCount
+		}
+		if patch.Aliases != nil {
+			aliases = *patch.Aliases
+		}
+		id, canonical, err := series.Resolve(w.db, name)
+		if err == nil {
+			err = w.saveSeriesDetails(id, canonical, expected, aliases)
+		}
+		if errors.Is(err, series.ErrAliasTaken) {
+			return apiErrorf(http.StatusConflict, "%v", err)
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения данных серии '%s': %w", name, err)
+		}
+	}
+
+	item, err := w.apiSeriesByName(r, name)
+	if err != nil {
+		return err
+	}
+	return apiRespond(wr, r, http.StatusOK, item)
+}
+
+// apiDeleteSeries убирает серию и номер в серии у всех её книг
+func (w *WebInterface) apiDeleteSeries(wr http.ResponseWriter, r *http.Request, name string) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiSeriesByName(r, name)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+
+	// Закрытые книги серии тоже выходят из неё, иначе серия осталась бы в каталоге
+	ids, err := w.apiQueryIDs("SELECT id FROM books WHERE series = ?", current.Name)
+	if err != nil {
+		return err
+	}
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	operationID := history.NewOperation()
+	for _, bookID := range ids {
+		if err := w.apiSetBookField(tx, operationID, bookID, history.FieldSeries, ""); err != nil {
+			return err
+		}
+		if err := w.apiSetBookField(tx, operationID, bookID, history.FieldSeriesNumber, ""); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wr.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiTags обслуживает /api/v1/tags и /api/v1/tags/{name}
+func (w *WebInterface) apiTags(wr http.ResponseWriter, r *http.Request, rest string) error {
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return w.apiListTags(wr, r)
+		case http.MethodPost:
+			return w.apiCreateTag(wr, r)
+		default:
+			return apiMethodNotAllowed(wr, "GET", "POST")
+		}
+	}
+
+	name, err := url.PathUnescape(rest)
+	if err != nil || name == "" {
+		return apiErrorf(http.StatusBadRequest, "invalid tag name")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		tag, err := w.apiTagByName(r, name)
+		if err != nil {
+			return err
+		}
+		return apiRespond(wr, r, http.StatusOK, tag)
+	case http.MethodPatch:
+		return w.apiUpdateTag(wr, r, name)
+	case http.MethodDelete:
+		return w.apiDeleteTag(wr, r, name)
+	default:
+		return apiMethodNotAllowed(wr, "GET", "PATCH", "DELETE")
+	}
+}
+
+// apiListTags возвращает страницу тегов с числом видимых книг; закрытые теги видны
+// только тем, кому разрешены закрытые книги
+func (w *WebInterface) apiListTags(wr http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPaging(r)
+	if err != nil {
+		return err
+	}
+	policy := access.ForRequest(r)
+
+	where := "WHERE 1=1 " + policy.TagFilter("t")
+	var args []interface{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		where += " AND LOWER(t.name) LIKE ?"
+		args = append(args, "%"+strings.ToLower(q)+"%")
+	}
+
+	var total int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM tags t "+where, args...).Scan(&total); err != nil {
+		return fmt.Errorf("ошибка подсчёта тегов: %w", err)
+	}
+
+	rows, err := w.db.Query(`
+		SELECT t.name, IFNULL(t.restricted, 0), t.shared, COUNT(DISTINCT b.id)
+		FROM tags t
+		LEFT JOIN book_tags bt ON bt.tag_id = t.id
+		LEFT JOIN books b ON b.id = bt.book_id `+policy.Filter("b")+`
+		`+where+` GROUP BY t.id ORDER BY t.name LIMIT ? OFFSET ?`,
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		return fmt.Errorf("ошибка получения тегов: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []apiTag{}
+	for rows.Next() {
+		var t apiTag
+		if err := rows.Scan(&t.Name, &t.Restricted, &t.Shared, &t.BookCount); err != nil {
+			return fmt.Errorf("ошибка чтения тега: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения тегов: %w", err)
+	}
+	return apiRespond(wr, r, http.StatusOK, apiPage{Items: tags, Total: total, Page: page, PerPage: perPage})
+}
+
+// apiTagByName возвращает тег с ID видимых книг
+func (w *WebInterface) apiTagByName(r *http.Request, name string) (*apiTag, error) {
+	policy := access.ForRequest(r)
+
+	var tagID int64
+	tag := &apiTag{Name: name}
+	err := w.db.QueryRow("SELECT t.id, IFNULL(t.restricted, 0), t.shared FROM tags t WHERE t.name = ? "+policy.TagFilter("t"), name).
+		Scan(&tagID, &tag.Restricted, &tag.Shared)
+	if err != nil {
+		return nil, apiNotFound(err, "tag %q not found", name)
+	}
+
+	tag.BookIDs, err = w.apiQueryIDs(`SELECT b.id FROM books b
+		JOIN book_tags bt ON bt.book_id = b.id
+		WHERE bt.tag_id = ? `+policy.Filter("b")+` ORDER BY b.id DESC`, tagID)
+	if err != nil {
+		return nil, err
+	}
+	tag.BookCount = len(tag.BookIDs)
+	return tag, nil
+}
+
+// apiCreateTag создаёт тег и добавляет его книгам из book_ids.
+// В отличие от авторов и серий, тег может существовать без книг: он хранит свои настройки
+func (w *WebInterface) apiCreateTag(wr http.ResponseWriter, r *http.Request) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	var body apiCatalogCreate
+	if err := apiDecode(r, &body); err != nil {
+		return err
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || strings.Contains(name, ",") || len(name) > 16 {
+		return apiErrorf(http.StatusBadRequest, "name must be 1-16 bytes long and must not contain commas")
+	}
+	if err := w.apiBookIDs(r, body.BookIDs); err != nil {
+		return err
+	}
+
+	if _, err := w.db.Exec("INSERT INTO tags (name) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM tags WHERE name = ?)", name, name); err != nil {
+		return fmt.Errorf("ошибка создания тега %s: %w", name, err)
+	}
+	if err := w.apiApplyTagSettings(name, body.Restricted, body.Shared); err != nil {
+		return err
+	}
+
+	operationID := history.NewOperation()
+	for _, bookID := range body.BookIDs {
+		oldValue, err := history.Current(w.db, w.rootPath, bookID, history.FieldTags)
+		if err != nil {
+			return err
+		}
+		if err := w.addTagToBook(int(bookID), name); err != nil {
+			return fmt.Errorf("ошибка добавления тега %s книге ID %d: %w", name, bookID, err)
+		}
+		w.logBookChange(operationID, history.OriginAPI, int(bookID), history.FieldTags, oldValue)
+	}
+
+	tag, err := w.apiTagByName(r, name)
+	if err != nil {
+		return err
+	}
+	wr.Header().Set("Location", apiPrefix+"tags/"+url.PathEscape(name))
+	return apiRespond(wr, r, http.StatusCreated, tag)
+}
+
+// apiUpdateTag переименовывает тег и меняет его настройки доступа.
+// При совпадении нового имени с существующим тегом они объединяются
+func (w *WebInterface) apiUpdateTag(wr http.ResponseWriter, r *http.Request, name string) error {
+	cfg := config.GetConfig()
+
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiTagByName(r, name)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+	var patch apiCatalogPatch
+	if err := apiDecode(r, &patch); err != nil {
+		return err
+	}
+	if patch.SortName != nil {
+		return apiErrorf(http.StatusBadRequest, "sort_name is not supported for tags")
+	}
+
+	if err := w.apiApplyTagSettings(name, patch.Restricted, patch.Shared); err != nil {
+		return err
+	}
+
+	if patch.Name != nil && strings.TrimSpace(*patch.Name) != name {
+		newName := strings.TrimSpace(*patch.Name)
+		if newName == "" || strings.Contains(newName, ",") || len(newName) > 16 {
+			return apiErrorf(http.StatusBadRequest, "name must be 1-16 bytes long and must not contain commas")
+		}
+		if err := w.renameTag(name, newName); err != nil {
+			return err
+		}
+		if cfg.Debug {
+			log.Printf("API: тег '%s' переименован в '%s'", name, newName)
+		}
+		name = newName
+	}
+
+	tag, err := w.apiTagByName(r, name)
+	if err != nil {
+		return err
+	}
+	return apiRespond(wr, r, http.StatusOK, tag)
+}
+
+// apiDeleteTag удаляет тег у всех книг и сам тег вместе с его настройками
+func (w *WebInterface) apiDeleteTag(wr http.ResponseWriter, r *http.Request, name string) error {
+	if err := w.apiRequire(r, users.PermEdit); err != nil {
+		return err
+	}
+	current, err := w.apiTagByName(r, name)
+	if err != nil {
+		return err
+	}
+	if err := apiCheckIfMatch(r, current); err != nil {
+		return err
+	}
+
+	tagBooks := "SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name = ?"
+	snapshot := w.snapshotBooks(history.FieldTags, tagBooks, name)
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM book_tags WHERE tag_id IN (SELECT id FROM tags WHERE name = ?)", name); err != nil {
+		return fmt.Errorf("ошибка удаления связей тега %s: %w", name, err)
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE name = ?", name); err != nil {
+		return fmt.Errorf("ошибка удаления тега %s: %w", name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	w.logSnapshotChanges(history.OriginAPI, history.FieldTags, snapshot)
+
+	wr.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// renameTag переименовывает тег; если тег с новым именем уже есть, книги переходят к нему
+func (w *WebInterface) renameTag(oldName, newName string) error {
+	tagBooks := "SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name = ?"
+	snapshot := w.snapshotBooks(history.FieldTags, tagBooks, oldName)
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldID, existingID int64
+	if err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", oldName).Scan(&oldID); err != nil {
+		return apiNotFound(err, "tag %q not found", oldName)
+	}
+	err = tx.QueryRow("SELECT id FROM tags WHERE name = ?", newName).Scan(&existingID)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := tx.Exec("UPDATE tags SET name = ? WHERE id = ?", newName, oldID); err != nil {
+			return fmt.Errorf("ошибка переименования тега %s: %w", oldName, err)
+		}
+	case err != nil:
+		return fmt.Errorf("ошибка поиска тега %s: %w", newName, err)
+	default:
+		// Тег, в который вливается старый, получает более строгие из их настроек,
+		// чтобы книги закрытого тега не открылись после объединения
+		var oldRestricted, existingRestricted int
+		var oldShared, existingShared string
+		if err := tx.QueryRow("SELECT restricted, shared FROM tags WHERE id = ?", oldID).Scan(&oldRestricted, &oldShared); err != nil {
+			return fmt.Errorf("ошибка чтения настроек тега %s: %w", oldName, err)
+		}
+		if err := tx.QueryRow("SELECT restricted, shared FROM tags WHERE id = ?", existingID).Scan(&existingRestricted, &existingShared); err != nil {
+			return fmt.Errorf("ошибка чтения настроек тега %s: %w", newName, err)
+		}
+		shared := access.StricterSharing(access.Sharing(existingShared), access.Sharing(oldShared))
+		if _, err := tx.Exec("UPDATE tags SET restricted = ?, shared = ? WHERE id = ?",
+			max(oldRestricted, existingRestricted), string(shared), existingID); err != nil {
+			return fmt.Errorf("ошибка переноса настроек к тегу %s: %w", newName, err)
+		}
+
+		// Книги, у которых уже есть оба тега, остаются с одним
+		if _, err := tx.Exec("UPDATE OR IGNORE book_tags SET tag_id = ? WHERE tag_id = ?", existingID, oldID); err != nil {
+			return fmt.Errorf("ошибка переноса книг к тегу %s: %w", newName, err)
+		}
+		if _, err := tx.Exec("DELETE FROM book_tags WHERE tag_id = ?", oldID); err != nil {
+			return fmt.Errorf("ошибка удаления связей тега %s: %w", oldName, err)
+		}
+		if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", oldID); err != nil {
+			return fmt.Errorf("ошибка удаления тега %s: %w", oldName, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	w.logSnapshotChanges(history.OriginAPI, history.FieldTags, snapshot)
+	return nil
+}
+
+// apiApplyTagSettings меняет закрытость тега и доступ к его книгам через Nostr
+func (w *WebInterface) apiApplyTagSettings(name string, restricted *bool, shared *string) error {
+	if shared != nil {
+		sharing, err := access.ParseSharing(*shared)
+		if err != nil {
+			return apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+		if err := access.SetTagSharing(w.db, name, sharing); err != nil {
+			return err
+		}
+	}
+	if restricted != nil {
+		if err := access.SetTagRestricted(w.db, name, *restricted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiSetBookField изменяет поле книги в транзакции tx и записывает изменение в журнал
+// как сделанное через API. Аннотация хранится в файле и изменяется через apiSetAnnotation.
+func (w *WebInterface) apiSetBookField(tx *sql.Tx, operationID string, bookID int64, field, value string) error {
+	oldValue, err := history.Current(tx, w.rootPath, bookID, field)
+	if err != nil {
+		return err
+	}
+	if err := history.SetField(tx, bookID, field, value); err != nil {
+		return fmt.Errorf("ошибка изменения поля %s книги ID %d: %w", field, bookID, err)
+	}
+	newValue, err := history.Current(tx, w.rootPath, bookID, field)
+	if err != nil {
+		return err
+	}
+	return history.Log(tx, operationID, history.OriginAPI, history.Change{
+		BookID: bookID, Field: field, OldValue: oldValue, NewValue: newValue,
+	})
+}
+
+// apiSetAnnotation изменяет аннотацию книги и записывает изменение в журнал как сделанное через API
+func (w *WebInterface) apiSetAnnotation(operationID string, bookID int64, annotation string) error {
+	oldValue, err := history.Current(w.db, w.rootPath, bookID, history.FieldAnnotation)
+	if err != nil {
+		log.Printf("Предупреждение: не удалось прочитать аннотацию книги ID %d для журнала: %v", bookID, err)
+	}
+	if err := w.updateBookAnnotation(int(bookID), annotation); err != nil {
+		return fmt.Errorf("ошибка изменения аннотации книги ID %d: %w", bookID, err)
+	}
+	w.logBookChange(operationID, history.OriginAPI, int(bookID), history.FieldAnnotation, oldValue)
+	return nil
+}
+
+// apiQueryIDs выполняет запрос, возвращающий столбец ID
+func (w *WebInterface) apiQueryIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := w.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка чтения результата: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// containsFold проверяет, есть ли строка в списке без учёта регистра
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,32 +2,124 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// Вход хранится в серверной сессии (см. users/sessions.go). Cookie session содержит
+// случайный токен и недоступна скриптам; cookie csrf содержит CSRF-токен сессии,
+// который csrf.js добавляет к формам и запросам fetch.
+
+const (
+	sessionCookieName = "session"
+	csrfCookieName    = "csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+	csrfFieldName     = "csrf_token"
 )
 
+// contextKey — ключ сессии в контексте запроса; пользователь хранится через users.NewContext
+type contextKey int
+
+const sessionContextKey contextKey = 0
+
+// SessionMiddleware определяет пользователя по cookie сессии и проверяет
+// CSRF-токен у изменяющих запросов вошедшего пользователя
+func (w *WebInterface) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			next.ServeHTTP(wr, r)
+			return
+		}
+
+		user, session, err := users.SessionUser(w.db, cookie.Value)
+		if err != nil {
+			if !errors.Is(err, users.ErrNotFound) {
+				log.Printf("Ошибка проверки сессии: %v", err)
+			} else {
+				// Сессия истекла или завершена на другом устройстве
+				clearSessionCookies(wr, r)
+			}
+			next.ServeHTTP(wr, r)
+			return
+		}
+
+		if !isSafeMethod(r.Method) && !session.CheckCSRF(csrfToken(r)) {
+			if config.GetConfig().Debug {
+				log.Printf("Отклонён запрос %s %s без действительного CSRF-токена", r.Method, r.URL.Path)
+			}
+			http.Error(wr, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		ctx := users.NewContext(r.Context(), user)
+		ctx = context.WithValue(ctx, sessionContextKey, session)
+		next.ServeHTTP(wr, r.WithContext(ctx))
+	})
+}
+
+// currentUser возвращает вошедшего пользователя или nil для гостя
+func (w *WebInterface) currentUser(r *http.Request) *users.User {
+	return users.FromContext(r.Context())
+}
+
+// currentSession возвращает сессию текущего запроса или nil для гостя
+func (w *WebInterface) currentSession(r *http.Request) *users.Session {
+	session, _ := r.Context().Value(sessionContextKey).(*users.Session)
+	return session
+}
+
+// isAuthenticated проверяет, выполнен ли вход
+func (w *WebInterface) isAuthenticated(r *http.Request) bool {
+	return w.currentUser(r) != nil
+}
+
+// can проверяет, разрешено ли действие текущему пользователю
+func (w *WebInterface) can(r *http.Request, p users.Permission) bool {
+	return w.currentUser(r).Can(p)
+}
+
 // AuthHandler обрабатывает запросы аутентификации
 func (w *WebInterface) AuthHandler(wr http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		// Показываем форму ввода пароля
+		// Показываем форму входа
 		w.showAuthForm(wr, r, "")
 	case http.MethodPost:
-		// Проверяем пароль
+		// Проверяем логин и пароль
 		w.checkPassword(wr, r)
 	default:
 		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// showAuthForm показывает форму ввода пароля
+// showAuthForm показывает форму входа или, если пользователей ещё нет, форму создания администратора
 func (w *WebInterface) showAuthForm(wr http.ResponseWriter, r *http.Request, errorMessage string) {
+	count, err := users.Count(w.db)
+	if err != nil {
+		log.Printf("Ошибка подсчёта пользователей: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	data := struct {
 		ErrorMessage string
+		Setup        bool
+		SetupAllowed bool
 	}{
 		ErrorMessage: errorMessage,
+		Setup:        count == 0,
+		SetupAllowed: isLocalRequest(r),
 	}
 
 	// Загружаем шаблон из файла
@@ -50,62 +142,179 @@ func (w *WebInterface) showAuthForm(wr http.ResponseWriter, r *http.Request, err
 	}
 }
 
-// checkPassword проверяет введенный пароль
+// checkPassword проверяет введенные логин и пароль
 func (w *WebInterface) checkPassword(wr http.ResponseWriter, r *http.Request) {
+	login := strings.TrimSpace(r.FormValue("login"))
 	password := r.FormValue("password")
-	if password == "" {
-		w.showAuthForm(wr, r, "Пароль не может быть пустым")
+	if login == "" || password == "" {
+		w.showAuthForm(wr, r, "Логин и пароль не могут быть пустыми")
+		return
+	}
+
+	count, err := users.Count(w.db)
+	if err != nil {
+		log.Printf("Ошибка подсчёта пользователей: %v", err)
+		w.showAuthForm(wr, r, "Ошибка базы данных")
 		return
 	}
 
-	// Если хэш пароля еще не установлен
-	if w.config.PasswordHash == "" {
-		// Создаем новый хэш и сохраняем в конфиг
-		hash := HashPassword(password)
-		w.config.PasswordHash = hash
-		if err := w.config.SaveConfig("turanga.conf"); err != nil {
-			log.Printf("Error saving config: %v", err)
-			w.showAuthForm(wr, r, "Ошибка сохранения конфигурации")
+	var user *users.User
+	if count == 0 {
+		// Первого администратора можно создать только с того же компьютера,
+		// иначе им стал бы любой, кто первым откроет страницу входа
+		if !isLocalRequest(r) {
+			w.showAuthForm(wr, r, "Первую учётную запись можно создать только с локального адреса")
+			return
+		}
+		user, err = users.Create(w.db, login, password, users.RoleAdmin)
+		if err != nil {
+			log.Printf("Ошибка создания администратора: %v", err)
+			w.showAuthForm(wr, r, "Ошибка создания учётной записи")
+			return
+		}
+		log.Printf("Создан администратор %s", user.Login)
+	} else {
+		user, err = users.Authenticate(w.db, login, password)
+		if errors.Is(err, users.ErrInvalidCredentials) {
+			w.showAuthForm(wr, r, "Неверный логин или пароль")
+			return
+		} else if err != nil {
+			log.Printf("Ошибка проверки пароля: %v", err)
+			w.showAuthForm(wr, r, "Ошибка базы данных")
 			return
 		}
-		// Устанавливаем cookie и перенаправляем
-		http.SetCookie(wr, &http.Cookie{
-			Name:  "auth",
-			Value: hash,
-			Path:  "/",
-		})
-		http.Redirect(wr, r, "/", http.StatusSeeOther)
-		return
 	}
 
-	// Проверяем хэш
-	inputHash := HashPassword(password)
-	if inputHash != w.config.PasswordHash {
-		w.showAuthForm(wr, r, "Неверный пароль")
+	if err := w.startSession(wr, r, user); err != nil {
+		log.Printf("Ошибка создания сессии: %v", err)
+		w.showAuthForm(wr, r, "Ошибка базы данных")
 		return
 	}
 
-	// Устанавливаем cookie
-	http.SetCookie(wr, &http.Cookie{
-		Name:  "auth",
-		Value: inputHash,
-		Path:  "/",
-	})
-
 	// Перенаправляем на главную
 	http.Redirect(wr, r, "/", http.StatusSeeOther)
 }
 
-// logoutHandler обрабатывает выход из системы
+// LogoutHandler завершает текущую сессию
 func (w *WebInterface) LogoutHandler(wr http.ResponseWriter, r *http.Request) {
-	// Удаляем cookie
-	http.SetCookie(wr, &http.Cookie{
-		Name:   "auth",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := users.DeleteSession(w.db, cookie.Value); err != nil {
+			log.Printf("Ошибка завершения сессии: %v", err)
+		}
+	}
+	clearSessionCookies(wr, r)
 
 	// Перенаправляем на главную
 	http.Redirect(wr, r, "/", http.StatusSeeOther)
 }
+
+// LogoutAllHandler завершает все сессии текущего пользователя
+// URL: POST /logout/all
+func (w *WebInterface) LogoutAllHandler(wr http.ResponseWriter, r *http.Request) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := users.DeleteUserSessions(w.db, user.ID); err != nil {
+		log.Printf("Ошибка завершения сессий пользователя %s: %v", user.Login, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Пользователь %s вышел на всех устройствах", user.Login)
+	clearSessionCookies(wr, r)
+
+	http.Redirect(wr, r, "/", http.StatusSeeOther)
+}
+
+// startSession создаёт сессию пользователя и устанавливает её cookie
+func (w *WebInterface) startSession(wr http.ResponseWriter, r *http.Request, user *users.User) error {
+	cfg := config.GetConfig()
+
+	token, session, err := users.CreateSession(w.db, user.ID, time.Duration(cfg.SessionDays)*24*time.Hour, r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	secure := isSecureRequest(r)
+	http.SetCookie(wr, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// CSRF-токен читает csrf.js, поэтому cookie доступна скриптам
+	http.SetCookie(wr, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    session.CSRFToken,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Cookie прежних версий с хешем пароля больше не нужна
+	http.SetCookie(wr, &http.Cookie{Name: "auth", Path: "/", MaxAge: -1})
+
+	if cfg.Debug {
+		log.Printf("Пользователь %s вошёл, сессия до %s", user.Login, session.ExpiresAt.Format("02.01.2006 15:04"))
+	}
+	return nil
+}
+
+// clearSessionCookies удаляет cookie сессии
+func clearSessionCookies(wr http.ResponseWriter, r *http.Request) {
+	for _, name := range []string{sessionCookieName, csrfCookieName, "auth"} {
+		http.SetCookie(wr, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   isSecureRequest(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// csrfToken извлекает CSRF-токен из заголовка, параметра адреса (формы с файлами)
+// или поля обычной формы
+func csrfToken(r *http.Request) string {
+	if token := r.Header.Get(csrfHeaderName); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get(csrfFieldName); token != "" {
+		return token
+	}
+	// Тело multipart не разбираем: загрузка файлов передаёт токен в адресе
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return r.PostFormValue(csrfFieldName)
+	}
+	return ""
+}
+
+// isSafeMethod сообщает, что метод не изменяет данные
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// isSecureRequest сообщает, что запрос пришёл по HTTPS, в том числе через обратный прокси
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// isLocalRequest проверяет, пришёл ли запрос с адреса обратной петли
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
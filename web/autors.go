@@ -10,8 +10,11 @@ import (
 	"strconv"
 	"strings"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/history"
 	"turanga/models"
+	"turanga/users"
 )
 
 // ShowAuthorHandler обрабатывает запросы к странице автора
@@ -69,6 +72,9 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
 	}
 	offset := (page - 1) * perPage
 
+	// Закрытые книги видны только тем, кому они разрешены
+	policy := access.ForRequest(r)
+
 	// Получаем общее количество книг автора
 	var totalBooks int
 	err = w.db.QueryRow(`
@@ -76,6 +82,7 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
         FROM books b 
         JOIN book_authors ba ON b.id = ba.book_id 
         WHERE ba.author_id = ?
+          `+policy.Filter("b")+`
     `, authorID).Scan(&totalBooks)
 	if err != nil {
 		log.Printf("Database error getting total books count for author %d: %v", authorID, err)
@@ -94,14 +101,13 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
         FROM books b 
         JOIN book_authors ba ON b.id = ba.book_id
         WHERE ba.author_id = ?
+          `+policy.Filter("b")+`
         GROUP BY b.id, b.title, b.series, b.series_number, b.file_hash
         ORDER BY 
             CASE WHEN b.series IS NULL OR b.series = '' THEN 1 ELSE 0 END,
             LOWER(b.series),
-            CASE 
-                WHEN b.series_number GLOB '[0-9]*' THEN CAST(b.series_number AS INTEGER)
-                ELSE 0
-            END,
+            b.series_index IS NULL,
+            b.series_index,
             LOWER(b.series_number),
             LOWER(b.title)
         LIMIT ? OFFSET ?
@@ -207,7 +213,8 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
 		PrevPage            int
 		NextPage            int
 		AuthorID            int
-		IsAuthenticated     bool
+		CanEdit             bool
+		Sharing             []SharingOption
 	}{
 		AuthorName:          authorName,
 		AuthorLastNameLower: authorLastNameLower, // Передаем значение
@@ -220,7 +227,11 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
 		PrevPage:            page - 1,
 		NextPage:            page + 1,
 		AuthorID:            authorID,
-		IsAuthenticated:     w.isAuthenticated(r),
+		CanEdit:             w.can(r, users.PermEdit),
+	}
+
+	if data.CanEdit {
+		data.Sharing = sharingOptions(w.commonSharing("SELECT book_id FROM book_authors WHERE author_id = ?", authorID))
 	}
 
 	// Генерируем список номеров страниц
@@ -251,7 +262,7 @@ func (w *WebInterface) ShowAuthorHandler(wr http.ResponseWriter, r *http.Request
 // SaveAuthorHandler обрабатывает сохранение изменений автора
 func (w *WebInterface) SaveAuthorHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermEdit) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -284,11 +295,28 @@ func (w *WebInterface) SaveAuthorHandler(wr http.ResponseWriter, r *http.Request
 		return
 	}
 
+	targetAuthorID, err := w.renameAuthor(history.OriginWeb, authorID, newName, newLastNameLowerInput)
+	if err != nil {
+		log.Printf("Ошибка переименования автора ID %d в '%s': %v", authorID, newName, err)
+		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
+		return
+	}
+
+	// Возвращаем успешный ответ с ID целевого автора
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	wr.WriteHeader(http.StatusOK)
+	wr.Write([]byte(fmt.Sprintf("OK:%d", targetAuthorID)))
+}
+
+// renameAuthor переименовывает автора во всех его книгах. Если автор с новым именем
+// уже есть, книги переходят к нему, а прежняя запись удаляется. Пустой lastNameLower
+// вычисляется из имени. Возвращает ID автора, к которому теперь относятся книги.
+func (w *WebInterface) renameAuthor(origin string, authorID int, newName, lastNameLower string) (int, error) {
 	// Определяем значение last_name_lower для сохранения в БД
 	var lastNameLowerToSave string
-	if newLastNameLowerInput != "" {
+	if lastNameLower != "" {
 		// Если пользователь ввел значение, используем его (в нижнем регистре)
-		lastNameLowerToSave = strings.ToLower(newLastNameLowerInput)
+		lastNameLowerToSave = strings.ToLower(lastNameLower)
 	} else {
 		// Если пользователь не ввел значение, вычисляем его из full_name
 		nameParts := strings.Fields(newName)
@@ -299,92 +327,54 @@ func (w *WebInterface) SaveAuthorHandler(wr http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// Переименование затрагивает все книги автора, запоминаем их авторов для журнала изменений
+	snapshot := w.snapshotBooks(history.FieldAuthors, "SELECT book_id FROM book_authors WHERE author_id = ?", authorID)
+
 	// Проверяем, существует ли уже автор с таким именем (full_name)
 	var existingAuthorID int
-	err = w.db.QueryRow("SELECT id FROM authors WHERE full_name = ?", newName).Scan(&existingAuthorID)
-
+	err := w.db.QueryRow("SELECT id FROM authors WHERE full_name = ?", newName).Scan(&existingAuthorID)
 	if err != nil && err != sql.ErrNoRows {
-		// Ошибка базы данных
-		log.Printf("Database error checking existing author: %v", err)
-		http.Error(wr, "Ошибка базы данных", http.StatusInternalServerError)
-		return
+		return 0, fmt.Errorf("ошибка проверки существующего автора: %w", err)
 	}
 
 	targetAuthorID := authorID
 
-	if err == nil {
+	if err == nil && existingAuthorID != authorID {
 		// Автор с таким именем уже существует
-		if existingAuthorID != authorID {
-			log.Printf("Автор с именем '%s' уже существует (ID: %d), текущий ID: %d", newName, existingAuthorID, authorID)
-
-			// Начинаем транзакцию для атомарного обновления
-			tx, err := w.db.Begin()
-			if err != nil {
-				log.Printf("Ошибка начала транзакции: %v", err)
-				http.Error(wr, "Ошибка базы данных", http.StatusInternalServerError)
-				return
-			}
-			// Откатываем транзакцию в случае ошибки, если Commit не будет вызван
-			defer func() {
-				if err != nil {
-					tx.Rollback()
-				}
-			}()
-
-			// Переносим связи книг от старого автора к новому
-			_, err = tx.Exec("UPDATE book_authors SET author_id = ? WHERE author_id = ?", existingAuthorID, authorID)
-			if err != nil {
-				// defer позаботится об откате
-				log.Printf("Ошибка обновления связей книг: %v", err)
-				http.Error(wr, "Ошибка обновления связей книг", http.StatusInternalServerError)
-				return
-			}
+		log.Printf("Автор с именем '%s' уже существует (ID: %d), текущий ID: %d", newName, existingAuthorID, authorID)
 
-			// Удаляем старого автора
-			_, err = tx.Exec("DELETE FROM authors WHERE id = ?", authorID)
-			if err != nil {
-				// defer позаботится об откате
-				log.Printf("Ошибка удаления старого автора: %v", err)
-				http.Error(wr, "Ошибка удаления старого автора", http.StatusInternalServerError)
-				return
-			}
+		// Начинаем транзакцию для атомарного обновления
+		tx, err := w.db.Begin()
+		if err != nil {
+			return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+		}
+		defer tx.Rollback()
 
-			// Коммитим транзакцию
-			err = tx.Commit()
-			if err != nil {
-				log.Printf("Ошибка коммита транзакции: %v", err)
-				http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
-				return
-			}
+		// Переносим связи книг от старого автора к новому
+		if _, err := tx.Exec("UPDATE book_authors SET author_id = ? WHERE author_id = ?", existingAuthorID, authorID); err != nil {
+			return 0, fmt.Errorf("ошибка обновления связей книг: %w", err)
+		}
 
-			// Используем существующий ID
-			targetAuthorID = existingAuthorID
+		// Удаляем старого автора
+		if _, err := tx.Exec("DELETE FROM authors WHERE id = ?", authorID); err != nil {
+			return 0, fmt.Errorf("ошибка удаления старого автора: %w", err)
+		}
 
-		} else {
-			// Это тот же автор - просто обновляем данные
-			// Обновляем также lower-поля
-			_, err = w.db.Exec("UPDATE authors SET full_name = ?, last_name_lower = ?, full_name_lower = ? WHERE id = ?",
-				newName, lastNameLowerToSave, strings.ToLower(newName), authorID)
-			if err != nil {
-				log.Printf("Database error updating author (ID: %d, fullName: %s): %v", authorID, newName, err)
-				http.Error(wr, "Ошибка сохранения изменений: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("ошибка коммита транзакции: %w", err)
 		}
+
+		// Используем существующий ID
+		targetAuthorID = existingAuthorID
 	} else {
-		// Автор не существует - обновляем текущего автора
-		// Обновляем также lower-поля
+		// Это тот же автор или имя свободно - просто обновляем данные, а также lower-поля
 		_, err = w.db.Exec("UPDATE authors SET full_name = ?, last_name_lower = ?, full_name_lower = ? WHERE id = ?",
 			newName, lastNameLowerToSave, strings.ToLower(newName), authorID)
 		if err != nil {
-			log.Printf("Database error updating author (ID: %d, fullName: %s): %v", authorID, newName, err)
-			http.Error(wr, "Ошибка сохранения изменений: "+err.Error(), http.StatusInternalServerError)
-			return
+			return 0, fmt.Errorf("ошибка обновления автора (ID: %d, fullName: %s): %w", authorID, newName, err)
 		}
 	}
 
-	// Возвращаем успешный ответ с ID целевого автора
-	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	wr.WriteHeader(http.StatusOK)
-	wr.Write([]byte(fmt.Sprintf("OK:%d", targetAuthorID)))
+	w.logSnapshotChanges(origin, history.FieldAuthors, snapshot)
+	return targetAuthorID, nil
 }
@@ -0,0 +1,134 @@
+// web/backup.go
+package web
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"turanga/backup"
+	"turanga/config"
+	"turanga/users"
+)
+
+// BackupHandler показывает страницу резервного копирования
+// URL: GET /backup
+func (w *WebInterface) BackupHandler(wr http.ResponseWriter, r *http.Request) {
+	if !w.can(r, users.PermManage) {
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.showBackupPage(wr, r.URL.Query().Get("message"), "")
+}
+
+// showBackupPage отображает страницу резервного копирования с сообщением
+func (w *WebInterface) showBackupPage(wr http.ResponseWriter, message, errorMessage string) {
+	cfg := config.GetConfig()
+
+	backups, err := backup.List(w.rootPath)
+	if err != nil {
+		log.Printf("Ошибка получения списка резервных копий: %v", err)
+	}
+
+	data := struct {
+		CatalogTitle string
+		Message      string
+		ErrorMessage string
+		Pending      bool
+		Backups      []string
+	}{
+		CatalogTitle: cfg.GetCatalogTitle(),
+		Message:      message,
+		ErrorMessage: errorMessage,
+		Pending:      backup.HasPending(w.rootPath),
+		Backups:      backups,
+	}
+
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "backup.html")
+	tmpl, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing backup template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(wr, "backup", data); err != nil {
+		log.Printf("Error executing backup template: %v", err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// BackupDownloadHandler отдаёт архив резервной копии
+// URL: GET /backup/download?books=1
+func (w *WebInterface) BackupDownloadHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermManage) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := backup.Options{IncludeBooks: r.URL.Query().Get("books") == "1"}
+
+	wr.Header().Set("Content-Type", "application/zip")
+	wr.Header().Set("Content-Disposition", `attachment; filename="`+backup.FileName(time.Now())+`"`)
+
+	// Архив пишется потоком: после начала передачи сообщить об ошибке клиенту уже нельзя
+	manifest, err := backup.Write(w.db, cfg, w.rootPath, wr, opts)
+	if err != nil {
+		log.Printf("Ошибка создания резервной копии: %v", err)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Резервная копия отдана клиенту: %d файлов, книги: %v", len(manifest.Files), opts.IncludeBooks)
+	}
+}
+
+// BackupRestoreHandler принимает архив и ставит его на восстановление при следующем запуске.
+// Восстанавливать работающую БД на лету небезопасно, поэтому замена файлов выполняется при старте.
+// URL: POST /backup/restore
+func (w *WebInterface) BackupRestoreHandler(wr http.ResponseWriter, r *http.Request) {
+	if !w.can(r, users.PermManage) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		w.showBackupPage(wr, "", "Ошибка чтения загруженного архива")
+		return
+	}
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		w.showBackupPage(wr, "", "Архив не выбран")
+		return
+	}
+	defer file.Close()
+
+	manifest, err := backup.StagePending(file, w.rootPath)
+	if err != nil {
+		log.Printf("Загруженный архив отклонён: %v", err)
+		w.showBackupPage(wr, "", "Архив не прошёл проверку: "+err.Error())
+		return
+	}
+
+	log.Printf("Архив от %s принят и будет восстановлен при следующем запуске",
+		manifest.CreatedAt.Format("2006-01-02 15:04:05"))
+	w.showBackupPage(wr, "Архив от "+manifest.CreatedAt.Format("2006-01-02 15:04")+" проверен.", "")
+}
@@ -3,18 +3,29 @@ package web
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"slices"
+	"turanga/access"
 	"turanga/config"
+	"turanga/convert"
+	"turanga/genres"
+	"turanga/kosync"
+	"turanga/language"
+	"turanga/mailer"
 	"turanga/models"
+	"turanga/reader"
+	"turanga/trash"
+	"turanga/users"
+	"turanga/works"
 )
 
 // ShowBookDetailHandler обрабатывает запросы к странице деталей книги
@@ -42,7 +53,7 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 			LEFT JOIN authors a ON ba.author_id = a.id 
 			WHERE ba.book_id = b.id) as authors_str,
 		   b.series, b.series_number, b.published_at, 
-		   b.isbn, b.year, b.publisher,
+		   b.isbn, b.year, b.publisher, b.language, b.source_language,
 		   b.file_url, b.file_type, b.file_hash, b.file_size,
 		   (SELECT GROUP_CONCAT(t.name, ', ') 
 			FROM book_tags bt 
@@ -74,7 +85,7 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 
 	var b models.BookWeb
 	// Обновлён список переменных для Scan
-	var isbn, year, publisher, series, seriesNumber sql.NullString
+	var isbn, year, publisher, series, seriesNumber, lang, srcLang sql.NullString
 	var fileSize sql.NullInt64
 	var id int
 	var authorsStr sql.NullString // ИЗМЕНЕНО: теперь sql.NullString вместо string
@@ -84,7 +95,7 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 	var over18 sql.NullBool
 
 	err = row.Scan(&id, &b.Title, &fileHash, &over18, &ipfsCID, &authorsStr, &series, &seriesNumber, &b.PublishedAt,
-		&isbn, &year, &publisher,
+		&isbn, &year, &publisher, &lang, &srcLang,
 		&fileURL, &fileType, &fileHash, &fileSize, &tagsStr)
 
 	// Обработку over18:
@@ -92,10 +103,16 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 		b.Over18 = over18.Bool
 	}
 
-	// Проверку доступа для книг 18+:
-	if b.Over18 && !w.isAuthenticated(r) {
-		http.Error(wr, "Доступ к этой книге ограничен", http.StatusForbidden)
-		return
+	// Проверку доступа к закрытым книгам (18+ и с закрытыми тегами):
+	policy := access.ForRequest(r)
+	if err == nil {
+		visible, accessErr := policy.CanSeeBook(w.db, int64(id))
+		if accessErr != nil {
+			err = accessErr
+		} else if !visible {
+			http.Error(wr, "Доступ к этой книге ограничен", http.StatusForbidden)
+			return
+		}
 	}
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -135,6 +152,8 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 	if series.Valid {
 		b.Series = series.String
 	}
+	b.Language = lang.String
+	b.SourceLang = srcLang.String
 	if seriesNumber.Valid {
 		b.SeriesNumber = seriesNumber.String
 	}
@@ -159,9 +178,11 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 		downloadURL := fmt.Sprintf("/opds-download/%d/", id)
 
 		fileWeb := models.BookFileWeb{
+			BookID:   id,
 			URL:      downloadURL, // <-- Здесь правильный URL для скачивания
 			Type:     fileType.String,
 			FileHash: fileHash.String,
+			IPFSCID:  b.IPFS_CID,
 		}
 		if fileSize.Valid {
 			fileWeb.FileSize = fileSize.Int64
@@ -169,6 +190,46 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 		b.Files = append(b.Files, fileWeb)
 	}
 
+	// Добавляем остальные форматы того же произведения
+	workFiles, err := works.Files(w.db, int64(id), policy, nil)
+	if err != nil {
+		log.Printf("Ошибка получения форматов произведения для книги ID %d: %v", id, err)
+	}
+	for _, f := range workFiles {
+		if f.BookID == int64(id) {
+			continue
+		}
+		b.Files = append(b.Files, models.BookFileWeb{
+			BookID:   int(f.BookID),
+			URL:      fmt.Sprintf("/opds-download/%d/", f.BookID),
+			Type:     f.FileType,
+			FileHash: f.FileHash,
+			FileSize: f.FileSize,
+			IPFSCID:  f.IPFSCID,
+		})
+	}
+
+	// Без своего epub книгу fb2 можно скачать в EPUB, сконвертированном на лету
+	hasEPUB := false
+	var fb2File *models.BookFileWeb
+	for i := range b.Files {
+		if b.Files[i].Type == "epub" {
+			hasEPUB = true
+		}
+		if fb2File == nil && convert.CanConvert(b.Files[i].Type) && b.Files[i].FileHash != "" {
+			fb2File = &b.Files[i]
+		}
+	}
+	if !hasEPUB && fb2File != nil {
+		b.Files = append(b.Files, models.BookFileWeb{
+			BookID:    fb2File.BookID,
+			URL:       convert.URL(fb2File.FileHash, b.Title),
+			Type:      "epub",
+			FileHash:  fb2File.FileHash,
+			Converted: true,
+		})
+	}
+
 	// Подготавливаем данные для шаблона
 	fileTypeStr := ""
 	if fileType.Valid {
@@ -176,19 +237,79 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 	}
 
 	data := struct {
-		Book            *models.BookWeb
-		Authors         []models.AuthorInfo
-		IsAuthenticated bool
-		Title           string
-		FileType        string
-		IPFSGateway     string
+		Book         *models.BookWeb
+		Authors      []models.AuthorInfo
+		CanEdit      bool
+		Title        string
+		FileType     string
+		IPFSGateway  string
+		History      []HistoryEntryView
+		Reading      *kosync.Progress // Последняя позиция чтения из KOReader
+		MailDevices  []mailer.Device  // Почтовые адреса устройств, на которые можно отправить книгу
+		CanRead      bool             // Есть fb2 или epub для чтения в браузере
+		ReaderPos    *reader.Position // Место, на котором остановились во встроенной читалке
+		Languages    []LanguageOption // Варианты языка книги для редактирования
+		SourceLangs  []LanguageOption // Варианты языка оригинала
+		Genres       []genres.Genre   // Жанры по классификатору fb2
+		Message      string
+		ErrorMessage string
+
+		// Доступ через Nostr: собственная настройка книги и тег, который её ужесточает
+		Sharing             []SharingOption
+		SharingEffective    string
+		SharingRestrictedBy string
 	}{
-		Book:            &b,
-		Authors:         authors,
-		IsAuthenticated: w.isAuthenticated(r),
-		Title:           b.Title,
-		FileType:        fileTypeStr,
-		IPFSGateway:     w.config.GetIPFSGateway(),
+		Book:         &b,
+		Authors:      authors,
+		CanEdit:      w.can(r, users.PermEdit),
+		Title:        b.Title,
+		FileType:     fileTypeStr,
+		IPFSGateway:  w.config.GetIPFSGateway(),
+		Languages:    languageOptions(b.Language),
+		SourceLangs:  languageOptions(b.SourceLang),
+		Message:      r.URL.Query().Get("message"),
+		ErrorMessage: r.URL.Query().Get("error"),
+	}
+	if data.Genres, err = genres.ForBook(w.db, int64(id)); err != nil {
+		log.Printf("Ошибка получения жанров книги ID %d: %v", id, err)
+	}
+	for _, f := range b.Files {
+		if slices.Contains(reader.Formats, f.Type) {
+			data.CanRead = true
+		}
+	}
+	if user := w.currentUser(r); user != nil {
+		if data.CanRead {
+			if data.ReaderPos, err = reader.GetPosition(w.db, user.ID, int64(id)); err != nil {
+				log.Printf("Ошибка получения места чтения книги ID %d: %v", id, err)
+			}
+		}
+		hashes := make([]string, 0, len(b.Files))
+		for _, f := range b.Files {
+			if f.FileHash != "" {
+				hashes = append(hashes, f.FileHash)
+			}
+		}
+		if data.Reading, err = kosync.ForFiles(w.db, user.ID, hashes); err != nil {
+			log.Printf("Ошибка получения прогресса чтения книги ID %d: %v", id, err)
+		}
+		if mailer.Enabled(w.config) && len(b.Files) > 0 {
+			if data.MailDevices, err = mailer.ListDevices(w.db, user.ID); err != nil {
+				log.Printf("Ошибка получения почтовых адресов пользователя %s: %v", user.Login, err)
+			}
+		}
+	}
+	if data.CanEdit {
+		data.History = w.bookHistory(bookID)
+
+		own, effective, restrictedBy, err := access.BookSharing(w.db, int64(id))
+		if err != nil {
+			log.Printf("Ошибка чтения доступа через nostr для книги ID %d: %v", id, err)
+		} else {
+			data.Sharing = sharingOptions(own)
+			data.SharingEffective = access.SharingLabels[effective]
+			data.SharingRestrictedBy = restrictedBy
+		}
 	}
 
 	//log.Printf("IPFS Gateway from config: %s", w.config.GetIPFSGateway())
@@ -201,6 +322,7 @@ func (w *WebInterface) ShowBookDetailHandler(wr http.ResponseWriter, r *http.Req
 		"trim":       strings.TrimSpace,
 		"urlquery":   url.QueryEscape,
 		"formatSize": FormatFileSize,
+		"langName":   language.Name,
 	}).ParseFiles(tmplPath)
 
 	if err != nil {
@@ -314,7 +436,7 @@ func (w *WebInterface) DeleteBookHandler(wr http.ResponseWriter, r *http.Request
 	cfg := config.GetConfig()
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermEdit) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -337,212 +459,22 @@ func (w *WebInterface) DeleteBookHandler(wr http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//	log.Printf("Начало блока IPFS удаления для книги ID %d", bookID)
-	var bookIPFSCID sql.NullString
-	// Получаем IPFS CID до удаления записи из БД
-	if cfg.Debug {
-		log.Printf("Проверка конфигурации: w.config != nil: %t, w.config.RemoveFromIPFSOnDelete: %t",
-			w.config != nil, w.config.RemoveFromIPFSOnDelete)
-	}
-
-	if w.config != nil && w.config.RemoveFromIPFSOnDelete {
-		if cfg.Debug {
-			log.Printf("Условие w.config != nil && w.config.RemoveFromIPFSOnDelete выполнено, продолжаем")
-		}
-		err = w.db.QueryRow("SELECT ipfs_cid FROM books WHERE id = ?", bookID).Scan(&bookIPFSCID)
-		if cfg.Debug {
-			log.Printf("Попытка получения IPFS CID для книги ID %d. Ошибка: %v, Valid: %t, Value: '%s'",
-				bookID, err, bookIPFSCID.Valid, bookIPFSCID.String)
-		}
-
-		if err != nil && err != sql.ErrNoRows {
-			if cfg.Debug {
-				log.Printf("Database error getting IPFS CID for book ID %d: %v", bookID, err)
-			}
-			// Не останавливаем удаление из-за ошибки получения CID
-		} else if err == nil && bookIPFSCID.Valid && bookIPFSCID.String != "" {
-			// CID найден и валиден
-			if cfg.Debug {
-				log.Printf("CID найден и валиден. Пробуем удалить из ipfs")
-			}
-			ipfsShell, shellErr := w.config.GetIPFSShell()
-			if shellErr == nil {
-				// Открепляем (Unpin) CID из IPFS
-				unpinErr := ipfsShell.Unpin(bookIPFSCID.String)
-				if unpinErr != nil {
-					if cfg.Debug {
-						log.Printf("Warning: Failed to unpin IPFS CID %s for book ID %d: %v", bookIPFSCID.String, bookID, unpinErr)
-					}
-					// Не возвращаем ошибку HTTP, просто логируем предупреждение
-				} else {
-					if cfg.Debug {
-						log.Printf("Successfully unpinned IPFS CID %s for book ID %d", bookIPFSCID.String, bookID)
-					}
-				}
-			} else {
-				if cfg.Debug {
-					log.Printf("Warning: Failed to get IPFS shell for unpinning CID %s: %v", bookIPFSCID.String, shellErr)
-				}
-			}
-		} else if err == nil {
-			if cfg.Debug {
-				log.Printf("IPFS CID для книги ID %d не найден или пуст (Valid: %t, Value: '%s')", bookID, bookIPFSCID.Valid, bookIPFSCID.String)
-			}
-		}
-		// Если bookIPFSCID не Valid или пустой, просто продолжаем
-	} else {
-		if cfg.Debug {
-			log.Printf("Условие w.config != nil && w.config.RemoveFromIPFSOnDelete НЕ выполнено, пропускаем блок IPFS")
-		}
-	}
-
-	// Получаем информацию о книге перед удалением (для удаления файлов)
-	var fileURL, fileHash sql.NullString
-	err = w.db.QueryRow("SELECT file_url, file_hash FROM books WHERE id = ?", bookID).Scan(&fileURL, &fileHash)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(wr, "Book not found", http.StatusNotFound)
-		} else {
-			log.Printf("Database error getting book info for ID %d: %v", bookID, err)
-			http.Error(wr, "Database error", http.StatusInternalServerError)
-		}
+	// Книга не удаляется окончательно, а переносится в корзину вместе с файлом.
+	// Обложка, аннотация и IPFS остаются нетронутыми до очистки корзины.
+	trashID, err := trash.Move(w.db, w.rootPath, int64(bookID))
+	if errors.Is(err, trash.ErrBookNotFound) {
+		http.Error(wr, "Book not found", http.StatusNotFound)
 		return
-	}
-
-	// Начинаем транзакцию
-	tx, err := w.db.Begin()
-	if err != nil {
-		log.Printf("Database error starting transaction for book ID %d: %v", bookID, err)
-		http.Error(wr, "Database error", http.StatusInternalServerError)
+	} else if err != nil {
+		log.Printf("Ошибка переноса книги ID %d в корзину: %v", bookID, err)
+		http.Error(wr, "Failed to move book to trash", http.StatusInternalServerError)
 		return
 	}
-	defer tx.Rollback()
 
-	// Удаляем запись из базы данных (CASCADE удалит связанные записи)
-	_, err = tx.Exec("DELETE FROM books WHERE id = ?", bookID)
-	if err != nil {
-		log.Printf("Database error deleting book ID %d: %v", bookID, err)
-		http.Error(wr, "Database error", http.StatusInternalServerError)
-		return
-	}
-
-	// Фиксируем транзакцию
-	err = tx.Commit()
-	if err != nil {
-		log.Printf("Database error committing transaction for book ID %d: %v", bookID, err)
-		http.Error(wr, "Database error", http.StatusInternalServerError)
-		return
-	}
-
-	// Удаляем файлы с диска (если они есть)
-	if fileURL.Valid && fileURL.String != "" {
-		// fileURL теперь содержит абсолютный путь к файлу
-		filePath := fileURL.String
-
-		// Проверяем существование файла перед удалением
-		if _, err := os.Stat(filePath); err == nil {
-			err := os.Remove(filePath)
-			if err != nil {
-				if cfg.Debug {
-					log.Printf("Error deleting book file %s: %v", filePath, err)
-				}
-				// Не возвращаем ошибку, просто логируем
-			} else {
-				if cfg.Debug {
-					log.Printf("Deleted book file: %s", filePath)
-				}
-			}
-		} else if os.IsNotExist(err) {
-			if cfg.Debug {
-				log.Printf("Book file not found (already deleted): %s", filePath)
-			}
-		} else {
-			if cfg.Debug {
-				log.Printf("Error checking book file %s: %v", filePath, err)
-			}
-		}
-	}
-
-	// Удаляем обложку с диска
-	if fileHash.Valid && fileHash.String != "" {
-		w.deleteCoverFileByHash(fileHash.String)
-	}
-
-	// Удаляем файл аннотации
-	if fileHash.Valid && fileHash.String != "" {
-		w.deleteAnnotationFileByHash(fileHash.String)
+	if cfg.Debug {
+		log.Printf("Книга ID %d перенесена в корзину (запись %d)", bookID, trashID)
 	}
 
 	// Возвращаем успешный ответ и перенаправляем на главную страницу
 	http.Redirect(wr, r, "/", http.StatusSeeOther)
 }
-
-// deleteCoverFileByHash удаляет файл обложки по хешу
-func (w *WebInterface) deleteCoverFileByHash(fileHash string) {
-	cfg := config.GetConfig()
-
-	// Определяем каталог covers в корневой директории приложения
-	coversDir := "./covers"
-	if w.rootPath != "" {
-		coversDir = filepath.Join(w.rootPath, "covers")
-	}
-
-	// Возможные расширения обложек
-	extensions := []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
-
-	// Ищем и удаляем файл обложки с любым из расширений
-	for _, ext := range extensions {
-		coverFileName := fileHash + ext
-		coverFilePath := filepath.Join(coversDir, coverFileName)
-
-		if _, err := os.Stat(coverFilePath); err == nil {
-			err := os.Remove(coverFilePath)
-			if err != nil {
-				if cfg.Debug {
-					log.Printf("Error deleting cover file %s: %v", coverFilePath, err)
-				}
-			} else {
-				if cfg.Debug {
-					log.Printf("Deleted cover file: %s", coverFilePath)
-				}
-			}
-		} else if !os.IsNotExist(err) {
-			if cfg.Debug {
-				log.Printf("Error checking cover file %s: %v", coverFilePath, err)
-			}
-		}
-	}
-}
-
-// deleteAnnotationFileByHash удаляет файл аннотации по хешу
-func (w *WebInterface) deleteAnnotationFileByHash(fileHash string) {
-	cfg := config.GetConfig()
-
-	// Определяем каталог notes в корневой директории приложения
-	notesDir := "./notes"
-	if w.rootPath != "" {
-		notesDir = filepath.Join(w.rootPath, "notes")
-	}
-
-	// Формируем путь к файлу аннотации
-	noteFileName := fileHash + ".txt"
-	noteFilePath := filepath.Join(notesDir, noteFileName)
-
-	// Проверяем существование файла перед удалением
-	if _, err := os.Stat(noteFilePath); err == nil {
-		err := os.Remove(noteFilePath)
-		if err != nil {
-			if cfg.Debug {
-				log.Printf("Error deleting annotation file %s: %v", noteFilePath, err)
-			}
-		} else {
-			if cfg.Debug {
-				log.Printf("Deleted annotation file: %s", noteFilePath)
-			}
-		}
-	} else if !os.IsNotExist(err) {
-		if cfg.Debug {
-			log.Printf("Error checking annotation file %s: %v", noteFilePath, err)
-		}
-	}
-}
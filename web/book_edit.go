@@ -16,15 +16,22 @@ import (
 	"strconv"
 	"strings"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/generation"
+	"turanga/history"
+	"turanga/language"
 	"turanga/scanner"
+	"turanga/search"
+	"turanga/series"
+	"turanga/users"
 )
 
 // SaveBookFieldHandler обрабатывает сохранение изменений полей книги
 // URL: /save/book/{id} или /save/book/{id}/cover
 func (w *WebInterface) SaveBookFieldHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermEdit) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -69,15 +76,17 @@ func (w *WebInterface) SaveBookFieldHandler(wr http.ResponseWriter, r *http.Requ
 
 	// Валидация поля
 	validFields := map[string]bool{
-		"title":      true,
-		"authors":    true,
-		"series":     true,
-		"year":       true,
-		"publisher":  true,
-		"isbn":       true,
-		"annotation": true,
-		"tags":       true,
-		"over18":     true,
+		"title":           true,
+		"authors":         true,
+		"series":          true,
+		"year":            true,
+		"publisher":       true,
+		"isbn":            true,
+		"annotation":      true,
+		"tags":            true,
+		"over18":          true,
+		"language":        true,
+		"source_language": true,
 	}
 
 	if !validFields[fieldName] {
@@ -85,6 +94,12 @@ func (w *WebInterface) SaveBookFieldHandler(wr http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Запоминаем прежнее значение для журнала изменений
+	oldValue, err := history.Current(w.db, w.rootPath, int64(bookID), fieldName)
+	if err != nil {
+		log.Printf("Предупреждение: не удалось прочитать поле %s книги ID %d для журнала: %v", fieldName, bookID, err)
+	}
+
 	// Специальная обработка для тегов
 	if fieldName == "tags" {
 		action := strings.TrimSpace(r.FormValue("action"))
@@ -102,6 +117,7 @@ func (w *WebInterface) SaveBookFieldHandler(wr http.ResponseWriter, r *http.Requ
 			http.Error(wr, "Ошибка сохранения тегов", http.StatusInternalServerError)
 			return
 		}
+		w.logBookChange(history.NewOperation(), history.OriginWeb, bookID, fieldName, oldValue)
 		wr.WriteHeader(http.StatusOK)
 		wr.Write([]byte("OK"))
 		return
@@ -114,6 +130,7 @@ func (w *WebInterface) SaveBookFieldHandler(wr http.ResponseWriter, r *http.Requ
 		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
 		return
 	}
+	w.logBookChange(history.NewOperation(), history.OriginWeb, bookID, fieldName, oldValue)
 
 	// Возвращаем успешный ответ
 	wr.WriteHeader(http.StatusOK)
@@ -157,14 +174,21 @@ func (w *WebInterface) updateBookField(bookID int, fieldName, value string) erro
 		}
 		// Обновляем также lower-поле
 		_, err := w.db.Exec("UPDATE books SET series = ?, series_lower = ? WHERE id = ?", seriesName, strings.ToLower(seriesName), bookID)
-		return err
+		if err != nil {
+			return err
+		}
+		// Связываем книгу с серией: псевдоним заменится каноническим названием
+		return series.Sync(w.db, int64(bookID))
 	case "series_number":
 		// Отдельная обработка номера серии (на случай прямого вызова)
 		if cfg.Debug {
 			log.Printf("Updating series_number directly: '%s'", value)
 		}
 		_, err := w.db.Exec("UPDATE books SET series_number = ? WHERE id = ?", value, bookID)
-		return err
+		if err != nil {
+			return err
+		}
+		return series.Sync(w.db, int64(bookID))
 	case "year":
 		_, err := w.db.Exec("UPDATE books SET year = ? WHERE id = ?", value, bookID)
 		return err
@@ -181,6 +205,21 @@ func (w *WebInterface) updateBookField(bookID int, fieldName, value string) erro
 	case "over18":
 		over18 := value == "true" || value == "1" || value == "on"
 		return w.updateBookOver18(bookID, over18)
+	case "language", "source_language":
+		// Пустое значение — язык не указан
+		code := language.Normalize(value)
+		if value != "" && code == "" {
+			return fmt.Errorf("неизвестный язык: %s", value)
+		}
+		_, err := w.db.Exec("UPDATE books SET "+fieldName+" = ? WHERE id = ?", code, bookID)
+		return err
+	case "shared":
+		sharing, err := access.ParseSharing(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.db.Exec("UPDATE books SET shared = ? WHERE id = ?", string(sharing), bookID)
+		return err
 	default:
 		return fmt.Errorf("unsupported field: %s", fieldName)
 	}
@@ -477,13 +516,21 @@ func (w *WebInterface) saveAnnotationToFile(bookID int, annotation string, fileH
 		}
 	}
 
+	if err := search.SetAnnotation(w.db, int64(bookID), annotation); err != nil {
+		log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+	}
+	// Аннотация хранится в файле, триггеры базы её изменение не видят
+	if err := generation.Touch(w.db); err != nil {
+		log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+	}
+
 	return nil
 }
 
 // saveBookCover обрабатывает загрузку новой обложки для книги
 func (w *WebInterface) saveBookCover(wr http.ResponseWriter, r *http.Request, bookID int) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermEdit) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -4,6 +4,7 @@ package web
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -16,9 +17,14 @@ import (
 	"sync"
 	"time"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/history"
+	"turanga/language"
 	"turanga/models"
 	"turanga/scanner"
+	"turanga/search"
+	"turanga/users"
 )
 
 // ShowWebInterface обрабатывает запросы к главной странице
@@ -27,10 +33,12 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 	var totalBooks int
 	var rows *sql.Rows
 	var err error
+	var searchIDs []int64 // порядок релевантности при поиске
 
 	// Получаем параметры пагинации и поиска из URL
 	pageStr := r.URL.Query().Get("page")
 	queryStr := r.URL.Query().Get("q") // <-- Получаем поисковый запрос
+	langFilter := language.Normalize(r.URL.Query().Get("lang"))
 	revisionSuccess := r.URL.Query().Get("revision") == "1"
 
 	page := 1
@@ -54,102 +62,36 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 	// Вычисляем смещение
 	offset := (page - 1) * perPage
 
-	// Определяем, авторизован ли пользователь для фильтрации по over18
-	isAuthenticated := w.isAuthenticated(r)
+	// Правила видимости закрытых книг для текущего пользователя
+	policy := access.ForRequest(r)
 
 	// Очищаем и подготавливаем поисковый запрос
 	cleanQuery := strings.TrimSpace(queryStr)
 
 	if queryStr != "" && cleanQuery != "" {
-		// Используем lower-версию поискового запроса для поиска
-		lowerQuery := strings.ToLower(cleanQuery)
-
-		// Добавляем логирование для отладки
-		//		log.Printf("Search query: '%s', lower query: '%s'", cleanQuery, lowerQuery)
-
-		// Формируем базовую часть WHERE для поиска с использованием lower-полей
-		searchCondition := `
-		(b.title_lower LIKE ? OR
-		 IFNULL(b.series_lower, '') LIKE ? OR
-		 EXISTS (
-			SELECT 1 FROM book_authors ba
-			JOIN authors a ON ba.author_id = a.id
-			WHERE ba.book_id = b.id AND a.full_name_lower LIKE ?
-		 ) OR
-		 EXISTS (
-			SELECT 1 FROM book_tags bt
-			JOIN tags t ON bt.tag_id = t.id
-			WHERE bt.book_id = b.id AND t.name LIKE ? COLLATE ICU_NOCASE
-		 ))
-	`
-
-		// Формируем полный WHERE и аргументы в зависимости от авторизации
-		var fullWhereCondition string
-		var argsCount []interface{}
-		var argsSelect []interface{}
-
-		if isAuthenticated {
-			// Для авторизованных: только условие поиска
-			fullWhereCondition = "WHERE " + searchCondition
-			argsCount = []interface{}{"%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + cleanQuery + "%"}
-			argsSelect = []interface{}{"%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + cleanQuery + "%"}
-		} else {
-			// Для неавторизованных: условие поиска И фильтр по over18
-			fullWhereCondition = "WHERE (" + searchCondition + ") AND IFNULL(b.over18, 0) = 0"
-			argsCount = []interface{}{"%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + cleanQuery + "%"}
-			argsSelect = []interface{}{"%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + lowerQuery + "%", "%" + cleanQuery + "%"}
-		}
-
-		// Запрос для подсчета общего количества найденных книг
-		countQuery := fmt.Sprintf(`
-		SELECT COUNT(*)
-		FROM books b
-		%s
-	`, fullWhereCondition)
-
-		err = w.db.QueryRow(countQuery, argsCount...).Scan(&totalBooks)
+		// Ищем по полнотекстовому индексу; гостям и детскому профилю закрытые книги не показываем
+		result, err := search.Books(w.db, search.Query{
+			Text:     cleanQuery,
+			Language: langFilter,
+			Access:   policy,
+			Limit:    perPage,
+			Offset:   offset,
+		})
 		if err != nil {
-			log.Printf("Database error getting total books count with query '%s': %v", queryStr, err)
-			log.Printf("Count query: %s", countQuery)
-			log.Printf("Count args: %v", argsCount)
+			log.Printf("Database error searching books with query '%s': %v", queryStr, err)
 			http.Error(wr, "Database error", http.StatusInternalServerError)
 			return
 		}
-
-		//		log.Printf("Found %d books for query '%s'", totalBooks, cleanQuery)
-
-		// Дополнительная диагностика - проверим отдельно каждое условие поиска
-		if totalBooks == 0 {
-			var titleCount, seriesCount, authorCount, tagCount int
-			searchPatternLower := "%" + lowerQuery + "%"
-			searchPatternOriginal := "%" + cleanQuery + "%"
-
-			// Проверка поиска по названию
-			w.db.QueryRow("SELECT COUNT(*) FROM books WHERE title_lower LIKE ?", searchPatternLower).Scan(&titleCount)
-			//			log.Printf("Title matches: %d", titleCount)
-
-			// Проверка поиска по серии
-			w.db.QueryRow("SELECT COUNT(*) FROM books WHERE IFNULL(series_lower, '') LIKE ?", searchPatternLower).Scan(&seriesCount)
-			//			log.Printf("Series matches: %d", seriesCount)
-
-			// Проверка поиска по авторам
-			w.db.QueryRow(`SELECT COUNT(DISTINCT b.id) FROM books b
-			JOIN book_authors ba ON ba.book_id = b.id
-			JOIN authors a ON ba.author_id = a.id
-			WHERE a.full_name_lower LIKE ?`, searchPatternLower).Scan(&authorCount)
-			//			log.Printf("Author matches: %d", authorCount)
-
-			// Проверка поиска по тегам (оставляем COLLATE ICU_NOCASE, т.к. теги не имеют lower-полей)
-			w.db.QueryRow(`SELECT COUNT(DISTINCT b.id) FROM books b
-			JOIN book_tags bt ON bt.book_id = b.id
-			JOIN tags t ON bt.tag_id = t.id
-			WHERE t.name LIKE ? COLLATE ICU_NOCASE`, searchPatternOriginal).Scan(&tagCount)
-			// log.Printf("Tag matches: %d", tagCount)
-
+		totalBooks = result.Total
+		searchIDs = result.IDs
+		if len(searchIDs) == 0 {
+			// Пустой список IN () недопустим, подставляем заведомо несуществующий ID
+			searchIDs = []int64{0}
 		}
+		placeholders, args := search.Placeholders(searchIDs)
 
-		// Запрос для выборки найденных книг
-		selectQuery := fmt.Sprintf(`
+		// Запрос для выборки подробностей о найденных книгах
+		rows, err = w.db.Query(`
 		SELECT b.id, b.title, b.file_type, b.file_hash, b.over18,
 			(SELECT CASE
 				WHEN COUNT(*) > 2 THEN 'коллектив авторов'
@@ -164,91 +106,52 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 			LEFT JOIN tags t ON bt.tag_id = t.id
 			WHERE bt.book_id = b.id) as tags_str
 		FROM books b
-		%s
-		GROUP BY b.id, b.title, b.file_type, b.file_hash, b.over18
-		ORDER BY b.id DESC
-		LIMIT ? OFFSET ?
-	`, fullWhereCondition)
-
-		// Добавляем аргументы пагинации в конец списка аргументов для выборки
-		argsSelect = append(argsSelect, perPage, offset)
-
-		rows, err = w.db.Query(selectQuery, argsSelect...)
+		WHERE b.id IN (`+placeholders+`)`, args...)
 		if err != nil {
 			log.Printf("Database error getting books with query '%s': %v", queryStr, err)
-			log.Printf("Select query: %s", selectQuery)
-			log.Printf("Select args: %v", argsSelect)
 			http.Error(wr, "Database error", http.StatusInternalServerError)
 			return
 		}
 
 	} else {
 		// --- ЛОГИКА БЕЗ ПОИСКА ---
-		if isAuthenticated {
-			// Авторизованные пользователи видят все книги
-			err = w.db.QueryRow("SELECT COUNT(*) FROM books").Scan(&totalBooks)
-			if err != nil {
-				log.Printf("Database error getting total books count: %v", err)
-				http.Error(wr, "Database error", http.StatusInternalServerError)
-				return
-			}
-
-			rows, err = w.db.Query(`
-			SELECT b.id, b.title, b.file_type, b.file_hash, b.over18,
-				(SELECT CASE
-					WHEN COUNT(*) > 2 THEN 'коллектив авторов'
-					WHEN COUNT(*) = 0 THEN 'Автор не указан'
-					ELSE GROUP_CONCAT(a.full_name, ', ')
-				END
-				FROM book_authors ba
-				LEFT JOIN authors a ON ba.author_id = a.id
-				WHERE ba.book_id = b.id) as authors_str,
-				(SELECT GROUP_CONCAT(t.name, ', ')
-				FROM book_tags bt
-				LEFT JOIN tags t ON bt.tag_id = t.id
-				WHERE bt.book_id = b.id) as tags_str
-			FROM books b
-			GROUP BY b.id, b.title, b.file_type, b.file_hash, b.over18
-			ORDER BY b.id DESC
-			LIMIT ? OFFSET ?`, perPage, offset)
-			if err != nil {
-				log.Printf("Database error getting books: %v", err)
-				http.Error(wr, "Database error", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			// Неавторизованные пользователи не видят книги 18+
-			err = w.db.QueryRow("SELECT COUNT(*) FROM books WHERE over18 = 0 OR over18 IS NULL").Scan(&totalBooks)
-			if err != nil {
-				log.Printf("Database error getting total books count (non-auth): %v", err)
-				http.Error(wr, "Database error", http.StatusInternalServerError)
-				return
-			}
+		// Закрытые книги (18+ и с закрытыми тегами) видят только те, кому они разрешены
+		visible := "WHERE 1=1 " + policy.Filter("b")
+		var visibleArgs []interface{}
+		if langFilter != "" {
+			visible += " AND b.language = ?"
+			visibleArgs = append(visibleArgs, langFilter)
+		}
+		err = w.db.QueryRow("SELECT COUNT(*) FROM books b "+visible, visibleArgs...).Scan(&totalBooks)
+		if err != nil {
+			log.Printf("Database error getting total books count: %v", err)
+			http.Error(wr, "Database error", http.StatusInternalServerError)
+			return
+		}
 
-			rows, err = w.db.Query(`
-			SELECT b.id, b.title, b.file_type, b.file_hash, b.over18,
-				(SELECT CASE
-					WHEN COUNT(*) > 2 THEN 'коллектив авторов'
-					WHEN COUNT(*) = 0 THEN 'Автор не указан'
-					ELSE GROUP_CONCAT(a.full_name, ', ')
-				END
-				FROM book_authors ba
-				LEFT JOIN authors a ON ba.author_id = a.id
-				WHERE ba.book_id = b.id) as authors_str,
-				(SELECT GROUP_CONCAT(t.name, ', ')
-				FROM book_tags bt
-				LEFT JOIN tags t ON bt.tag_id = t.id
-				WHERE bt.book_id = b.id) as tags_str
-			FROM books b
-			WHERE b.over18 = 0 OR b.over18 IS NULL
-			GROUP BY b.id, b.title, b.file_type, b.file_hash, b.over18
-			ORDER BY b.id DESC
-			LIMIT ? OFFSET ?`, perPage, offset)
-			if err != nil {
-				log.Printf("Database error getting books (non-auth): %v", err)
-				http.Error(wr, "Database error", http.StatusInternalServerError)
-				return
-			}
+		rows, err = w.db.Query(`
+		SELECT b.id, b.title, b.file_type, b.file_hash, b.over18,
+			(SELECT CASE
+				WHEN COUNT(*) > 2 THEN 'коллектив авторов'
+				WHEN COUNT(*) = 0 THEN 'Автор не указан'
+				ELSE GROUP_CONCAT(a.full_name, ', ')
+			END
+			FROM book_authors ba
+			LEFT JOIN authors a ON ba.author_id = a.id
+			WHERE ba.book_id = b.id) as authors_str,
+			(SELECT GROUP_CONCAT(t.name, ', ')
+			FROM book_tags bt
+			LEFT JOIN tags t ON bt.tag_id = t.id
+			WHERE bt.book_id = b.id) as tags_str
+		FROM books b
+		`+visible+`
+		GROUP BY b.id, b.title, b.file_type, b.file_hash, b.over18
+		ORDER BY b.id DESC
+		LIMIT ? OFFSET ?`, append(visibleArgs, perPage, offset)...)
+		if err != nil {
+			log.Printf("Database error getting books: %v", err)
+			http.Error(wr, "Database error", http.StatusInternalServerError)
+			return
 		}
 	}
 
@@ -299,6 +202,11 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// Результаты поиска выводим в порядке релевантности
+	if searchIDs != nil {
+		books = search.SortByIDs(books, searchIDs, func(b models.BookWeb) int64 { return int64(b.ID) })
+	}
+
 	// Вычисляем общее количество страниц
 	totalPages := (totalBooks + perPage - 1) / perPage // Округление вверх
 	if totalPages == 0 {
@@ -321,6 +229,12 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 	}
 
 	// Подготавливаем данные для шаблона, включая данные пагинации
+	user := w.currentUser(r)
+	userLogin := ""
+	if user != nil {
+		userLogin = user.Login
+	}
+
 	data := struct {
 		Books           []models.BookWeb
 		CurrentPage     int
@@ -331,8 +245,14 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 		PrevPage        int
 		NextPage        int
 		IsAuthenticated bool
+		IsAdmin         bool
+		UserLogin       string
 		CatalogTitle    string
 		Query           string
+		Language        string
+		Languages       []LanguageFilter // Фильтр по языку: число книг каталога на каждом языке
+		AllLanguagesURL string
+		PageQuery       template.URL // Параметры поиска и фильтра для ссылок на страницы, с «&» в конце
 		AppTitle        string
 		RevisionSuccess bool
 		IPFSEnabled     bool
@@ -345,15 +265,31 @@ func (w *WebInterface) ShowWebInterface(wr http.ResponseWriter, r *http.Request,
 		EndPage:         endPage,
 		PrevPage:        page - 1,
 		NextPage:        page + 1,
-		IsAuthenticated: w.isAuthenticated(r),
+		IsAuthenticated: user != nil,
+		IsAdmin:         user.IsAdmin(),
+		UserLogin:       userLogin,
 		CatalogTitle:    w.config.GetCatalogTitle(),
 		Query:           queryStr,
+		Language:        langFilter,
 		AppTitle:        w.appTitle,
 		RevisionSuccess: revisionSuccess,
 		IPFSEnabled:     w.isIPFSAvailable(),
 		NostrEnabled:    w.isNostrAvailable(),
 	}
 
+	// Ссылки фильтра по языку сохраняют поисковый запрос, ссылки на страницы — и запрос, и язык
+	if params := catalogQuery(queryStr, langFilter).Encode(); params != "" {
+		data.PageQuery = template.URL(params + "&")
+	}
+	data.AllLanguagesURL = "/?" + catalogQuery(queryStr, "").Encode()
+	if data.Languages, err = w.catalogLanguages(policy); err != nil {
+		log.Printf("Ошибка получения языков каталога: %v", err)
+	}
+	for i := range data.Languages {
+		data.Languages[i].Active = data.Languages[i].Code == langFilter
+		data.Languages[i].URL = "/?" + catalogQuery(queryStr, data.Languages[i].Code).Encode()
+	}
+
 	// Генерируем список номеров страниц для отображения
 	for i := startPage; i <= endPage; i++ {
 		data.PageNumbers = append(data.PageNumbers, i)
@@ -396,7 +332,7 @@ func (w *WebInterface) RevisionHandler(wr http.ResponseWriter, r *http.Request)
 	}
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermRevision) {
 		log.Println("RevisionHandler: Пользователь не аутентифицирован")
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -412,6 +348,38 @@ func (w *WebInterface) RevisionHandler(wr http.ResponseWriter, r *http.Request)
 		log.Println("RevisionHandler: Пользователь аутентифицирован, метод POST")
 	}
 
+	if err := w.startRevision(); err != nil {
+		log.Printf("RevisionHandler: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errRevisionRunning) {
+			status = http.StatusConflict
+		}
+		http.Error(wr, err.Error(), status)
+		return
+	}
+
+	// Отправляем ответ клиенту немедленно
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(http.StatusOK)
+	json.NewEncoder(wr).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Ревизия начата. Пожалуйста, подождите завершения операции.",
+		"status":  "started",
+	})
+
+	if cfg.Debug {
+		log.Println("RevisionHandler: Отправлен ответ 200 OK клиенту")
+	}
+}
+
+// errRevisionRunning — ревизия уже выполняется, вторую не запускаем
+var errRevisionRunning = errors.New("ревизия уже выполняется")
+
+// startRevision запускает полную ревизию библиотеки в фоне; ход выполнения
+// доступен через GetRevisionProgress. Используется веб-интерфейсом и API
+func (w *WebInterface) startRevision() error {
+	cfg := config.GetConfig()
+
 	// Передаем DB и конфигурацию в scanner
 	scanner.SetDB(w.db)
 	if w.config != nil {
@@ -429,26 +397,13 @@ func (w *WebInterface) RevisionHandler(wr http.ResponseWriter, r *http.Request)
 	var dummy int
 	err := w.db.QueryRow("SELECT 1").Scan(&dummy)
 	if err != nil {
-		log.Printf("RevisionHandler: Критическая ошибка доступа к БД: %v", err)
-		http.Error(wr, "Критическая ошибка: невозможно получить доступ к базе данных", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("критическая ошибка: невозможно получить доступ к базе данных: %w", err)
 	}
 	//	log.Println("RevisionHandler: Доступ к БД подтвержден")
 
-	// Сбрасываем прогресс перед началом
-	ResetRevisionProgress()
-
-	// Отправляем ответ клиенту немедленно
-	wr.Header().Set("Content-Type", "application/json")
-	wr.WriteHeader(http.StatusOK)
-	json.NewEncoder(wr).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Ревизия начата. Пожалуйста, подождите завершения операции.",
-		"status":  "started",
-	})
-
-	if cfg.Debug {
-		log.Println("RevisionHandler: Отправлен ответ 200 OK клиенту")
+	// Сбрасываем прогресс перед началом; заодно проверяем, что ревизия ещё не идёт
+	if !beginRevisionProgress() {
+		return errRevisionRunning
 	}
 
 	// --- АСИНХРОННОЕ ВЫПОЛНЕНИЕ РЕВИЗИИ ---
@@ -464,14 +419,19 @@ func (w *WebInterface) RevisionHandler(wr http.ResponseWriter, r *http.Request)
 		}{
 			{"Заполнение недостающих полей поиска", scanner.FillMissingLowercaseFields, 2},   // 1. Сначала заполняем пустые поля
 			{"Очистка отсутствующих файлов", scanner.CleanupMissingFiles, 2},                 // 2. Удаляем записи для *отсутствующих* файлов из БД
-			{"Сканирование каталога книг", scanner.ScanBooksDirectory, 76},                   // 3. Находим *новые* файлы, добавляем в БД
+			{"Сканирование каталога книг", scanner.ScanBooksDirectory, 72},                   // 3. Находим *новые* файлы, добавляем в БД
 			{"Переименование книг по конфигурации", scanner.RenameBooksAccordingToConfig, 2}, // 4. Переименовываем файлы *и обновляем БД*
 			{"Очистка неиспользуемых данных", scanner.CleanupOrphanedData, 2},                // 5. Удаляем неиспользуемых авторов/тегов (после переименования)
 			{"Очистка данных nostr", w.cleanupAllNostrData, 2},                               // 6. Очистка Nostr
 			{"Создание недостающих обложек", scanner.GenerateMissingCovers, 5},               // 7. Создаём обложки
 			{"Создание недостающих аннотаций", scanner.GenerateMissingAnnotations, 2},        // 8. Создаём аннотации
-			{"Добавление недостающих ссылок IPFS", w.addMissingIPFSLinks, 5},                 // 9. Добавляем IPFS
-			{"Очистка лишних файлов в каталоге", scanner.CleanupExtraFiles, 2},               // 10. Удаляем файлы, не связанные с БД
+			{"Определение языка книг", scanner.FillMissingLanguages, 2},                      // 9. Язык книг, добавленных до появления поля
+			{"Определение жанров книг", scanner.FillMissingGenres, 2},                        // 10. Жанры книг, добавленных до появления жанров
+			{"Добавление недостающих ссылок IPFS", w.addMissingIPFSLinks, 5},                 // 11. Добавляем IPFS
+			{"Очистка лишних файлов в каталоге", scanner.CleanupExtraFiles, 2},               // 12. Удаляем файлы, не связанные с БД
+			{"Упорядочивание серий", scanner.FillMissingSeries, 2},                           // 13. Связываем книги с сериями до группировки форматов
+			{"Группировка форматов книг", scanner.AssignMissingWorks, 2},                     // 14. Объединяем форматы одного произведения
+			{"Перестроение поискового индекса", scanner.RebuildSearchIndex, 2},               // 15. Индекс с учётом новых аннотаций
 		}
 
 		totalWeight := 0
@@ -512,6 +472,7 @@ func (w *WebInterface) RevisionHandler(wr http.ResponseWriter, r *http.Request)
 		SetRevisionCompleted()
 		log.Printf("%s Полная ревизия завершена!", logPrefix)
 	}()
+	return nil
 }
 
 // ProgressHandler возвращает текущий прогресс ревизии
@@ -723,6 +684,11 @@ func updateIPFSCIDWithRetry(database *sql.DB, bookID int, ipfsCID string, maxRet
 	for i := 0; i < maxRetries; i++ {
 		_, err := database.Exec("UPDATE books SET ipfs_cid = ? WHERE id = ?", ipfsCID, bookID)
 		if err == nil {
+			if err := history.Log(database, history.NewOperation(), history.OriginRevision, history.Change{
+				BookID: int64(bookID), Field: history.FieldIPFSCID, NewValue: ipfsCID,
+			}); err != nil {
+				log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+			}
 			return nil // Успех
 		}
 
@@ -792,6 +758,22 @@ func SetRevisionCompleted() {
 func ResetRevisionProgress() {
 	revisionProgress.mu.Lock()
 	defer revisionProgress.mu.Unlock()
+	resetRevisionProgressLocked()
+}
+
+// beginRevisionProgress сбрасывает прогресс, если ревизия сейчас не выполняется;
+// возвращает false, если она уже идёт
+func beginRevisionProgress() bool {
+	revisionProgress.mu.Lock()
+	defer revisionProgress.mu.Unlock()
+	if revisionProgress.Status == "running" {
+		return false
+	}
+	resetRevisionProgressLocked()
+	return true
+}
+
+func resetRevisionProgressLocked() {
 	revisionProgress.Status = "running"
 	revisionProgress.Progress = 0
 	revisionProgress.Message = "Начало ревизии"
@@ -0,0 +1,226 @@
+// web/genres.go
+package web
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/genres"
+	"turanga/models"
+)
+
+// GenreCount — жанр с числом книг для страницы жанров
+type GenreCount struct {
+	genres.Genre
+	Count int
+}
+
+// GenreGroupView — раздел классификатора на странице жанров
+type GenreGroupView struct {
+	Code   string
+	Name   string
+	NameEn string
+	Count  int
+	Genres []GenreCount
+}
+
+// GenresHandler показывает жанры по классификатору fb2 и книги жанра
+// URL: /genre/ — все разделы и жанры, /genre/{код} — книги жанра
+func (w *WebInterface) GenresHandler(wr http.ResponseWriter, r *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/genre/"), "/")
+	if code == "" {
+		w.showGenreTree(wr, r)
+		return
+	}
+	genre, ok := genres.Lookup(code)
+	if !ok {
+		http.NotFound(wr, r)
+		return
+	}
+	w.showGenreBooks(wr, r, genre)
+}
+
+// showGenreTree показывает разделы и жанры, в которых есть видимые пользователю книги
+func (w *WebInterface) showGenreTree(wr http.ResponseWriter, r *http.Request) {
+	policy := access.ForRequest(r)
+	counts, err := genres.Counts(w.db, policy)
+	if err != nil {
+		log.Printf("Ошибка подсчёта книг по жанрам: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	groupCounts, err := genres.GroupCounts(w.db, policy)
+	if err != nil {
+		log.Printf("Ошибка подсчёта книг по разделам жанров: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var groups []GenreGroupView
+	for _, group := range genres.Tree {
+		if groupCounts[group.Code] == 0 {
+			continue
+		}
+		view := GenreGroupView{Code: group.Code, Name: group.Name, NameEn: group.NameEn, Count: groupCounts[group.Code]}
+		for _, genre := range group.Genres {
+			if counts[genre.Code] > 0 {
+				view.Genres = append(view.Genres, GenreCount{Genre: genre, Count: counts[genre.Code]})
+			}
+		}
+		groups = append(groups, view)
+	}
+
+	data := struct {
+		CatalogTitle string
+		Groups       []GenreGroupView
+	}{
+		CatalogTitle: w.config.GetCatalogTitle(),
+		Groups:       groups,
+	}
+	w.renderGenresTemplate(wr, "genres", data)
+}
+
+// showGenreBooks показывает книги жанра постранично
+func (w *WebInterface) showGenreBooks(wr http.ResponseWriter, r *http.Request, genre genres.Genre) {
+	cfg := config.GetConfig()
+
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	perPage := 60
+	if w.config != nil {
+		perPage = w.config.PaginationThreshold
+	}
+	offset := (page - 1) * perPage
+
+	policy := access.ForRequest(r)
+	condition, args := genres.BookCondition([]string{genre.Code})
+
+	var totalBooks int
+	err := w.db.QueryRow("SELECT COUNT(*) FROM books b WHERE "+condition+" "+policy.Filter("b"), args...).Scan(&totalBooks)
+	if err != nil {
+		log.Printf("Database error getting total books count for genre %s: %v", genre.Code, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := w.db.Query(`
+		SELECT b.id, b.title, b.file_hash,
+		       CASE
+		           WHEN COUNT(a.id) > 2 THEN 'коллектив авторов'
+		           WHEN COUNT(a.id) = 0 THEN 'Автор не указан'
+		           ELSE GROUP_CONCAT(a.full_name, ', ')
+		       END as authors_str
+		FROM books b
+		LEFT JOIN book_authors ba ON b.id = ba.book_id
+		LEFT JOIN authors a ON ba.author_id = a.id
+		WHERE `+condition+`
+		  `+policy.Filter("b")+`
+		GROUP BY b.id, b.title, b.file_hash
+		ORDER BY LOWER(b.title)
+		LIMIT ? OFFSET ?
+	`, append(args, perPage, offset)...)
+	if err != nil {
+		log.Printf("Database error getting genre books for %s: %v", genre.Code, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var books []models.BookWeb
+	for rows.Next() {
+		var b models.BookWeb
+		var fileHash sql.NullString
+		if err := rows.Scan(&b.ID, &b.Title, &fileHash, &b.AuthorsStr); err != nil {
+			if cfg.Debug {
+				log.Printf("Error scanning genre book row: %v", err)
+			}
+			continue
+		}
+		if fileHash.Valid {
+			b.FileHash = fileHash.String
+			b.CoverURL = w.getCoverURLFromFileHash(fileHash.String, w.config)
+		}
+		books = append(books, b)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating genre book rows: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := (totalBooks + perPage - 1) / perPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	pageRange := 5
+	startPage := page - pageRange/2
+	if startPage < 1 {
+		startPage = 1
+	}
+	endPage := startPage + pageRange - 1
+	if endPage > totalPages {
+		endPage = totalPages
+		startPage = endPage - pageRange + 1
+		if startPage < 1 {
+			startPage = 1
+		}
+	}
+
+	group, _ := genres.LookupGroup(genre.Group)
+	data := struct {
+		Genre       genres.Genre
+		GroupName   string
+		Total       int
+		Books       []models.BookWeb
+		CurrentPage int
+		TotalPages  int
+		StartPage   int
+		EndPage     int
+		PageNumbers []int
+		PrevPage    int
+		NextPage    int
+	}{
+		Genre:       genre,
+		GroupName:   group.Name,
+		Total:       totalBooks,
+		Books:       books,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		StartPage:   startPage,
+		EndPage:     endPage,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+	}
+	for i := startPage; i <= endPage; i++ {
+		data.PageNumbers = append(data.PageNumbers, i)
+	}
+	w.renderGenresTemplate(wr, "genre", data)
+}
+
+// renderGenresTemplate выполняет шаблон из genres.html
+func (w *WebInterface) renderGenresTemplate(wr http.ResponseWriter, name string, data interface{}) {
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "genres.html")
+	tmpl, err := template.New("genres.html").Funcs(template.FuncMap{
+		"sub": func(a, b int) int { return a - b },
+	}).ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing genres template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(wr, name, data); err != nil {
+		log.Printf("Error executing %s template: %v", name, err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,198 @@
+// web/history.go
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/history"
+	"turanga/users"
+)
+
+// fieldLabels — подписи полей в истории изменений
+var fieldLabels = map[string]string{
+	history.FieldTitle:        "Название",
+	history.FieldAuthors:      "Авторы",
+	history.FieldSeries:       "Серия",
+	history.FieldSeriesNumber: "Номер в серии",
+	history.FieldYear:         "Год",
+	history.FieldPublisher:    "Издатель",
+	history.FieldISBN:         "ISBN",
+	history.FieldTags:         "Теги",
+	history.FieldOver18:       "18+",
+	history.FieldAnnotation:   "Аннотация",
+	history.FieldIPFSCID:      "IPFS CID",
+	history.FieldShared:       "Доступ через Nostr",
+	history.FieldLanguage:     "Язык",
+	history.FieldSourceLang:   "Язык оригинала",
+	history.FieldCreated:      "Добавлена",
+}
+
+// originLabels — подписи источников изменений
+var originLabels = map[string]string{
+	history.OriginWeb:      "веб-интерфейс",
+	history.OriginRevision: "ревизия",
+	history.OriginNostr:    "Nostr",
+	history.OriginImport:   "импорт",
+	history.OriginUndo:     "отмена",
+	history.OriginAPI:      "API",
+}
+
+// HistoryEntryView — запись журнала для шаблона страницы книги
+type HistoryEntryView struct {
+	ID            int64
+	OperationID   string
+	OperationSize int
+	Field         string
+	OldValue      string
+	NewValue      string
+	Origin        string
+	CreatedAt     string
+	Undone        bool
+	CanUndo       bool
+}
+
+// logBookChange записывает в журнал изменение поля книги, сделанное через веб-интерфейс или API.
+// Новое значение читается из БД, чтобы в журнале была ровно та форма, что сохранена.
+func (w *WebInterface) logBookChange(operationID, origin string, bookID int, field, oldValue string) {
+	newValue, err := history.Current(w.db, w.rootPath, int64(bookID), field)
+	if err != nil {
+		log.Printf("Предупреждение: не удалось прочитать поле %s книги ID %d для журнала: %v", field, bookID, err)
+		return
+	}
+	if err := history.Log(w.db, operationID, origin, history.Change{
+		BookID: int64(bookID), Field: field, OldValue: oldValue, NewValue: newValue,
+	}); err != nil {
+		log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+	}
+}
+
+// snapshotBooks запоминает значение поля у всех книг, выбранных запросом.
+// Нужна для массовых операций (переименование автора или серии), затрагивающих много книг.
+func (w *WebInterface) snapshotBooks(field, query string, args ...interface{}) map[int]string {
+	rows, err := w.db.Query(query, args...)
+	if err != nil {
+		log.Printf("Предупреждение: не удалось получить книги для журнала: %v", err)
+		return nil
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	snapshot := make(map[int]string, len(ids))
+	for _, id := range ids {
+		value, err := history.Current(w.db, w.rootPath, int64(id), field)
+		if err != nil {
+			log.Printf("Предупреждение: %v", err)
+			continue
+		}
+		snapshot[id] = value
+	}
+	return snapshot
+}
+
+// logSnapshotChanges записывает в журнал изменения поля у книг из снимка одной операцией
+func (w *WebInterface) logSnapshotChanges(origin, field string, snapshot map[int]string) {
+	operationID := history.NewOperation()
+	for bookID, oldValue := range snapshot {
+		w.logBookChange(operationID, origin, bookID, field, oldValue)
+	}
+}
+
+// bookHistory готовит историю изменений книги для отображения
+func (w *WebInterface) bookHistory(bookID int) []HistoryEntryView {
+	entries, err := history.ForBook(w.db, int64(bookID), 50)
+	if err != nil {
+		log.Printf("Ошибка получения истории книги ID %d: %v", bookID, err)
+		return nil
+	}
+
+	views := make([]HistoryEntryView, 0, len(entries))
+	for _, e := range entries {
+		field := fieldLabels[e.Field]
+		if field == "" {
+			field = e.Field
+		}
+		origin := originLabels[e.Origin]
+		if origin == "" {
+			origin = e.Origin
+		}
+		views = append(views, HistoryEntryView{
+			ID:            e.ID,
+			OperationID:   e.OperationID,
+			OperationSize: e.OperationSize,
+			Field:         field,
+			OldValue:      e.OldValue,
+			NewValue:      e.NewValue,
+			Origin:        origin,
+			CreatedAt:     e.CreatedAt.Format("02.01.2006 15:04"),
+			Undone:        e.Undone,
+			CanUndo:       !e.Undone && e.Field != history.FieldCreated,
+		})
+	}
+	return views
+}
+
+// UndoChangeHandler отменяет одно изменение или всю операцию
+// URL: POST /history/undo/{id} или POST /history/undo-operation/{operation_id}
+// Поле формы return — ID книги, на страницу которой нужно вернуться
+func (w *WebInterface) UndoChangeHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/history/undo-operation/"):
+		operationID := strings.TrimPrefix(r.URL.Path, "/history/undo-operation/")
+		if operationID == "" {
+			http.Error(wr, "Operation ID is required", http.StatusBadRequest)
+			return
+		}
+		err = history.UndoOperation(w.db, w.rootPath, operationID)
+	default:
+		entryID, convErr := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/history/undo/"), 10, 64)
+		if convErr != nil {
+			http.Error(wr, "Invalid change ID", http.StatusBadRequest)
+			return
+		}
+		err = history.Undo(w.db, w.rootPath, entryID)
+	}
+
+	if err != nil {
+		log.Printf("Ошибка отмены изменения %s: %v", r.URL.Path, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, history.ErrConflict) {
+			status = http.StatusConflict
+		}
+		http.Error(wr, err.Error(), status)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Выполнена отмена: %s", r.URL.Path)
+	}
+
+	if bookID, err := strconv.Atoi(r.FormValue("return")); err == nil && bookID > 0 {
+		http.Redirect(wr, r, fmt.Sprintf("/book/%d", bookID), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(wr, r, "/", http.StatusSeeOther)
+}
@@ -12,16 +12,15 @@ import (
 
 	"turanga/config"
 	"turanga/nostr"
+	"turanga/users"
 
 	shell "github.com/ipfs/go-ipfs-api"
 )
 
 // DownloadIPFSBookHandler обрабатывает скачивание книги через IPFS
 func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.Request) {
-	cfg := config.GetConfig()
-
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		w.writeJSONError(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -51,15 +50,56 @@ func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.R
 		return
 	}
 
+	filePath, existed, err := w.downloadIPFSBook(requestData.FileHash, requestData.IPFSCID, requestData.FileType, requestData.Title)
+	if err != nil {
+		log.Printf("Ошибка скачивания книги через IPFS: %v", err)
+		if filePath == "" {
+			w.writeJSONError(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Файл уже скачан, но не зарегистрирован в БД
+		w.writeJSONResponse(wr, map[string]interface{}{
+			"success":  false,
+			"message":  "File downloaded but failed to register: " + err.Error(),
+			"path":     filePath,
+			"fileHash": requestData.FileHash,
+		})
+		return
+	}
+	if existed {
+		w.writeJSONResponse(wr, map[string]interface{}{
+			"success":  true,
+			"message":  "File already exists",
+			"path":     filePath,
+			"fileHash": requestData.FileHash,
+		})
+		return
+	}
+
+	// Отправляем успешный ответ с информацией о скачанной книге (немедленно)
+	w.writeJSONResponse(wr, map[string]interface{}{
+		"success":  true,
+		"message":  "File downloaded and registered successfully",
+		"path":     filePath,
+		"fileHash": requestData.FileHash,
+		"book_id":  w.bookIDByHash(requestData.FileHash),
+	})
+}
+
+// downloadIPFSBook скачивает книгу из IPFS в каталог книг и регистрирует её в БД.
+// Возвращает путь к файлу и признак того, что файл уже был скачан раньше.
+// Если файл скачан, но не зарегистрирован, возвращается и путь, и ошибка
+func (w *WebInterface) downloadIPFSBook(fileHash, cid, fileType, title string) (string, bool, error) {
+	cfg := config.GetConfig()
+
 	if cfg.Debug {
-		log.Printf("Начинаем скачивание книги через IPFS: hash=%s, cid=%s, type=%s",
-			requestData.FileHash, requestData.IPFSCID, requestData.FileType)
+		log.Printf("Начинаем скачивание книги через IPFS: hash=%s, cid=%s, type=%s", fileHash, cid, fileType)
 	}
 
 	// Определяем расширение файла
-	ext := getFileExtensionByType(requestData.FileType)
+	ext := getFileExtensionByType(fileType)
 	if cfg.Debug {
-		log.Printf("Определено расширение: '%s' для типа файла: '%s'", ext, requestData.FileType)
+		log.Printf("Определено расширение: '%s' для типа файла: '%s'", ext, fileType)
 	}
 	if ext == "" {
 		ext = ".bin"
@@ -96,9 +136,7 @@ func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.R
 
 	// Создаем каталог если он не существует
 	if err := os.MkdirAll(booksDir, 0755); err != nil {
-		log.Printf("Ошибка создания каталога books: %v", err)
-		w.writeJSONError(wr, "Failed to create books directory", http.StatusInternalServerError)
-		return
+		return "", false, fmt.Errorf("failed to create books directory: %w", err)
 	}
 
 	if cfg.Debug {
@@ -106,7 +144,7 @@ func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.R
 	}
 
 	// Формируем путь к файлу
-	fileName := fmt.Sprintf("%s%s", requestData.FileHash, ext)
+	fileName := fmt.Sprintf("%s%s", fileHash, ext)
 	filePath := filepath.Join(booksDir, fileName)
 
 	// Проверяем, не существует ли уже файл
@@ -114,29 +152,18 @@ func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.R
 		if cfg.Debug {
 			log.Printf("Файл уже существует: %s", filePath)
 		}
-		w.writeJSONResponse(wr, map[string]interface{}{
-			"success":  true,
-			"message":  "File already exists",
-			"path":     filePath,
-			"fileHash": requestData.FileHash,
-		})
-		return
+		return filePath, true, nil
 	}
 
 	// Получаем IPFS клиент
 	ipfsShell, err := w.getIPFSShell()
 	if err != nil {
-		log.Printf("Ошибка получения IPFS клиента: %v", err)
-		w.writeJSONError(wr, "IPFS not configured", http.StatusInternalServerError)
-		return
+		return "", false, fmt.Errorf("IPFS not configured: %w", err)
 	}
 
 	// Скачиваем файл
-	err = w.downloadIPFSFile(ipfsShell, requestData.IPFSCID, filePath)
-	if err != nil {
-		log.Printf("Ошибка скачивания файла из IPFS: %v", err)
-		w.writeJSONError(wr, "Failed to download file from IPFS: "+err.Error(), http.StatusInternalServerError)
-		return
+	if err := w.downloadIPFSFile(ipfsShell, cid, filePath); err != nil {
+		return "", false, fmt.Errorf("failed to download file from IPFS: %w", err)
 	}
 
 	log.Printf("Файл успешно скачан: %s", filePath)
@@ -144,85 +171,71 @@ func (w *WebInterface) DownloadIPFSBookHandler(wr http.ResponseWriter, r *http.R
 	// Получаем информацию о файле
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
-		log.Printf("Ошибка получения информации о файле: %v", err)
-		w.writeJSONResponse(wr, map[string]interface{}{
-			"success":  false,
-			"message":  "File downloaded but failed to get file info: " + err.Error(),
-			"path":     filePath,
-			"fileHash": requestData.FileHash,
-		})
-		return
+		return filePath, false, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Регистрируем файл в БД через scanner
-	err = w.registerDownloadedBook(filePath, fileInfo, requestData.Title, requestData.FileHash)
-	if err != nil {
-		if cfg.Debug {
-			log.Printf("Ошибка регистрации книги в БД: %v", err)
-		}
-		// Не возвращаем ошибку, так как файл уже скачан
-		w.writeJSONResponse(wr, map[string]interface{}{
-			"success":  false,
-			"message":  "File downloaded but failed to register in DB: " + err.Error(),
-			"path":     filePath,
-			"fileHash": requestData.FileHash,
-		})
-		return
+	if err := w.registerDownloadedBook(filePath, fileInfo, title, fileHash); err != nil {
+		return filePath, false, err
 	}
 
 	if cfg.Debug {
 		log.Printf("Книга успешно зарегистрирована в БД: %s", filePath)
 	}
 
-	// Получаем ID зарегистрированной книги из БД и pubkey отправителя
+	// Начисляем бонус другу, приславшему книгу; не блокируем ответ
+	go w.creditIPFSResponder(fileHash)
+
+	return filePath, false, nil
+}
+
+// bookIDByHash возвращает ID книги с указанным хешем файла или 0
+func (w *WebInterface) bookIDByHash(fileHash string) int64 {
 	var bookID int64
-	var responderPubkey sql.NullString
+	if err := w.db.QueryRow("SELECT id FROM books WHERE file_hash = ?", fileHash).Scan(&bookID); err != nil && err != sql.ErrNoRows {
+		log.Printf("Ошибка поиска книги по хешу %s: %v", fileHash, err)
+	}
+	return bookID
+}
+
+// creditIPFSResponder увеличивает счетчик бонусов друга, приславшего книгу в ответ на запрос
+func (w *WebInterface) creditIPFSResponder(fileHash string) {
+	cfg := config.GetConfig()
 
 	// Ищем pubkey отправителя через file_hash в nostr_response_books и nostr_received_responses
-	// Выполняем запрос асинхронно чтобы не блокировать основной поток
-	go func() {
-		err := w.db.QueryRow(`
-			SELECT b.id, r.responder_pubkey 
-			FROM books b
-			LEFT JOIN nostr_response_books rb ON b.file_hash = rb.file_hash
-			LEFT JOIN nostr_received_responses r ON rb.response_id = r.id
-			WHERE b.file_hash = ? 
-			LIMIT 1
-		`, requestData.FileHash).Scan(&bookID, &responderPubkey)
-
-		if err != nil && err != sql.ErrNoRows {
-			if cfg.Debug {
-				log.Printf("Ошибка получения ID книги и pubkey отправителя из БД: %v", err)
-			}
-			return
+	var responderPubkey sql.NullString
+	err := w.db.QueryRow(`
+		SELECT r.responder_pubkey 
+		FROM books b
+		LEFT JOIN nostr_response_books rb ON b.file_hash = rb.file_hash
+		LEFT JOIN nostr_received_responses r ON rb.response_id = r.id
+		WHERE b.file_hash = ? 
+		LIMIT 1
+	`, fileHash).Scan(&responderPubkey)
+
+	if err != nil && err != sql.ErrNoRows {
+		if cfg.Debug {
+			log.Printf("Ошибка получения pubkey отправителя из БД: %v", err)
 		}
+		return
+	}
 
-		// Если найден pubkey отправителя, увеличиваем счетчик бонусов
-		if responderPubkey.Valid && responderPubkey.String != "" && w.NostrClient != nil {
-			// Создаем временный SubscriptionManager для вызова функции
-			subManager := nostr.NewSubscriptionManager(w.NostrClient, w.config, w.db)
-			err := subManager.IncrementFriendDownloadCount(responderPubkey.String)
-			if err != nil {
-				if cfg.Debug {
-					log.Printf("Ошибка увеличения счетчика бонусов для друга %s: %v", responderPubkey.String, err)
-				}
-			} else {
-				if cfg.Debug {
-					log.Printf("Увеличен счетчик бонусов для друга %s при скачивании книги %s",
-						responderPubkey.String, requestData.FileHash)
-				}
+	// Если найден pubkey отправителя, увеличиваем счетчик бонусов
+	if responderPubkey.Valid && responderPubkey.String != "" && w.NostrClient != nil {
+		// Создаем временный SubscriptionManager для вызова функции
+		subManager := nostr.NewSubscriptionManager(w.NostrClient, w.config, w.db)
+		err := subManager.IncrementFriendDownloadCount(responderPubkey.String)
+		if err != nil {
+			if cfg.Debug {
+				log.Printf("Ошибка увеличения счетчика бонусов для друга %s: %v", responderPubkey.String, err)
+			}
+		} else {
+			if cfg.Debug {
+				log.Printf("Увеличен счетчик бонусов для друга %s при скачивании книги %s",
+					responderPubkey.String, fileHash)
 			}
 		}
-	}()
-
-	// Отправляем успешный ответ с информацией о скачанной книге (немедленно)
-	w.writeJSONResponse(wr, map[string]interface{}{
-		"success":  true,
-		"message":  "File downloaded and registered successfully",
-		"path":     filePath,
-		"fileHash": requestData.FileHash,
-		"book_id":  bookID,
-	})
+	}
 }
 
 // Вспомогательная функция для получения IPFS shell
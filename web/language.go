@@ -0,0 +1,79 @@
+// web/language.go
+package web
+
+import (
+	"fmt"
+	"net/url"
+
+	"turanga/access"
+	"turanga/language"
+)
+
+// LanguageOption — вариант в списке выбора языка
+type LanguageOption struct {
+	Code     string
+	Name     string
+	Selected bool
+}
+
+// languageOptions готовит список языков для редактирования; selected — текущий код.
+// Язык не из списка language.Known добавляется, чтобы его не потерять при сохранении.
+func languageOptions(selected string) []LanguageOption {
+	options := make([]LanguageOption, 0, len(language.Known)+2)
+	options = append(options, LanguageOption{Name: "не указан", Selected: selected == ""})
+	known := false
+	for _, l := range language.Known {
+		options = append(options, LanguageOption{Code: l.Code, Name: l.Name, Selected: l.Code == selected})
+		known = known || l.Code == selected
+	}
+	if selected != "" && !known {
+		options = append(options, LanguageOption{Code: selected, Name: selected, Selected: true})
+	}
+	return options
+}
+
+// LanguageFilter — язык в фильтре каталога
+type LanguageFilter struct {
+	Code   string
+	Name   string
+	Count  int
+	Active bool
+	URL    string
+}
+
+// catalogLanguages считает видимые пользователю книги каталога по языкам.
+// Книги без языка в фильтр не попадают.
+func (w *WebInterface) catalogLanguages(policy access.Policy) ([]LanguageFilter, error) {
+	rows, err := w.db.Query(`
+        SELECT b.language, COUNT(*) FROM books b
+        WHERE IFNULL(b.language, '') != '' ` + policy.Filter("b") + `
+        GROUP BY b.language
+        ORDER BY COUNT(*) DESC, b.language`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта книг по языкам: %w", err)
+	}
+	defer rows.Close()
+
+	var filters []LanguageFilter
+	for rows.Next() {
+		var f LanguageFilter
+		if err := rows.Scan(&f.Code, &f.Count); err != nil {
+			return nil, fmt.Errorf("ошибка чтения языка: %w", err)
+		}
+		f.Name = language.Name(f.Code)
+		filters = append(filters, f)
+	}
+	return filters, rows.Err()
+}
+
+// catalogQuery возвращает параметры адреса каталога с поиском и фильтром по языку
+func catalogQuery(query, lang string) url.Values {
+	values := url.Values{}
+	if query != "" {
+		values.Set("q", query)
+	}
+	if lang != "" {
+		values.Set("lang", lang)
+	}
+	return values
+}
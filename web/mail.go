@@ -0,0 +1,172 @@
+// web/mail.go
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"turanga/access"
+	"turanga/mailer"
+)
+
+// deliveryLogLimit — сколько последних отправок показывать на странице учётной записи
+const deliveryLogLimit = 20
+
+// DeliveryView — запись журнала отправки книг для шаблона
+type DeliveryView struct {
+	ID          int64
+	BookID      int64
+	Title       string
+	Email       string
+	StatusLabel string
+	Failed      bool
+	Error       string
+	CreatedAt   string
+}
+
+// deliveryViews готовит журнал отправки пользователя для шаблона
+func (w *WebInterface) deliveryViews(userID int64) []DeliveryView {
+	deliveries, err := mailer.ListDeliveries(w.db, userID, deliveryLogLimit)
+	if err != nil {
+		log.Printf("Ошибка получения журнала отправки пользователя %d: %v", userID, err)
+		return nil
+	}
+	views := make([]DeliveryView, 0, len(deliveries))
+	for _, d := range deliveries {
+		view := DeliveryView{
+			ID:          d.ID,
+			BookID:      d.BookID,
+			Title:       d.Title,
+			Email:       d.Email,
+			StatusLabel: mailer.StatusLabels[d.Status],
+			Failed:      d.Status == mailer.StatusFailed,
+			CreatedAt:   d.CreatedAt.Format("02.01.2006 15:04"),
+		}
+		// Ошибку показываем и для книги в очереди: видно, почему отправка повторяется
+		if d.Status != mailer.StatusSent {
+			view.Error = d.LastError
+		}
+		if d.Attempts > 1 && d.Status == mailer.StatusPending {
+			view.StatusLabel = fmt.Sprintf("%s, попыток: %d", view.StatusLabel, d.Attempts)
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// AccountMailHandler добавляет и удаляет почтовые адреса устройств и повторяет неудачные отправки
+// URL: POST /account/mail/add, POST /account/mail/delete/{id}, POST /account/mail/retry/{id}
+func (w *WebInterface) AccountMailHandler(wr http.ResponseWriter, r *http.Request) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	redirect := func(key, message string) {
+		http.Redirect(wr, r, "/account?"+key+"="+url.QueryEscape(message)+"#mail", http.StatusSeeOther)
+	}
+
+	action, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/account/mail/"), "/")
+	if action == "add" {
+		device, err := mailer.AddDevice(w.db, user.ID, r.FormValue("name"), r.FormValue("email"), r.FormValue("convert_fb2") != "")
+		if err != nil {
+			log.Printf("Ошибка добавления почтового адреса пользователя %s: %v", user.Login, err)
+			redirect("error", "Не удалось добавить адрес: проверьте, что он указан верно")
+			return
+		}
+		log.Printf("Пользователь %s добавил почтовый адрес устройства %s", user.Login, device.Email)
+		redirect("message", "Адрес "+device.Email+" добавлен")
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(wr, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	switch action {
+	case "delete":
+		if err := mailer.DeleteDevice(w.db, user.ID, id); err != nil {
+			log.Printf("Ошибка удаления почтового адреса %d пользователя %s: %v", id, user.Login, err)
+			redirect("error", "Не удалось удалить адрес")
+			return
+		}
+		redirect("message", "Адрес удалён")
+	case "retry":
+		if err := mailer.Retry(w.db, user.ID, id); err != nil {
+			log.Printf("Ошибка повторной отправки %d пользователя %s: %v", id, user.Login, err)
+			redirect("error", "Не удалось повторить отправку")
+			return
+		}
+		redirect("message", "Книга снова поставлена в очередь отправки")
+	default:
+		http.Error(wr, "Unknown action", http.StatusNotFound)
+	}
+}
+
+// SendBookHandler ставит книгу в очередь на отправку на почтовый адрес устройства
+// URL: POST /send/book/{id}
+func (w *WebInterface) SendBookHandler(wr http.ResponseWriter, r *http.Request) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !mailer.Enabled(w.config) {
+		http.Error(wr, "Mail is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	bookID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/send/book/"), 10, 64)
+	if err != nil || bookID <= 0 {
+		http.Error(wr, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+	visible, err := access.ForUser(user).CanSeeBook(w.db, bookID)
+	if err != nil {
+		log.Printf("Ошибка проверки доступа к книге %d: %v", bookID, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		http.NotFound(wr, r)
+		return
+	}
+
+	redirect := func(key, message string) {
+		http.Redirect(wr, r, fmt.Sprintf("/book/%d?%s=%s", bookID, key, url.QueryEscape(message)), http.StatusSeeOther)
+	}
+
+	deviceID, _ := strconv.ParseInt(r.FormValue("device"), 10, 64)
+	device, err := mailer.GetDevice(w.db, user.ID, deviceID)
+	if errors.Is(err, mailer.ErrNotFound) {
+		redirect("error", "Выберите устройство")
+		return
+	} else if err != nil {
+		log.Printf("Ошибка получения почтового адреса %d: %v", deviceID, err)
+		redirect("error", "Не удалось отправить книгу")
+		return
+	}
+
+	if _, err := mailer.Enqueue(w.db, user.ID, bookID, device); err != nil {
+		log.Printf("Ошибка постановки книги %d в очередь отправки: %v", bookID, err)
+		redirect("error", "Не удалось отправить книгу")
+		return
+	}
+	log.Printf("Пользователь %s отправляет книгу %d на %s", user.Login, bookID, device.Email)
+	redirect("message", "Книга отправляется на «"+device.Name+"», состояние — на странице учётной записи")
+}
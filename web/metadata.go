@@ -0,0 +1,101 @@
+// web/metadata.go
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"turanga/config"
+	"turanga/metadata"
+	"turanga/users"
+)
+
+// MetadataExportHandler выгружает метаданные всех книг
+// URL: GET /metadata/export?format=json|csv|opf
+func (w *WebInterface) MetadataExportHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermManage) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case metadata.FormatJSON, metadata.FormatCSV, metadata.FormatOPF:
+	case "":
+		format = metadata.FormatJSON
+	default:
+		http.Error(wr, "Unknown format", http.StatusBadRequest)
+		return
+	}
+
+	records, err := metadata.Export(w.db, w.rootPath)
+	if err != nil {
+		log.Printf("Ошибка выгрузки метаданных: %v", err)
+		http.Error(wr, "Ошибка выгрузки метаданных", http.StatusInternalServerError)
+		return
+	}
+
+	contentType, ext := metadata.ContentType(format)
+	wr.Header().Set("Content-Type", contentType)
+	wr.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="turanga-metadata-%s%s"`, time.Now().Format("20060102"), ext))
+
+	if err := metadata.Write(wr, format, records); err != nil {
+		log.Printf("Ошибка записи метаданных: %v", err)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Выгружены метаданные %d книг в формате %s", len(records), format)
+	}
+}
+
+// MetadataImportHandler применяет загруженные метаданные к книгам по хешу файла
+// URL: POST /metadata/import
+func (w *WebInterface) MetadataImportHandler(wr http.ResponseWriter, r *http.Request) {
+	if !w.can(r, users.PermManage) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		w.showBackupPage(wr, "", "Ошибка чтения загруженного файла")
+		return
+	}
+	file, _, err := r.FormFile("metadata")
+	if err != nil {
+		w.showBackupPage(wr, "", "Файл не выбран")
+		return
+	}
+	defer file.Close()
+
+	records, err := metadata.Read(file)
+	if err != nil {
+		w.showBackupPage(wr, "", "Ошибка чтения метаданных: "+err.Error())
+		return
+	}
+
+	stats, err := metadata.Apply(w.db, w.rootPath, records)
+	if err != nil {
+		log.Printf("Ошибка импорта метаданных: %v", err)
+		w.showBackupPage(wr, "", "Ошибка применения метаданных: "+err.Error())
+		return
+	}
+
+	log.Printf("Импорт метаданных: записей %d, применено %d, не найдено %d, ошибок %d",
+		stats.Total, stats.Applied, stats.NotFound, stats.Failed)
+	w.showBackupPage(wr, fmt.Sprintf("Метаданные применены к %d книгам из %d (не найдено в библиотеке: %d, ошибок: %d).",
+		stats.Applied, stats.Total, stats.NotFound, stats.Failed), "")
+}
@@ -0,0 +1,265 @@
+// web/reader.go
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/access"
+	"turanga/reader"
+	"turanga/works"
+)
+
+// readerMaxBodySize — наибольший размер запроса с местом чтения
+const readerMaxBodySize = 1 << 10
+
+// ReaderTOCItem — пункт оглавления читалки
+type ReaderTOCItem struct {
+	Title   string
+	URL     string
+	Level   int
+	Current bool
+}
+
+// ReaderHandler показывает книгу fb2 или epub в браузере по главам
+// URL: GET /read/{id}?ch={глава}, GET /read/{id}/res/{путь картинки}, POST /read/{id}/position
+func (w *WebInterface) ReaderHandler(wr http.ResponseWriter, r *http.Request) {
+	idStr, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/read/"), "/")
+	bookID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || bookID <= 0 {
+		http.Error(wr, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	policy := access.ForRequest(r)
+	visible, err := policy.CanSeeBook(w.db, bookID)
+	if err != nil {
+		log.Printf("Ошибка проверки доступа к книге %d: %v", bookID, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		http.NotFound(wr, r)
+		return
+	}
+
+	if rest == "position" {
+		w.saveReaderPosition(wr, r, bookID)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files, err := works.Files(w.db, bookID, policy, reader.Formats)
+	if err != nil {
+		log.Printf("Ошибка получения файлов книги %d: %v", bookID, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	file, ok := reader.PickFile(files)
+	if !ok {
+		http.Error(wr, "Эту книгу нельзя читать в браузере: нет файла fb2 или epub", http.StatusNotFound)
+		return
+	}
+	book, err := reader.OpenFile(w.db, w.rootPath, file)
+	if err != nil {
+		log.Printf("Ошибка открытия книги %d для чтения: %v", file.BookID, err)
+		http.Error(wr, "Не удалось открыть книгу", http.StatusInternalServerError)
+		return
+	}
+	defer book.Close()
+
+	if name, ok := strings.CutPrefix(rest, "res/"); ok {
+		w.serveReaderResource(wr, r, book, name)
+		return
+	}
+	if rest != "" {
+		http.NotFound(wr, r)
+		return
+	}
+	w.showReaderChapter(wr, r, bookID, file, book)
+}
+
+// readerURL возвращает адрес главы в читалке
+func readerURL(bookID int64, chapter int, anchor string) string {
+	u := fmt.Sprintf("/read/%d?ch=%d", bookID, chapter)
+	if anchor != "" {
+		u += "#" + url.PathEscape(anchor)
+	}
+	return u
+}
+
+// showReaderChapter показывает главу книги. Без номера главы открывается место,
+// на котором пользователь остановился в прошлый раз.
+func (w *WebInterface) showReaderChapter(wr http.ResponseWriter, r *http.Request, bookID int64, file works.File, book *reader.Book) {
+	user := w.currentUser(r)
+	chapter, offset := 0, 0.0
+	if ch := r.URL.Query().Get("ch"); ch != "" {
+		n, err := strconv.Atoi(ch)
+		if err != nil || n < 0 || n >= len(book.Chapters) {
+			http.Redirect(wr, r, fmt.Sprintf("/read/%d", bookID), http.StatusSeeOther)
+			return
+		}
+		chapter = n
+	} else if user != nil {
+		pos, err := reader.GetPosition(w.db, user.ID, bookID)
+		if err != nil {
+			log.Printf("Ошибка получения места чтения книги %d: %v", bookID, err)
+		} else if pos != nil && pos.Chapter < len(book.Chapters) {
+			chapter, offset = pos.Chapter, pos.Offset
+		}
+	}
+
+	content, err := book.RenderChapter(chapter, reader.URLs{
+		Resource: func(name string) string {
+			segments := strings.Split(name, "/")
+			for i, s := range segments {
+				segments[i] = url.PathEscape(s)
+			}
+			return fmt.Sprintf("/read/%d/res/%s", bookID, strings.Join(segments, "/"))
+		},
+		Chapter: func(n int, anchor string) string {
+			return readerURL(bookID, n, anchor)
+		},
+	})
+	if err != nil {
+		log.Printf("Ошибка показа главы %d книги %d: %v", chapter, bookID, err)
+		http.Error(wr, "Не удалось показать главу", http.StatusInternalServerError)
+		return
+	}
+
+	// Оглавление книги, а если его нет — список глав
+	var toc []ReaderTOCItem
+	for _, entry := range book.TOC {
+		toc = append(toc, ReaderTOCItem{
+			Title:   entry.Title,
+			URL:     readerURL(bookID, entry.Chapter, reader.Anchor(entry.Fragment)),
+			Level:   entry.Level,
+			Current: entry.Chapter == chapter,
+		})
+	}
+	if len(toc) == 0 {
+		for i, c := range book.Chapters {
+			toc = append(toc, ReaderTOCItem{Title: c.Title, URL: readerURL(bookID, i, ""), Current: i == chapter})
+		}
+	}
+
+	title := file.Title
+	if title == "" {
+		title = book.Title
+	}
+	data := struct {
+		CatalogTitle  string
+		BookID        int64
+		Title         string
+		ChapterTitle  string
+		Chapter       int
+		ChapterNumber int // С единицы, для показа
+		Chapters      int
+		PrevURL       string
+		NextURL       string
+		TOC           []ReaderTOCItem
+		Content       template.HTML // Очищено reader.RenderChapter
+		Offset        float64
+		SavePosition  bool
+	}{
+		CatalogTitle:  w.config.GetCatalogTitle(),
+		BookID:        bookID,
+		Title:         title,
+		ChapterTitle:  book.Chapters[chapter].Title,
+		Chapter:       chapter,
+		ChapterNumber: chapter + 1,
+		Chapters:      len(book.Chapters),
+		TOC:           toc,
+		Content:       template.HTML(content),
+		Offset:        offset,
+		SavePosition:  user != nil,
+	}
+	if chapter > 0 {
+		data.PrevURL = readerURL(bookID, chapter-1, "")
+	}
+	if chapter < len(book.Chapters)-1 {
+		data.NextURL = readerURL(bookID, chapter+1, "")
+	}
+
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "reader.html")
+	tmpl, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing reader template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Разметка книги очищена, но скрипты и чужие ресурсы на странице читалки всё равно запрещены
+	wr.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; object-src 'none'; frame-ancestors 'self'")
+	if err := tmpl.ExecuteTemplate(wr, "reader", data); err != nil {
+		log.Printf("Error executing reader template: %v", err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// serveReaderResource отдаёт картинку из книги
+func (w *WebInterface) serveReaderResource(wr http.ResponseWriter, r *http.Request, book *reader.Book, name string) {
+	rc, contentType, err := book.Resource(name)
+	if err != nil {
+		if w.config.Debug {
+			log.Printf("Картинка для читалки не найдена: %v", err)
+		}
+		http.NotFound(wr, r)
+		return
+	}
+	defer rc.Close()
+
+	wr.Header().Set("Content-Type", contentType)
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	// Картинки svg могут содержать скрипты: запрещаем их и при открытии картинки напрямую
+	wr.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+	wr.Header().Set("Cache-Control", "private, max-age=86400")
+	if _, err := io.Copy(wr, rc); err != nil && w.config.Debug {
+		log.Printf("Ошибка отдачи картинки %s: %v", name, err)
+	}
+}
+
+// saveReaderPosition запоминает место чтения книги вошедшим пользователем
+func (w *WebInterface) saveReaderPosition(wr http.ResponseWriter, r *http.Request, bookID int64) {
+	user := w.currentUser(r)
+	if user == nil {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Chapter int     `json:"chapter"`
+		Offset  float64 `json:"offset"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, readerMaxBodySize)).Decode(&req); err != nil {
+		http.Error(wr, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Chapter < 0 || req.Offset < 0 || req.Offset > 1 {
+		http.Error(wr, "Invalid position", http.StatusBadRequest)
+		return
+	}
+
+	if err := reader.SavePosition(w.db, user.ID, bookID, reader.Position{Chapter: req.Chapter, Offset: req.Offset}); err != nil {
+		log.Printf("Ошибка сохранения места чтения книги %d пользователя %s: %v", bookID, user.Login, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
@@ -4,6 +4,7 @@ package web
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -17,7 +18,9 @@ import (
 	//	"time"
 
 	"turanga/config"
+	"turanga/language"
 	"turanga/scanner"
+	"turanga/users"
 )
 
 // ResponseBook представляет книгу из ответа
@@ -50,6 +53,7 @@ type RequestInfo struct {
 	Title    string
 	FileHash string
 	ISBN     string
+	Language string // Необязательный язык книги (ISO 639-1)
 }
 
 // ShowRequestFormHandler отображает форму запроса книги через Nostr или ответы на активные запросы
@@ -61,7 +65,7 @@ func (w *WebInterface) ShowRequestFormHandler(wr http.ResponseWriter, r *http.Re
 	}
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		log.Printf("Request form access attempt by unauthorized user")
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -312,6 +316,7 @@ func (w *WebInterface) showRequestForm(wr http.ResponseWriter, r *http.Request)
 		ShowResponses   bool
 		Query           string
 		RequestInfo     RequestInfo
+		Languages       []LanguageOption
 	}{
 		IsAuthenticated: w.isAuthenticated(r),
 		CatalogTitle:    w.config.GetCatalogTitle(),
@@ -320,6 +325,7 @@ func (w *WebInterface) showRequestForm(wr http.ResponseWriter, r *http.Request)
 		ShowResponses:   false,
 		Query:           "",
 		RequestInfo:     RequestInfo{},
+		Languages:       languageOptions(""),
 	}
 
 	// Загружаем и выполняем шаблон
@@ -579,11 +585,11 @@ func (w *WebInterface) getRequestInfoByID(requestEventID string) (RequestInfo, e
 
 	// Запрашиваем информацию о конкретном запросе из таблицы nostr_book_requests
 	err := w.db.QueryRow(`
-		SELECT author, series, title, file_hash, isbn 
+		SELECT author, series, title, file_hash, isbn, IFNULL(language, '')
 		FROM nostr_book_requests 
 		WHERE event_id = ? AND pubkey = (SELECT pubkey FROM nostr_book_requests WHERE event_id = ? LIMIT 1)
 		LIMIT 1
-	`, requestEventID, requestEventID).Scan(&info.Author, &info.Series, &info.Title, &info.FileHash, &info.ISBN)
+	`, requestEventID, requestEventID).Scan(&info.Author, &info.Series, &info.Title, &info.FileHash, &info.ISBN, &info.Language)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -605,7 +611,7 @@ func (w *WebInterface) HandleRequestFormHandler(wr http.ResponseWriter, r *http.
 	}
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		log.Printf("Request form submission attempt by unauthorized user")
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -619,63 +625,81 @@ func (w *WebInterface) HandleRequestFormHandler(wr http.ResponseWriter, r *http.
 	}
 
 	// Получаем параметры из формы
-	author := r.FormValue("author")
-	series := r.FormValue("series")
-	title := r.FormValue("title")
-	fileHash := r.FormValue("file_hash")
-	isbn := r.FormValue("isbn") // <-- Добавляем ISBN
-
-	// Валидируем ISBN, если задан
-	if isbn != "" {
-		isbn = strings.TrimSpace(isbn)
-		if !scanner.IsValidISBN(isbn) {
-			log.Printf("Invalid ISBN provided: %s", isbn)
-			http.Error(wr, "Invalid ISBN format", http.StatusBadRequest)
-			return
+	info := RequestInfo{
+		Author:   r.FormValue("author"),
+		Series:   r.FormValue("series"),
+		Title:    r.FormValue("title"),
+		FileHash: r.FormValue("file_hash"),
+		ISBN:     r.FormValue("isbn"),
+		Language: r.FormValue("language"),
+	}
+	if err := validateBookRequest(&info); err != nil {
+		if cfg.Debug {
+			log.Printf("Request form rejected: %v", err)
 		}
-	}
-
-	// Валидируем остальные параметры
-	author = strings.TrimSpace(author)
-	series = strings.TrimSpace(series)
-	title = strings.TrimSpace(title)
-	fileHash = strings.TrimSpace(fileHash)
-
-	// Проверяем, что хотя бы одно поле заполнено
-	if author == "" && series == "" && title == "" && fileHash == "" && isbn == "" {
-		log.Println("Request form submitted with all fields empty")
-		http.Error(wr, "At least one field must be filled", http.StatusBadRequest)
+		http.Error(wr, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Проверяем формат хеша (если задан)
-	if fileHash != "" {
-		// Хеш должен быть длиной 16 символов и содержать только [a-f0-9]
-		if len(fileHash) != 16 {
-			if cfg.Debug {
-				log.Printf("Request form submitted with invalid hash length %d", len(fileHash))
-			}
-			http.Error(wr, "File hash must be exactly 16 characters long", http.StatusBadRequest)
+	if _, err := w.publishBookRequest(r.Context(), info); err != nil {
+		log.Printf("Error publishing book request event: %v", err)
+		if errors.Is(err, errNostrDisabled) {
+			http.Error(wr, "Интеграция с Nostr не настроена", http.StatusServiceUnavailable)
 			return
 		}
+		http.Error(wr, "Error publishing request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Успешно отправлено - перенаправляем на страницу с ответами
+	http.Redirect(wr, r, "/request", http.StatusSeeOther)
+}
 
-		// Проверяем, что хеш содержит только допустимые символы
-		for _, char := range fileHash {
+// errNostrDisabled — Nostr клиент не инициализирован
+var errNostrDisabled = errors.New("nostr клиент не инициализирован")
+
+// validateBookRequest нормализует поля запроса книги и проверяет их:
+// хотя бы одно поле заполнено (язык не в счёт: он только уточняет запрос), ISBN корректен,
+// язык известен, хеш — 16 символов [a-f0-9]
+func validateBookRequest(info *RequestInfo) error {
+	info.Author = strings.TrimSpace(info.Author)
+	info.Series = strings.TrimSpace(info.Series)
+	info.Title = strings.TrimSpace(info.Title)
+	info.FileHash = strings.TrimSpace(info.FileHash)
+	info.ISBN = strings.TrimSpace(info.ISBN)
+	lang := strings.TrimSpace(info.Language)
+	info.Language = language.Normalize(lang)
+
+	if info.ISBN != "" && !scanner.IsValidISBN(info.ISBN) {
+		return fmt.Errorf("invalid ISBN format")
+	}
+	if lang != "" && info.Language == "" {
+		return fmt.Errorf("unknown language")
+	}
+	if info.Author == "" && info.Series == "" && info.Title == "" && info.FileHash == "" && info.ISBN == "" {
+		return fmt.Errorf("at least one field must be filled")
+	}
+	if info.FileHash != "" {
+		if len(info.FileHash) != 16 {
+			return fmt.Errorf("file hash must be exactly 16 characters long")
+		}
+		for _, char := range info.FileHash {
 			if !((char >= 'a' && char <= 'f') || (char >= '0' && char <= '9')) {
-				if cfg.Debug {
-					log.Printf("Request form submitted with invalid hash character '%c' in hash '%s'", char, fileHash)
-				}
-				http.Error(wr, "File hash contains invalid characters", http.StatusBadRequest)
-				return
+				return fmt.Errorf("file hash contains invalid characters")
 			}
 		}
 	}
+	return nil
+}
+
+// publishBookRequest публикует проверенный запрос книги через Nostr,
+// предварительно очищая устаревшие запросы и ответы. Возвращает ID опубликованного события.
+func (w *WebInterface) publishBookRequest(ctx context.Context, info RequestInfo) (string, error) {
+	cfg := config.GetConfig()
 
 	// Проверяем, инициализирован ли Nostr клиент
 	if w.NostrClient == nil {
-		log.Println("Nostr клиент не инициализирован, публикация запроса книги пропущена")
-		http.Error(wr, "Интеграция с Nostr не настроена", http.StatusServiceUnavailable)
-		return
+		return "", errNostrDisabled
 	}
 
 	// Выполняем очистку перед отправкой нового запроса
@@ -694,19 +718,11 @@ func (w *WebInterface) HandleRequestFormHandler(wr http.ResponseWriter, r *http.
 	}
 
 	// Создаем контекст с таймаутом для публикации
-	pubCtx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	pubCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Публикуем событие запроса через Nostr клиент
-	err := w.NostrClient.PublishBookRequestEvent(pubCtx, author, series, title, fileHash, isbn)
-	if err != nil {
-		log.Printf("Error publishing book request event: %v", err)
-		http.Error(wr, "Error publishing request: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Успешно отправлено - перенаправляем на страницу с ответами
-	http.Redirect(wr, r, "/request", http.StatusSeeOther)
+	return w.NostrClient.PublishBookRequestEvent(pubCtx, info.Author, info.Series, info.Title, info.FileHash, info.ISBN, info.Language)
 }
 
 // getLastRequestInfo возвращает информацию о последнем отправленном запросе
@@ -715,12 +731,12 @@ func (w *WebInterface) getLastRequestInfo() (RequestInfo, error) {
 
 	// Запрашиваем информацию о последнем запросе из таблицы nostr_book_requests
 	err := w.db.QueryRow(`
-		SELECT author, series, title, file_hash, isbn 
+		SELECT author, series, title, file_hash, isbn, IFNULL(language, '')
 		FROM nostr_book_requests 
 		WHERE sent = 1 
 		ORDER BY created_at DESC 
 		LIMIT 1
-	`).Scan(&info.Author, &info.Series, &info.Title, &info.FileHash, &info.ISBN)
+	`).Scan(&info.Author, &info.Series, &info.Title, &info.FileHash, &info.ISBN, &info.Language)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -747,7 +763,7 @@ func (w *WebInterface) hasActiveRequests() (bool, error) {
 // CheckUpdatesHandler проверяет наличие новых данных
 func (w *WebInterface) CheckUpdatesHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		w.writeJSONResponse(wr, map[string]interface{}{
 			"hasNewData": false,
 			"error":      "Unauthorized",
@@ -796,7 +812,7 @@ func (w *WebInterface) CheckUpdatesHandler(wr http.ResponseWriter, r *http.Reque
 // CheckUpdatesDetailedHandler детальная проверка обновлений
 func (w *WebInterface) CheckUpdatesDetailedHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		w.writeJSONResponse(wr, map[string]interface{}{
 			"updated": false,
 			"error":   "Unauthorized",
@@ -846,7 +862,7 @@ func (w *WebInterface) CheckUpdatesDetailedHandler(wr http.ResponseWriter, r *ht
 // ResponseCountHandler возвращает количество полученных ответов
 func (w *WebInterface) ResponseCountHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		w.writeJSONResponse(wr, map[string]interface{}{
 			"count": -1,
 			"error": "Unauthorized",
@@ -3,6 +3,8 @@ package web
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
@@ -11,8 +13,12 @@ import (
 	"strconv"
 	"strings"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/history"
 	"turanga/models"
+	"turanga/series"
+	"turanga/users"
 )
 
 // ShowSeriesHandler обрабатывает запросы к странице серии
@@ -34,6 +40,18 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Псевдоним или другое написание названия ведут на страницу канонической серии
+	seriesInfo, err := series.Find(w.db, seriesName)
+	if err != nil && !errors.Is(err, series.ErrNotFound) {
+		log.Printf("Database error getting series %s: %v", seriesName, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if seriesInfo != nil && seriesInfo.Name != seriesName {
+		http.Redirect(wr, r, "/s/"+url.QueryEscape(seriesInfo.Name), http.StatusFound)
+		return
+	}
+
 	// Получаем параметры пагинации из URL
 	pageStr := r.URL.Query().Get("page")
 	page := 1
@@ -59,12 +77,16 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 	}
 	offset := (page - 1) * perPage
 
+	// Закрытые книги видны только тем, кому они разрешены
+	policy := access.ForRequest(r)
+
 	// Получаем общее количество книг в серии
 	var totalBooks int
 	err = w.db.QueryRow(`
 		SELECT COUNT(*) 
 		FROM books b 
 		WHERE b.series = ?
+		  `+policy.Filter("b")+`
 	`, seriesName).Scan(&totalBooks)
 	if err != nil {
 		log.Printf("Database error getting total books count for series %s: %v", seriesName, err)
@@ -82,11 +104,10 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 		       b.file_hash
 		FROM books b 
 		WHERE b.series = ?
+		  `+policy.Filter("b")+`
 		ORDER BY 
-			CASE 
-				WHEN b.series_number GLOB '[0-9]*' THEN CAST(b.series_number AS INTEGER)
-				ELSE 0
-			 END,
+			b.series_index IS NULL,
+			b.series_index,
 			LOWER(b.series_number),
 			LOWER(b.title)
 		LIMIT ? OFFSET ?
@@ -153,28 +174,48 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// Тома серии: сколько есть и каких не хватает среди видимых пользователю книг
+	var volumes SeriesVolumes
+	if seriesInfo != nil {
+		volumes, err = w.seriesVolumes(seriesInfo, policy)
+		if err != nil {
+			log.Printf("Database error getting volumes for series %s: %v", seriesName, err)
+			http.Error(wr, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Подготавливаем данные для шаблона
 	data := struct {
-		SeriesName      string
-		Books           []models.BookWeb
-		CurrentPage     int
-		TotalPages      int
-		StartPage       int
-		EndPage         int
-		PageNumbers     []int
-		PrevPage        int
-		NextPage        int
-		IsAuthenticated bool
+		SeriesName  string
+		Books       []models.BookWeb
+		CurrentPage int
+		TotalPages  int
+		StartPage   int
+		EndPage     int
+		PageNumbers []int
+		PrevPage    int
+		NextPage    int
+		CanEdit     bool
+		CanRequest  bool
+		Sharing     []SharingOption
+		Volumes     SeriesVolumes
 	}{
-		SeriesName:      seriesName,
-		Books:           books,
-		CurrentPage:     page,
-		TotalPages:      totalPages,
-		StartPage:       startPage,
-		EndPage:         endPage,
-		PrevPage:        page - 1,
-		NextPage:        page + 1,
-		IsAuthenticated: w.isAuthenticated(r),
+		SeriesName:  seriesName,
+		Books:       books,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		StartPage:   startPage,
+		EndPage:     endPage,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+		CanEdit:     w.can(r, users.PermEdit),
+		CanRequest:  len(volumes.Missing) > 0 && w.NostrClient != nil && w.can(r, users.PermNostr),
+		Volumes:     volumes,
+	}
+
+	if data.CanEdit {
+		data.Sharing = sharingOptions(w.commonSharing("SELECT id FROM books WHERE series = ?", seriesName))
 	}
 
 	// Генерируем список номеров страниц
@@ -186,9 +227,11 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 	// Предполагая, что у WebInterface есть поле rootPath
 	tmplPath := filepath.Join(w.rootPath, "web", "templates", "series.html")
 	tmpl, err := template.New("series").Funcs(template.FuncMap{
-		"sub":        func(a, b int) int { return a - b },
-		"urlquery":   url.QueryEscape,
-		"formatSize": FormatFileSize, // Добавим на всякий случай
+		"sub":           func(a, b int) int { return a - b },
+		"urlquery":      url.QueryEscape,
+		"formatSize":    FormatFileSize, // Добавим на всякий случай
+		"formatVolumes": series.FormatVolumes,
+		"join":          strings.Join,
 	}).ParseFiles(tmplPath)
 
 	if err != nil {
@@ -209,7 +252,7 @@ func (w *WebInterface) ShowSeriesHandler(wr http.ResponseWriter, r *http.Request
 // SaveSeriesHandler обрабатывает сохранение изменений серии
 func (w *WebInterface) SaveSeriesHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermEdit) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -241,9 +284,7 @@ func (w *WebInterface) SaveSeriesHandler(wr http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Обновляем название серии во всех книгах, а также lower-поле
-	_, err = w.db.Exec("UPDATE books SET series = ?, series_lower = ? WHERE series = ?", newName, strings.ToLower(newName), oldSeriesName)
-	if err != nil {
+	if _, err := w.renameSeries(history.OriginWeb, oldSeriesName, newName); err != nil {
 		log.Printf("Database error updating series name from '%s' to '%s': %v", oldSeriesName, newName, err)
 		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
 		return
@@ -253,3 +294,220 @@ func (w *WebInterface) SaveSeriesHandler(wr http.ResponseWriter, r *http.Request
 	wr.WriteHeader(http.StatusOK)
 	wr.Write([]byte("OK"))
 }
+
+// renameSeries переименовывает серию во всех книгах одной операцией журнала.
+// Если новое название занято другой серией или её псевдонимом, серии объединяются.
+// Возвращает каноническое название серии после переименования.
+func (w *WebInterface) renameSeries(origin, oldName, newName string) (string, error) {
+	snapshot := w.snapshotBooks(history.FieldSeries, "SELECT id FROM books WHERE series = ?", oldName)
+
+	// Объединение удаляет прежнюю серию, поэтому книги переносятся в той же транзакции
+	tx, err := w.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Обновляем название серии во всех книгах, а также lower-поле
+	canonical, err := series.Rename(tx, oldName, newName)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("ошибка фиксации переименования серии: %w", err)
+	}
+	w.logSnapshotChanges(origin, history.FieldSeries, snapshot)
+	return canonical, nil
+}
+
+// SeriesVolumes — тома серии для страницы серии
+type SeriesVolumes struct {
+	Expected int      // Ожидаемое число томов, 0 — неизвестно
+	Present  int      // Сколько разных томов есть
+	Missing  []int    // Номера недостающих томов
+	Aliases  []string // Другие названия серии
+}
+
+// seriesVolumes считает имеющиеся и недостающие тома серии среди видимых книг
+func (w *WebInterface) seriesVolumes(info *series.Series, policy access.Policy) (SeriesVolumes, error) {
+	numbers, err := series.Numbers(w.db, info.ID, policy.Filter("b"))
+	if err != nil {
+		return SeriesVolumes{}, err
+	}
+	return SeriesVolumes{
+		Expected: info.Expected,
+		Present:  series.Present(numbers),
+		Missing:  series.Missing(numbers, info.Expected),
+		Aliases:  info.Aliases,
+	}, nil
+}
+
+// seriesFromPath извлекает из URL название серии после префикса и находит серию
+func (w *WebInterface) seriesFromPath(r *http.Request, prefix string) (*series.Series, error) {
+	name, err := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || strings.TrimSpace(name) == "" {
+		return nil, series.ErrNotFound
+	}
+	return series.Find(w.db, name)
+}
+
+// SaveSeriesDetailsHandler сохраняет ожидаемое число томов и псевдонимы серии
+// URL: /save/series-details/{encoded_series_name}
+func (w *WebInterface) SaveSeriesDetailsHandler(wr http.ResponseWriter, r *http.Request) {
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, err := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, "/save/series-details/"))
+	if err != nil || strings.TrimSpace(name) == "" {
+		http.Error(wr, "Series name is required", http.StatusBadRequest)
+		return
+	}
+	expected := 0
+	if value := strings.TrimSpace(r.FormValue("expected")); value != "" {
+		expected, err = strconv.Atoi(value)
+		if err != nil || expected < 0 {
+			http.Error(wr, "Число томов должно быть неотрицательным целым", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Серия создаётся, если её книги ещё не связаны ревизией
+	id, canonical, err := series.Resolve(w.db, name)
+	if err == nil {
+		err = w.saveSeriesDetails(id, canonical, expected, series.SplitAliases(r.FormValue("aliases")))
+	}
+	if errors.Is(err, series.ErrAliasTaken) {
+		http.Error(wr, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения данных серии %s: %v", name, err)
+		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(wr, r, "/s/"+url.QueryEscape(canonical), http.StatusSeeOther)
+}
+
+// saveSeriesDetails сохраняет число томов и псевдонимы серии одной транзакцией
+func (w *WebInterface) saveSeriesDetails(id int64, name string, expected int, aliases []string) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := series.SetExpected(tx, id, expected); err != nil {
+		return err
+	}
+	if err := series.SetAliases(tx, id, aliases); err != nil {
+		return err
+	}
+	// Книги, записанные под новыми псевдонимами, переходят в серию
+	rows, err := tx.Query("SELECT id FROM books WHERE series_id IS NOT ? AND series_lower IN (SELECT alias_lower FROM series_aliases WHERE series_id = ?)", id, id)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска книг под псевдонимами серии %s: %w", name, err)
+	}
+	var bookIDs []int64
+	for rows.Next() {
+		var bookID int64
+		if err := rows.Scan(&bookID); err == nil {
+			bookIDs = append(bookIDs, bookID)
+		}
+	}
+	rows.Close()
+	for _, bookID := range bookIDs {
+		if err := series.Sync(tx, bookID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RequestMissingVolumesHandler публикует в Nostr запрос недостающих томов серии.
+// Запрос ищет серию целиком (с автором, если он у серии один): номера томов в
+// запросе книги не передаются, нужные тома выбираются среди ответов.
+// URL: /request/series/{encoded_series_name}
+func (w *WebInterface) RequestMissingVolumesHandler(wr http.ResponseWriter, r *http.Request) {
+	if !w.can(r, users.PermNostr) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info, err := w.seriesFromPath(r, "/request/series/")
+	if errors.Is(err, series.ErrNotFound) {
+		http.NotFound(wr, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения серии для запроса: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	policy := access.ForRequest(r)
+	volumes, err := w.seriesVolumes(info, policy)
+	if err != nil {
+		log.Printf("Ошибка подсчёта томов серии %s: %v", info.Name, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(volumes.Missing) == 0 {
+		http.Redirect(wr, r, "/s/"+url.QueryEscape(info.Name), http.StatusSeeOther)
+		return
+	}
+
+	request := RequestInfo{
+		Series:   info.Name,
+		Author:   w.seriesSingleValue(info.ID, policy, "SELECT DISTINCT a.full_name FROM books b JOIN book_authors ba ON ba.book_id = b.id JOIN authors a ON a.id = ba.author_id"),
+		Language: w.seriesSingleValue(info.ID, policy, "SELECT DISTINCT b.language FROM books b"),
+	}
+	if err := validateBookRequest(&request); err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := w.publishBookRequest(r.Context(), request); err != nil {
+		log.Printf("Ошибка публикации запроса томов %s серии %s: %v", series.FormatVolumes(volumes.Missing), info.Name, err)
+		if errors.Is(err, errNostrDisabled) {
+			http.Error(wr, "Интеграция с Nostr не настроена", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(wr, "Error publishing request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(wr, r, "/request", http.StatusSeeOther)
+}
+
+// seriesSingleValue возвращает значение, общее для всех видимых книг серии
+// (автора, язык), или пустую строку, если значений несколько
+func (w *WebInterface) seriesSingleValue(seriesID int64, policy access.Policy, query string) string {
+	rows, err := w.db.Query(query+" WHERE b.series_id = ? "+policy.Filter("b")+" LIMIT 2", seriesID)
+	if err != nil {
+		log.Printf("Предупреждение: не удалось получить данные книг серии: %v", err)
+		return ""
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err == nil && value.String != "" {
+			values = append(values, value.String)
+		}
+	}
+	if len(values) != 1 {
+		return ""
+	}
+	return values[0]
+}
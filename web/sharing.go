@@ -0,0 +1,141 @@
+// web/sharing.go
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"turanga/access"
+	"turanga/config"
+	"turanga/history"
+	"turanga/users"
+)
+
+// SharingOption — вариант настройки доступа через Nostr для шаблона
+type SharingOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+// sharingOptions готовит варианты настройки доступа; selected — текущее значение
+// (пустое, если у книг на странице оно разное)
+func sharingOptions(selected access.Sharing) []SharingOption {
+	options := make([]SharingOption, 0, len(access.SharingLevels)+1)
+	if selected == "" {
+		options = append(options, SharingOption{Label: "по-разному", Selected: true})
+	}
+	for _, level := range access.SharingLevels {
+		options = append(options, SharingOption{
+			Value:    string(level),
+			Label:    access.SharingLabels[level],
+			Selected: level == selected,
+		})
+	}
+	return options
+}
+
+// SaveSharingHandler изменяет настройку доступа через Nostr: у одной книги,
+// сразу у всех книг автора или серии, или у тега (действует на все книги с тегом)
+// URL: POST /save/sharing/book/{id}, /save/sharing/author/{id}, /save/sharing/series/{name}, /save/sharing/tag/{name}
+func (w *WebInterface) SaveSharingHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scope, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/save/sharing/"), "/")
+	key, err := url.QueryUnescape(key)
+	if err != nil || key == "" {
+		http.Error(wr, "Invalid sharing target", http.StatusBadRequest)
+		return
+	}
+
+	sharing, err := access.ParseSharing(r.FormValue("shared"))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Тег хранит собственную настройку, она действует и на книги, которые получат тег позже
+	if scope == "tag" {
+		if err := access.SetTagSharing(w.db, key, sharing); err != nil {
+			log.Printf("Ошибка изменения доступа через nostr для тега %s: %v", key, err)
+			http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
+			return
+		}
+		if cfg.Debug {
+			log.Printf("Тег '%s': доступ через nostr — %s", key, sharing)
+		}
+		http.Redirect(wr, r, "/tag/"+url.QueryEscape(key), http.StatusSeeOther)
+		return
+	}
+
+	var booksQuery, redirect string
+	var arg interface{}
+	switch scope {
+	case "book", "author":
+		id, convErr := strconv.Atoi(key)
+		if convErr != nil || id <= 0 {
+			http.Error(wr, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		arg = id
+		if scope == "book" {
+			booksQuery = "SELECT id FROM books WHERE id = ?"
+			redirect = fmt.Sprintf("/book/%d", id)
+		} else {
+			booksQuery = "SELECT book_id FROM book_authors WHERE author_id = ?"
+			redirect = fmt.Sprintf("/author/%d", id)
+		}
+	case "series":
+		arg = key
+		booksQuery = "SELECT id FROM books WHERE series = ?"
+		redirect = "/s/" + url.QueryEscape(key)
+	default:
+		http.Error(wr, "Unknown sharing target", http.StatusNotFound)
+		return
+	}
+
+	// Все книги меняются одной операцией, её можно отменить целиком
+	snapshot := w.snapshotBooks(history.FieldShared, booksQuery, arg)
+	res, err := w.db.Exec("UPDATE books SET shared = ? WHERE id IN ("+booksQuery+")", string(sharing), arg)
+	if err != nil {
+		log.Printf("Ошибка изменения доступа через nostr (%s %s): %v", scope, key, err)
+		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
+		return
+	}
+	w.logSnapshotChanges(history.OriginWeb, history.FieldShared, snapshot)
+
+	if cfg.Debug {
+		n, _ := res.RowsAffected()
+		log.Printf("Доступ через nostr (%s %s) изменён на %s у книг: %d", scope, key, sharing, n)
+	}
+	http.Redirect(wr, r, redirect, http.StatusSeeOther)
+}
+
+// commonSharing возвращает настройку доступа, общую для всех книг из booksQuery,
+// или пустое значение, если у книг она разная
+func (w *WebInterface) commonSharing(booksQuery string, arg interface{}) access.Sharing {
+	var distinct int
+	var value string
+	err := w.db.QueryRow("SELECT COUNT(DISTINCT shared), IFNULL(MIN(shared), '') FROM books WHERE id IN ("+booksQuery+")", arg).Scan(&distinct, &value)
+	if err != nil {
+		log.Printf("Ошибка чтения настройки доступа через nostr: %v", err)
+		return ""
+	}
+	if distinct != 1 {
+		return ""
+	}
+	return access.Sharing(value)
+}
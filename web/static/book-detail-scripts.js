@@ -300,6 +300,8 @@
             display.style.display = 'block';
         } else if (fieldName === 'tags') {
             // Для тегов ничего не делаем при сохранении, так как они обновляются по-другому
+        } else if (fieldName === 'language' || fieldName === 'source_language') {
+            showLanguage(field, display, value);
         } else {
             // Для остальных полей
             if (value) {
@@ -312,6 +314,30 @@
         }
     }
 
+    // Показ языка: в поле хранится код, а показывается название из списка выбора
+    function showLanguage(field, display, code) {
+        const select = field.querySelector('select.edit-field-input');
+        if (select) {
+            select.value = code || '';
+        }
+        if (!code) {
+            display.textContent = 'Не указан';
+        } else {
+            const option = select ? select.querySelector('option[value="' + CSS.escape(code) + '"]') : null;
+            const name = option ? option.textContent : code;
+            if (field.dataset.field === 'language') {
+                // Язык книги — ссылка на каталог с фильтром по языку
+                const link = document.createElement('a');
+                link.href = '/?lang=' + encodeURIComponent(code);
+                link.textContent = name;
+                display.replaceChildren(link);
+            } else {
+                display.textContent = name;
+            }
+        }
+        display.style.display = 'inline';
+    }
+
     // Отмена редактирования поля
     function cancelFieldEdit(field) {
         const display = field.querySelector('.editable-display');
@@ -365,6 +391,8 @@
                 display.style.display = 'block';
             } else if (fieldName === 'tags') {
                 // Для тегов ничего не делаем при отмене, так как они обновляются по-другому
+            } else if (fieldName === 'language' || fieldName === 'source_language') {
+                showLanguage(field, display, currentValue);
             } else {
                 // Для остальных полей
                 if (currentValue) {
@@ -795,7 +823,8 @@
                     // Фокус на первом input
                     if (inputs.length > 0) {
                         inputs[0].focus();
-                        inputs[0].select();
+                        // У списка выбора (язык) нет выделения текста
+                        if (inputs[0].tagName === 'INPUT') inputs[0].select();
                     } else if (textarea) {
                         textarea.focus();
                     }
@@ -873,7 +902,7 @@
         if (titleDeleteBtn) {
             titleDeleteBtn.addEventListener('click', function(e) {
                 e.stopPropagation();
-                if (confirm('Вы уверены, что хотите удалить эту книгу?\nЭто действие удалит:\n- Запись из базы данных\n- Файл книги с диска\n- Обложку с диска\nДействие нельзя отменить!')) {
+                if (confirm('Переместить книгу в корзину?\nКнигу можно будет восстановить на странице корзины,\nпока она не будет очищена.')) {
                     // Отправляем запрос на удаление
                     fetch(`/delete/book/${encodeURIComponent(bookID)}`, {
                         method: 'POST'
@@ -0,0 +1,57 @@
+// web/static/csrf.js
+
+// Добавляет CSRF-токен сессии к изменяющим запросам: сервер отклоняет
+// POST вошедшего пользователя без действительного токена
+(function() {
+    // Токен сессии лежит в cookie csrf, пока выполнен вход
+    function getCsrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)csrf=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    function isSafeMethod(method) {
+        return ['GET', 'HEAD', 'OPTIONS'].includes(method.toUpperCase());
+    }
+
+    // Запросы fetch к этому же серверу получают заголовок X-CSRF-Token
+    const originalFetch = window.fetch;
+    window.fetch = function(input, init) {
+        const request = input instanceof Request ? input : null;
+        const method = (init && init.method) || (request ? request.method : 'GET');
+        const url = new URL(request ? request.url : input, window.location.href);
+        const token = getCsrfToken();
+
+        if (token && !isSafeMethod(method) && url.origin === window.location.origin) {
+            const headers = new Headers((init && init.headers) || (request ? request.headers : undefined));
+            headers.set('X-CSRF-Token', token);
+            init = Object.assign({}, init, { headers: headers });
+        }
+        return originalFetch.call(this, input, init);
+    };
+
+    // Обычные формы получают скрытое поле csrf_token, формы с файлами — параметр в адресе,
+    // потому что сервер не разбирает тело multipart до обработчика
+    document.addEventListener('submit', function(e) {
+        const form = e.target;
+        if (!(form instanceof HTMLFormElement) || isSafeMethod(form.method)) return;
+
+        const token = getCsrfToken();
+        if (!token) return;
+
+        if (form.enctype === 'multipart/form-data') {
+            const action = new URL(form.action, window.location.href);
+            action.searchParams.set('csrf_token', token);
+            form.action = action.toString();
+            return;
+        }
+
+        let field = form.querySelector('input[name="csrf_token"]');
+        if (!field) {
+            field = document.createElement('input');
+            field.type = 'hidden';
+            field.name = 'csrf_token';
+            form.appendChild(field);
+        }
+        field.value = token;
+    }, true);
+})();
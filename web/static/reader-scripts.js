@@ -0,0 +1,71 @@
+// web/static/reader-scripts.js
+
+// Читалка: возвращает к месту, на котором остановились, и запоминает новое место
+(function() {
+    'use strict';
+
+    const body = document.body;
+    const bookId = body.dataset.bookId;
+    const chapter = parseInt(body.dataset.chapter, 10) || 0;
+    const savePosition = body.dataset.savePosition === 'true';
+    let saveTimer = null;
+    let lastSaved = null;
+
+    // Доля прокрутки главы, от 0 до 1
+    function currentOffset() {
+        const height = document.documentElement.scrollHeight - window.innerHeight;
+        if (height <= 0) return 0;
+        return Math.min(1, Math.max(0, window.scrollY / height));
+    }
+
+    function restoreOffset() {
+        const offset = parseFloat(body.dataset.offset) || 0;
+        // Переход по якорю (оглавление, сноска) важнее сохранённого места
+        if (offset <= 0 || window.location.hash) return;
+        const height = document.documentElement.scrollHeight - window.innerHeight;
+        window.scrollTo(0, Math.round(offset * height));
+    }
+
+    function save() {
+        const offset = Math.round(currentOffset() * 10000) / 10000;
+        if (offset === lastSaved) return;
+        lastSaved = offset;
+        fetch('/read/' + bookId + '/position', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ chapter: chapter, offset: offset }),
+            keepalive: true
+        }).catch(function(err) {
+            console.error('Не удалось сохранить место чтения:', err);
+        });
+    }
+
+    function scheduleSave() {
+        clearTimeout(saveTimer);
+        saveTimer = setTimeout(save, 1000);
+    }
+
+    // Стрелки влево и вправо листают главы
+    document.addEventListener('keydown', function(e) {
+        if (e.altKey || e.ctrlKey || e.metaKey || e.shiftKey) return;
+        if (e.target.closest('input, textarea, select')) return;
+        const rel = e.key === 'ArrowLeft' ? 'prev' : e.key === 'ArrowRight' ? 'next' : null;
+        const link = rel && document.querySelector('.reader-nav a[rel="' + rel + '"]');
+        if (link) window.location.href = link.href;
+    });
+
+    // Картинки меняют высоту страницы: восстанавливаем место, когда всё загружено
+    window.addEventListener('load', function() {
+        restoreOffset();
+        if (!savePosition) return;
+        // Открытие главы тоже запоминается, даже если её не прокручивали
+        save();
+        window.addEventListener('scroll', scheduleSave, { passive: true });
+        document.addEventListener('visibilitychange', function() {
+            if (document.visibilityState === 'hidden') {
+                clearTimeout(saveTimer);
+                save();
+            }
+        });
+    });
+})();
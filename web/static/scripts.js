@@ -24,6 +24,7 @@ function initAuthModal() {
 
     const authForm = document.getElementById('auth-modal-form');
     const authCancelBtn = document.getElementById('auth-cancel-btn');
+    const loginInput = document.getElementById('modal-login');
     const passwordInput = document.getElementById('modal-password');
     const errorMessage = document.getElementById('auth-error-message');
     
@@ -32,9 +33,10 @@ function initAuthModal() {
     // Функция для открытия модального окна
     function openAuthModal() {
         authOverlay.style.display = 'block';
-        // Устанавливаем фокус на поле ввода пароля
-        if (passwordInput) {
-            setTimeout(() => passwordInput.focus(), 100);
+        // Устанавливаем фокус на поле ввода логина
+        const focusInput = loginInput || passwordInput;
+        if (focusInput) {
+            setTimeout(() => focusInput.focus(), 100);
         }
     }
     
@@ -64,10 +66,11 @@ function initAuthModal() {
         authForm.addEventListener('submit', function(e) {
             e.preventDefault();
             
+            const login = loginInput ? loginInput.value.trim() : '';
             const password = passwordInput ? passwordInput.value : '';
-            if (!password) {
+            if (!login || !password) {
                 if (errorMessage) {
-                    errorMessage.textContent = 'Пожалуйста, введите пароль.';
+                    errorMessage.textContent = 'Пожалуйста, введите логин и пароль.';
                     errorMessage.style.display = 'block';
                     errorMessage.style.backgroundColor = '#f8d7da';
                     errorMessage.style.color = '#721c24';
@@ -78,6 +81,7 @@ function initAuthModal() {
             
             // Отправляем данные через fetch
             const formData = new FormData();
+            formData.append('login', login);
             formData.append('password', password);
             
             fetch('/auth', {
@@ -85,8 +89,12 @@ function initAuthModal() {
                 body: formData
             })
             .then(response => {
-                if (response.ok) {
+                // При успешном входе сервер перенаправляет на главную,
+                // при ошибке снова отдаёт форму входа
+                if (response.ok && response.redirected) {
                     window.location.href = '/';
+                } else if (response.ok) {
+                    throw new Error('Неверный логин или пароль.');
                 } else {
                     return response.text().then(text => { throw new Error(text); });
                 }
@@ -94,7 +102,7 @@ function initAuthModal() {
             .catch(error => {
                 console.error('Ошибка авторизации:', error);
                 if (errorMessage) {
-                    const errorMsg = error.message && error.message !== 'Unauthorized' ? error.message : 'Неверный пароль.';
+                    const errorMsg = error.message && error.message !== 'Unauthorized' ? error.message : 'Неверный логин или пароль.';
                     errorMessage.textContent = errorMsg;
                     errorMessage.style.display = 'block';
                     errorMessage.style.backgroundColor = '#f8d7da';
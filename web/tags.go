@@ -11,8 +11,10 @@ import (
 	"strconv"
 	"strings"
 
+	"turanga/access"
 	"turanga/config"
 	"turanga/models"
+	"turanga/users"
 )
 
 // ShowTagHandler обрабатывает запросы к странице тега
@@ -59,6 +61,19 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 	}
 	offset := (page - 1) * perPage
 
+	// Закрытые книги и сам закрытый тег видны только тем, кому они разрешены
+	policy := access.ForRequest(r)
+	restricted, err := access.IsTagRestricted(w.db, tagName)
+	if err != nil {
+		log.Printf("Database error checking tag %s: %v", tagName, err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if restricted && !policy.ShowRestricted() {
+		http.Error(wr, "Tag not found", http.StatusNotFound)
+		return
+	}
+
 	// Получаем общее количество книг с этим тегом
 	var totalBooks int
 	err = w.db.QueryRow(`
@@ -67,6 +82,7 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 		JOIN book_tags bt ON b.id = bt.book_id
 		JOIN tags t ON bt.tag_id = t.id
 		WHERE t.name = ?
+		  `+policy.Filter("b")+`
 	`, tagName).Scan(&totalBooks)
 	if err != nil {
 		log.Printf("Database error getting total books count for tag %s: %v", tagName, err)
@@ -88,6 +104,7 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 		LEFT JOIN book_authors ba ON b.id = ba.book_id
 		LEFT JOIN authors a ON ba.author_id = a.id
 		WHERE t.name = ?
+		  `+policy.Filter("b")+`
 		GROUP BY b.id, b.title, b.file_hash
 		ORDER BY LOWER(b.title)
 		LIMIT ? OFFSET ?
@@ -162,6 +179,9 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 		PrevPage        int
 		NextPage        int
 		IsAuthenticated bool
+		CanEdit         bool
+		Restricted      bool
+		Sharing         []SharingOption
 	}{
 		TagName:         tagName,
 		Books:           books,
@@ -172,6 +192,16 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 		PrevPage:        page - 1,
 		NextPage:        page + 1,
 		IsAuthenticated: w.isAuthenticated(r),
+		CanEdit:         w.can(r, users.PermEdit),
+		Restricted:      restricted,
+	}
+
+	if data.CanEdit {
+		sharing, err := access.TagSharing(w.db, tagName)
+		if err != nil {
+			log.Printf("Ошибка чтения доступа через nostr для тега %s: %v", tagName, err)
+		}
+		data.Sharing = sharingOptions(sharing)
 	}
 
 	// Генерируем список номеров страниц
@@ -200,3 +230,37 @@ func (w *WebInterface) ShowTagHandler(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// SaveTagHandler закрывает или открывает тег: книги с закрытым тегом
+// видны только тем, кому разрешены книги 18+
+// URL: POST /save/tag/{name}
+func (w *WebInterface) SaveTagHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tagName, err := url.QueryUnescape(strings.TrimPrefix(r.URL.Path, "/save/tag/"))
+	if err != nil || tagName == "" {
+		http.Error(wr, "Invalid tag name", http.StatusBadRequest)
+		return
+	}
+
+	restricted := r.FormValue("restricted") == "1"
+	if err := access.SetTagRestricted(w.db, tagName, restricted); err != nil {
+		log.Printf("Ошибка изменения доступа к тегу %s: %v", tagName, err)
+		http.Error(wr, "Ошибка сохранения изменений", http.StatusInternalServerError)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Тег '%s' закрыт: %v", tagName, restricted)
+	}
+	http.Redirect(wr, r, "/tag/"+url.QueryEscape(tagName), http.StatusSeeOther)
+}
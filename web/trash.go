@@ -0,0 +1,186 @@
+// web/trash.go
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/trash"
+	"turanga/users"
+)
+
+// TrashItemView — книга в корзине для шаблона
+type TrashItemView struct {
+	ID        int64
+	Title     string
+	Authors   string
+	FileType  string
+	FileSize  string
+	DeletedAt string
+	PurgeAt   string
+	HasFile   bool
+}
+
+// TrashHandler показывает содержимое корзины
+// URL: GET /trash
+func (w *WebInterface) TrashHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	items, err := trash.List(w.db)
+	if err != nil {
+		log.Printf("Ошибка получения содержимого корзины: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]TrashItemView, 0, len(items))
+	for _, item := range items {
+		view := TrashItemView{
+			ID:        item.ID,
+			Title:     item.Title,
+			Authors:   strings.Join(item.Authors, ", "),
+			FileType:  strings.ToUpper(item.FileType),
+			FileSize:  FormatFileSize(item.FileSize),
+			DeletedAt: item.DeletedAt.Format("02.01.2006 15:04"),
+			HasFile:   item.TrashPath != "",
+		}
+		if cfg.TrashDays > 0 {
+			view.PurgeAt = item.DeletedAt.AddDate(0, 0, cfg.TrashDays).Format("02.01.2006")
+		}
+		views = append(views, view)
+	}
+
+	data := struct {
+		CatalogTitle string
+		Message      string
+		ErrorMessage string
+		TrashDays    int
+		Items        []TrashItemView
+	}{
+		CatalogTitle: cfg.GetCatalogTitle(),
+		Message:      r.URL.Query().Get("message"),
+		ErrorMessage: r.URL.Query().Get("error"),
+		TrashDays:    cfg.TrashDays,
+		Items:        views,
+	}
+
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "trash.html")
+	tmpl, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing trash template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(wr, "trash", data); err != nil {
+		log.Printf("Error executing trash template: %v", err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// TrashRestoreHandler возвращает книгу из корзины в библиотеку
+// URL: POST /trash/restore/{id}
+func (w *WebInterface) TrashRestoreHandler(wr http.ResponseWriter, r *http.Request) {
+	trashID, ok := w.trashRequest(wr, r, "/trash/restore/")
+	if !ok {
+		return
+	}
+
+	bookID, err := trash.Restore(w.db, w.rootPath, trashID)
+	if err != nil {
+		log.Printf("Ошибка восстановления записи корзины %d: %v", trashID, err)
+		message := "Не удалось восстановить книгу"
+		if errors.Is(err, trash.ErrHashExists) {
+			message = "Такая книга уже есть в библиотеке"
+		}
+		http.Redirect(wr, r, "/trash?error="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(wr, r, fmt.Sprintf("/book/%d", bookID), http.StatusSeeOther)
+}
+
+// TrashPurgeHandler окончательно удаляет книгу из корзины
+// URL: POST /trash/purge/{id} или POST /trash/purge/all
+func (w *WebInterface) TrashPurgeHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if strings.TrimPrefix(r.URL.Path, "/trash/purge/") == "all" {
+		if !w.can(r, users.PermEdit) {
+			http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		items, err := trash.List(w.db)
+		if err != nil {
+			log.Printf("Ошибка получения содержимого корзины: %v", err)
+			http.Error(wr, "Database error", http.StatusInternalServerError)
+			return
+		}
+		purged := 0
+		for _, item := range items {
+			if err := trash.Purge(w.db, cfg, w.rootPath, item.ID); err != nil {
+				log.Printf("Ошибка очистки записи корзины %d: %v", item.ID, err)
+				continue
+			}
+			purged++
+		}
+		message := fmt.Sprintf("Корзина очищена, удалено книг: %d", purged)
+		http.Redirect(wr, r, "/trash?message="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+
+	trashID, ok := w.trashRequest(wr, r, "/trash/purge/")
+	if !ok {
+		return
+	}
+
+	if err := trash.Purge(w.db, cfg, w.rootPath, trashID); err != nil {
+		log.Printf("Ошибка очистки записи корзины %d: %v", trashID, err)
+		http.Redirect(wr, r, "/trash?error="+url.QueryEscape("Не удалось удалить книгу"), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(wr, r, "/trash?message="+url.QueryEscape("Книга удалена окончательно"), http.StatusSeeOther)
+}
+
+// trashRequest проверяет доступ и метод запроса и извлекает ID записи корзины из URL
+func (w *WebInterface) trashRequest(wr http.ResponseWriter, r *http.Request, prefix string) (int64, bool) {
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+
+	trashID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, prefix), 10, 64)
+	if err != nil || trashID <= 0 {
+		http.Error(wr, "Invalid trash item ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return trashID, true
+}
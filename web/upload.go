@@ -15,6 +15,7 @@ import (
 
 	"turanga/config"
 	"turanga/scanner"
+	"turanga/users"
 )
 
 // UploadHandler обрабатывает загрузку файлов
@@ -22,7 +23,7 @@ func (w *WebInterface) UploadHandler(wr http.ResponseWriter, r *http.Request) {
 	//	log.Printf("UploadHandler called")
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermUpload) {
 		log.Printf("Upload attempt by unauthorized user")
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -55,7 +56,7 @@ func (w *WebInterface) UploadBookHandler(wr http.ResponseWriter, r *http.Request
 	}
 
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermUpload) {
 		log.Printf("Upload attempt by unauthorized user")
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
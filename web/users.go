@@ -0,0 +1,166 @@
+// web/users.go
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/users"
+)
+
+// UserView — учётная запись для шаблона
+type UserView struct {
+	ID        int64
+	Login     string
+	Role      string
+	RoleLabel string
+	CreatedAt string
+	IsSelf    bool
+}
+
+// RoleOption — вариант выбора роли для шаблона
+type RoleOption struct {
+	Value string
+	Label string
+}
+
+// UsersHandler показывает список пользователей
+// URL: GET /users
+func (w *WebInterface) UsersHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	current := w.currentUser(r)
+	if !current.Can(users.PermManage) {
+		http.Redirect(wr, r, "/auth", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := users.List(w.db)
+	if err != nil {
+		log.Printf("Ошибка получения списка пользователей: %v", err)
+		http.Error(wr, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]UserView, 0, len(list))
+	for _, u := range list {
+		views = append(views, UserView{
+			ID:        u.ID,
+			Login:     u.Login,
+			Role:      u.Role,
+			RoleLabel: users.RoleLabels[u.Role],
+			CreatedAt: u.CreatedAt.Format("02.01.2006"),
+			IsSelf:    u.ID == current.ID,
+		})
+	}
+	roles := make([]RoleOption, 0, len(users.Roles))
+	for _, role := range users.Roles {
+		roles = append(roles, RoleOption{Value: role, Label: users.RoleLabels[role]})
+	}
+
+	data := struct {
+		CatalogTitle string
+		Message      string
+		ErrorMessage string
+		Users        []UserView
+		Roles        []RoleOption
+	}{
+		CatalogTitle: cfg.GetCatalogTitle(),
+		Message:      r.URL.Query().Get("message"),
+		ErrorMessage: r.URL.Query().Get("error"),
+		Users:        views,
+		Roles:        roles,
+	}
+
+	tmplPath := filepath.Join(w.rootPath, "web", "templates", "users.html")
+	tmpl, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		log.Printf("Error parsing users template: %v", err)
+		http.Error(wr, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(wr, "users", data); err != nil {
+		log.Printf("Error executing users template: %v", err)
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// UserActionHandler изменяет учётные записи
+// URL: POST /users/add, /users/role/{id}, /users/password/{id}, /users/delete/{id}
+func (w *WebInterface) UserActionHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	current := w.currentUser(r)
+	if !current.Can(users.PermManage) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	action, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+
+	var err error
+	var message string
+	if action == "add" {
+		var user *users.User
+		user, err = users.Create(w.db, r.FormValue("login"), r.FormValue("password"), r.FormValue("role"))
+		if err == nil {
+			message = "Пользователь " + user.Login + " добавлен"
+		}
+	} else {
+		id, convErr := strconv.ParseInt(idStr, 10, 64)
+		if convErr != nil || id <= 0 {
+			http.Error(wr, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		switch action {
+		case "role":
+			err = users.SetRole(w.db, id, r.FormValue("role"))
+			message = "Роль изменена"
+		case "password":
+			err = users.SetPassword(w.db, id, r.FormValue("password"))
+			message = "Пароль изменён"
+			// Смена пароля завершает все сессии, включая текущую — выдаём новую
+			if err == nil && id == current.ID {
+				err = w.startSession(wr, r, current)
+			}
+		case "delete":
+			err = users.Delete(w.db, id)
+			message = "Пользователь удалён"
+		default:
+			http.Error(wr, "Unknown action", http.StatusNotFound)
+			return
+		}
+	}
+
+	if err != nil {
+		log.Printf("Ошибка действия %s над пользователями: %v", r.URL.Path, err)
+		errorMessage := "Не удалось выполнить действие"
+		if errors.Is(err, users.ErrLoginTaken) || errors.Is(err, users.ErrLastAdmin) || errors.Is(err, users.ErrNotFound) {
+			errorMessage = err.Error()
+		}
+		http.Redirect(wr, r, "/users?error="+url.QueryEscape(errorMessage), http.StatusSeeOther)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Выполнено действие над пользователями: %s", r.URL.Path)
+	}
+	http.Redirect(wr, r, "/users?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
@@ -5,16 +5,8 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-
-	"github.com/cespare/xxhash/v2"
 )
 
-// HashPassword создает xxhash от строки пароля
-func HashPassword(password string) string {
-	h := xxhash.Sum64String(password)
-	return fmt.Sprintf("%016x", h)
-}
-
 // GenerateRandomPassword генерирует случайный пароль
 func GenerateRandomPassword(length int) (string, error) {
 	bytes := make([]byte, length)
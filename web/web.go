@@ -11,14 +11,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"turanga/access"
 	"turanga/config"
+	"turanga/history"
 	"turanga/nostr"
 	"turanga/scanner"
+	"turanga/users"
 )
 
 // Максимальный размер загружаемой книги — 100MB
@@ -48,21 +52,6 @@ func NewWebInterface(database *sql.DB, cfg *config.Config, nc *nostr.Client, roo
 	}
 }
 
-// isAuthenticated проверяет, авторизован ли пользователь
-func (w *WebInterface) isAuthenticated(r *http.Request) bool {
-	cookie, err := r.Cookie("auth")
-	if err != nil {
-		return false
-	}
-
-	// Если хэш не установлен, разрешаем доступ (первый вход)
-	if w.config.PasswordHash == "" {
-		return true
-	}
-
-	return cookie.Value == w.config.PasswordHash
-}
-
 // loadTemplates загружает все шаблоны из файлов
 func (w *WebInterface) loadTemplates() (*template.Template, error) {
 	var err error
@@ -164,10 +153,40 @@ func (w *WebInterface) getCoverURLFromFileHash(fileHash string, cfg *config.Conf
 	return ""
 }
 
+// CoversHandler отдаёт обложки из каталога covers. Имя файла обложки — хеш книги,
+// поэтому обложку закрытой книги видят только те, кому разрешена сама книга.
+// URL: GET /covers/{hash}.{ext}
+func (w *WebInterface) CoversHandler(coversDir string) http.HandlerFunc {
+	fileServer := http.StripPrefix("/covers/", http.FileServer(http.Dir(coversDir)))
+	return func(wr http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		fileHash := strings.TrimSuffix(name, path.Ext(name))
+
+		visible, err := access.ForRequest(r).CanSeeFile(w.db, fileHash)
+		if err != nil {
+			log.Printf("Ошибка проверки доступа к обложке %s: %v", name, err)
+			http.Error(wr, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !visible {
+			http.NotFound(wr, r)
+			return
+		}
+
+		// ETag по размеру и времени изменения файла: FileServer сам ответит 304
+		// на If-None-Match, а Last-Modified он ставит по времени файла
+		if info, err := os.Stat(filepath.Join(coversDir, name)); err == nil {
+			wr.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+			wr.Header().Set("Cache-Control", "private, no-cache")
+		}
+		fileServer.ServeHTTP(wr, r)
+	}
+}
+
 // RequestBookViaNostrHandler обрабатывает запрос книги через Nostr
 func (w *WebInterface) RequestBookViaNostrHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -195,7 +214,7 @@ func (w *WebInterface) RequestBookViaNostrHandler(wr http.ResponseWriter, r *htt
 	defer cancel()
 
 	// Публикуем событие запроса через Nostr клиент
-	err := w.NostrClient.PublishBookRequestEvent(ctx, author, series, title, fileHash, "")
+	_, err := w.NostrClient.PublishBookRequestEvent(ctx, author, series, title, fileHash, "", "")
 	if err != nil {
 		log.Printf("Ошибка публикации запроса книги через Nostr: %v", err)
 		http.Error(wr, "Ошибка отправки запроса в сеть Nostr: "+err.Error(), http.StatusInternalServerError)
@@ -211,7 +230,7 @@ func (w *WebInterface) RequestBookViaNostrHandler(wr http.ResponseWriter, r *htt
 // AddToBlacklistHandler обрабатывает добавление записей в чёрный список
 func (w *WebInterface) AddToBlacklistHandler(wr http.ResponseWriter, r *http.Request) {
 	// Проверяем аутентификацию
-	if !w.isAuthenticated(r) {
+	if !w.can(r, users.PermNostr) {
 		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -297,6 +316,16 @@ func (w *WebInterface) registerDownloadedBook(filePath string, fileInfo os.FileI
 		return fmt.Errorf("failed to process book file: %w", err)
 	}
 
+	// Отмечаем в журнале изменений, откуда появилась книга
+	var bookID int64
+	if err := w.db.QueryRow("SELECT id FROM books WHERE file_hash = ?", fileHash).Scan(&bookID); err == nil {
+		if err := history.Log(w.db, history.NewOperation(), history.OriginNostr, history.Change{
+			BookID: bookID, Field: history.FieldCreated, NewValue: title,
+		}); err != nil {
+			log.Printf("Предупреждение: %v (книга ID %d)", err, bookID)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,114 @@
+// web/works.go
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"turanga/config"
+	"turanga/users"
+	"turanga/works"
+)
+
+// MergeWorkHandler объединяет книгу с другим файлом того же произведения
+// URL: POST /work/merge/{id}, поле формы target — ID или хеш другой книги
+func (w *WebInterface) MergeWorkHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bookID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/work/merge/"))
+	if err != nil {
+		http.Error(wr, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	targetID, err := w.resolveBookReference(r.FormValue("target"))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := works.Merge(w.db, int64(bookID), targetID); err != nil {
+		log.Printf("Ошибка объединения книги ID %d с книгой ID %d: %v", bookID, targetID, err)
+		http.Error(wr, "Не удалось объединить книги", http.StatusInternalServerError)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Книга ID %d объединена с книгой ID %d", bookID, targetID)
+	}
+	http.Redirect(wr, r, fmt.Sprintf("/book/%d", bookID), http.StatusSeeOther)
+}
+
+// SplitWorkHandler выделяет файл книги из произведения в отдельную книгу
+// URL: POST /work/split/{id}
+func (w *WebInterface) SplitWorkHandler(wr http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+
+	if !w.can(r, users.PermEdit) {
+		http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bookID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/work/split/"))
+	if err != nil {
+		http.Error(wr, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := works.Split(w.db, int64(bookID)); err != nil {
+		log.Printf("Ошибка выделения книги ID %d из произведения: %v", bookID, err)
+		http.Error(wr, "Не удалось отделить формат", http.StatusInternalServerError)
+		return
+	}
+
+	if cfg.Debug {
+		log.Printf("Книга ID %d выделена в отдельное произведение", bookID)
+	}
+
+	// Возвращаемся на страницу, с которой пришли
+	returnID := bookID
+	if id, err := strconv.Atoi(r.FormValue("return")); err == nil && id > 0 {
+		returnID = id
+	}
+	http.Redirect(wr, r, fmt.Sprintf("/book/%d", returnID), http.StatusSeeOther)
+}
+
+// resolveBookReference находит книгу по ID или хешу файла
+func (w *WebInterface) resolveBookReference(ref string) (int64, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return 0, fmt.Errorf("не указана книга")
+	}
+
+	var id int64
+	var err error
+	if n, convErr := strconv.ParseInt(ref, 10, 64); convErr == nil && len(ref) != 16 {
+		err = w.db.QueryRow("SELECT id FROM books WHERE id = ?", n).Scan(&id)
+	} else {
+		err = w.db.QueryRow("SELECT id FROM books WHERE file_hash = ?", strings.ToLower(ref)).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("книга %s не найдена", ref)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска книги %s: %w", ref, err)
+	}
+	return id, nil
+}
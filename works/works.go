@@ -0,0 +1,286 @@
+// works/works.go
+package works
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"turanga/access"
+	"turanga/models"
+	"turanga/search"
+)
+
+// Произведение (work) объединяет несколько файлов одной книги: fb2, fb2.zip, epub и т.д.
+// Каждый файл по-прежнему остаётся отдельной строкой books со своим хешем,
+// works лишь группирует такие строки через books.work_id.
+
+// normalize приводит строку к виду для сравнения: нижний регистр, ё→е,
+// только буквы и цифры, одиночные пробелы между словами
+func normalize(s string) string {
+	words := strings.FieldsFunc(search.Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// Key вычисляет ключ группировки по авторам, названию и серии.
+// Порядок авторов не важен. Пустой ключ означает, что группировать не по чему.
+func Key(title, series string, authors []string) string {
+	title = normalize(title)
+	if title == "" {
+		return ""
+	}
+	var names []string
+	for _, a := range authors {
+		if n := normalize(a); n != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|") + "\x1f" + title + "\x1f" + normalize(series)
+}
+
+// bookKey читает из БД данные книги и вычисляет её ключ
+func bookKey(q models.Querier, bookID int64) (string, string, error) {
+	var title, series sql.NullString
+	err := q.QueryRow("SELECT title, series FROM books WHERE id = ?", bookID).Scan(&title, &series)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+	}
+
+	rows, err := q.Query(`
+        SELECT a.full_name FROM book_authors ba
+        JOIN authors a ON a.id = ba.author_id
+        WHERE ba.book_id = ?`, bookID)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка получения авторов книги ID %d: %w", bookID, err)
+	}
+	defer rows.Close()
+
+	var authors []string
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err == nil && name.Valid {
+			authors = append(authors, name.String)
+		}
+	}
+
+	return Key(title.String, series.String, authors), title.String, rows.Err()
+}
+
+// Assign привязывает книгу к произведению с тем же ключом, создавая его при необходимости.
+// Книги, уже входящие в произведение, не трогаются: ручное объединение имеет приоритет.
+func Assign(q models.Querier, bookID int64) error {
+	var workID sql.NullInt64
+	if err := q.QueryRow("SELECT work_id FROM books WHERE id = ?", bookID).Scan(&workID); err != nil {
+		return fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+	}
+	if workID.Valid {
+		return nil
+	}
+
+	key, title, err := bookKey(q, bookID)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	if key != "" {
+		err = q.QueryRow("SELECT id FROM works WHERE match_key = ?", key).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("ошибка поиска произведения: %w", err)
+		}
+	}
+	if id == 0 {
+		id, err = create(q, title, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.Exec("UPDATE books SET work_id = ? WHERE id = ?", id, bookID); err != nil {
+		return fmt.Errorf("ошибка привязки книги ID %d к произведению: %w", bookID, err)
+	}
+	return nil
+}
+
+// create добавляет запись о произведении. Пустой ключ хранится как NULL,
+// такие произведения никогда не подбираются автоматически.
+func create(q models.Querier, title, key string) (int64, error) {
+	var matchKey interface{}
+	if key != "" {
+		matchKey = key
+	}
+	res, err := q.Exec("INSERT INTO works (title, match_key, created_at) VALUES (?, ?, ?)",
+		title, matchKey, time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания произведения: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// Merge переносит книгу (и все файлы её произведения) в произведение книги targetID
+func Merge(db *sql.DB, bookID, targetID int64) error {
+	if bookID == targetID {
+		return fmt.Errorf("нельзя объединить книгу саму с собой")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := Assign(tx, targetID); err != nil {
+		return err
+	}
+	if err := Assign(tx, bookID); err != nil {
+		return err
+	}
+
+	var fromWork, toWork int64
+	if err := tx.QueryRow("SELECT work_id FROM books WHERE id = ?", bookID).Scan(&fromWork); err != nil {
+		return fmt.Errorf("ошибка получения произведения книги ID %d: %w", bookID, err)
+	}
+	if err := tx.QueryRow("SELECT work_id FROM books WHERE id = ?", targetID).Scan(&toWork); err != nil {
+		return fmt.Errorf("ошибка получения произведения книги ID %d: %w", targetID, err)
+	}
+
+	if fromWork != toWork {
+		if _, err := tx.Exec("UPDATE books SET work_id = ? WHERE work_id = ?", toWork, fromWork); err != nil {
+			return fmt.Errorf("ошибка объединения произведений: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Split выделяет книгу в отдельное произведение.
+// Новое произведение не получает ключ, чтобы книга не вернулась в группу при следующем импорте.
+func Split(db *sql.DB, bookID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	var title sql.NullString
+	if err := tx.QueryRow("SELECT title FROM books WHERE id = ?", bookID).Scan(&title); err != nil {
+		return fmt.Errorf("ошибка получения книги ID %d: %w", bookID, err)
+	}
+
+	id, err := create(tx, title.String, "")
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE books SET work_id = ? WHERE id = ?", id, bookID); err != nil {
+		return fmt.Errorf("ошибка выделения книги ID %d: %w", bookID, err)
+	}
+
+	return tx.Commit()
+}
+
+// File описывает один файл произведения
+type File struct {
+	BookID   int64
+	Title    string
+	FileType string
+	FileHash string
+	FileSize int64
+	IPFSCID  string
+}
+
+// Files возвращает все файлы произведения, к которому относится книга.
+// Закрытые книги отбрасываются по правилам policy; фильтр форматов задаётся fileTypes (nil — любые).
+func Files(db *sql.DB, bookID int64, policy access.Policy, fileTypes []string) ([]File, error) {
+	query := `
+        SELECT b.id, b.title, b.file_type, b.file_hash, b.file_size, b.ipfs_cid
+        FROM books b
+        WHERE (b.id = ? OR b.work_id = (SELECT work_id FROM books WHERE id = ?))`
+	args := []interface{}{bookID, bookID}
+
+	query += " " + policy.Filter("b")
+	if len(fileTypes) > 0 {
+		query += " AND b.file_type IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(fileTypes)), ", ") + ")"
+		for _, t := range fileTypes {
+			args = append(args, t)
+		}
+	}
+	query += " ORDER BY b.id = ? DESC, b.file_type, b.id"
+	args = append(args, bookID)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения файлов произведения: %w", err)
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		var title, fileType, fileHash, ipfsCID sql.NullString
+		var fileSize sql.NullInt64
+		if err := rows.Scan(&f.BookID, &title, &fileType, &fileHash, &fileSize, &ipfsCID); err != nil {
+			return nil, fmt.Errorf("ошибка чтения файлов произведения: %w", err)
+		}
+		f.Title = title.String
+		f.FileType = fileType.String
+		f.FileHash = fileHash.String
+		f.FileSize = fileSize.Int64
+		f.IPFSCID = ipfsCID.String
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// Group возвращает соответствие «книга → произведение» для переданных книг.
+// Книги без произведения в результат не попадают.
+func Group(db *sql.DB, bookIDs []int64) (map[int64]int64, error) {
+	result := make(map[int64]int64)
+	if len(bookIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders, args := search.Placeholders(bookIDs)
+	rows, err := db.Query("SELECT id, work_id FROM books WHERE work_id IS NOT NULL AND id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения произведений: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID, workID int64
+		if err := rows.Scan(&bookID, &workID); err != nil {
+			return nil, fmt.Errorf("ошибка чтения произведений: %w", err)
+		}
+		result[bookID] = workID
+	}
+	return result, rows.Err()
+}
+
+// AssignAll привязывает к произведениям все книги, у которых их ещё нет
+func AssignAll(q models.Querier) (int, error) {
+	rows, err := q.Query("SELECT id FROM books WHERE work_id IS NULL ORDER BY id")
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения книг без произведения: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := Assign(q, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}